@@ -1,16 +1,33 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"agent-flow/internal/agent"
 	"agent-flow/internal/api"
 	"agent-flow/internal/channel"
+	"agent-flow/internal/chat"
+	"agent-flow/internal/jobs"
+	"agent-flow/internal/logs"
+	"agent-flow/internal/memory"
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
 	"agent-flow/internal/store"
 )
 
 func main() {
+	// SIGINT/SIGTERM 时取消, 触发优雅退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// 初始化存储
 	db, err := store.NewPostgres(os.Getenv("DATABASE_URL"))
 	if err != nil {
@@ -25,15 +42,103 @@ func main() {
 	}
 	defer redis.Close()
 
+	// 初始化媒体存储 (渠道入站图片/文件)
+	blobs, err := store.NewBlobStore(os.Getenv("BLOB_DIR"))
+	if err != nil {
+		log.Fatalf("Failed to init blob store: %v", err)
+	}
+
 	// 初始化渠道管理器
-	channelMgr := channel.NewManager(db, redis)
+	channelMgr := channel.NewManager(db, redis, blobs)
+
+	// 初始化模型服务
+	modelSvc := model.NewService()
+	modelSvc.Limiter().UseRedis(redis)
+	modelSvc.Cache().UseRedis(redis)
+	if err := modelSvc.UseRegistry(db, redis); err != nil {
+		log.Printf("Failed to load model registry: %v", err)
+	}
+	if err := modelSvc.Router().UseStore(db, redis); err != nil {
+		log.Printf("Failed to load routing policies: %v", err)
+	}
+
+	// 发现自托管模型 (Ollama / OpenAI兼容服务)
+	discoverCtx, cancelDiscover := context.WithTimeout(context.Background(), 10*time.Second)
+	for provider, err := range modelSvc.DiscoverAll(discoverCtx) {
+		log.Printf("Model discovery failed for %s: %v", provider, err)
+	}
+	cancelDiscover()
+
+	// 初始化提示词模板 (写入缺失的内置模板)
+	prompts := prompt.NewService(db)
+	modelSvc.UsePrompts(prompts)
+	if err := prompts.EnsureDefaults(); err != nil {
+		log.Printf("Failed to seed prompt templates: %v", err)
+	}
+
+	// 后台任务 (定时任务通过Redis锁在副本间只执行一次)
+	jobMgr := jobs.NewManager(redis)
+	jobMgr.Go("routing-log", modelSvc.Router().Run)
+	jobMgr.Go("registry-sync", modelSvc.RunRegistrySync)
+	jobMgr.Go("ratelimit-sync", modelSvc.Limiter().Run)
+
+	// 初始化智能体运行时
+	logSvc := logs.NewService(os.Getenv("LOG_DIR"))
+	jobMgr.Go("log-writer", logSvc.Run)
+	memorySvc := memory.NewService(db, redis)
+	memorySvc.UseEmbeddings(modelSvc, os.Getenv("KNOWLEDGE_EMBEDDING_MODEL"))
+	memorySvc.UseBlobs(blobs)
+	memorySvc.UsePrompts(prompts)
+	jobMgr.Go("ingest-worker", memorySvc.RunIngestWorker)
+	jobMgr.Go("knowledge-sync", memorySvc.RunIndexSync)
+	jobMgr.Every("knowledge-embedding", time.Minute, memorySvc.EmbeddingJob())
+
+	// 记忆整合与过期清理 (MEMORY_MAINTENANCE_INTERVAL 默认6h, 设为0关闭)
+	memoryInterval := 6 * time.Hour
+	if v := os.Getenv("MEMORY_MAINTENANCE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			memoryInterval = d
+		}
+	}
+	if memoryInterval > 0 {
+		jobMgr.Every("memory-maintenance", memoryInterval, memorySvc.MaintenanceJob(memory.MaintenanceConfig{
+			Model: os.Getenv("MEMORY_CONSOLIDATION_MODEL"),
+		}))
+	}
+	agentSvc := agent.NewService(db, memorySvc, modelSvc, prompts)
+	agentSvc.UseLogs(logSvc)
+	jobMgr.Go("task-worker", agentSvc.RunTaskWorker)
+
+	// 渠道入站消息 (webhook 立即应答, 由后台处理并主动回复)
+	channelMgr.UseAgents(agentSvc)
+	jobMgr.Go("channel-inbound", channelMgr.Run)
+
+	// 聊天 WebSocket 推送
+	chatHub := chat.NewHub()
+	jobMgr.Go("chat-hub", chatHub.Run)
+	chatSvc := chat.NewService(db, redis, chatHub)
+
+	// 定时绩效评估 (PERF_EVAL_INTERVAL 如 24h, 未设置时不启用)
+	if interval, err := time.ParseDuration(os.Getenv("PERF_EVAL_INTERVAL")); err == nil && interval > 0 {
+		rootID, _ := strconv.ParseUint(os.Getenv("PERF_EVAL_ORG_ROOT"), 10, 64)
+		jobMgr.Every("performance-eval", interval, agentSvc.PerformanceEvalJob(agent.PerformanceEvalConfig{
+			EvalInterval: interval,
+			OrgRootID:    uint(rootID),
+			CompanyGoal:  os.Getenv("PERF_EVAL_GOAL"),
+			UseDatasets:  os.Getenv("PERF_EVAL_USE_DATASETS") == "true",
+		}))
+	}
+	jobMgr.Start(ctx)
 
 	// 路由设置
 	r := gin.Default()
 
 	// API路由
-	apiHandler := api.NewHandler(db, redis, channelMgr)
+	apiHandler := api.NewHandler(db, redis, channelMgr, modelSvc, prompts, agentSvc)
+	apiHandler.UseJobs(jobMgr)
+	apiHandler.UseMemory(memorySvc)
 	apiHandler.RegisterRoutes(r)
+	chat.NewHandler(chatSvc).RegisterRoutes(r)
 
 	// Webhook路由 (各渠道消息入口)
 	r.POST("/webhook/:channel_type", channelMgr.HandleWebhook)
@@ -48,6 +153,25 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	log.Printf("Server starting on port %s", port)
-	r.Run(":" + port)
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down...")
+
+	// 先停止接收请求, 再取消后台任务 (日志写入协程会写完剩余日志)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	if err := jobMgr.Stop(shutdownCtx); err != nil {
+		log.Printf("Background jobs shutdown: %v", err)
+	}
 }
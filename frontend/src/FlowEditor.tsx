@@ -106,6 +106,20 @@ function LLMNode({ data, selected }: NodeProps<NodeData>) {
   )
 }
 
+function VoteNode({ data, selected }: NodeProps<NodeData>) {
+  return (
+    <div className={`custom-node vote-node ${selected ? 'selected' : ''}`}>
+      <Handle type="target" position={Position.Top} />
+      <div className="node-icon">🗳️</div>
+      <div className="node-content">
+        <div className="node-label">多模型投票</div>
+        <div className="node-desc">{data.models || '选择模型'}</div>
+      </div>
+      <Handle type="source" position={Position.Bottom} />
+    </div>
+  )
+}
+
 function OutputNode({ data, selected }: NodeProps<NodeData>) {
   return (
     <div className={`custom-node output-node ${selected ? 'selected' : ''}`}>
@@ -126,6 +140,7 @@ const nodeTypes = {
   condition: ConditionNode,
   tool: ToolNode,
   llm: LLMNode,
+  vote: VoteNode,
   output: OutputNode,
 }
 
@@ -183,6 +198,9 @@ function Sidebar({ onDrag }: { onDrag: (type: string, label: string) => void })
           <div className="palette-item" onClick={() => onDrag('llm', '大模型')}>
             <span>🧠</span> 大模型
           </div>
+          <div className="palette-item" onClick={() => onDrag('vote', '多模型投票')}>
+            <span>🗳️</span> 多模型投票
+          </div>
         </div>
 
         <div className="palette-section">
@@ -333,15 +351,86 @@ function PropertiesPanel({
       )}
 
       {selectedNode.type === 'llm' && (
-        <div className="property-group">
-          <label>System Prompt</label>
-          <textarea 
-            value={selectedNode.data.prompt || ''} 
-            onChange={(e) => handleChange('prompt', e.target.value)}
-            rows={4}
-            placeholder="设置AI的系统提示词..."
-          />
-        </div>
+        <>
+          <div className="property-group">
+            <label>提示词模板</label>
+            <input 
+              type="text" 
+              value={selectedNode.data.promptKey || ''} 
+              onChange={(e) => handleChange('promptKey', e.target.value)}
+              placeholder="模板ID, 例如: agent.assistant (优先于下方文本)"
+            />
+          </div>
+          <div className="property-group">
+            <label>模板版本</label>
+            <input 
+              type="number" 
+              min={0}
+              value={selectedNode.data.promptVersion || 0} 
+              onChange={(e) => handleChange('promptVersion', e.target.value)}
+              placeholder="0 表示最新版本"
+            />
+          </div>
+          <div className="property-group">
+            <label>System Prompt</label>
+            <textarea 
+              value={selectedNode.data.prompt || ''} 
+              onChange={(e) => handleChange('prompt', e.target.value)}
+              rows={4}
+              placeholder="设置AI的系统提示词..."
+            />
+          </div>
+        </>
+      )}
+
+      {selectedNode.type === 'vote' && (
+        <>
+          <div className="property-group">
+            <label>参与模型</label>
+            <input 
+              type="text" 
+              value={selectedNode.data.models || ''} 
+              onChange={(e) => handleChange('models', e.target.value)}
+              placeholder="逗号分隔, 例如: gpt-4,claude-3-opus,glm-4"
+            />
+          </div>
+          <div className="property-group">
+            <label>投票方法</label>
+            <select 
+              value={selectedNode.data.votingMethod || ''}
+              onChange={(e) => handleChange('votingMethod', e.target.value)}
+            >
+              <option value="">综合评分</option>
+              <option value="judge">LLM评审</option>
+              <option value="cross">交叉评估</option>
+              <option value="pairwise">两两对决</option>
+              <option value="majority">多数投票</option>
+              <option value="borda">Borda计数</option>
+              <option value="self_consistency">自洽采样</option>
+              <option value="length">按长度</option>
+            </select>
+          </div>
+          <div className="property-group">
+            <label>任务类型</label>
+            <select 
+              value={selectedNode.data.taskType || 'general'}
+              onChange={(e) => handleChange('taskType', e.target.value)}
+            >
+              <option value="general">通用</option>
+              <option value="decision">决策</option>
+              <option value="creation">创作</option>
+              <option value="analysis">分析</option>
+            </select>
+          </div>
+          <div className="property-group">
+            <label>System Prompt</label>
+            <textarea 
+              value={selectedNode.data.prompt || ''} 
+              onChange={(e) => handleChange('prompt', e.target.value)}
+              rows={3}
+            />
+          </div>
+        </>
       )}
     </aside>
   )
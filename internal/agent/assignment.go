@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"agent-flow/internal/store"
+	"gorm.io/gorm"
+)
+
+// 模型切换来源
+const (
+	SwitchVote       = "vote"       // 下级投票换领导模型
+	SwitchPerf       = "perf"       // 领导根据业绩换下属模型
+	SwitchEvaluation = "evaluation" // 定时绩效评估
+	SwitchAdmin      = "admin"      // 管理员指定
+)
+
+// SwitchPolicy 模型切换限制
+type SwitchPolicy struct {
+	MinTenure   time.Duration `json:"min_tenure"`   // 切换后至少使用多久才能再次切换
+	MaxSwitches int           `json:"max_switches"` // 每个周期内最多切换次数, 0表示不限制
+	Period      time.Duration `json:"period"`
+}
+
+// defaultSwitchPolicy 默认限制, 可通过环境变量调整
+func defaultSwitchPolicy() SwitchPolicy {
+	policy := SwitchPolicy{MinTenure: time.Hour, MaxSwitches: 3, Period: 24 * time.Hour}
+	if d, err := time.ParseDuration(os.Getenv("MODEL_SWITCH_MIN_TENURE")); err == nil {
+		policy.MinTenure = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("MODEL_SWITCH_MAX_PER_PERIOD")); err == nil {
+		policy.MaxSwitches = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("MODEL_SWITCH_PERIOD")); err == nil && d > 0 {
+		policy.Period = d
+	}
+	return policy
+}
+
+// Vote 一张投票
+type Vote struct {
+	Voter string `json:"voter"` // 投票者 (成员名或模型名)
+	Model string `json:"model"`
+	Score *int   `json:"score,omitempty"`
+}
+
+// SwitchRequest 申请切换模型
+type SwitchRequest struct {
+	Subject string `json:"subject"`
+	To      string `json:"to"`
+	Source  string `json:"source"`
+	Reason  string `json:"reason"`
+	Score   *int   `json:"score,omitempty"`
+	Votes   []Vote `json:"votes,omitempty"`
+}
+
+// AgentSubject 数据库中智能体的模型分配Key
+func AgentSubject(agentID uint) string {
+	return fmt.Sprintf("agent:%d", agentID)
+}
+
+// RoleSubject 默认协作链路中角色的模型分配Key
+func RoleSubject(role string) string {
+	return "role:" + role
+}
+
+func nodeSubject(node *OrgNode) string {
+	if node.AgentID != 0 {
+		return AgentSubject(node.AgentID)
+	}
+	return RoleSubject(node.Role)
+}
+
+// SetSwitchPolicy 设置模型切换限制
+func (s *Service) SetSwitchPolicy(policy SwitchPolicy) {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+	s.switchPolicy = policy
+}
+
+// GetSwitchPolicy 当前的模型切换限制
+func (s *Service) GetSwitchPolicy() SwitchPolicy {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+	return s.switchPolicy
+}
+
+// AssignedModel 已分配的模型, 没有分配记录时返回 fallback
+func (s *Service) AssignedModel(subject, fallback string) string {
+	if s.db == nil {
+		return fallback
+	}
+	a, err := s.db.GetModelAssignment(subject)
+	if err != nil || a.Model == "" {
+		return fallback
+	}
+	return a.Model
+}
+
+// ProposeSwitch 按切换限制处理一次切换申请, 无论是否生效都记录日志
+func (s *Service) ProposeSwitch(req SwitchRequest, current string) (*store.ModelSwitchLog, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+
+	assignment, err := s.db.GetModelAssignment(req.Subject)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		assignment = &store.ModelAssignment{Subject: req.Subject, Model: current}
+	case err != nil:
+		return nil, err
+	}
+	if assignment.Model == "" {
+		assignment.Model = current
+	}
+
+	entry := &store.ModelSwitchLog{
+		Subject:   req.Subject,
+		FromModel: assignment.Model,
+		ToModel:   req.To,
+		Source:    req.Source,
+		Score:     req.Score,
+		Reason:    req.Reason,
+		Votes:     "[]",
+	}
+	if len(req.Votes) > 0 {
+		votes, _ := json.Marshal(req.Votes)
+		entry.Votes = string(votes)
+	}
+
+	entry.Blocked = s.checkSwitch(assignment, req)
+	if entry.Blocked == "" {
+		if err := s.applySwitch(assignment, req); err != nil {
+			entry.Blocked = err.Error()
+		} else {
+			entry.Applied = true
+		}
+	}
+
+	if err := s.db.CreateModelSwitchLog(entry); err != nil {
+		return nil, err
+	}
+	if entry.Applied {
+		log.Printf("[Agent] %s model %s → %s (%s: %s)", req.Subject, entry.FromModel, req.To, req.Source, req.Reason)
+	}
+	return entry, nil
+}
+
+// checkSwitch 检查切换限制, 返回拦截原因
+func (s *Service) checkSwitch(a *store.ModelAssignment, req SwitchRequest) string {
+	if req.To == "" || req.To == a.Model {
+		return "模型未变化"
+	}
+	if !s.modelAvailable(req.To) {
+		return fmt.Sprintf("模型 %s 当前不可用", req.To)
+	}
+	if req.Source == SwitchAdmin {
+		return ""
+	}
+	if a.Locked {
+		return fmt.Sprintf("已被 %s 锁定为 %s", a.LockedBy, a.Model)
+	}
+
+	policy := s.switchPolicy
+	if a.ID != 0 && policy.MinTenure > 0 && time.Since(a.AssignedAt) < policy.MinTenure {
+		return fmt.Sprintf("当前模型使用不足 %s", policy.MinTenure)
+	}
+	if policy.MaxSwitches > 0 {
+		n, err := s.db.CountModelSwitches(req.Subject, time.Now().Add(-policy.Period))
+		if err != nil {
+			return err.Error()
+		}
+		if int(n) >= policy.MaxSwitches {
+			return fmt.Sprintf("%s 内已切换 %d 次", policy.Period, n)
+		}
+	}
+	return ""
+}
+
+// applySwitch 保存分配, 数据库中的智能体同步更新配置
+func (s *Service) applySwitch(a *store.ModelAssignment, req SwitchRequest) error {
+	if agentID, ok := parseAgentSubject(req.Subject); ok {
+		if err := s.switchAgentModel(agentID, req.To); err != nil {
+			return err
+		}
+	}
+	a.Model = req.To
+	a.AssignedAt = time.Now()
+	return s.db.SaveModelAssignment(a)
+}
+
+// LockModel 管理员指定并锁定模型 (locked为false时解除锁定, model为空时保持当前模型)
+func (s *Service) LockModel(subject, modelName string, locked bool, by string) (*store.ModelAssignment, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+
+	if modelName != "" {
+		entry, err := s.ProposeSwitch(SwitchRequest{Subject: subject, To: modelName, Source: SwitchAdmin, Reason: "管理员指定 " + by}, "")
+		if err != nil {
+			return nil, err
+		}
+		if !entry.Applied && entry.FromModel != modelName {
+			return nil, fmt.Errorf("switch to %s rejected: %s", modelName, entry.Blocked)
+		}
+	}
+
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+	a, err := s.db.GetModelAssignment(subject)
+	if err != nil {
+		return nil, fmt.Errorf("no model assigned to %s", subject)
+	}
+	a.Locked = locked
+	a.LockedBy = ""
+	if locked {
+		a.LockedBy = by
+	}
+	return a, s.db.SaveModelAssignment(a)
+}
+
+func (s *Service) modelAvailable(name string) bool {
+	for _, m := range s.candidateModels() {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAgentSubject(subject string) (uint, bool) {
+	var id uint
+	if _, err := fmt.Sscanf(subject, "agent:%d", &id); err != nil || id == 0 {
+		return 0, false
+	}
+	return id, true
+}
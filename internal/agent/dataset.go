@@ -0,0 +1,324 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-flow/internal/store"
+)
+
+// sourceEval 评测运行不计入绩效KPI, 也不写入记忆
+const sourceEval = "eval"
+
+const evalParallel = 4
+
+// LeaderboardEntry 排行榜中的一个模型
+type LeaderboardEntry struct {
+	Rank         int     `json:"rank"`
+	Model        string  `json:"model"`
+	Score        float64 `json:"score"`     // 加权平均分 0-1
+	PassRate     float64 `json:"pass_rate"` // 通过率
+	Cases        int     `json:"cases"`
+	Errors       int     `json:"errors"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	AvgTokens    float64 `json:"avg_tokens"`
+}
+
+// ValidateScorer 校验评分器名称和参数
+func ValidateScorer(name, config string) error {
+	if GetScorer(name) == nil {
+		return fmt.Errorf("unknown scorer %q", name)
+	}
+	if _, err := parseScorerConfig(config); err != nil {
+		return fmt.Errorf("scorer_config: %w", err)
+	}
+	return nil
+}
+
+func parseScorerConfig(raw string) (map[string]interface{}, error) {
+	cfg := map[string]interface{}{}
+	if raw = strings.TrimSpace(raw); raw == "" || raw == "null" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// RunDataset 在候选模型上批量运行数据集并评分, models为空时使用智能体当前模型和所有可用模型
+func (s *Service) RunDataset(ctx context.Context, datasetID uint, models []string) (*store.EvalRun, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+	ds, err := s.db.GetEvalDataset(datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("dataset %d not found: %w", datasetID, err)
+	}
+	if len(ds.Cases) == 0 {
+		return nil, fmt.Errorf("dataset %d has no cases", datasetID)
+	}
+	def, err := s.LoadAgent(ds.AgentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(models) == 0 {
+		models = s.candidateModels()
+	}
+	models = withModel(models, def.Model)
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no models to evaluate")
+	}
+
+	modelsJSON, _ := json.Marshal(models)
+	run := &store.EvalRun{
+		DatasetID:   ds.ID,
+		AgentID:     ds.AgentID,
+		Models:      string(modelsJSON),
+		Leaderboard: "[]",
+		Status:      CycleRunning,
+	}
+	if err := s.db.CreateEvalRun(run); err != nil {
+		return nil, err
+	}
+
+	results := s.evalCases(ctx, def, ds, models, run.ID)
+	board := buildLeaderboard(ds.Cases, results)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = CycleCompleted
+	if err := s.db.CreateEvalResults(results); err != nil {
+		run.Status = CycleFailed
+		run.Error = err.Error()
+	}
+	boardJSON, _ := json.Marshal(board)
+	run.Leaderboard = string(boardJSON)
+	if err := s.db.UpdateEvalRun(run); err != nil {
+		return nil, err
+	}
+	run.Results = results
+	return run, nil
+}
+
+// evalCases 并行运行所有 (模型, 用例) 组合
+func (s *Service) evalCases(ctx context.Context, def *Definition, ds *store.EvalDataset, models []string, runID uint) []store.EvalResult {
+	type job struct {
+		model string
+		c     store.EvalCase
+	}
+	jobs := make(chan job)
+	var mu sync.Mutex
+	var results []store.EvalResult
+
+	var wg sync.WaitGroup
+	for i := 0; i < evalParallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				r := s.evalCase(ctx, def, ds, j.model, j.c)
+				r.RunID = runID
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, m := range models {
+		for _, c := range ds.Cases {
+			jobs <- job{model: m, c: c}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Model != results[j].Model {
+			return results[i].Model < results[j].Model
+		}
+		return results[i].CaseID < results[j].CaseID
+	})
+	return results
+}
+
+// evalCase 以指定模型运行智能体并对输出评分
+func (s *Service) evalCase(ctx context.Context, def *Definition, ds *store.EvalDataset, modelName string, c store.EvalCase) store.EvalResult {
+	result := store.EvalResult{CaseID: c.ID, Model: modelName}
+
+	d := *def
+	d.Model = modelName
+	started := time.Now()
+	out, err := s.runAs(ctx, &d, sourceEval, s.SystemPrompt(&d, ""), userMessage(c.Input))
+	result.LatencyMs = time.Since(started).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Output = out.Output
+	result.Tokens = out.Tokens
+
+	name, rawCfg := ds.Scorer, ds.ScorerConfig
+	if c.Scorer != "" {
+		name = c.Scorer
+	}
+	if strings.TrimSpace(c.ScorerConfig) != "" && strings.TrimSpace(c.ScorerConfig) != "{}" {
+		rawCfg = c.ScorerConfig
+	}
+	scorer := GetScorer(name)
+	if scorer == nil {
+		result.Error = fmt.Sprintf("unknown scorer %q", name)
+		return result
+	}
+	cfg, err := parseScorerConfig(rawCfg)
+	if err != nil {
+		result.Error = fmt.Sprintf("scorer_config: %v", err)
+		return result
+	}
+
+	score, err := scorer.Score(ctx, s.modelSvc, ScoreInput{Input: c.Input, Expected: c.Expected, Output: out.Output, Config: cfg, Prompts: s.prompts})
+	if err != nil {
+		result.Error = fmt.Sprintf("%s: %v", name, err)
+		return result
+	}
+	result.Score = score.Score
+	result.Passed = score.Passed
+	result.Detail = score.Detail
+	return result
+}
+
+// buildLeaderboard 按用例权重汇总每个模型的得分并排名 (出错的用例计0分)
+func buildLeaderboard(cases []store.EvalCase, results []store.EvalResult) []LeaderboardEntry {
+	weights := map[uint]float64{}
+	for _, c := range cases {
+		w := c.Weight
+		if w <= 0 {
+			w = 1
+		}
+		weights[c.ID] = w
+	}
+
+	type acc struct {
+		entry                     LeaderboardEntry
+		weighted, total, passed   float64
+		latency, tokens, finished float64
+	}
+	byModel := map[string]*acc{}
+	var order []string
+	for _, r := range results {
+		a, ok := byModel[r.Model]
+		if !ok {
+			a = &acc{entry: LeaderboardEntry{Model: r.Model}}
+			byModel[r.Model] = a
+			order = append(order, r.Model)
+		}
+		w := weights[r.CaseID]
+		a.entry.Cases++
+		a.total += w
+		a.weighted += w * r.Score
+		if r.Passed {
+			a.passed++
+		}
+		if r.Error != "" {
+			a.entry.Errors++
+			continue
+		}
+		a.finished++
+		a.latency += float64(r.LatencyMs)
+		a.tokens += float64(r.Tokens)
+	}
+
+	board := make([]LeaderboardEntry, 0, len(order))
+	for _, m := range order {
+		a := byModel[m]
+		if a.total > 0 {
+			a.entry.Score = a.weighted / a.total
+		}
+		a.entry.PassRate = a.passed / float64(a.entry.Cases)
+		if a.finished > 0 {
+			a.entry.AvgLatencyMs = a.latency / a.finished
+			a.entry.AvgTokens = a.tokens / a.finished
+		}
+		board = append(board, a.entry)
+	}
+	sort.SliceStable(board, func(i, j int) bool {
+		if board[i].Score != board[j].Score {
+			return board[i].Score > board[j].Score
+		}
+		return board[i].AvgLatencyMs < board[j].AvgLatencyMs
+	})
+	for i := range board {
+		board[i].Rank = i + 1
+	}
+	return board
+}
+
+// datasetVerdict 用智能体的评测数据集给当前模型打分, 有明显更好的模型时建议切换; 没有数据集时返回false
+func (s *Service) datasetVerdict(ctx context.Context, member *OrgNode, models []string, margin float64) (EvaluationResult, bool) {
+	datasets, err := s.db.ListEvalDatasets(member.AgentID)
+	if err != nil || len(datasets) == 0 {
+		return EvaluationResult{}, false
+	}
+
+	// 多个数据集取平均分
+	scores := map[string]float64{}
+	counted := 0
+	var names []string
+	for _, ds := range datasets {
+		run, err := s.RunDataset(ctx, ds.ID, models)
+		if err != nil {
+			continue
+		}
+		var board []LeaderboardEntry
+		if json.Unmarshal([]byte(run.Leaderboard), &board) != nil {
+			continue
+		}
+		for _, e := range board {
+			scores[e.Model] += e.Score
+		}
+		counted++
+		names = append(names, fmt.Sprintf("%s#%d", ds.Name, run.ID))
+	}
+	if counted == 0 {
+		return EvaluationResult{}, false
+	}
+
+	current := scores[member.Model] / float64(counted)
+	best, bestScore := member.Model, current
+	for m, total := range scores {
+		if avg := total / float64(counted); avg > bestScore {
+			best, bestScore = m, avg
+		}
+	}
+
+	result := EvaluationResult{
+		newModel: member.Model,
+		score:    int(current*100 + 0.5),
+		reason:   fmt.Sprintf("数据集评分 %.2f (%s)", current, strings.Join(names, ", ")),
+	}
+	if best != member.Model && bestScore-current > margin {
+		result.shouldSwitch = true
+		result.newModel = best
+		result.reason = fmt.Sprintf("数据集评分 %s %.2f 高于当前 %s %.2f (%s)", best, bestScore, member.Model, current, strings.Join(names, ", "))
+	}
+	return result, true
+}
+
+// withModel 确保列表包含指定模型 (放在首位)
+func withModel(models []string, m string) []string {
+	if m == "" {
+		return models
+	}
+	out := []string{m}
+	for _, x := range models {
+		if x != m {
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,518 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+	"agent-flow/internal/store"
+)
+
+// ========== 定时绩效评估 ==========
+
+// PerformanceEvalConfig 绩效评估定时任务配置
+type PerformanceEvalConfig struct {
+	EvalInterval time.Duration `json:"-"`            // 评估间隔，如 1小时、每天
+	Models       []string      `json:"models"`       // 可用模型列表, 为空时使用模型服务中可用的模型
+	OrgRootID    uint          `json:"org_root_id"`  // 评估的组织 (根智能体ID), 0表示评估周期内有运行记录的所有智能体
+	CompanyGoal  string        `json:"goal"`         // 公司目标/业绩指标
+	UseDatasets  bool          `json:"use_datasets"` // 有评测数据集的智能体以数据集评分作为切换依据
+	ScoreMargin  float64       `json:"score_margin"` // 数据集评分高出当前模型多少才切换, 默认0.05
+}
+
+const defaultScoreMargin = 0.05
+
+// 评估轮次状态
+const (
+	CycleRunning   = "running"
+	CycleCompleted = "completed"
+	CycleFailed    = "failed"
+)
+
+// PerformanceEvalJob 绩效评估定时任务, 由 jobs.Manager 按 EvalInterval 调度, ctx 在停机时取消
+func (s *Service) PerformanceEvalJob(cfg PerformanceEvalConfig) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := s.RunPerformanceEval(ctx, cfg)
+		return err
+	}
+}
+
+// evalMember 参与评估的成员
+type evalMember struct {
+	node   *OrgNode
+	leader *OrgNode // 上级, 根节点为nil
+	stats  *store.RunStats
+	rating *int // 下属打分平均值
+}
+
+// RunPerformanceEval 执行一轮绩效评估并保存结果
+// 流程：统计运行KPI → 汇总公司业绩 → CEO 结合下属打分自评 → 各级领导评估下属 → 切换模型
+func (s *Service) RunPerformanceEval(ctx context.Context, cfg PerformanceEvalConfig) (*store.EvaluationCycle, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+
+	// 统计区间: 上一轮结束至今, 首次评估取一个评估间隔
+	end := time.Now()
+	start := end.Add(-cfg.EvalInterval)
+	if cfg.EvalInterval <= 0 {
+		start = end.Add(-24 * time.Hour)
+	}
+	if last, err := s.db.LastEvaluationCycle(); err == nil && last.PeriodEnd.After(start) {
+		start = last.PeriodEnd
+	}
+
+	cycle := &store.EvaluationCycle{
+		Goal:        cfg.CompanyGoal,
+		OrgRootID:   cfg.OrgRootID,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		KPIs:        "{}",
+		Status:      CycleRunning,
+	}
+	if err := s.db.CreateEvaluationCycle(cycle); err != nil {
+		return nil, err
+	}
+
+	evals, err := s.evaluateCycle(ctx, cfg, cycle)
+	finished := time.Now()
+	cycle.FinishedAt = &finished
+	cycle.Status = CycleCompleted
+	if err != nil {
+		cycle.Status = CycleFailed
+		cycle.Error = err.Error()
+	}
+	if uerr := s.db.UpdateEvaluationCycle(cycle); uerr != nil {
+		log.Printf("[Eval] save cycle %d failed: %v", cycle.ID, uerr)
+	}
+	cycle.Evaluations = evals
+	return cycle, err
+}
+
+// evaluateCycle 评估周期内的所有成员, 逐个保存结果
+func (s *Service) evaluateCycle(ctx context.Context, cfg PerformanceEvalConfig, cycle *store.EvaluationCycle) ([]store.AgentEvaluation, error) {
+	members, err := s.evalMembers(cfg.OrgRootID, cycle.PeriodStart, cycle.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		cycle.Summary = "评估周期内没有运行记录"
+		return nil, nil
+	}
+
+	// 公司整体业绩 = 所有成员运行结果的汇总
+	company := aggregateStats(members)
+	kpis, _ := json.Marshal(company)
+	cycle.KPIs = string(kpis)
+	companyPerformance := formatStats("公司整体", company)
+
+	models := cfg.Models
+	if len(models) == 0 {
+		models = s.candidateModels()
+	}
+
+	var evals []store.AgentEvaluation
+	switched := 0
+	for _, m := range members {
+		var result EvaluationResult
+		var reviewer uint
+		if m.leader != nil {
+			reviewer = m.leader.AgentID
+		}
+		if r, ok := s.datasetSignal(ctx, cfg, m.node, models); ok {
+			// 数据集评分是客观信号, 优先于大模型的主观评估
+			result = r
+		} else if m.leader == nil {
+			// 顶级成员: 结合公司业绩和下属打分自评
+			if m.rating != nil {
+				result = s.evaluateRoleWithSubordinateScore(ctx, models, m.node.Model, companyPerformance, cfg.CompanyGoal, *m.rating)
+			} else {
+				result = s.evaluateRolePerformance(ctx, models, m.node.Model, m.node, m.stats, companyPerformance, cfg.CompanyGoal)
+			}
+		} else {
+			// 上级根据公司业绩和下属KPI评估
+			result = s.evaluateRolePerformance(ctx, models, m.leader.Model, m.node, m.stats, companyPerformance, cfg.CompanyGoal)
+		}
+
+		memberKPIs, _ := json.Marshal(m.stats)
+		eval := store.AgentEvaluation{
+			CycleID:          cycle.ID,
+			AgentID:          m.node.AgentID,
+			Role:             m.node.Role,
+			ReviewerID:       reviewer,
+			KPIs:             string(memberKPIs),
+			SubordinateScore: m.rating,
+			Score:            result.score,
+			Review:           result.reason,
+			Model:            m.node.Model,
+			NewModel:         m.node.Model,
+		}
+		if result.shouldSwitch {
+			score := result.score
+			entry, err := s.ProposeSwitch(SwitchRequest{
+				Subject: AgentSubject(m.node.AgentID),
+				To:      result.newModel,
+				Source:  SwitchEvaluation,
+				Reason:  result.reason,
+				Score:   &score,
+			}, m.node.Model)
+			switch {
+			case err != nil:
+				eval.Review += fmt.Sprintf(" (切换模型失败: %v)", err)
+			case !entry.Applied:
+				eval.Review += fmt.Sprintf(" (未切换到 %s: %s)", result.newModel, entry.Blocked)
+			default:
+				eval.NewModel = result.newModel
+				eval.Switched = true
+				switched++
+			}
+		}
+
+		if err := s.db.CreateAgentEvaluation(&eval); err != nil {
+			return evals, err
+		}
+		evals = append(evals, eval)
+	}
+
+	cycle.Summary = fmt.Sprintf("%s; 评估 %d 个智能体, 切换 %d 个模型", companyPerformance, len(members), switched)
+	return evals, nil
+}
+
+// datasetSignal 启用数据集评分时, 在候选模型上运行成员的评测数据集
+func (s *Service) datasetSignal(ctx context.Context, cfg PerformanceEvalConfig, member *OrgNode, models []string) (EvaluationResult, bool) {
+	if !cfg.UseDatasets {
+		return EvaluationResult{}, false
+	}
+	margin := cfg.ScoreMargin
+	if margin <= 0 {
+		margin = defaultScoreMargin
+	}
+	return s.datasetVerdict(ctx, member, models, margin)
+}
+
+// evalMembers 评估对象: 指定组织的全部成员, 或周期内有运行记录的智能体
+func (s *Service) evalMembers(rootID uint, from, to time.Time) ([]*evalMember, error) {
+	var members []*evalMember
+	if rootID != 0 {
+		org, err := s.LoadOrg(rootID)
+		if err != nil {
+			return nil, err
+		}
+		var walk func(node, leader *OrgNode)
+		walk = func(node, leader *OrgNode) {
+			members = append(members, &evalMember{node: node, leader: leader})
+			for _, child := range node.Children {
+				walk(child, node)
+			}
+		}
+		walk(org, nil)
+	} else {
+		ids, err := s.db.ActiveAgentIDs(from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			def, err := s.LoadAgent(id)
+			if err != nil {
+				log.Printf("[Eval] skip agent %d: %v", id, err)
+				continue
+			}
+			members = append(members, &evalMember{node: &OrgNode{AgentID: id, Name: def.Name, Role: RoleWorker, Model: def.Model, def: def}})
+		}
+		if err := s.resolveLeaders(members); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range members {
+		stats, err := s.db.AgentRunStats(m.node.AgentID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		m.stats = stats
+		if m.rating, err = s.db.AverageRating(m.node.AgentID, from, to); err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+// resolveLeaders 未指定组织时按上下级关系找到每个成员的上级作为评审人, 没有上级的成员才自评
+func (s *Service) resolveLeaders(members []*evalMember) error {
+	if s.memorySvc == nil {
+		return nil
+	}
+	nodes := make(map[uint]*OrgNode, len(members))
+	for _, m := range members {
+		nodes[m.node.AgentID] = m.node
+	}
+	for _, m := range members {
+		parentID, err := s.memorySvc.GetParentID(m.node.AgentID)
+		if err != nil {
+			return fmt.Errorf("load leader of agent %d: %w", m.node.AgentID, err)
+		}
+		if parentID == nil {
+			continue
+		}
+		// 上级在周期内可能没有运行记录, 不在成员列表里
+		leader, ok := nodes[*parentID]
+		if !ok {
+			def, err := s.LoadAgent(*parentID)
+			if err != nil {
+				log.Printf("[Eval] leader %d of agent %d not loaded, self review instead: %v", *parentID, m.node.AgentID, err)
+				continue
+			}
+			leader = &OrgNode{AgentID: *parentID, Name: def.Name, Model: def.Model, def: def}
+			nodes[*parentID] = leader
+		}
+		m.leader = leader
+	}
+	return nil
+}
+
+// switchAgentModel 把切换后的模型写回智能体配置
+func (s *Service) switchAgentModel(agentID uint, modelName string) error {
+	row, err := s.db.GetAgent(agentID)
+	if err != nil {
+		return err
+	}
+	row.ModelName = modelName
+	if s.modelSvc != nil {
+		if cfg, ok := s.modelSvc.GetModel(modelName); ok {
+			row.ModelProvider = string(cfg.Provider)
+		}
+	}
+	return s.db.UpdateAgent(row)
+}
+
+func aggregateStats(members []*evalMember) *store.RunStats {
+	total := &store.RunStats{}
+	var tokens, latency, steps float64
+	for _, m := range members {
+		n := float64(m.stats.Runs)
+		total.Runs += m.stats.Runs
+		total.Succeeded += m.stats.Succeeded
+		tokens += m.stats.AvgTokens * n
+		latency += m.stats.AvgLatencyMs * n
+		steps += m.stats.AvgSteps * n
+	}
+	if total.Runs > 0 {
+		n := float64(total.Runs)
+		total.SuccessRate = float64(total.Succeeded) / n
+		total.AvgTokens = tokens / n
+		total.AvgLatencyMs = latency / n
+		total.AvgSteps = steps / n
+	}
+	return total
+}
+
+func formatStats(who string, stats *store.RunStats) string {
+	return fmt.Sprintf("%s: 运行 %d 次, 成功率 %.0f%%, 平均 %.0f tokens, 平均耗时 %.1fs, 平均 %.1f 步",
+		who, stats.Runs, stats.SuccessRate*100, stats.AvgTokens, stats.AvgLatencyMs/1000, stats.AvgSteps)
+}
+
+// evaluateRoleWithSubordinateScore 评估角色表现（结合业绩和下属打分）
+func (s *Service) evaluateRoleWithSubordinateScore(ctx context.Context, models []string, currentModel, companyPerformance, goal string, subordinateScore int) EvaluationResult {
+	evalPrompt := fmt.Sprintf(`你作为公司的CEO，需要根据两个因素来决定是否更换AI模型：
+
+因素1 - 公司整体业绩:
+%s
+
+因素2 - 下属对你的平均打分: %d/100分
+(下属在执行你安排的任务后对你工作表现的评分)
+
+公司目标:
+%s
+
+当前使用的AI模型: %s
+
+评估规则：
+- 如果业绩不达标(<目标80%%)，即使下属打分高，也需要考虑更换模型
+- 如果下属打分低(<70分)，即使业绩达标，也需要更换模型
+- 如果两者都差(业绩<60%% 且 下属打分<60)，必须更换模型
+
+可选模型: %s
+`, companyPerformance, subordinateScore, goal, currentModel, strings.Join(models, ", "))
+
+	verdict, err := s.evaluate(ctx, currentModel, s.renderPrompt(prompt.Ref{Key: prompt.KeyCEOReview}, nil), evalPrompt)
+	if err != nil {
+		return EvaluationResult{shouldSwitch: false, newModel: currentModel, reason: "评估失败", score: subordinateScore}
+	}
+
+	result := toEvaluationResult(verdict, models, currentModel)
+
+	// 强制规则：下属打分太低必须换
+	if subordinateScore < 60 {
+		result.shouldSwitch = true
+		result.reason = fmt.Sprintf("下属打分过低(%d分)", subordinateScore)
+		if result.newModel == currentModel {
+			for _, m := range models {
+				if m != currentModel {
+					result.newModel = m
+					break
+				}
+			}
+		}
+		if result.newModel == currentModel {
+			result.shouldSwitch = false
+		}
+	}
+
+	return result
+}
+
+// EvaluationResult 评估结果
+type EvaluationResult struct {
+	shouldSwitch bool
+	newModel     string
+	reason       string
+	score        int
+}
+
+// evaluateRolePerformance 评估某个成员的表现（从公司业绩和成员KPI出发）, reviewerModel 为评估者使用的模型
+func (s *Service) evaluateRolePerformance(ctx context.Context, models []string, reviewerModel string, member *OrgNode, stats *store.RunStats, companyPerformance, goal string) EvaluationResult {
+	evalPrompt := fmt.Sprintf(`请评估公司中 %s (%s) 的工作表现和模型配置是否合适。
+
+公司整体业绩/成果:
+%s
+
+该成员的KPI:
+%s
+
+公司目标:
+%s
+
+请从以下角度评估:
+1. 该成员的工作是否对公司业绩有贡献?
+2. 当前使用的AI模型(%s)是否最适合当前任务?
+3. 是否需要更换模型来提升业绩?
+
+可选模型: %s
+`, member.Name, member.Role, companyPerformance, formatStats(member.Name, stats), goal, member.Model, strings.Join(models, ", "))
+
+	verdict, err := s.evaluate(ctx, reviewerModel, s.renderPrompt(prompt.Ref{Key: prompt.KeyLeaderPerf}, nil), evalPrompt)
+	if err != nil {
+		return EvaluationResult{shouldSwitch: false, newModel: member.Model, reason: "评估失败"}
+	}
+
+	return toEvaluationResult(verdict, models, member.Model)
+}
+
+// roleVerdict 绩效评估的结构化输出
+type roleVerdict struct {
+	Score            int    `json:"score" desc:"0-100分"`
+	ShouldSwitch     bool   `json:"should_switch" desc:"是否更换模型"`
+	Reason           string `json:"reason" desc:"一句话原因"`
+	RecommendedModel string `json:"recommended_model,omitempty" desc:"推荐的模型, 必须是可选模型之一"`
+}
+
+// evaluate 以结构化输出调用模型进行评估
+func (s *Service) evaluate(ctx context.Context, modelName, systemPrompt, prompt string) (roleVerdict, error) {
+	if s.modelSvc == nil {
+		return roleVerdict{}, fmt.Errorf("model service not initialized")
+	}
+	return model.Structured[roleVerdict](ctx, s.modelSvc, model.Request{
+		Model: modelName,
+		Messages: []model.Message{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: prompt},
+		},
+	})
+}
+
+// toEvaluationResult 转换评估结果, 推荐模型不在可选列表中时保持当前模型
+func toEvaluationResult(v roleVerdict, models []string, currentModel string) EvaluationResult {
+	result := EvaluationResult{
+		shouldSwitch: v.ShouldSwitch,
+		newModel:     currentModel,
+		reason:       v.Reason,
+		score:        v.Score,
+	}
+	if result.reason == "" {
+		result.reason = "保持当前模型"
+	}
+	if v.ShouldSwitch {
+		for _, m := range models {
+			if m == v.RecommendedModel {
+				result.newModel = m
+				break
+			}
+		}
+		if result.newModel == currentModel {
+			result.shouldSwitch = false
+		}
+	}
+	return result
+}
+
+// candidateModels 可切换的模型列表
+func (s *Service) candidateModels() []string {
+	if s.modelSvc == nil {
+		return nil
+	}
+	return s.modelSvc.GetAvailableModels()
+}
+
+// ========== 运行记录 ==========
+
+// recordRun 保存一次运行结果, 作为绩效KPI
+func (s *Service) recordRun(def *Definition, source string, started time.Time, result *RunResult, err error) {
+	if s.db == nil || def.ID == 0 {
+		return
+	}
+	run := &store.AgentRun{
+		AgentID:   def.ID,
+		Source:    source,
+		Model:     def.Model,
+		Success:   err == nil && result != nil && result.StopReason == StopFinal,
+		LatencyMs: time.Since(started).Milliseconds(),
+	}
+	if result != nil {
+		run.StopReason = result.StopReason
+		run.Steps = len(result.Steps)
+		run.Tokens = result.Tokens
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if err := s.db.CreateAgentRun(run); err != nil {
+		log.Printf("[Eval] record run of agent %d failed: %v", def.ID, err)
+	}
+}
+
+// leaderRating 下属对上级任务安排的评价
+type leaderRating struct {
+	Score   int    `json:"score" min:"0" max:"100" desc:"0-100分"`
+	Comment string `json:"comment" desc:"一句话评价"`
+}
+
+// rateLeader 下属完成子任务后对上级的任务安排打分
+func (s *Service) rateLeader(ctx context.Context, leader, member *OrgNode, task, output string) {
+	if s.db == nil || s.modelSvc == nil || leader.AgentID == 0 || member.AgentID == 0 {
+		return
+	}
+	rating, err := model.Structured[leaderRating](ctx, s.modelSvc, model.Request{
+		Model: member.Model,
+		Messages: []model.Message{
+			{Role: "system", Content: s.renderPrompt(prompt.Ref{Key: prompt.KeyRateLeader}, map[string]interface{}{"leader": leader.Name})},
+			{Role: "user", Content: fmt.Sprintf("上级安排:\n%s\n\n你的执行结果:\n%s", task, truncateRunes(output, 2000))},
+		},
+	})
+	if err != nil {
+		log.Printf("[Eval] agent %d rate leader %d failed: %v", member.AgentID, leader.AgentID, err)
+		return
+	}
+	if err := s.db.CreateAgentRating(&store.AgentRating{
+		RaterID: member.AgentID,
+		RateeID: leader.AgentID,
+		Score:   rating.Score,
+		Comment: rating.Comment,
+	}); err != nil {
+		log.Printf("[Eval] save rating failed: %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/store"
+)
+
+// StopBlocked 护栏拦截了输入或输出
+const StopBlocked = "guardrail"
+
+const defaultBlockMessage = "抱歉, 这个问题超出了我的服务范围, 无法回答。"
+
+// 护栏规则
+const (
+	RuleAllowTopics = "allow_topics"
+	RuleDenyTopics  = "deny_topics"
+	RulePII         = "pii"
+	RuleMaxLength   = "max_length"
+	RuleModeration  = "moderation"
+)
+
+// 护栏动作
+const (
+	ActionBlocked   = "blocked"
+	ActionRewritten = "rewritten"
+)
+
+// GuardrailPolicy 智能体的护栏策略 (ModelSettings.Guardrails)
+type GuardrailPolicy struct {
+	AllowTopics       []string               `json:"allow_topics,omitempty" desc:"允许的话题 (关键词或正则), 非空时输入必须命中其一"`
+	DenyTopics        []string               `json:"deny_topics,omitempty" desc:"禁止的话题 (关键词或正则), 输入或输出命中时拦截"`
+	RedactPII         bool                   `json:"redact_pii,omitempty" desc:"输入和输出中的个人信息脱敏"`
+	PIITypes          []string               `json:"pii_types,omitempty" desc:"脱敏类型: email, phone, id_card, bank_card, ip; 为空时全部"`
+	MaxResponseLength int                    `json:"max_response_length,omitempty" min:"0" desc:"回复最大字符数, 超出时截断"`
+	Disclaimer        string                 `json:"disclaimer,omitempty" desc:"追加在回复末尾的免责声明"`
+	Moderation        string                 `json:"moderation,omitempty" desc:"内容审核器, 如 keyword"`
+	ModerationConfig  map[string]interface{} `json:"moderation_config,omitempty" desc:"审核器参数"`
+	BlockMessage      string                 `json:"block_message,omitempty" desc:"拦截时的回复"`
+}
+
+// ========== 内容审核 ==========
+
+// ModerationResult 审核结果
+type ModerationResult struct {
+	Flagged  bool   `json:"flagged"`
+	Category string `json:"category,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Moderator 内容审核器 (可接入外部分类服务)
+type Moderator interface {
+	Name() string
+	Description() string
+	Moderate(ctx context.Context, text string, cfg map[string]interface{}) (ModerationResult, error)
+}
+
+// ModeratorRegistry 审核器注册表
+var ModeratorRegistry = map[string]Moderator{}
+
+// RegisterModerator 注册审核器
+func RegisterModerator(m Moderator) {
+	ModeratorRegistry[m.Name()] = m
+}
+
+// GetModerator 获取审核器
+func GetModerator(name string) Moderator {
+	return ModeratorRegistry[name]
+}
+
+// ListModerators 列出审核器 (按名称排序)
+func ListModerators() []Moderator {
+	moderators := make([]Moderator, 0, len(ModeratorRegistry))
+	for _, m := range ModeratorRegistry {
+		moderators = append(moderators, m)
+	}
+	sort.Slice(moderators, func(i, j int) bool { return moderators[i].Name() < moderators[j].Name() })
+	return moderators
+}
+
+func init() {
+	RegisterModerator(&KeywordModerator{})
+}
+
+// KeywordModerator 离线关键词/正则审核; config: categories (只启用部分内置分类), patterns ({分类: [正则]}, 追加自定义规则)
+type KeywordModerator struct{}
+
+// keywordCategories 内置分类规则
+var keywordCategories = map[string][]*regexp.Regexp{
+	"violence":  compileAll(`(?i)(制作|自制)(炸弹|炸药|枪支)`, `(?i)how to (make|build) (a )?(bomb|explosive|gun)`),
+	"self_harm": compileAll(`(自杀|自残)(方法|方式|教程)`, `(?i)(ways|how) to (kill myself|commit suicide)`),
+	"drugs":     compileAll(`(制作|合成|购买)(冰毒|海洛因|大麻|毒品)`, `(?i)(synthesi[sz]e|cook|buy) (meth|heroin|cocaine)`),
+	"fraud":     compileAll(`(洗钱|套现|刷单)(方法|教程|渠道)`, `(?i)how to launder money`),
+}
+
+func compileAll(patterns ...string) []*regexp.Regexp {
+	out := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		out[i] = regexp.MustCompile(p)
+	}
+	return out
+}
+
+func (m *KeywordModerator) Name() string { return "keyword" }
+func (m *KeywordModerator) Description() string {
+	return "离线关键词/正则审核, 参数: categories (启用的内置分类), patterns ({分类: [正则]})"
+}
+
+func (m *KeywordModerator) Moderate(ctx context.Context, text string, cfg map[string]interface{}) (ModerationResult, error) {
+	categories := map[string][]*regexp.Regexp{}
+	if list, ok := cfg["categories"].([]interface{}); ok {
+		for _, c := range list {
+			name := fmt.Sprint(c)
+			if rules, ok := keywordCategories[name]; ok {
+				categories[name] = rules
+			}
+		}
+	} else {
+		for name, rules := range keywordCategories {
+			categories[name] = rules
+		}
+	}
+	if custom, ok := cfg["patterns"].(map[string]interface{}); ok {
+		for name, v := range custom {
+			list, _ := v.([]interface{})
+			for _, p := range list {
+				re, err := cachedRegexp(fmt.Sprint(p))
+				if err != nil {
+					return ModerationResult{}, fmt.Errorf("pattern %q: %w", p, err)
+				}
+				categories[name] = append(categories[name], re)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, re := range categories[name] {
+			if match := re.FindString(text); match != "" {
+				return ModerationResult{Flagged: true, Category: name, Reason: fmt.Sprintf("命中 %s 规则: %s", name, match)}, nil
+			}
+		}
+	}
+	return ModerationResult{}, nil
+}
+
+var regexpCache sync.Map
+
+func cachedRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexpCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.Store(pattern, re)
+	return re, nil
+}
+
+// ========== 个人信息脱敏 ==========
+
+// piiRules 按顺序替换 (身份证号先于银行卡号)
+var piiRules = []struct {
+	kind        string
+	re          *regexp.Regexp
+	replacement string
+}{
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{"id_card", regexp.MustCompile(`\b\d{17}[\dXx]\b`), "[ID_CARD]"},
+	{"bank_card", regexp.MustCompile(`\b\d{16,19}\b`), "[BANK_CARD]"},
+	{"phone", regexp.MustCompile(`(?:\+86[- ]?|\b)1[3-9]\d{9}\b`), "[PHONE]"},
+	{"ip", regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), "[IP]"},
+}
+
+// ========== 护栏 ==========
+
+// guardrail 编译后的护栏策略
+type guardrail struct {
+	policy GuardrailPolicy
+	allow  []*regexp.Regexp
+	deny   []*regexp.Regexp
+	pii    map[string]bool
+}
+
+// GuardrailViolation 一次拦截或改写
+type GuardrailViolation struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// GuardrailCheck 护栏试运行结果
+type GuardrailCheck struct {
+	Text       string               `json:"text"` // 改写后的文本, 拦截时为拦截回复
+	Blocked    bool                 `json:"blocked"`
+	Violations []GuardrailViolation `json:"violations"`
+}
+
+// compileGuardrail 校验并编译护栏策略, 话题不区分大小写
+func compileGuardrail(p GuardrailPolicy) (*guardrail, []string) {
+	g := &guardrail{policy: p, pii: map[string]bool{}}
+	var problems []string
+	compile := func(field string, patterns []string) []*regexp.Regexp {
+		var out []*regexp.Regexp
+		for _, pattern := range patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("guardrails.%s: invalid pattern %q: %v", field, pattern, err))
+				continue
+			}
+			out = append(out, re)
+		}
+		return out
+	}
+	g.allow = compile(RuleAllowTopics, p.AllowTopics)
+	g.deny = compile(RuleDenyTopics, p.DenyTopics)
+
+	known := map[string]bool{}
+	for _, r := range piiRules {
+		known[r.kind] = true
+	}
+	for _, kind := range p.PIITypes {
+		if !known[kind] {
+			problems = append(problems, fmt.Sprintf("guardrails.pii_types: unknown type %q", kind))
+		}
+		g.pii[kind] = true
+	}
+	if p.Moderation != "" && GetModerator(p.Moderation) == nil {
+		problems = append(problems, fmt.Sprintf("guardrails.moderation: unknown moderator %q", p.Moderation))
+	}
+	return g, problems
+}
+
+func (g *guardrail) blockMessage() string {
+	if g.policy.BlockMessage != "" {
+		return g.policy.BlockMessage
+	}
+	return defaultBlockMessage
+}
+
+// checkInput 检查用户输入: 话题 → 审核 → 脱敏; blocked 不为nil时不再调用模型
+func (g *guardrail) checkInput(ctx context.Context, input string) (string, []GuardrailViolation, *GuardrailViolation) {
+	if len(g.allow) > 0 && matchAny(g.allow, input) == "" {
+		return input, nil, &GuardrailViolation{RuleAllowTopics, ActionBlocked, "输入不属于允许的话题"}
+	}
+	if hit := matchAny(g.deny, input); hit != "" {
+		return input, nil, &GuardrailViolation{RuleDenyTopics, ActionBlocked, "输入涉及禁止的话题: " + hit}
+	}
+	if v := g.moderate(ctx, input); v != nil {
+		return input, nil, v
+	}
+	input, rewrites := g.redact(input)
+	return input, rewrites, nil
+}
+
+// checkMessage 按 checkInput 检查用户消息的全部文本 (Content 和文本片段), 脱敏时逐段改写
+func (g *guardrail) checkMessage(ctx context.Context, msg model.Message) (model.Message, []GuardrailViolation, *GuardrailViolation) {
+	if _, _, blocked := g.checkInput(ctx, msg.Text()); blocked != nil {
+		return msg, nil, blocked
+	}
+	var rewrites []GuardrailViolation
+	msg.Content, rewrites = g.redact(msg.Content)
+	if len(msg.Parts) > 0 {
+		parts := make([]model.ContentPart, len(msg.Parts))
+		copy(parts, msg.Parts)
+		for i := range parts {
+			if parts[i].Type != model.PartText {
+				continue
+			}
+			text, rw := g.redact(parts[i].Text)
+			parts[i].Text = text
+			rewrites = append(rewrites, rw...)
+		}
+		msg.Parts = parts
+	}
+	return msg, rewrites, nil
+}
+
+// checkOutput 检查模型回复: 话题 → 审核 → 脱敏 → 长度 → 免责声明
+func (g *guardrail) checkOutput(ctx context.Context, output string) (string, []GuardrailViolation, *GuardrailViolation) {
+	if hit := matchAny(g.deny, output); hit != "" {
+		return output, nil, &GuardrailViolation{RuleDenyTopics, ActionBlocked, "回复涉及禁止的话题: " + hit}
+	}
+	if v := g.moderate(ctx, output); v != nil {
+		return output, nil, v
+	}
+	output, rewrites := g.redact(output)
+	if max := g.policy.MaxResponseLength; max > 0 && utf8.RuneCountInString(output) > max {
+		rewrites = append(rewrites, GuardrailViolation{RuleMaxLength, ActionRewritten, fmt.Sprintf("回复 %d 字符, 截断为 %d", utf8.RuneCountInString(output), max)})
+		output = string([]rune(output)[:max]) + "..."
+	}
+	return g.withDisclaimer(output), rewrites, nil
+}
+
+func (g *guardrail) withDisclaimer(output string) string {
+	d := strings.TrimSpace(g.policy.Disclaimer)
+	if d == "" || strings.Contains(output, d) {
+		return output
+	}
+	return output + "\n\n" + d
+}
+
+// moderate 调用审核器; 审核器出错时按拦截处理
+func (g *guardrail) moderate(ctx context.Context, text string) *GuardrailViolation {
+	if g.policy.Moderation == "" {
+		return nil
+	}
+	res, err := GetModerator(g.policy.Moderation).Moderate(ctx, text, g.policy.ModerationConfig)
+	if err != nil {
+		return &GuardrailViolation{RuleModeration, ActionBlocked, fmt.Sprintf("审核失败: %v", err)}
+	}
+	if !res.Flagged {
+		return nil
+	}
+	reason := res.Reason
+	if reason == "" {
+		reason = "命中审核分类 " + res.Category
+	}
+	return &GuardrailViolation{RuleModeration, ActionBlocked, reason}
+}
+
+// redact 个人信息脱敏, 每种类型记录一次改写
+func (g *guardrail) redact(text string) (string, []GuardrailViolation) {
+	if !g.policy.RedactPII {
+		return text, nil
+	}
+	var rewrites []GuardrailViolation
+	for _, r := range piiRules {
+		if len(g.pii) > 0 && !g.pii[r.kind] {
+			continue
+		}
+		n := len(r.re.FindAllStringIndex(text, -1))
+		if n == 0 {
+			continue
+		}
+		text = r.re.ReplaceAllString(text, r.replacement)
+		rewrites = append(rewrites, GuardrailViolation{RulePII, ActionRewritten, fmt.Sprintf("脱敏 %d 处 %s", n, r.kind)})
+	}
+	return text, rewrites
+}
+
+func matchAny(patterns []*regexp.Regexp, text string) string {
+	for _, re := range patterns {
+		if re.MatchString(text) {
+			return re.String()[len("(?i)"):]
+		}
+	}
+	return ""
+}
+
+// logGuardrail 记录护栏拦截/改写
+func (s *Service) logGuardrail(def *Definition, source, stage string, violations ...GuardrailViolation) {
+	for _, v := range violations {
+		log.Printf("[Guardrail] agent %d (%s) %s %s by %s: %s", def.ID, source, stage, v.Action, v.Rule, v.Reason)
+		if s.db == nil {
+			continue
+		}
+		if err := s.db.CreateGuardrailEvent(&store.GuardrailEvent{
+			AgentID: def.ID,
+			Source:  source,
+			Stage:   stage,
+			Rule:    v.Rule,
+			Action:  v.Action,
+			Reason:  v.Reason,
+		}); err != nil {
+			log.Printf("[Guardrail] save event: %v", err)
+		}
+	}
+}
+
+// CheckGuardrails 不调用模型, 按智能体的护栏策略检查一段输入或回复 (stage: input/output)
+func (s *Service) CheckGuardrails(ctx context.Context, def *Definition, stage, text string) (*GuardrailCheck, error) {
+	check := &GuardrailCheck{Text: text, Violations: []GuardrailViolation{}}
+	if def.guard == nil {
+		return check, nil
+	}
+
+	var rewrites []GuardrailViolation
+	var blocked *GuardrailViolation
+	switch stage {
+	case "input":
+		check.Text, rewrites, blocked = def.guard.checkInput(ctx, text)
+	case "output":
+		check.Text, rewrites, blocked = def.guard.checkOutput(ctx, text)
+	default:
+		return nil, fmt.Errorf("unknown stage %q", stage)
+	}
+	check.Violations = append(check.Violations, rewrites...)
+	if blocked != nil {
+		check.Blocked = true
+		check.Text = def.guard.blockMessage()
+		check.Violations = append(check.Violations, *blocked)
+	}
+	return check, nil
+}
@@ -0,0 +1,451 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"agent-flow/internal/memory"
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+)
+
+// 组织角色 (按在层级中的位置确定: 根节点为CEO, 叶子为Worker, 其余为Manager)
+const (
+	RoleCEO     = "ceo"
+	RoleManager = "manager"
+	RoleWorker  = "worker"
+)
+
+const (
+	defaultMaxParallel = 4
+	maxOrgDepth        = 8
+)
+
+// OrgNode 协作组织中的一个成员
+type OrgNode struct {
+	AgentID  uint       `json:"agent_id"` // 默认链路中为0
+	Name     string     `json:"name"`
+	Role     string     `json:"role"`
+	Model    string     `json:"model"`
+	Children []*OrgNode `json:"children,omitempty"`
+
+	def    *Definition
+	custom bool // 智能体绑定了自己的提示词, 角色提示词追加在其后
+}
+
+// Subtask 上级分配给下属的子任务
+type Subtask struct {
+	Assignee     int    `json:"assignee" desc:"执行者编号, 见下属列表"`
+	Title        string `json:"title" desc:"子任务标题"`
+	Instructions string `json:"instructions" desc:"具体要求和验收标准"`
+}
+
+// taskPlan 上级拆分任务的结构化输出
+type taskPlan struct {
+	Analysis string    `json:"analysis" desc:"对任务的分析"`
+	Subtasks []Subtask `json:"subtasks" desc:"分配给下属的子任务, 每个子任务指定一名下属"`
+}
+
+// TaskReport 成员对一项任务的处理结果, 包含下属的结果
+type TaskReport struct {
+	AgentID   uint          `json:"agent_id"`
+	Name      string        `json:"name"`
+	Role      string        `json:"role"`
+	Model     string        `json:"model"`
+	Title     string        `json:"title,omitempty"` // 子任务标题
+	Task      string        `json:"task"`
+	Analysis  string        `json:"analysis,omitempty"`
+	Output    string        `json:"output"`
+	Error     string        `json:"error,omitempty"`
+	NextModel string        `json:"next_model,omitempty"` // 领导根据业绩更换的模型 (下次协作生效)
+	Subtasks  []*TaskReport `json:"subtasks,omitempty"`
+}
+
+// CollaborationResult 多智能体协作结果
+type CollaborationResult struct {
+	Output string      `json:"output"`
+	Org    *OrgNode    `json:"org"`
+	Report *TaskReport `json:"report"`
+}
+
+// LoadOrg 从 memory.AgentRelationship 构建以 rootID 为根的组织结构 (manage/delegate 关系)
+func (s *Service) LoadOrg(rootID uint) (*OrgNode, error) {
+	if s.memorySvc == nil {
+		return nil, fmt.Errorf("memory service not initialized")
+	}
+	return s.buildOrg(rootID, s.memorySvc.GetChildRelationships)
+}
+
+// buildOrg 按关系记录递归加载成员, 拒绝环和过深的层级
+func (s *Service) buildOrg(rootID uint, children func(uint) ([]memory.AgentRelationship, error)) (*OrgNode, error) {
+	visited := map[uint]bool{}
+
+	var build func(id uint, depth int) (*OrgNode, error)
+	build = func(id uint, depth int) (*OrgNode, error) {
+		if visited[id] {
+			return nil, fmt.Errorf("org: agent %d appears twice in the hierarchy", id)
+		}
+		if depth > maxOrgDepth {
+			return nil, fmt.Errorf("org: hierarchy deeper than %d levels", maxOrgDepth)
+		}
+		visited[id] = true
+
+		def, err := s.LoadAgent(id)
+		if err != nil {
+			return nil, err
+		}
+		node := &OrgNode{
+			AgentID: id,
+			Name:    def.Name,
+			Model:   def.Model,
+			def:     def,
+			custom:  def.Prompt != nil || def.Settings.SystemPrompt != "",
+		}
+
+		rels, err := children(id)
+		if err != nil {
+			return nil, fmt.Errorf("org: load subordinates of agent %d: %w", id, err)
+		}
+		for _, rel := range rels {
+			if rel.RelationType == "collaborate" {
+				continue
+			}
+			child, err := build(rel.ChildID, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+	}
+
+	root, err := build(rootID, 0)
+	if err != nil {
+		return nil, err
+	}
+	assignRoles(root, true)
+	return root, nil
+}
+
+// defaultOrg 未配置组织时使用的 CEO → Manager → Worker 链路
+func defaultOrg(cfg Config) *OrgNode {
+	member := func(name, role string) *OrgNode {
+		def := &Definition{Name: name, Model: cfg.Model}
+		def.Settings.MaxTokens = cfg.MaxTokens
+		def.Settings.Locale = cfg.Locale
+		if cfg.Temperature > 0 {
+			t := cfg.Temperature
+			def.Settings.Temperature = &t
+		}
+		return &OrgNode{Name: name, Role: role, Model: cfg.Model, def: def}
+	}
+	worker := member("Worker", RoleWorker)
+	manager := member("Manager", RoleManager)
+	manager.Children = []*OrgNode{worker}
+	ceo := member("CEO", RoleCEO)
+	ceo.Children = []*OrgNode{manager}
+	return ceo
+}
+
+func assignRoles(node *OrgNode, root bool) {
+	switch {
+	case root:
+		node.Role = RoleCEO
+	case len(node.Children) == 0:
+		node.Role = RoleWorker
+	default:
+		node.Role = RoleManager
+	}
+	for _, child := range node.Children {
+		assignRoles(child, false)
+	}
+}
+
+// Collaborate 按组织结构协作处理任务: 上级拆分子任务并指派给下属, 下属并行执行, 结果逐级汇总给CEO
+func (s *Service) Collaborate(ctx context.Context, cfg Config, input string) (*CollaborationResult, error) {
+	if s.modelSvc == nil {
+		return nil, fmt.Errorf("model service not initialized")
+	}
+
+	org := defaultOrg(cfg)
+	if cfg.OrgRootID != 0 {
+		var err error
+		if org, err = s.LoadOrg(cfg.OrgRootID); err != nil {
+			return nil, err
+		}
+	}
+	s.fillModels(org, cfg.Model)
+
+	models := cfg.AvailableModels
+	if len(models) == 0 {
+		models = s.candidateModels()
+	}
+	// 下级投票决定是否更换 CEO 模型
+	if cfg.EnableModelVote {
+		s.voteLeader(ctx, models, org)
+	}
+
+	c := &collaboration{svc: s, cfg: cfg, models: models, sem: make(chan struct{}, maxParallel(cfg))}
+	report := c.handle(ctx, org, input)
+	if report.Error != "" {
+		return nil, fmt.Errorf("%s(%s) 处理失败: %s", org.Name, org.Role, report.Error)
+	}
+	return &CollaborationResult{Output: report.Output, Org: org, Report: report}, nil
+}
+
+// fillModels 成员的模型: 已分配的模型 > 智能体配置 > cfg.Model
+func (s *Service) fillModels(node *OrgNode, fallback string) {
+	if node.AgentID == 0 {
+		node.Model = s.AssignedModel(RoleSubject(node.Role), node.Model)
+	}
+	if node.Model == "" {
+		node.Model = fallback
+	}
+	for _, child := range node.Children {
+		s.fillModels(child, fallback)
+	}
+}
+
+// voteLeader 直属下级投票, 得票最多的模型按切换限制生效
+func (s *Service) voteLeader(ctx context.Context, models []string, leader *OrgNode) {
+	winner, votes := s.voteForModel(ctx, models, leader, leader.Children)
+	if winner == leader.Model {
+		return
+	}
+	count := 0
+	for _, v := range votes {
+		if v.Model == winner {
+			count++
+		}
+	}
+	entry, err := s.ProposeSwitch(SwitchRequest{
+		Subject: nodeSubject(leader),
+		To:      winner,
+		Source:  SwitchVote,
+		Reason:  fmt.Sprintf("下级投票 %d/%d 票", count, len(votes)),
+		Votes:   votes,
+	}, leader.Model)
+	if err != nil {
+		log.Printf("[Agent] vote for %s: %v", leader.Name, err)
+		return
+	}
+	if entry.Applied {
+		leader.Model = winner
+	}
+}
+
+func maxParallel(cfg Config) int {
+	if cfg.MaxParallel > 0 {
+		return cfg.MaxParallel
+	}
+	return defaultMaxParallel
+}
+
+// collaboration 一次协作的运行状态
+type collaboration struct {
+	svc    *Service
+	cfg    Config
+	models []string
+	sem    chan struct{} // 限制同时执行的叶子任务数
+}
+
+// handle 成员处理一项任务: 有下属时拆分并汇总, 否则直接执行
+func (c *collaboration) handle(ctx context.Context, node *OrgNode, task string) *TaskReport {
+	report := &TaskReport{AgentID: node.AgentID, Name: node.Name, Role: node.Role, Model: node.Model, Task: task}
+	if len(node.Children) == 0 {
+		c.execute(ctx, node, task, report)
+		return report
+	}
+
+	plan, err := c.plan(ctx, node, task)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Analysis = plan.Analysis
+
+	// 子任务并行执行
+	report.Subtasks = make([]*TaskReport, len(plan.Subtasks))
+	var wg sync.WaitGroup
+	for i, sub := range plan.Subtasks {
+		wg.Add(1)
+		go func(i int, sub Subtask) {
+			defer wg.Done()
+			child := node.Children[sub.Assignee-1]
+			r := c.handle(ctx, child, formatSubtask(task, sub))
+			r.Title = sub.Title
+			if c.cfg.EnableModelVote && r.Error == "" {
+				// 下属对任务安排打分, 作为上级绩效评估的依据
+				c.svc.rateLeader(ctx, node, child, r.Task, r.Output)
+			}
+			if c.cfg.EnablePerfSwitch && r.Error == "" {
+				// 领导根据业绩决定是否更换下属模型 (下次协作生效)
+				r.NextModel = c.svc.evaluateAndSwitchModel(ctx, c.models, node, child, r.Output)
+			}
+			report.Subtasks[i] = r
+		}(i, sub)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range report.Subtasks {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if failed == len(report.Subtasks) {
+		report.Error = "所有子任务均失败"
+		return report
+	}
+
+	c.summarize(ctx, node, task, report)
+	return report
+}
+
+// plan 上级分析任务并拆分为指派给具体下属的子任务
+func (c *collaboration) plan(ctx context.Context, node *OrgNode, task string) (*taskPlan, error) {
+	key := prompt.KeyManager
+	if node.Role == RoleCEO {
+		key = prompt.KeyCEO
+	}
+
+	var team strings.Builder
+	assignees := make([]interface{}, len(node.Children))
+	for i, child := range node.Children {
+		assignees[i] = i + 1
+		fmt.Fprintf(&team, "%d. %s (%s)\n", i+1, child.Name, child.Role)
+	}
+	user := fmt.Sprintf("任务:\n%s\n\n下属列表:\n%s\n请分析任务并拆分为子任务, 每个子任务用编号指定一名下属执行。可以给同一名下属分配多个子任务, 不需要的下属可以不分配。", task, team.String())
+
+	schema := model.SchemaOf(taskPlan{})
+	items := schema["properties"].(map[string]interface{})["subtasks"].(map[string]interface{})["items"].(map[string]interface{})
+	items["properties"].(map[string]interface{})["assignee"].(map[string]interface{})["enum"] = assignees
+
+	req := c.svc.BuildRequest(node.def, []model.Message{
+		{Role: "system", Content: c.memberPrompt(ctx, node, key, task)},
+		{Role: "user", Content: user},
+	})
+	req.Model = node.Model
+	req.ResponseFormat = &model.ResponseFormat{Name: "task_plan", Schema: schema}
+
+	var plan taskPlan
+	if _, err := c.svc.modelSvc.CompleteJSON(ctx, req, &plan); err != nil {
+		return nil, fmt.Errorf("拆分任务失败: %w", err)
+	}
+	for i := range plan.Subtasks {
+		if a := plan.Subtasks[i].Assignee; a < 1 || a > len(node.Children) {
+			plan.Subtasks[i].Assignee = i%len(node.Children) + 1
+		}
+	}
+	if len(plan.Subtasks) == 0 {
+		// 没有拆分时整体交给第一位下属
+		plan.Subtasks = []Subtask{{Assignee: 1, Title: "完成任务", Instructions: task}}
+	}
+	return &plan, nil
+}
+
+// execute 执行者完成任务 (智能体配置了工具时进入 ReAct 循环)
+func (c *collaboration) execute(ctx context.Context, node *OrgNode, task string, report *TaskReport) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+
+	def := *node.def
+	def.Model = node.Model
+	result, err := c.svc.runAs(ctx, &def, "collaboration", c.memberPrompt(ctx, node, prompt.KeyWorker, task), userMessage(task))
+	if err != nil {
+		report.Error = err.Error()
+		return
+	}
+	report.Output = result.Output
+}
+
+// summarize 上级审核下属结果并汇总: CEO给出最终答案, Manager向上汇报
+func (c *collaboration) summarize(ctx context.Context, node *OrgNode, task string, report *TaskReport) {
+	key := prompt.KeyManagerReport
+	if node.Role == RoleCEO {
+		key = prompt.KeyCEOFinal
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "任务:\n%s\n\n你的分析:\n%s\n\n下属提交的结果:\n", task, report.Analysis)
+	for i, r := range report.Subtasks {
+		fmt.Fprintf(&b, "\n[%d] %s (%s)\n", i+1, r.Name, r.Role)
+		if r.Error != "" {
+			fmt.Fprintf(&b, "执行失败: %s\n", r.Error)
+			continue
+		}
+		fmt.Fprintf(&b, "%s\n", r.Output)
+	}
+
+	def := *node.def
+	def.Model = node.Model
+	def.Tools = nil
+	result, err := c.svc.runAs(ctx, &def, "collaboration", c.memberPrompt(ctx, node, key, task), userMessage(b.String()))
+	if err != nil {
+		report.Error = fmt.Sprintf("汇总失败: %v", err)
+		return
+	}
+	report.Output = result.Output
+}
+
+// memberPrompt 成员在某一环节的系统提示词: 智能体自己的提示词 (如有) + 角色提示词
+func (c *collaboration) memberPrompt(ctx context.Context, node *OrgNode, key, task string) string {
+	var role string
+	switch key {
+	case prompt.KeyCEO:
+		role = c.svc.rolePrompt(c.cfg, c.cfg.CEOPromptRef, c.cfg.CEOPrompt, key)
+	case prompt.KeyManager:
+		role = c.svc.rolePrompt(c.cfg, c.cfg.ManagerPromptRef, c.cfg.ManagerPrompt, key)
+	case prompt.KeyWorker:
+		role = c.svc.rolePrompt(c.cfg, c.cfg.WorkerPromptRef, c.cfg.WorkerPrompt, key)
+	case prompt.KeyCEOFinal:
+		role = c.svc.rolePrompt(c.cfg, c.cfg.CEOFinalPromptRef, "", key)
+	default:
+		role = c.svc.rolePrompt(c.cfg, nil, "", key)
+	}
+	if !node.custom {
+		return role
+	}
+	return c.svc.SystemPrompt(node.def, c.svc.GetContextForAgent(ctx, node.AgentID, task)) + "\n\n" + role
+}
+
+func formatSubtask(task string, sub Subtask) string {
+	return fmt.Sprintf("上级任务:\n%s\n\n你的子任务: %s\n%s", task, sub.Title, sub.Instructions)
+}
+
+// FormatReport 把协作结果整理为文本 (模型状态 / 分工 / 执行结果 / 最终方案)
+func (r *CollaborationResult) FormatReport() string {
+	var b strings.Builder
+	b.WriteString("【模型状态】\n")
+	writeOrg(&b, r.Org, 0)
+	b.WriteString("\n【任务分工】\n")
+	writeReport(&b, r.Report, 0)
+	b.WriteString("\n【最终方案】\n")
+	b.WriteString(r.Output)
+	return b.String()
+}
+
+func writeOrg(b *strings.Builder, node *OrgNode, depth int) {
+	fmt.Fprintf(b, "%s%s (%s): %s\n", strings.Repeat("  ", depth), node.Name, node.Role, node.Model)
+	for _, child := range node.Children {
+		writeOrg(b, child, depth+1)
+	}
+}
+
+func writeReport(b *strings.Builder, r *TaskReport, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, sub := range r.Subtasks {
+		status := "完成"
+		if sub.Error != "" {
+			status = "失败: " + sub.Error
+		}
+		fmt.Fprintf(b, "%s- %s → %s [%s]\n", indent, sub.Title, sub.Name, status)
+		if sub.NextModel != "" {
+			fmt.Fprintf(b, "%s  模型已更换: %s → %s (下次生效)\n", indent, sub.Model, sub.NextModel)
+		}
+		writeReport(b, sub, depth+1)
+	}
+}
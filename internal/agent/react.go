@@ -0,0 +1,353 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"agent-flow/internal/logs"
+	"agent-flow/internal/model"
+	"agent-flow/internal/tools"
+)
+
+// 工具循环默认限制
+const (
+	defaultMaxSteps    = 8
+	defaultTokenBudget = 32000
+	maxObservationLen  = 4000 // 单次工具输出回填给模型的最大字符数
+	actionFinal        = "final"
+)
+
+// 停止原因
+const (
+	StopFinal       = "final"        // 模型给出最终答案
+	StopMaxSteps    = "max_steps"    // 达到步数上限
+	StopTokenBudget = "token_budget" // 超出token预算
+)
+
+// RunResult 一次智能体运行的结果和轨迹
+type RunResult struct {
+	Output     string         `json:"output"`
+	StopReason string         `json:"stop_reason"`
+	Steps      []logs.StepLog `json:"steps"`  // thought / action / observation
+	Tokens     int            `json:"tokens"` // 估算消耗的token
+	LogID      string         `json:"log_id,omitempty"`
+}
+
+// reactStep 模型每一步的结构化输出
+type reactStep struct {
+	Thought     string                 `json:"thought" desc:"当前的思考"`
+	Action      string                 `json:"action" desc:"要调用的工具名, 已能回答时为 final"`
+	ActionInput map[string]interface{} `json:"action_input,omitempty" desc:"工具参数"`
+	FinalAnswer string                 `json:"final_answer,omitempty" desc:"action为final时的最终答案"`
+}
+
+// UseLogs 记录工具循环的步骤日志
+func (s *Service) UseLogs(logSvc *logs.Service) {
+	s.logSvc = logSvc
+}
+
+// RunWithTrace 以智能体身份处理输入并返回执行轨迹; 配置了工具时进入 ReAct 循环
+func (s *Service) RunWithTrace(ctx context.Context, def *Definition, input string) (*RunResult, error) {
+	return s.RunMessage(ctx, def, userMessage(input))
+}
+
+// RunMessage 同 RunWithTrace, 用户消息可带图片/文件等多模态片段 (渠道入站消息)
+func (s *Service) RunMessage(ctx context.Context, def *Definition, msg model.Message) (*RunResult, error) {
+	if s.modelSvc == nil {
+		return nil, fmt.Errorf("model service not initialized")
+	}
+
+	contextInfo := s.GetContextForAgent(ctx, def.ID, msg.Content)
+	return s.runAs(ctx, def, "run", s.SystemPrompt(def, contextInfo), msg)
+}
+
+// userMessage 纯文本用户消息
+func userMessage(input string) model.Message {
+	return model.Message{Role: "user", Content: input}
+}
+
+// runAs 以给定的系统提示词运行智能体 (协作中按角色指定提示词), source 记录在运行结果中
+func (s *Service) runAs(ctx context.Context, def *Definition, source, system string, user model.Message) (*RunResult, error) {
+	started := time.Now()
+	result, err := s.guardedRun(ctx, def, source, system, user)
+	if source != sourceEval {
+		s.recordRun(def, source, started, result, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.memorySvc != nil && def.ID != 0 && source != sourceEval && result.StopReason != StopBlocked {
+		input := user.Content
+		if def.guard != nil {
+			input, _ = def.guard.redact(input) // 记忆中不保存个人信息
+		}
+		_ = s.memorySvc.AddActionMemory(def.ID, nil, input, result.Output)
+	}
+	return result, nil
+}
+
+// guardedRun 按智能体的护栏策略检查输入和输出, 所有运行路径 (接口/渠道/流程/协作/任务) 都经过这里
+func (s *Service) guardedRun(ctx context.Context, def *Definition, source, system string, user model.Message) (*RunResult, error) {
+	run := s.runPlain
+	if len(def.Tools) > 0 {
+		run = s.runLoop
+	}
+	g := def.guard
+	if g == nil {
+		return run(ctx, def, system, user)
+	}
+
+	// 渠道消息的文字可能在文本片段中, 一并检查和脱敏
+	user, rewrites, blocked := g.checkMessage(ctx, user)
+	s.logGuardrail(def, source, "input", rewrites...)
+	if blocked != nil {
+		s.logGuardrail(def, source, "input", *blocked)
+		return &RunResult{Output: g.blockMessage(), StopReason: StopBlocked}, nil
+	}
+
+	result, err := run(ctx, def, system, user)
+	if err != nil {
+		return nil, err
+	}
+	output, rewrites, blocked := g.checkOutput(ctx, result.Output)
+	s.logGuardrail(def, source, "output", rewrites...)
+	if blocked != nil {
+		s.logGuardrail(def, source, "output", *blocked)
+		result.Output = g.blockMessage()
+		result.StopReason = StopBlocked
+		return result, nil
+	}
+	result.Output = output
+	return result, nil
+}
+
+// runPlain 无工具时单次调用
+func (s *Service) runPlain(ctx context.Context, def *Definition, system string, user model.Message) (*RunResult, error) {
+	messages := []model.Message{
+		{Role: "system", Content: system},
+		user,
+	}
+	resp, err := s.modelSvc.Complete(ctx, s.BuildRequest(def, messages))
+	if err != nil {
+		return nil, fmt.Errorf("agent %s: %w", def.Name, err)
+	}
+	return &RunResult{
+		Output:     resp.Content,
+		StopReason: StopFinal,
+		Tokens:     model.CountMessagesTokens(messages) + model.CountTokens(resp.Content),
+	}, nil
+}
+
+// runLoop ReAct 循环: 思考 → 调用工具 → 观察结果, 直到给出最终答案或达到限制
+func (s *Service) runLoop(ctx context.Context, def *Definition, system string, user model.Message) (*RunResult, error) {
+	maxSteps := def.Settings.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxSteps
+	}
+	budget := def.Settings.TokenBudget
+	if budget <= 0 {
+		budget = defaultTokenBudget
+	}
+
+	trace := newTracer(s.logSvc, def, user.Content)
+	result := &RunResult{LogID: trace.logID}
+
+	schema := model.SchemaOf(reactStep{})
+	schema["properties"].(map[string]interface{})["action"].(map[string]interface{})["enum"] = actionEnum(def.Tools)
+
+	messages := []model.Message{
+		{Role: "system", Content: system + "\n\n" + toolInstructions(def.Tools)},
+		user,
+	}
+
+	for step := 1; step <= maxSteps; step++ {
+		if result.Tokens+model.CountMessagesTokens(messages) > budget {
+			result.StopReason = StopTokenBudget
+			break
+		}
+
+		req := s.BuildRequest(def, messages)
+		req.ResponseFormat = &model.ResponseFormat{Name: "react_step", Schema: schema}
+		req.Cache = nil // 每一步依赖工具的实时结果, 不走缓存
+
+		var out reactStep
+		resp, err := s.modelSvc.CompleteJSON(ctx, req, &out)
+		if err != nil {
+			trace.finish(result, err)
+			return nil, fmt.Errorf("agent %s step %d: %w", def.Name, step, err)
+		}
+		result.Tokens += model.CountMessagesTokens(messages) + model.CountTokens(resp.Content)
+
+		trace.record(result, step, "thought", map[string]interface{}{"thought": out.Thought}, nil, nil)
+
+		if out.Action == actionFinal || out.Action == "" {
+			result.Output = out.FinalAnswer
+			if result.Output == "" {
+				result.Output = out.Thought
+			}
+			result.StopReason = StopFinal
+			trace.finish(result, nil)
+			return result, nil
+		}
+
+		observation, toolErr := s.callTool(ctx, def, out.Action, out.ActionInput)
+		trace.record(result, step, "action", map[string]interface{}{"tool": out.Action, "input": out.ActionInput}, nil, nil)
+		trace.record(result, step, "observation", map[string]interface{}{"tool": out.Action}, map[string]interface{}{"output": observation}, toolErr)
+
+		messages = append(messages,
+			model.Message{Role: "assistant", Content: resp.Content},
+			model.Message{Role: "user", Content: "Observation: " + observation},
+		)
+	}
+
+	if result.StopReason == "" {
+		result.StopReason = StopMaxSteps
+	}
+
+	// 达到步数上限时, 在预算允许的情况下让模型基于已有观察直接作答
+	final := "已达到执行限制, 未能得到最终答案。"
+	if result.StopReason == StopMaxSteps && result.Tokens+model.CountMessagesTokens(messages) <= budget {
+		messages = append(messages, model.Message{Role: "user", Content: "已达到工具调用次数上限。请不要再调用工具, 直接根据以上信息给出最终答案。"})
+		resp, err := s.modelSvc.Complete(ctx, s.BuildRequest(def, messages))
+		if err == nil {
+			final = resp.Content
+			result.Tokens += model.CountMessagesTokens(messages) + model.CountTokens(resp.Content)
+		}
+	}
+	result.Output = final
+	trace.finish(result, nil)
+	return result, nil
+}
+
+// callTool 执行工具 (只允许智能体配置中的工具), 返回回填给模型的观察结果
+func (s *Service) callTool(ctx context.Context, def *Definition, name string, input map[string]interface{}) (string, error) {
+	allowed := false
+	for _, t := range def.Tools {
+		if t == name {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		err := fmt.Errorf("tool %q is not allowed for this agent", name)
+		return "error: " + err.Error(), err
+	}
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+
+	res := tools.ExecuteTool(ctx, name, input)
+	if res.Error != "" {
+		return "error: " + res.Error, fmt.Errorf("%s", res.Error)
+	}
+	return truncateRunes(res.Output, maxObservationLen), nil
+}
+
+// toolInstructions 工具说明和输出格式
+func toolInstructions(names []string) string {
+	var b strings.Builder
+	b.WriteString("你可以使用以下工具。每一步只输出一个JSON对象: thought 写思考, action 写工具名并在 action_input 中给出参数; ")
+	b.WriteString("已经可以回答时 action 写 final 并在 final_answer 中给出最终答案。工具结果会以 Observation 的形式返回给你。\n\n可用工具:\n")
+	for _, name := range names {
+		t := tools.GetTool(name)
+		if t == nil {
+			continue
+		}
+		schema := t.Schema()
+		fmt.Fprintf(&b, "- %s: %s\n", name, t.Description())
+
+		params := make([]string, 0, len(schema.Parameters))
+		for p := range schema.Parameters {
+			params = append(params, p)
+		}
+		sort.Strings(params)
+		for _, p := range params {
+			param := schema.Parameters[p]
+			required := ""
+			if param.Required {
+				required = ", 必填"
+			}
+			fmt.Fprintf(&b, "    - %s (%s%s): %s\n", p, param.Type, required, param.Description)
+		}
+	}
+	return b.String()
+}
+
+func actionEnum(names []string) []interface{} {
+	values := make([]interface{}, 0, len(names)+1)
+	for _, n := range names {
+		values = append(values, n)
+	}
+	return append(values, actionFinal)
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "...(已截断)"
+}
+
+// ========== 步骤轨迹 ==========
+
+// tracer 把每一步写入 RunResult.Steps, 并同步到执行日志服务 (已配置时)
+type tracer struct {
+	logSvc *logs.Service
+	logID  string
+	agent  string
+}
+
+func newTracer(logSvc *logs.Service, def *Definition, input string) *tracer {
+	t := &tracer{logSvc: logSvc, agent: fmt.Sprint(def.ID)}
+	if logSvc != nil {
+		t.logID = logSvc.StartExecution("agent:"+t.agent, def.Name, "agent", input).ID
+	}
+	return t
+}
+
+// record 记录一步 (thought / action / observation)
+func (t *tracer) record(result *RunResult, step int, kind string, input, output map[string]interface{}, err error) {
+	now := time.Now()
+	status := "success"
+	if err != nil {
+		status = "failed"
+	}
+	entry := logs.StepLog{
+		ID:        fmt.Sprintf("%d-%s", step, kind),
+		NodeID:    t.agent,
+		NodeName:  fmt.Sprintf("step %d", step),
+		NodeType:  kind,
+		StartedAt: now,
+		EndedAt:   &now,
+		Status:    status,
+		Input:     input,
+		Output:    output,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	result.Steps = append(result.Steps, entry)
+
+	if t.logSvc != nil {
+		t.logSvc.AddStep(t.logID, entry.ID, entry.NodeID, entry.NodeName, kind, input)
+		t.logSvc.EndStep(t.logID, entry.ID, status, output, err)
+	}
+}
+
+func (t *tracer) finish(result *RunResult, err error) {
+	if t.logSvc == nil {
+		return
+	}
+	status := "success"
+	output := interface{}(map[string]interface{}{"output": result.Output, "stop_reason": result.StopReason, "tokens": result.Tokens})
+	if err != nil {
+		status = "failed"
+		output = map[string]interface{}{"error": err.Error()}
+	}
+	t.logSvc.EndExecution(t.logID, status, output)
+}
@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+	"agent-flow/internal/store"
+	"agent-flow/internal/tools"
+)
+
+// ModelSettings store.Agent.ModelConfig 的JSON结构
+type ModelSettings struct {
+	Temperature  *float64               `json:"temperature,omitempty" min:"0" max:"2" desc:"采样温度"`
+	MaxTokens    int                    `json:"max_tokens,omitempty" min:"0" max:"200000" desc:"输出上限, 0表示使用模型默认值"`
+	SystemPrompt string                 `json:"system_prompt,omitempty" desc:"内联系统提示词 (未绑定模板时使用)"`
+	Locale       string                 `json:"locale,omitempty" enum:"zh,en" desc:"提示词语言"`
+	PromptVars   map[string]interface{} `json:"prompt_vars,omitempty" desc:"提示词模板变量"`
+	TaskType     string                 `json:"task_type,omitempty" desc:"任务类型, 用于模型路由"`
+	Context      *model.ContextPolicy   `json:"context,omitempty" desc:"上下文截断策略"`
+	Cache        *model.CachePolicy     `json:"cache,omitempty" desc:"响应缓存策略"`
+	MaxSteps     int                    `json:"max_steps,omitempty" min:"1" max:"50" desc:"工具循环最大步数, 默认8"`
+	TokenBudget  int                    `json:"token_budget,omitempty" min:"0" desc:"工具循环token预算, 默认32000"`
+	Guardrails   *GuardrailPolicy       `json:"guardrails,omitempty" desc:"护栏策略 (话题、脱敏、长度、免责声明、内容审核)"`
+}
+
+// settingsSchema ModelConfig 的校验Schema (不允许未知字段, 避免拼写错误被静默忽略)
+var settingsSchema = func() map[string]interface{} {
+	schema := model.SchemaOf(ModelSettings{})
+	schema["additionalProperties"] = false
+	return schema
+}()
+
+// ToolRef store.Agent.Tools 中的工具项, 可以是工具名字符串或 {"name": "..."} 对象
+type ToolRef struct {
+	Name string `json:"name"`
+}
+
+// Definition 从数据库加载并校验后的智能体定义
+type Definition struct {
+	ID       uint          `json:"id"`
+	Name     string        `json:"name"`
+	Model    string        `json:"model"` // model.Service 中注册的模型名, 为空时按路由策略选择
+	Settings ModelSettings `json:"settings"`
+	Tools    []string      `json:"tools"`
+	Prompt   *prompt.Ref   `json:"prompt,omitempty"`
+
+	guard *guardrail // 由 Settings.Guardrails 编译
+}
+
+// ParseAgentID 解析字符串形式的智能体ID (流程节点/渠道中以字符串传递)
+func ParseAgentID(agentID string) (uint, error) {
+	id, err := strconv.ParseUint(strings.TrimSpace(agentID), 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid agent id: %q", agentID)
+	}
+	return uint(id), nil
+}
+
+// LoadAgent 加载智能体定义
+func (s *Service) LoadAgent(agentID uint) (*Definition, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+	row, err := s.db.GetAgent(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("agent %d not found: %w", agentID, err)
+	}
+	def, err := s.Resolve(row)
+	if err != nil {
+		return nil, err
+	}
+	// 管理员锁定的模型优先于智能体配置
+	if a, err := s.db.GetModelAssignment(AgentSubject(agentID)); err == nil && a.Locked {
+		def.Model = a.Model
+	}
+	return def, nil
+}
+
+// Resolve 校验 store.Agent 配置并转换为运行时定义
+func (s *Service) Resolve(row *store.Agent) (*Definition, error) {
+	def := &Definition{ID: row.ID, Name: row.Name}
+	var problems []string
+
+	// ModelConfig: 先按Schema校验原始JSON, 再解码
+	if raw := strings.TrimSpace(row.ModelConfig); raw != "" && raw != "null" {
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			problems = append(problems, fmt.Sprintf("model_config: invalid JSON: %v", err))
+		} else if errs := model.ValidateSchema(settingsSchema, value); len(errs) > 0 {
+			for _, e := range errs {
+				problems = append(problems, "model_config"+strings.TrimPrefix(e, "$"))
+			}
+		} else if err := json.Unmarshal([]byte(raw), &def.Settings); err != nil {
+			problems = append(problems, fmt.Sprintf("model_config: %v", err))
+		}
+	}
+
+	// Guardrails: 话题规则必须是合法正则, 审核器必须已注册
+	if def.Settings.Guardrails != nil {
+		guard, errs := compileGuardrail(*def.Settings.Guardrails)
+		problems = append(problems, errs...)
+		def.guard = guard
+	}
+
+	// Tools: 必须是已注册的工具
+	names, err := parseToolRefs(row.Tools)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("tools: %v", err))
+	}
+	for _, name := range names {
+		if tools.GetTool(name) == nil {
+			problems = append(problems, fmt.Sprintf("tools: unknown tool %q", name))
+			continue
+		}
+		def.Tools = append(def.Tools, name)
+	}
+
+	// 模型: 必须已在模型服务中注册, 且与声明的供应商一致
+	if row.ModelName != "" {
+		def.Model = row.ModelName
+		if s.modelSvc != nil {
+			cfg, ok := s.modelSvc.GetModel(row.ModelName)
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("model_name: unknown model %q", row.ModelName))
+			case row.ModelProvider != "" && string(cfg.Provider) != row.ModelProvider:
+				problems = append(problems, fmt.Sprintf("model_provider: %q does not match provider %q of model %q", row.ModelProvider, cfg.Provider, row.ModelName))
+			}
+		}
+	}
+
+	// 提示词模板
+	if row.PromptKey != "" {
+		def.Prompt = &prompt.Ref{Key: row.PromptKey, Version: row.PromptVersion, Locale: def.Settings.Locale}
+		if _, err := s.prompts.Get(row.PromptKey, row.PromptVersion); err != nil {
+			problems = append(problems, fmt.Sprintf("prompt_key: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ConfigError{AgentID: row.ID, Problems: problems}
+	}
+	return def, nil
+}
+
+// ConfigError 智能体配置不合法
+type ConfigError struct {
+	AgentID  uint
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("agent %d config invalid: %s", e.AgentID, strings.Join(e.Problems, "; "))
+}
+
+// parseToolRefs 解析工具列表JSON: ["shell", {"name": "web_search"}]
+func parseToolRefs(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "null" {
+		return nil, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &items); err != nil {
+		return nil, fmt.Errorf("expected an array: %v", err)
+	}
+
+	names := make([]string, 0, len(items))
+	for i, item := range items {
+		var name string
+		if err := json.Unmarshal(item, &name); err != nil {
+			var ref ToolRef
+			if err := json.Unmarshal(item, &ref); err != nil || ref.Name == "" {
+				return nil, fmt.Errorf("item %d must be a tool name or {\"name\": ...}", i)
+			}
+			name = ref.Name
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// SystemPrompt 智能体系统提示词: 绑定的模板 > 内联文本 > 默认模板, 记忆上下文作为 context 变量传入
+func (s *Service) SystemPrompt(def *Definition, contextInfo string) string {
+	vars := map[string]interface{}{}
+	for k, v := range def.Settings.PromptVars {
+		vars[k] = v
+	}
+	vars["context"] = contextInfo
+
+	if def.Prompt != nil {
+		return s.renderPrompt(*def.Prompt, vars)
+	}
+	if def.Settings.SystemPrompt != "" {
+		if contextInfo == "" {
+			return def.Settings.SystemPrompt
+		}
+		return def.Settings.SystemPrompt + "\n\n相关背景信息:\n" + contextInfo
+	}
+	return s.renderPrompt(prompt.Ref{Key: prompt.KeyAssistant, Locale: def.Settings.Locale}, vars)
+}
+
+// BuildRequest 按智能体定义构建模型请求
+func (s *Service) BuildRequest(def *Definition, messages []model.Message) model.Request {
+	req := model.Request{
+		Model:     def.Model,
+		Messages:  messages,
+		MaxTokens: def.Settings.MaxTokens,
+		TaskType:  def.Settings.TaskType,
+		AgentID:   strconv.FormatUint(uint64(def.ID), 10),
+		Cache:     def.Settings.Cache,
+		Context:   def.Settings.Context,
+	}
+	if def.Settings.Temperature != nil {
+		// 显式设置的0也要传给模型, 不能退回模型默认温度
+		req.Temperature = model.Temperature(*def.Settings.Temperature)
+	}
+	return req
+}
+
+// Run 以智能体身份处理一次输入, 只返回最终答案
+func (s *Service) Run(ctx context.Context, def *Definition, input string) (string, error) {
+	result, err := s.RunWithTrace(ctx, def, input)
+	if err != nil {
+		return "", err
+	}
+	return result.Output, nil
+}
@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+)
+
+// 内置评分器
+const (
+	ScorerExact     = "exact"       // 完全匹配
+	ScorerRegex     = "regex"       // 正则匹配
+	ScorerJSONField = "json_fields" // JSON字段匹配
+	ScorerLLMJudge  = "llm_judge"   // 大模型按评分标准打分
+	ScorerEmbedding = "embedding"   // 向量相似度
+)
+
+// ScoreInput 评分输入
+type ScoreInput struct {
+	Input    string                 `json:"input"`
+	Expected string                 `json:"expected"`
+	Output   string                 `json:"output"`
+	Config   map[string]interface{} `json:"config"`
+	Prompts  *prompt.Service        `json:"-"` // 评审类打分器的系统提示词, 为nil时使用内置模板
+}
+
+// ScoreResult 评分结果, Score 归一化到 0-1
+type ScoreResult struct {
+	Score  float64 `json:"score"`
+	Passed bool    `json:"passed"`
+	Detail string  `json:"detail"`
+}
+
+// Scorer 评分器
+type Scorer interface {
+	Name() string
+	Description() string
+	Score(ctx context.Context, svc *model.Service, in ScoreInput) (ScoreResult, error)
+}
+
+// ScorerRegistry 评分器注册表
+var ScorerRegistry = map[string]Scorer{}
+
+// RegisterScorer 注册评分器
+func RegisterScorer(s Scorer) {
+	ScorerRegistry[s.Name()] = s
+}
+
+// GetScorer 获取评分器
+func GetScorer(name string) Scorer {
+	return ScorerRegistry[name]
+}
+
+// ListScorers 列出评分器 (按名称排序)
+func ListScorers() []Scorer {
+	scorers := make([]Scorer, 0, len(ScorerRegistry))
+	for _, s := range ScorerRegistry {
+		scorers = append(scorers, s)
+	}
+	sort.Slice(scorers, func(i, j int) bool { return scorers[i].Name() < scorers[j].Name() })
+	return scorers
+}
+
+func init() {
+	RegisterScorer(&ExactScorer{})
+	RegisterScorer(&RegexScorer{})
+	RegisterScorer(&JSONFieldScorer{})
+	RegisterScorer(&LLMJudgeScorer{})
+	RegisterScorer(&EmbeddingScorer{})
+}
+
+// passThreshold 通过阈值, 默认0.5
+func passThreshold(cfg map[string]interface{}, def float64) float64 {
+	if v, ok := cfg["threshold"].(float64); ok {
+		return v
+	}
+	return def
+}
+
+func cfgString(cfg map[string]interface{}, key string) string {
+	v, _ := cfg[key].(string)
+	return v
+}
+
+func cfgBool(cfg map[string]interface{}, key string) bool {
+	v, _ := cfg[key].(bool)
+	return v
+}
+
+// ========== 完全匹配 ==========
+
+// ExactScorer 去除首尾空白后完全匹配; config: ignore_case
+type ExactScorer struct{}
+
+func (s *ExactScorer) Name() string { return ScorerExact }
+func (s *ExactScorer) Description() string {
+	return "输出与期望完全一致 (去除首尾空白), 参数: ignore_case"
+}
+
+func (s *ExactScorer) Score(ctx context.Context, svc *model.Service, in ScoreInput) (ScoreResult, error) {
+	out, want := strings.TrimSpace(in.Output), strings.TrimSpace(in.Expected)
+	match := out == want
+	if cfgBool(in.Config, "ignore_case") {
+		match = strings.EqualFold(out, want)
+	}
+	if match {
+		return ScoreResult{Score: 1, Passed: true, Detail: "完全匹配"}, nil
+	}
+	return ScoreResult{Score: 0, Detail: "不匹配"}, nil
+}
+
+// ========== 正则匹配 ==========
+
+// RegexScorer 输出匹配正则 (config.pattern, 为空时使用期望输出作为正则)
+type RegexScorer struct{}
+
+func (s *RegexScorer) Name() string { return ScorerRegex }
+func (s *RegexScorer) Description() string {
+	return "输出匹配正则表达式, 参数: pattern (为空时使用期望输出)"
+}
+
+func (s *RegexScorer) Score(ctx context.Context, svc *model.Service, in ScoreInput) (ScoreResult, error) {
+	pattern := cfgString(in.Config, "pattern")
+	if pattern == "" {
+		pattern = in.Expected
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ScoreResult{}, fmt.Errorf("invalid pattern: %w", err)
+	}
+	if re.MatchString(in.Output) {
+		return ScoreResult{Score: 1, Passed: true, Detail: "匹配 " + pattern}, nil
+	}
+	return ScoreResult{Score: 0, Detail: "未匹配 " + pattern}, nil
+}
+
+// ========== JSON字段匹配 ==========
+
+// JSONFieldScorer 从输出中提取JSON对象, 与期望JSON逐字段比较; config: fields (为空时比较期望中的所有字段), threshold
+type JSONFieldScorer struct{}
+
+func (s *JSONFieldScorer) Name() string { return ScorerJSONField }
+func (s *JSONFieldScorer) Description() string {
+	return "输出JSON中的字段与期望JSON一致, 按匹配字段比例计分, 参数: fields, threshold"
+}
+
+// objectSchema 只约束顶层为对象, 用于从输出中提取JSON对象
+var objectSchema = map[string]interface{}{"type": "object"}
+
+func (s *JSONFieldScorer) Score(ctx context.Context, svc *model.Service, in ScoreInput) (ScoreResult, error) {
+	var want map[string]interface{}
+	if err := json.Unmarshal([]byte(in.Expected), &want); err != nil {
+		return ScoreResult{}, fmt.Errorf("expected output is not a JSON object: %w", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(model.ExtractJSON(in.Output, objectSchema)), &got); err != nil {
+		return ScoreResult{Score: 0, Detail: "输出不是JSON对象"}, nil
+	}
+
+	var fields []string
+	if list, ok := in.Config["fields"].([]interface{}); ok {
+		for _, f := range list {
+			fields = append(fields, fmt.Sprint(f))
+		}
+	}
+	if len(fields) == 0 {
+		for k := range want {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+	}
+	if len(fields) == 0 {
+		return ScoreResult{Score: 1, Passed: true, Detail: "没有需要比较的字段"}, nil
+	}
+
+	var missed []string
+	for _, f := range fields {
+		w, _ := json.Marshal(want[f])
+		g, _ := json.Marshal(got[f])
+		if string(w) != string(g) {
+			missed = append(missed, f)
+		}
+	}
+	score := float64(len(fields)-len(missed)) / float64(len(fields))
+	detail := fmt.Sprintf("%d/%d 个字段匹配", len(fields)-len(missed), len(fields))
+	if len(missed) > 0 {
+		detail += ", 不匹配: " + strings.Join(missed, ", ")
+	}
+	return ScoreResult{Score: score, Passed: score >= passThreshold(in.Config, 1), Detail: detail}, nil
+}
+
+// ========== 大模型评分 ==========
+
+// LLMJudgeScorer 由评审模型按评分标准打分; config: rubric, model (为空时按路由选择), threshold
+type LLMJudgeScorer struct{}
+
+type judgeVerdict struct {
+	Score  int    `json:"score" min:"0" max:"10" desc:"0-10分"`
+	Reason string `json:"reason" desc:"一句话说明打分依据"`
+}
+
+func (s *LLMJudgeScorer) Name() string { return ScorerLLMJudge }
+func (s *LLMJudgeScorer) Description() string {
+	return "评审模型按评分标准打0-10分, 参数: rubric, model, threshold"
+}
+
+func (s *LLMJudgeScorer) Score(ctx context.Context, svc *model.Service, in ScoreInput) (ScoreResult, error) {
+	if svc == nil {
+		return ScoreResult{}, fmt.Errorf("model service not initialized")
+	}
+	rubric := cfgString(in.Config, "rubric")
+	if rubric == "" {
+		rubric = "回答是否正确、完整地解决了问题, 与参考答案是否一致。"
+	}
+
+	user := fmt.Sprintf("评分标准:\n%s\n\n问题:\n%s\n\n参考答案:\n%s\n\n待评分的回答:\n%s", rubric, in.Input, in.Expected, in.Output)
+	v, err := model.Structured[judgeVerdict](ctx, svc, model.Request{
+		Model:       cfgString(in.Config, "model"),
+		Temperature: model.Temperature(0),
+		Messages: []model.Message{
+			{Role: "system", Content: in.Prompts.RenderOrBuiltin(prompt.Ref{Key: prompt.KeyEvalJudge}, nil)},
+			{Role: "user", Content: user},
+		},
+	})
+	if err != nil {
+		return ScoreResult{}, err
+	}
+	score := float64(v.Score) / 10
+	return ScoreResult{Score: score, Passed: score >= passThreshold(in.Config, 0.6), Detail: v.Reason}, nil
+}
+
+// ========== 向量相似度 ==========
+
+// EmbeddingScorer 输出与期望的向量余弦相似度; config: model (为空时使用默认向量模型), threshold
+type EmbeddingScorer struct{}
+
+func (s *EmbeddingScorer) Name() string { return ScorerEmbedding }
+func (s *EmbeddingScorer) Description() string {
+	return "输出与期望输出的向量余弦相似度, 参数: model, threshold"
+}
+
+func (s *EmbeddingScorer) Score(ctx context.Context, svc *model.Service, in ScoreInput) (ScoreResult, error) {
+	if svc == nil {
+		return ScoreResult{}, fmt.Errorf("model service not initialized")
+	}
+	resp, err := svc.Embed(ctx, cfgString(in.Config, "model"), []string{in.Output, in.Expected})
+	if err != nil {
+		return ScoreResult{}, err
+	}
+	sim := model.Cosine(resp.Vectors[0], resp.Vectors[1])
+	if sim < 0 {
+		sim = 0
+	}
+	return ScoreResult{
+		Score:  sim,
+		Passed: sim >= passThreshold(in.Config, 0.8),
+		Detail: fmt.Sprintf("相似度 %.3f (%s)", sim, resp.Model),
+	}, nil
+}
@@ -2,14 +2,18 @@ package agent
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/sashabaranov/go-openai"
-	"corpflow/internal/memory"
+	"agent-flow/internal/logs"
+	"agent-flow/internal/memory"
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+	"agent-flow/internal/store"
 )
 
 // Service 智能体服务
@@ -17,22 +21,37 @@ type Service struct {
 	openaiClient *openai.Client
 	anthropicKey string
 	defaultModel string
+	db           *store.Postgres
 	memorySvc    *memory.Service
+	modelSvc     *model.Service
+	prompts      *prompt.Service
+	logSvc       *logs.Service
+	switchMu     sync.Mutex
+	switchPolicy SwitchPolicy
+	taskMu       sync.Mutex
+	taskCancels  map[uint]context.CancelFunc // 本副本内执行中的任务
 }
 
-// NewService 创建智能体服务
-func NewService(memSvc *memory.Service) *Service {
+// NewService 创建智能体服务, prompts为nil时只使用内置提示词
+func NewService(db *store.Postgres, memSvc *memory.Service, modelSvc *model.Service, prompts *prompt.Service) *Service {
 	openaiKey := os.Getenv("OPENAI_API_KEY")
 	var client *openai.Client
 	if openaiKey != "" {
 		client = openai.NewClient(openaiKey)
 	}
+	if prompts == nil {
+		prompts = prompt.NewService(nil)
+	}
 
 	return &Service{
 		openaiClient: client,
 		anthropicKey: os.Getenv("ANTHROPIC_API_KEY"),
 		defaultModel: "gpt-4",
+		db:           db,
 		memorySvc:    memSvc,
+		modelSvc:     modelSvc,
+		prompts:      prompts,
+		switchPolicy: defaultSwitchPolicy(),
 	}
 }
 
@@ -51,169 +70,183 @@ func (s *Service) ProcessWithVoting(ctx context.Context, cfg Config, input strin
 	}
 	
 	// 降级为普通单智能体处理
-	return s.callModel(ctx, cfg.Model, cfg.SystemPrompt, input)
+	return s.callModel(ctx, cfg.Model, s.rolePrompt(cfg, cfg.SystemPromptRef, cfg.SystemPrompt, prompt.KeyAssistant), input)
 }
 
 // ProcessWithCollaboration 多智能体协作处理
-// CEO 分解任务 → Manager 分配任务 → Worker 执行 → Manager 汇总 → CEO 最终决策
+// 组织结构来自 cfg.OrgRootID 下的智能体关系 (未配置时为 CEO → Manager → Worker):
+// 上级拆分子任务并指派给下属 → 下属并行执行 → 逐级汇总 → CEO 最终决策
 // 支持动态模型切换：下级投票换领导模型，领导根据业绩换下属模型
 func (s *Service) ProcessWithCollaboration(ctx context.Context, cfg Config, input string) (string, error) {
-	// 初始化模型
-	ceoModel := cfg.Model
-	managerModel := cfg.Model
-	workerModel := cfg.Model
-	
-	availableModels := []string{"gpt-4", "glm-4", "claude-3-sonnet", "kimi"}
-	
-	// 1. CEO 角色：分析任务，分解为子任务，同时评估是否需要更换模型
-	ceoPrompt := cfg.CEOPrompt
-	if ceoPrompt == "" {
-		ceoPrompt = "你是一个CEO，负责分析用户需求并分解任务。"
-	}
-	
-	// 下级投票决定是否更换 CEO 模型
-	ceoModel, _ = s.voteForModel(ctx, availableModels, "CEO", ceoModel)
-	
-	ceoResponse, err := s.callModel(ctx, ceoModel, ceoPrompt, input)
-	if err != nil {
-		return "", fmt.Errorf("CEO 分析失败: %v", err)
-	}
-	
-	// 2. Manager 角色：接收CEO分解的子任务，分配给Worker
-	managerPrompt := cfg.ManagerPrompt
-	if managerPrompt == "" {
-		managerPrompt = "你是一个Manager，负责将任务分配给具体的执行者。"
-	}
-	managerResponse, err := s.callModel(ctx, managerModel, managerPrompt, 
-		"CEO分析结果: "+ceoResponse+"\n\n原始任务: "+input)
-	if err != nil {
-		return "", fmt.Errorf("Manager 分配失败: %v", err)
-	}
-	
-	// 3. Worker 角色：执行具体任务
-	workerPrompt := cfg.WorkerPrompt
-	if workerPrompt == "" {
-		workerPrompt = "你是一个Worker，负责执行具体的任务。"
-	}
-	workerResponse, err := s.callModel(ctx, workerModel, workerPrompt, 
-		"Manager安排: "+managerResponse+"\n\n请执行具体任务并给出结果。")
+	result, err := s.Collaborate(ctx, cfg, input)
 	if err != nil {
-		return "", fmt.Errorf("Worker 执行失败: %v", err)
+		return "", err
 	}
-	
-	// 4. 领导根据业绩（响应质量）决定是否更换下属模型
-	managerModel = s.evaluateAndSwitchModel(ctx, availableModels, "Manager", managerModel, managerResponse)
-	workerModel = s.evaluateAndSwitchModel(ctx, availableModels, "Worker", workerModel, workerResponse)
-	
-	// 5. CEO 最终决策：汇总所有结果，给出最终答案
-	finalPrompt := "你是一个CEO，负责整合所有信息给出最终答案。"
-	finalResponse, err := s.callModel(ctx, ceoModel, finalPrompt, 
-		"CEO分析: "+ceoResponse+"\n\nManager安排: "+managerResponse+"\n\nWorker执行结果: "+workerResponse+"\n\n原始任务: "+input)
-	if err != nil {
-		return "", fmt.Errorf("CEO 最终决策失败: %v", err)
-	}
-	
-	// 汇总完整流程（包含模型切换信息）
-	result := fmt.Sprintf("【模型状态】\nCEO: %s | Manager: %s | Worker: %s\n\n【任务分析】\n%s\n\n【任务分配】\n%s\n\n【执行结果】\n%s\n\n【最终方案】\n%s", 
-		ceoModel, managerModel, workerModel, ceoResponse, managerResponse, workerResponse, finalResponse)
-	
-	return result, nil
+	return result.FormatReport(), nil
 }
 
-// voteForModel 下级投票选择最佳模型
-func (s *Service) voteForModel(ctx context.Context, models []string, role, currentModel string) (string, error) {
-	if len(models) < 2 {
-		return currentModel, nil
+// voteForModel 下级投票选择最适合领导的模型, 返回得票最多的模型和投票明细
+func (s *Service) voteForModel(ctx context.Context, models []string, leader *OrgNode, voters []*OrgNode) (string, []Vote) {
+	if s.modelSvc == nil || len(models) < 2 || len(voters) == 0 {
+		return leader.Model, nil
 	}
-	
-	// 模拟下级投票：让其他模型评估当前模型
-	var bestModel string
-	bestScore := 0.0
-	
-	votePrompt := fmt.Sprintf("作为%s的下级，请投票选择最适合当前任务的AI模型。候选模型: %v。当前模型: %s。请直接返回你认为最佳的模型名称。", role, models, currentModel)
-	
-	for _, model := range models {
-		response, err := s.callModel(ctx, model, "你是一个公正的评审", votePrompt)
+
+	votePrompt := fmt.Sprintf("作为%s的下级，请投票选择最适合当前任务的AI模型。候选模型: %s。当前模型: %s。", leader.Name, strings.Join(models, ", "), leader.Model)
+	format := ballotFormat(models)
+
+	var votes []Vote
+	tally := map[string]int{}
+	for _, voter := range voters {
+		var ballot modelBallot
+		_, err := s.modelSvc.CompleteJSON(ctx, model.Request{
+			Model: voter.Model,
+			Messages: []model.Message{
+				{Role: "system", Content: s.renderPrompt(prompt.Ref{Key: prompt.KeyVoteJudge}, nil)},
+				{Role: "user", Content: votePrompt},
+			},
+			ResponseFormat: format,
+		}, &ballot)
 		if err != nil {
 			continue
 		}
-		// 简单评分逻辑
-		score := float64(len(response)) / 10 // 粗略评分
-		if score > bestScore {
-			bestScore = score
-			bestModel = model
+		votes = append(votes, Vote{Voter: voter.Name, Model: ballot.Model})
+		tally[ballot.Model]++
+	}
+
+	// 得票最多者胜出, 平票时保持当前模型
+	best := leader.Model
+	for _, m := range models {
+		if tally[m] > tally[best] {
+			best = m
 		}
 	}
-	
-	if bestModel == "" {
-		return currentModel, nil
+	return best, votes
+}
+
+// modelBallot 下级投票的结构化输出
+type modelBallot struct {
+	Model  string `json:"model" desc:"选择的模型, 必须是候选模型之一"`
+	Reason string `json:"reason" desc:"一句话理由"`
+}
+
+// ballotFormat 投票的输出格式, 模型名以枚举限定为候选模型 (不符合时由结构化调用要求模型修正)
+func ballotFormat(models []string) *model.ResponseFormat {
+	schema := model.SchemaOf(modelBallot{})
+	enum := make([]interface{}, len(models))
+	for i, m := range models {
+		enum[i] = m
 	}
-	
-	return bestModel, nil
+	schema["properties"].(map[string]interface{})["model"].(map[string]interface{})["enum"] = enum
+	return &model.ResponseFormat{Name: "modelBallot", Schema: schema}
 }
 
-// evaluateAndSwitchModel 领导根据业绩决定是否更换下属模型
-func (s *Service) evaluateAndSwitchModel(ctx context.Context, models []string, role, currentModel, performance string) string {
+// evaluateAndSwitchModel 领导根据业绩决定是否更换下属模型, 返回生效的新模型 (未切换时为空)
+func (s *Service) evaluateAndSwitchModel(ctx context.Context, models []string, leader, member *OrgNode, performance string) string {
 	if len(models) < 2 {
-		return currentModel
+		return ""
 	}
-	
+
 	// 让领导评估下属表现
-	evalPrompt := fmt.Sprintf("作为%s的领导，请评估下属的工作质量(0-100分)，并决定是否需要更换模型。直接返回: 分数,是否换模型(yes/no)", role)
-	
-	response, err := s.callModel(ctx, currentModel, "你是一个严格的领导", evalPrompt+"\n\n工作成果: "+performance)
-	if err != nil {
-		return currentModel
+	evalPrompt := fmt.Sprintf("作为%s的领导，请评估下属的工作质量(0-100分)，并决定是否需要更换模型。下属当前模型: %s。可选模型: %s", member.Name, member.Model, strings.Join(models, ", "))
+
+	verdict, err := s.evaluate(ctx, leader.Model, s.renderPrompt(prompt.Ref{Key: prompt.KeyLeaderReview}, nil), evalPrompt+"\n\n工作成果: "+performance)
+	if err != nil || !verdict.ShouldSwitch {
+		return ""
 	}
-	
-	// 解析响应，判断是否需要更换
-	// 简单实现：如果响应中包含 "yes" 或 "更换" 则更换模型
-	if strings.Contains(response, "yes") || strings.Contains(response, "更换") || strings.Contains(response, "换") {
-		// 选择一个不同的模型
-		for _, model := range models {
-			if model != currentModel {
-				return model
-			}
+
+	// 优先使用推荐的模型, 否则选择一个不同的模型
+	next := ""
+	for _, m := range models {
+		if m == verdict.RecommendedModel && m != member.Model {
+			next = m
 		}
 	}
-	
-	return currentModel
+	for _, m := range models {
+		if next == "" && m != member.Model {
+			next = m
+		}
+	}
+	if next == "" {
+		return ""
+	}
+
+	score := verdict.Score
+	entry, err := s.ProposeSwitch(SwitchRequest{
+		Subject: nodeSubject(member),
+		To:      next,
+		Source:  SwitchPerf,
+		Reason:  verdict.Reason,
+		Score:   &score,
+		Votes:   []Vote{{Voter: leader.Name, Model: next, Score: &score}},
+	}, member.Model)
+	if err != nil || !entry.Applied {
+		return ""
+	}
+	return next
 }
 
-// ProcessWithAgent 使用指定智能体处理 (带记忆)
+// ProcessWithAgent 使用指定智能体处理 (带记忆), 配置从数据库加载
 func (s *Service) ProcessWithAgent(ctx context.Context, agentID, input, userID string) (string, error) {
-	// 获取智能体配置
-	// TODO: 从DB获取
-	
-	// 获取相关记忆
-	contextInfo := s.GetContextForAgent(agentID, input)
-	
-	// 构建系统prompt
-	systemPrompt := "你是一个AI助手，请帮助用户解决问题。"
-	if contextInfo != "" {
-		systemPrompt += "\n\n相关背景信息:\n" + contextInfo
+	id, err := ParseAgentID(agentID)
+	if err != nil {
+		return "", err
 	}
+	def, err := s.LoadAgent(id)
+	if err != nil {
+		return "", err
+	}
+	return s.Run(ctx, def, input)
+}
 
-	response := s.callModel(ctx, s.defaultModel, systemPrompt, input)
-	
-	// 记录到记忆
-	if s.memorySvc != nil {
-		_ = s.memorySvc.AddActionMemory(agentID, nil, input, response)
+// ProcessMessage 处理渠道入站消息 (可带图片/文件等附件): 指定智能体时以其身份运行, 否则由默认模型直接回答
+func (s *Service) ProcessMessage(ctx context.Context, agentID uint, msg model.Message) (string, error) {
+	if agentID == 0 {
+		if s.modelSvc == nil {
+			return s.CallLLM(ctx, s.defaultModel, msg.Text())
+		}
+		resp, err := s.modelSvc.Complete(ctx, model.Request{
+			Model: s.defaultModel,
+			Messages: []model.Message{
+				{Role: "system", Content: s.renderPrompt(prompt.Ref{Key: prompt.KeyAssistantBrief}, nil)},
+				msg,
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return resp.Content, nil
 	}
-	
-	return response, nil
+
+	def, err := s.LoadAgent(agentID)
+	if err != nil {
+		return "", err
+	}
+	result, err := s.RunMessage(ctx, def, msg)
+	if err != nil {
+		return "", err
+	}
+	return result.Output, nil
 }
 
-// GetContextForAgent 获取智能体的上下文记忆
-func (s *Service) GetContextForAgent(agentID uint, currentInput string) string {
+// 上下文中附带的记忆条数和知识分块数
+const (
+	contextMemoryLimit   = 10
+	contextKnowledgeTopK = 5
+)
+
+// GetContextForAgent 获取智能体的上下文记忆, 相关知识按检索结果编号以便回答时引用
+func (s *Service) GetContextForAgent(ctx context.Context, agentID uint, currentInput string) string {
 	if s.memorySvc == nil {
 		return ""
 	}
 
 	var context strings.Builder
 
-	// 1. 获取自己的记忆
-	memories, _ := s.memorySvc.GetMemories(agentID, 10)
+	// 1. 自己的记忆 (按时效、重要性和与当前输入的相关性综合排序)
+	memories, err := s.memorySvc.RecallMemories([]uint{agentID}, currentInput, contextMemoryLimit)
+	if err != nil {
+		log.Printf("Memory recall for agent %d failed: %v", agentID, err)
+	}
 	if len(memories) > 0 {
 		context.WriteString("【我的历史行为】\n")
 		for _, mem := range memories {
@@ -221,22 +254,31 @@ func (s *Service) GetContextForAgent(agentID uint, currentInput string) string {
 		}
 	}
 
-	// 2. 获取下属的记忆 (如果我是上级)
-	subMemories, _ := s.memorySvc.GetSubordinateMemories(agentID)
-	if len(subMemories) > 0 {
-		context.WriteString("\n【下属的行为】\n")
-		for _, mem := range subMemories {
-			context.WriteString(fmt.Sprintf("- %s\n", mem.Content))
+	// 2. 下属的记忆 (如果我是上级)
+	if subIDs, _ := s.memorySvc.GetSubordinateIDs(agentID); len(subIDs) > 0 {
+		subMemories, err := s.memorySvc.RecallMemories(subIDs, currentInput, contextMemoryLimit)
+		if err != nil {
+			log.Printf("Memory recall for subordinates of agent %d failed: %v", agentID, err)
+		}
+		if len(subMemories) > 0 {
+			context.WriteString("\n【下属的行为】\n")
+			for _, mem := range subMemories {
+				context.WriteString(fmt.Sprintf("- %s\n", mem.Content))
+			}
 		}
 	}
 
-	// 3. 获取相关知识
-	knowledge, _ := s.memorySvc.SearchKnowledge(agentID, currentInput)
-	if len(knowledge) > 0 {
+	// 3. 检索相关知识 (自己和下属的知识中最相关的分块)
+	hits, err := s.memorySvc.RetrieveForAgent(ctx, agentID, currentInput, contextKnowledgeTopK)
+	if err != nil {
+		log.Printf("Knowledge retrieval for agent %d failed: %v", agentID, err)
+	}
+	if len(hits) > 0 {
 		context.WriteString("\n【相关知识】\n")
-		for _, k := range knowledge {
-			context.WriteString(fmt.Sprintf("- %s: %s\n", k.Title, k.Content))
+		for i, hit := range hits {
+			context.WriteString(fmt.Sprintf("[%d] %s (知识#%d 第%d段): %s\n", i+1, hit.Title, hit.KnowledgeID, hit.Seq+1, hit.Content))
 		}
+		context.WriteString("引用以上知识时请在句末用 [编号] 标注来源。\n")
 	}
 
 	return context.String()
@@ -291,13 +333,27 @@ func (s *Service) RecordResult(agentID uint, task string, success bool, details
 }
 
 // CallLLM 调用大模型
-func (s *Service) CallLLM(ctx context.Context, model, prompt string) (string, error) {
-	systemPrompt := "你是一个AI助手，请简洁地回答用户问题。"
-	return s.callModel(ctx, model, systemPrompt, prompt)
-}
+func (s *Service) CallLLM(ctx context.Context, model, userPrompt string) (string, error) {
+	systemPrompt := s.renderPrompt(prompt.Ref{Key: prompt.KeyAssistantBrief}, nil)
+	return s.callModel(ctx, model, systemPrompt, userPrompt)
+}
+
+// callModel 调用模型 (优先经模型服务路由, 未配置时直连OpenAI)
+func (s *Service) callModel(ctx context.Context, modelName, systemPrompt, userPrompt string) (string, error) {
+	if s.modelSvc != nil {
+		resp, err := s.modelSvc.Complete(ctx, model.Request{
+			Model: modelName,
+			Messages: []model.Message{
+				{Role: "system", Content: systemPrompt},
+				{Role: "user", Content: userPrompt},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return resp.Content, nil
+	}
 
-// callModel 调用模型
-func (s *Service) callModel(ctx context.Context, model, systemPrompt, userPrompt string) (string, error) {
 	if s.openaiClient == nil {
 		return "⚠️ 请配置 OPENAI_API_KEY 环境变量", nil
 	}
@@ -305,7 +361,7 @@ func (s *Service) callModel(ctx context.Context, model, systemPrompt, userPrompt
 	resp, err := s.openaiClient.CreateChatCompletion(
 		ctx,
 		openai.ChatCompletionRequest{
-			Model: model,
+			Model: modelName,
 			Messages: []openai.ChatCompletionMessage{
 				{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
 				{Role: openai.ChatMessageRoleUser, Content: userPrompt},
@@ -323,6 +379,36 @@ func (s *Service) callModel(ctx context.Context, model, systemPrompt, userPrompt
 	return resp.Choices[0].Message.Content, nil
 }
 
+// rolePrompt 角色系统提示词: 模板引用 > 内联文本 > 内置默认模板
+func (s *Service) rolePrompt(cfg Config, ref *prompt.Ref, inline, defaultKey string) string {
+	if ref == nil {
+		if inline != "" {
+			return inline
+		}
+		ref = &prompt.Ref{Key: defaultKey}
+	}
+	r := *ref
+	if r.Locale == "" {
+		r.Locale = cfg.Locale
+	}
+	return s.renderPrompt(r, nil)
+}
+
+// renderPrompt 渲染提示词模板, 失败时回退到内置版本
+func (s *Service) renderPrompt(ref prompt.Ref, vars map[string]interface{}) string {
+	text, err := s.prompts.Render(ref, vars)
+	if err == nil {
+		return text
+	}
+	log.Printf("[Agent] render prompt %s@%d failed: %v", ref.Key, ref.Version, err)
+
+	text, err = prompt.NewService(nil).Render(prompt.Ref{Key: ref.Key, Locale: ref.Locale}, vars)
+	if err != nil {
+		return ""
+	}
+	return text
+}
+
 // ========== 智能体配置 ==========
 
 // Config 智能体配置
@@ -332,8 +418,10 @@ type Config struct {
 	Description string                 `json:"description"`
 	Model       string                 `json:"model"`        // 默认模型
 	Provider    string                 `json:"provider"`    // openai/anthropic/custom
-	SystemPrompt string                `json:"system_prompt"`
-	Tools       []string               `json:"tools"`       // 工具列表
+	SystemPrompt string                `json:"system_prompt"` // 内联提示词 (已废弃, 优先使用 system_prompt_ref)
+	SystemPromptRef *prompt.Ref        `json:"system_prompt_ref,omitempty"`
+	Locale      string                 `json:"locale,omitempty"` // 提示词语言 zh/en, 为空时使用 PROMPT_LOCALE
+	Tools       []string               `json:"tools"`       // 工具列表 (tools.ToolRegistry 中的工具名)
 	Temperature float64                `json:"temperature"`
 	MaxTokens   int                    `json:"max_tokens"`
 	
@@ -342,18 +430,17 @@ type Config struct {
 	CEOPrompt         string            `json:"ceo_prompt"`          // CEO 角色提示
 	ManagerPrompt     string            `json:"manager_prompt"`      // Manager 角色提示
 	WorkerPrompt      string            `json:"worker_prompt"`       // Worker 角色提示
-	AvailableModels   []string          `json:"available_models"`    // 可用模型列表（用于动态切换）
+	CEOPromptRef      *prompt.Ref       `json:"ceo_prompt_ref,omitempty"`       // 按模板引用角色提示 (优先于内联文本)
+	ManagerPromptRef  *prompt.Ref       `json:"manager_prompt_ref,omitempty"`
+	WorkerPromptRef   *prompt.Ref       `json:"worker_prompt_ref,omitempty"`
+	CEOFinalPromptRef *prompt.Ref       `json:"ceo_final_prompt_ref,omitempty"`
+	OrgRootID         uint              `json:"org_root_id,omitempty"` // 组织结构根节点 (智能体ID), 按智能体关系构建层级
+	MaxParallel       int               `json:"max_parallel,omitempty"` // 同时执行的子任务数, 默认4
+	AvailableModels   []string          `json:"available_models"`    // 可用模型列表（用于动态切换）, 为空时使用模型服务中可用的模型
 	EnableModelVote   bool              `json:"enable_model_vote"`   // 启用下级投票换领导模型
 	EnablePerfSwitch  bool              `json:"enable_perf_switch"`  // 启用领导根据业绩换下属模型
 }
 
-// Tool 工具定义
-type Tool struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Parameters  map[string]interface{} `json:"parameters"`
-}
-
 // Message 消息
 type Message struct {
 	Role    string `json:"role"`    // system/user/assistant
@@ -389,360 +476,3 @@ func (c *Conversation) ToOpenAIMessages() []openai.ChatCompletionMessage {
 	}
 	return msgs
 }
-
-// ========== 工具服务 ==========
-
-// ToolService 工具服务
-type ToolService struct {
-	tools map[string]ToolHandler
-}
-
-// ToolHandler 工具处理器
-type ToolHandler func(ctx context.Context, params map[string]interface{}) (string, error)
-
-// NewToolService 创建工具服务
-func NewToolService() *ToolService {
-	ts := &ToolService{
-		tools: make(map[string]ToolHandler),
-	}
-
-	// 注册内置工具
-	ts.Register("search_web", ts.handleSearchWeb)
-	ts.Register("calculate", ts.handleCalculate)
-	ts.Register("get_time", ts.handleGetTime)
-	ts.Register("fetch_url", ts.handleFetchURL)
-
-	return ts
-}
-
-// Register 注册工具
-func (ts *ToolService) Register(name string, handler ToolHandler) {
-	ts.tools[name] = handler
-}
-
-// Execute 执行工具
-func (ts *ToolService) Execute(ctx context.Context, name string, params map[string]interface{}) (string, error) {
-	handler, ok := ts.tools[name]
-	if !ok {
-		return "", fmt.Errorf("tool not found: %s", name)
-	}
-	return handler(ctx, params)
-}
-
-// ListTools 列出所有工具
-func (ts *ToolService) ListTools() []Tool {
-	tools := make([]Tool, 0, len(ts.tools))
-	for name, handler := range ts.tools {
-		// 通过反射或注册时存储description
-		tools = append(tools, Tool{
-			Name:        name,
-			Description: fmt.Sprintf("Tool: %s", name),
-		})
-	}
-	_ = handler // 避免编译错误
-	return tools
-}
-
-// ========== 内置工具实现 ==========
-
-func (ts *ToolService) handleSearchWeb(ctx context.Context, params map[string]interface{}) (string, error) {
-	query, _ := params["query"].(string)
-	if query == "" {
-		return "", fmt.Errorf("missing query parameter")
-	}
-	// TODO: 集成搜索API
-	return fmt.Sprintf("搜索结果: %s (请配置搜索API)", query), nil
-}
-
-func (ts *ToolService) handleCalculate(ctx context.Context, params map[string]interface{}) (string, error) {
-	expr, _ := params["expression"].(string)
-	if expr == "" {
-		return "", fmt.Errorf("missing expression parameter")
-	}
-	// 简单计算器 (生产环境使用专门库)
-	// TODO: 使用govaluate或其他库
-	return fmt.Sprintf("计算结果: %s", expr), nil
-}
-
-func (ts *ToolService) handleGetTime(ctx context.Context, params map[string]interface{}) (string, error) {
-	return "当前时间获取成功", nil
-}
-
-func (ts *ToolService) handleFetchURL(ctx context.Context, params map[string]interface{}) (string, error) {
-	url, _ := params["url"].(string)
-	if url == "" {
-		return "", fmt.Errorf("missing url parameter")
-	}
-	// TODO: 实际获取URL内容
-	return fmt.Sprintf("获取内容: %s", url), nil
-}
-
-// ========== 工具转换为OpenAI格式 ==========
-
-// ToOpenAITools 转换为OpenAI工具格式
-func ToOpenAITools(tools []Tool) []openai.Tool {
-	result := make([]openai.Tool, len(tools))
-	for i, t := range tools {
-		params, _ := json.Marshal(t.Parameters)
-		result[i] = openai.Tool{
-			Type: "function",
-			Function: &openai.FunctionDefinition{
-				Name:        t.Name,
-				Description: t.Description,
-				Parameters:  json.RawMessage(params),
-			},
-		}
-	}
-	return result
-}
-
-// ========== 定时绩效评估 ==========
-
-// PerformanceEvalConfig 绩效评估定时任务配置
-type PerformanceEvalConfig struct {
-	EvalInterval time.Duration // 评估间隔，如 1小时、每天
-	Models       []string      // 可用模型列表
-	Workers      []string      // 待评估的下属列表: ["Manager", "Worker"]
-	CompanyGoal  string        // 公司目标/业绩指标
-}
-
-// StartPerformanceEvalScheduler 启动绩效评估定时调度器
-func (s *Service) StartPerformanceEvalScheduler(cfg PerformanceEvalConfig) {
-	go func() {
-		ticker := time.NewTicker(cfg.EvalInterval)
-		defer ticker.Stop()
-		
-		for range ticker.C {
-			s.runCompanyPerformanceEval(cfg)
-		}
-	}()
-}
-
-// runCompanyPerformanceEval 从公司整体业绩出发进行绩效评估
-// 流程：评估公司业绩 → 下属打分 → CEO调整模型 → Manager调整 → Worker调整
-func (s *Service) runCompanyPerformanceEval(cfg PerformanceEvalConfig) {
-	ctx := context.Background()
-	
-	// 1. 获取公司整体业绩
-	companyPerformance := s.getCompanyPerformance()
-	
-	// 2. 下属对 CEO 的打分（Manager 和 Worker 分别评价 CEO）
-	ceoScoreFromManager := s.getSubordinateScore("CEO", "Manager")
-	ceoScoreFromWorker := s.getSubordinateScore("CEO", "Worker")
-	ceoAvgScore := (ceoScoreFromManager + ceoScoreFromWorker) / 2
-	
-	// 3. 获取 CEO 当前使用的模型
-	ceoModel := s.defaultModel
-	
-	fmt.Printf("\n========== 公司绩效评估 ==========\n")
-	fmt.Printf("公司整体业绩: %s\n", companyPerformance)
-	fmt.Printf("目标: %s\n", cfg.CompanyGoal)
-	fmt.Printf("下属打分 - Manager: %d分, Worker: %d分, 平均: %d分\n\n", 
-		ceoScoreFromManager, ceoScoreFromWorker, ceoAvgScore)
-	
-	// 4. CEO 评估：基于公司业绩 + 下属打分，共同决定是否调整模型
-	ceoEvaluation := s.evaluateRoleWithSubordinateScore(ctx, ceoModel, companyPerformance, cfg.CompanyGoal, ceoAvgScore)
-	if ceoEvaluation.shouldSwitch {
-		ceoModel = ceoEvaluation.newModel
-		fmt.Printf("→ CEO 模型调整为: %s (原因: %s)\n", ceoModel, ceoEvaluation.reason)
-	} else {
-		fmt.Printf("→ CEO 模型保持: %s (业绩:%d分, 下属打分:%d分)\n", ceoModel, ceoEvaluation.score, ceoAvgScore)
-	}
-	
-	// 5. Manager 评估：CEO 基于公司业绩评估 Manager
-	managerModel := s.defaultModel
-	managerEvaluation := s.evaluateRolePerformance(ctx, "Manager", managerModel, companyPerformance, cfg.CompanyGoal)
-	if managerEvaluation.shouldSwitch {
-		managerModel = managerEvaluation.newModel
-		fmt.Printf("→ Manager 模型调整为: %s (原因: %s)\n", managerModel, managerEvaluation.reason)
-	} else {
-		fmt.Printf("→ Manager 模型保持: %s (评分:%d分)\n", managerModel, managerEvaluation.score)
-	}
-	
-	// 6. Worker 评估：Manager 基于公司业绩评估 Worker
-	workerModel := s.defaultModel
-	workerEvaluation := s.evaluateRolePerformance(ctx, "Worker", workerModel, companyPerformance, cfg.CompanyGoal)
-	if workerEvaluation.shouldSwitch {
-		workerModel = workerEvaluation.newModel
-		fmt.Printf("→ Worker 模型调整为: %s (原因: %s)\n", workerModel, workerEvaluation.reason)
-	} else {
-		fmt.Printf("→ Worker 模型保持: %s (评分:%d分)\n", workerModel, workerEvaluation.score)
-	}
-	
-	fmt.Printf("=====================================\n\n")
-	
-	// TODO: 保存评估结果到数据库
-}
-
-// getSubordinateScore 获取下属对领导的打分
-func (s *Service) getSubordinateScore(leader, subordinate string) int {
-	// TODO: 从数据库获取实际打分数据
-	// 这里返回模拟数据
-	scores := map[string]int{
-		"CEO_Manager": 75,  // Manager 给 CEO 打分
-		"CEO_Worker":  80,  // Worker 给 CEO 打分
-	}
-	key := fmt.Sprintf("%s_%s", leader, subordinate)
-	if score, ok := scores[key]; ok {
-		return score
-	}
-	return 70 // 默认分数
-}
-
-// evaluateRoleWithSubordinateScore 评估角色表现（结合业绩和下属打分）
-func (s *Service) evaluateRoleWithSubordinateScore(ctx context.Context, currentModel, companyPerformance, goal string, subordinateScore int) EvaluationResult {
-	evalPrompt := fmt.Sprintf(`你作为公司的CEO，需要根据两个因素来决定是否更换AI模型：
-
-因素1 - 公司整体业绩:
-%s
-
-因素2 - 下属对你的平均打分: %d/100分
-(Manager和Worker对你工作表现的评分)
-
-公司目标:
-%s
-
-当前使用的AI模型: %s
-
-评估规则：
-- 如果业绩不达标(<目标80%)，即使下属打分高，也需要考虑更换模型
-- 如果下属打分低(<70分)，即使业绩达标，也需要更换模型
-- 如果两者都差(业绩<60% 且 下属打分<60)，必须更换模型
-
-请直接返回以下格式:
-分数(0-100):
-是否更换模型(yes/no):
-更换原因(一句话):
-推荐模型:
-`, companyPerformance, subordinateScore, goal, currentModel)
-
-	response, err := s.callModel(ctx, currentModel, "你是一个追求业绩的CEO", evalPrompt)
-	if err != nil {
-		return EvaluationResult{shouldSwitch: false, newModel: currentModel, reason: "评估失败"}
-	}
-	
-	// 解析响应
-	result := EvaluationResult{
-		shouldSwitch: false,
-		newModel:     currentModel,
-		reason:       "保持当前模型",
-		score:        subordinateScore,
-	}
-	
-	lines := strings.Split(response, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "分数") {
-			fmt.Sscanf(line, "分数%d", &result.score)
-		}
-		if strings.Contains(line, "是") && strings.Contains(line, "换") {
-			result.shouldSwitch = true
-		}
-		if strings.Contains(line, "更换原因") && len(strings.Split(line, ":")) > 1 {
-			result.reason = strings.TrimSpace(strings.Split(line, ":")[1])
-		}
-		for _, m := range []string{"gpt-4", "glm-4", "claude-3", "kimi"} {
-			if strings.Contains(line, m) {
-				result.newModel = m
-				break
-			}
-		}
-	}
-	
-	// 强制规则：下属打分太低必须换
-	if subordinateScore < 60 {
-		result.shouldSwitch = true
-		result.reason = fmt.Sprintf("下属打分过低(%d分)", subordinateScore)
-	}
-	
-	return result
-}
-
-// EvaluationResult 评估结果
-type EvaluationResult struct {
-	shouldSwitch bool
-	newModel     string
-	reason       string
-	score        int
-}
-
-// evaluateRolePerformance 评估某个角色的表现（从公司业绩出发）
-func (s *Service) evaluateRolePerformance(ctx context.Context, role, currentModel, companyPerformance, goal string) EvaluationResult {
-	evalPrompt := fmt.Sprintf(`你作为公司的%s，需要根据公司整体业绩来评估自己的工作表现和模型配置是否合适。
-
-公司整体业绩/成果:
-%s
-
-公司目标:
-%s
-
-请从以下角度评估:
-1. 自己的工作是否对公司业绩有贡献?
-2. 当前使用的AI模型(%s)是否最适合当前任务?
-3. 是否需要更换模型来提升业绩?
-
-请直接返回以下格式(每行一个):
-分数(0-100):
-是否更换模型(yes/no):
-更换原因(一句话):
-推荐模型(如果是yes):
-`, role, companyPerformance, goal, currentModel)
-
-	response, err := s.callModel(ctx, currentModel, "你是一个追求业绩的领导者", evalPrompt)
-	if err != nil {
-		return EvaluationResult{shouldSwitch: false, newModel: currentModel, reason: "评估失败"}
-	}
-	
-	// 解析响应
-	result := EvaluationResult{
-		shouldSwitch: strings.Contains(response, "yes"),
-		newModel:     currentModel,
-		reason:       "保持当前模型",
-	}
-	
-	// 提取推荐模型
-	lines := strings.Split(response, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "推荐模型") || strings.Contains(line, "gpt-") || strings.Contains(line, "glm-") || strings.Contains(line, "claude") {
-			for _, m := range []string{"gpt-4", "glm-4", "claude-3-sonnet", "kimi"} {
-				if strings.Contains(line, m) {
-					result.newModel = m
-					break
-				}
-			}
-		}
-		if strings.Contains(line, "分数") {
-			fmt.Sscanf(line, "分数%d", &result.score)
-		}
-		if strings.Contains(line, "更换原因") {
-			result.reason = strings.Split(line, ":")[1]
-		}
-	}
-	
-	return result
-}
-
-// getCompanyPerformance 获取公司整体业绩
-func (s *Service) getCompanyPerformance() string {
-	// TODO: 从数据库获取公司实际业绩数据
-	// 这里返回模拟数据
-	return "本月完成产品上线3个，用户增长20%，收入增长15%"
-}
-
-// getWorkerPerformance 获取下属的工作表现数据
-func (s *Service) getWorkerPerformance(worker string) string {
-	// TODO: 从数据库或记忆服务获取下属最近的工作记录
-	// 这里返回模拟数据
-	return fmt.Sprintf("%s 最近完成了代码审查、测试生成等任务，工作质量良好", worker)
-}
-
-// getLeaderRole 获取下属对应的领导角色
-func (s *Service) getLeaderRole(worker string) string {
-	switch worker {
-	case "Manager":
-		return "CEO"
-	case "Worker":
-		return "Manager"
-	default:
-		return "CEO"
-	}
-}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+	"agent-flow/internal/store"
+)
+
+// newTestService 接入模拟 Ollama 的智能体服务, replies 为模型名 -> 固定回复
+func newTestService(t *testing.T, replies map[string]string) *Service {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			var models []map[string]string
+			for name := range replies {
+				models = append(models, map[string]string{"name": name})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": models})
+		case "/api/chat":
+			var req struct {
+				Model string `json:"model"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"model":   req.Model,
+				"message": map[string]string{"role": "assistant", "content": replies[req.Model]},
+			})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, key := range []string{"OPENAI_API_KEY", "ANTHROPIC_API_KEY", "ZHIPU_API_KEY", "MINIMAX_API_KEY",
+		"KIMI_API_KEY", "DASHSCOPE_API_KEY", "DEEPSEEK_API_KEY", "CUSTOM_OPENAI_BASE_URL"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("OLLAMA_BASE_URL", srv.URL)
+	modelSvc := model.NewService()
+	if _, err := modelSvc.DiscoverModels(context.Background(), model.ProviderOllama); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	return NewService(nil, nil, modelSvc, prompt.NewService(nil))
+}
+
+func TestVoteForModel(t *testing.T) {
+	s := newTestService(t, map[string]string{
+		"a": `{"model": "ollama/b", "reason": "更擅长推理"}`,
+		"b": `{"model": "gpt-5", "reason": "不在候选中"}`, // 修复重试后仍不合法, 视为弃权
+	})
+	models := []string{"ollama/a", "ollama/b"}
+	leader := &OrgNode{Name: "CEO", Model: "ollama/a"}
+	voters := []*OrgNode{{Name: "经理", Model: "ollama/a"}, {Name: "员工", Model: "ollama/b"}}
+
+	winner, votes := s.voteForModel(context.Background(), models, leader, voters)
+	if winner != "ollama/b" {
+		t.Errorf("winner = %q, want ollama/b", winner)
+	}
+	if len(votes) != 1 || votes[0].Voter != "经理" || votes[0].Model != "ollama/b" {
+		t.Errorf("votes = %+v", votes)
+	}
+
+	// 候选不足两个时不投票
+	if winner, votes := s.voteForModel(context.Background(), models[:1], leader, voters); winner != "ollama/a" || votes != nil {
+		t.Errorf("single candidate: winner=%q votes=%v", winner, votes)
+	}
+}
+
+func TestBuildRequestKeepsExplicitZeroTemperature(t *testing.T) {
+	s := &Service{}
+	zero := 0.0
+	def := &Definition{Model: "m"}
+	if req := s.BuildRequest(def, nil); req.Temperature != nil {
+		t.Fatalf("unset temperature = %v, want nil", *req.Temperature)
+	}
+	def.Settings.Temperature = &zero
+	req := s.BuildRequest(def, nil)
+	if req.Temperature == nil || *req.Temperature != 0 {
+		t.Fatalf("temperature = %v, want explicit 0", req.Temperature)
+	}
+}
+
+func TestResolvePartialPolicies(t *testing.T) {
+	s := &Service{prompts: prompt.NewService(nil)}
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"cache enabled only", `{"cache":{"enabled":true}}`, ""},
+		{"context strategy only", `{"context":{"strategy":"summarize"}}`, ""},
+		{"both", `{"cache":{"enabled":true,"mode":"semantic"},"context":{"strategy":"keep_last","keep_last":4}}`, ""},
+		{"unknown strategy", `{"context":{"strategy":"shrink"}}`, "model_config.context.strategy"},
+		{"unknown cache mode", `{"cache":{"enabled":true,"mode":"fuzzy"}}`, "model_config.cache.mode"},
+	}
+	for _, tt := range tests {
+		def, err := s.Resolve(&store.Agent{ID: 1, Name: "a", ModelConfig: tt.config})
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if def.Settings.Cache == nil && def.Settings.Context == nil {
+				t.Errorf("%s: policies not decoded", tt.name)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want it to mention %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGuardrailChecksTextParts(t *testing.T) {
+	g, errs := compileGuardrail(GuardrailPolicy{AllowTopics: []string{"订单"}, DenyTopics: []string{"赌博"}, RedactPII: true})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	ctx := context.Background()
+	image := model.ContentPart{Type: model.PartImage, Data: []byte("png"), MimeType: "image/png"}
+
+	// 文字只在片段中时也能命中允许的话题, 并逐段脱敏
+	msg := model.Message{Role: "user", Parts: []model.ContentPart{image, model.TextPart("订单问题, 邮箱 a@example.com")}}
+	out, rewrites, blocked := g.checkMessage(ctx, msg)
+	if blocked != nil {
+		t.Fatalf("blocked: %+v", blocked)
+	}
+	if strings.Contains(out.Parts[1].Text, "a@example.com") || len(rewrites) == 0 {
+		t.Fatalf("part not redacted: %q", out.Parts[1].Text)
+	}
+	if msg.Parts[1].Text != "订单问题, 邮箱 a@example.com" {
+		t.Error("original message parts were modified")
+	}
+
+	// 禁止的话题在片段中也会拦截
+	msg.Parts[1] = model.TextPart("订单里的赌博")
+	if _, _, blocked := g.checkMessage(ctx, msg); blocked == nil || blocked.Rule != RuleDenyTopics {
+		t.Fatalf("blocked = %+v, want deny_topics", blocked)
+	}
+}
+
+func TestCheckTaskOutputArray(t *testing.T) {
+	task := &store.Task{OutputSchema: `{"type": "array", "items": {"type": "object", "required": ["id"]}}`}
+	raw, _, err := checkTaskOutput(task, "结果:\n```json\n[{\"id\": 1}, {\"id\": 2}]\n```")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw != `[{"id": 1}, {"id": 2}]` {
+		t.Fatalf("raw = %q", raw)
+	}
+}
@@ -0,0 +1,567 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/store"
+)
+
+// 任务状态
+const (
+	TaskPending   = "pending"
+	TaskRunning   = "running"
+	TaskCompleted = "completed"
+	TaskFailed    = "failed"
+	TaskEscalated = "escalated" // 执行者无法完成, 上报给委派方
+	TaskCancelled = "cancelled"
+)
+
+// TaskStatuses 任务看板的列顺序
+var TaskStatuses = []string{TaskPending, TaskRunning, TaskEscalated, TaskCompleted, TaskFailed, TaskCancelled}
+
+const (
+	sourceTask         = "task"
+	defaultTaskTimeout = 30 * time.Minute
+	taskPollInterval   = 2 * time.Second
+	escalatePrefix     = "ESCALATE:" // 执行者输出以此开头时上报给委派方
+)
+
+// TaskDone 任务是否已结束
+func TaskDone(status string) bool {
+	switch status {
+	case TaskCompleted, TaskFailed, TaskEscalated, TaskCancelled:
+		return true
+	}
+	return false
+}
+
+// TaskSpec 创建/委派任务的参数
+type TaskSpec struct {
+	AssigneeID   uint                   `json:"assignee_id"`
+	Title        string                 `json:"title"`
+	Instructions string                 `json:"instructions"`
+	OutputSchema map[string]interface{} `json:"output_schema,omitempty"` // 期望输出的JSON Schema, 为空时输出自由文本
+	Deadline     *time.Time             `json:"deadline,omitempty"`      // 子任务为空时继承上级任务的截止时间
+}
+
+// TaskError 任务参数或状态不允许该操作
+type TaskError struct {
+	TaskID uint
+	Reason string
+}
+
+func (e *TaskError) Error() string {
+	if e.TaskID == 0 {
+		return "task: " + e.Reason
+	}
+	return fmt.Sprintf("task %d: %s", e.TaskID, e.Reason)
+}
+
+// TaskColumn 任务看板中的一列
+type TaskColumn struct {
+	Status string       `json:"status"`
+	Count  int          `json:"count"`
+	Tasks  []store.Task `json:"tasks"`
+}
+
+// delegation 上级委派给下属的子任务 (结构化输出)
+type delegation struct {
+	AssigneeID   uint   `json:"assignee_id" desc:"下属智能体ID, 见下属列表"`
+	Title        string `json:"title" desc:"子任务标题"`
+	Instructions string `json:"instructions" desc:"具体要求和验收标准"`
+}
+
+type delegationPlan struct {
+	Analysis string       `json:"analysis" desc:"对任务的分析"`
+	Subtasks []delegation `json:"subtasks" desc:"委派给下属的子任务; 自己可以直接完成时为空"`
+}
+
+// CreateTask 创建顶层任务 (由用户或系统委派), 状态为 pending, 由任务执行协程领取
+func (s *Service) CreateTask(spec TaskSpec) (*store.Task, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+	return s.newTask(nil, 0, spec)
+}
+
+// Delegate 执行中的任务委派子任务, 执行者只能是任务执行者的下属 (memory.GetSubordinateIDs)
+func (s *Service) Delegate(parentID uint, spec TaskSpec) (*store.Task, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+	parent, err := s.db.GetTask(parentID)
+	if err != nil {
+		return nil, &TaskError{TaskID: parentID, Reason: "not found"}
+	}
+	if parent.Status != TaskRunning {
+		return nil, &TaskError{TaskID: parentID, Reason: fmt.Sprintf("cannot delegate from a %s task", parent.Status)}
+	}
+	subs, err := s.GetSubordinates(parent.AssigneeID)
+	if err != nil {
+		return nil, fmt.Errorf("load subordinates of agent %d: %w", parent.AssigneeID, err)
+	}
+	if !containsID(subs, spec.AssigneeID) {
+		return nil, &TaskError{TaskID: parentID, Reason: fmt.Sprintf("agent %d is not a subordinate of agent %d", spec.AssigneeID, parent.AssigneeID)}
+	}
+	return s.newTask(parent, parent.AssigneeID, spec)
+}
+
+func (s *Service) newTask(parent *store.Task, creatorID uint, spec TaskSpec) (*store.Task, error) {
+	if strings.TrimSpace(spec.Instructions) == "" {
+		return nil, &TaskError{Reason: "instructions required"}
+	}
+	if _, err := s.db.GetAgent(spec.AssigneeID); err != nil {
+		return nil, &TaskError{Reason: fmt.Sprintf("assignee agent %d not found", spec.AssigneeID)}
+	}
+
+	schema := "{}"
+	if len(spec.OutputSchema) > 0 {
+		data, err := json.Marshal(spec.OutputSchema)
+		if err != nil {
+			return nil, &TaskError{Reason: fmt.Sprintf("output_schema: %v", err)}
+		}
+		schema = string(data)
+	}
+
+	t := &store.Task{
+		CreatorID:    creatorID,
+		AssigneeID:   spec.AssigneeID,
+		Title:        spec.Title,
+		Instructions: spec.Instructions,
+		OutputSchema: schema,
+		Deadline:     spec.Deadline,
+		Status:       TaskPending,
+	}
+	if parent != nil {
+		t.ParentID = &parent.ID
+		t.RootID = parent.RootID
+		// 子任务不能晚于上级任务的截止时间
+		if parent.Deadline != nil && (t.Deadline == nil || t.Deadline.After(*parent.Deadline)) {
+			t.Deadline = parent.Deadline
+		}
+	}
+	if t.Title == "" {
+		t.Title = truncateRunes(strings.TrimSpace(strings.SplitN(spec.Instructions, "\n", 2)[0]), 60)
+	}
+	if err := s.db.CreateTask(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RunTask 领取并执行任务; 执行者有下属时先拆分委派并等待子任务, 再汇总结果
+// 任务已被其他协程或副本领取时返回 TaskError
+func (s *Service) RunTask(ctx context.Context, id uint) (*store.Task, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("agent store not configured")
+	}
+	started := time.Now()
+	claimed, err := s.db.TransitionTask(id, []string{TaskPending}, map[string]interface{}{"status": TaskRunning, "started_at": started})
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, &TaskError{TaskID: id, Reason: "not pending"}
+	}
+	t, err := s.db.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := started.Add(defaultTaskTimeout)
+	if t.Deadline != nil {
+		deadline = *t.Deadline
+	}
+	runCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	s.trackTask(id, cancel)
+	defer s.untrackTask(id)
+
+	result, escalation, err := s.performTask(runCtx, t)
+	switch {
+	case err == nil && escalation == "":
+		s.finishTask(id, TaskCompleted, map[string]interface{}{"result": result})
+	case err == nil:
+		s.finishTask(id, TaskEscalated, map[string]interface{}{"result": result, "escalation": escalation})
+	default:
+		reason := err.Error()
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			reason = "deadline exceeded"
+		}
+		// 子任务失败时上报给委派方, 顶层任务直接标记失败
+		status := TaskFailed
+		updates := map[string]interface{}{"error": reason}
+		if t.ParentID != nil {
+			status = TaskEscalated
+			updates["escalation"] = reason
+		}
+		s.finishTask(id, status, updates)
+	}
+	return s.db.GetTask(id)
+}
+
+// finishTask 结束执行中的任务 (已被取消或上报的任务保持原状态)
+func (s *Service) finishTask(id uint, status string, updates map[string]interface{}) {
+	updates["status"] = status
+	updates["finished_at"] = time.Now()
+	if _, err := s.db.TransitionTask(id, []string{TaskRunning}, updates); err != nil {
+		log.Printf("[Task] finish task %d: %v", id, err)
+	}
+}
+
+// performTask 执行任务, 返回结果和上报原因 (为空表示完成)
+func (s *Service) performTask(ctx context.Context, t *store.Task) (string, string, error) {
+	def, err := s.LoadAgent(t.AssigneeID)
+	if err != nil {
+		return "", "", err
+	}
+
+	input := taskBrief(t)
+	subs, err := s.GetSubordinates(t.AssigneeID)
+	if err != nil {
+		return "", "", fmt.Errorf("load subordinates: %w", err)
+	}
+	if len(subs) > 0 {
+		plan, err := s.planDelegation(ctx, def, t, subs)
+		if err != nil {
+			return "", "", err
+		}
+		if len(plan.Subtasks) > 0 {
+			report, err := s.delegateAndWait(ctx, t, plan)
+			if err != nil {
+				return "", "", err
+			}
+			input = fmt.Sprintf("%s\n\n你的分析:\n%s\n\n%s\n请审核下属的结果并给出任务的最终结果。", input, plan.Analysis, report)
+		}
+	}
+
+	system := s.SystemPrompt(def, s.GetContextForAgent(ctx, def.ID, t.Instructions)) +
+		"\n\n如果无法完成任务, 请以 " + escalatePrefix + " 开头说明原因, 任务会上报给委派方。"
+	result, err := s.runAs(ctx, def, sourceTask, system, userMessage(input))
+	if err != nil {
+		return "", "", err
+	}
+
+	output := strings.TrimSpace(result.Output)
+	if strings.HasPrefix(output, escalatePrefix) {
+		return "", strings.TrimSpace(strings.TrimPrefix(output, escalatePrefix)), nil
+	}
+	return checkTaskOutput(t, output)
+}
+
+// checkTaskOutput 任务指定了输出Schema时, 结果必须是符合Schema的JSON
+func checkTaskOutput(t *store.Task, output string) (string, string, error) {
+	schema := taskSchema(t)
+	if schema == nil {
+		return output, "", nil
+	}
+	raw := model.ExtractJSON(output, schema)
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return "", "", fmt.Errorf("output is not valid JSON: %w", err)
+	}
+	if problems := model.ValidateSchema(schema, value); len(problems) > 0 {
+		return "", "", fmt.Errorf("output does not match schema: %s", strings.Join(problems, "; "))
+	}
+	return raw, "", nil
+}
+
+func taskSchema(t *store.Task) map[string]interface{} {
+	var schema map[string]interface{}
+	if json.Unmarshal([]byte(t.OutputSchema), &schema) != nil || len(schema) == 0 {
+		return nil
+	}
+	return schema
+}
+
+// taskBrief 把任务整理为执行者的输入
+func taskBrief(t *store.Task) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "任务 #%d: %s\n\n要求:\n%s\n", t.ID, t.Title, t.Instructions)
+	if t.Deadline != nil {
+		fmt.Fprintf(&b, "\n截止时间: %s\n", t.Deadline.Format(time.RFC3339))
+	}
+	if t.OutputSchema != "" && t.OutputSchema != "{}" {
+		fmt.Fprintf(&b, "\n输出必须是符合以下JSON Schema的JSON对象:\n%s\n", t.OutputSchema)
+	}
+	return b.String()
+}
+
+// planDelegation 执行者决定是否把任务拆分给下属
+func (s *Service) planDelegation(ctx context.Context, def *Definition, t *store.Task, subs []uint) (*delegationPlan, error) {
+	var team strings.Builder
+	ids := make([]interface{}, len(subs))
+	for i, id := range subs {
+		ids[i] = id
+		name := fmt.Sprintf("agent-%d", id)
+		if row, err := s.db.GetAgent(id); err == nil {
+			name = row.Name
+		}
+		fmt.Fprintf(&team, "- %d: %s\n", id, name)
+	}
+	user := fmt.Sprintf("%s\n下属列表 (ID: 名称):\n%s\n请分析任务, 需要时拆分为子任务并用ID指定下属执行; 可以自己直接完成时不要拆分。", taskBrief(t), team.String())
+
+	schema := model.SchemaOf(delegationPlan{})
+	items := schema["properties"].(map[string]interface{})["subtasks"].(map[string]interface{})["items"].(map[string]interface{})
+	items["properties"].(map[string]interface{})["assignee_id"].(map[string]interface{})["enum"] = ids
+
+	req := s.BuildRequest(def, []model.Message{
+		{Role: "system", Content: s.SystemPrompt(def, "")},
+		{Role: "user", Content: user},
+	})
+	req.ResponseFormat = &model.ResponseFormat{Name: "delegation_plan", Schema: schema}
+
+	var plan delegationPlan
+	if _, err := s.modelSvc.CompleteJSON(ctx, req, &plan); err != nil {
+		return nil, fmt.Errorf("plan delegation: %w", err)
+	}
+	valid := plan.Subtasks[:0]
+	for _, d := range plan.Subtasks {
+		if containsID(subs, d.AssigneeID) && strings.TrimSpace(d.Instructions) != "" {
+			valid = append(valid, d)
+		}
+	}
+	plan.Subtasks = valid
+	return &plan, nil
+}
+
+// delegateAndWait 创建子任务并行执行, 等待全部结束后整理结果
+func (s *Service) delegateAndWait(ctx context.Context, t *store.Task, plan *delegationPlan) (string, error) {
+	ids := make([]uint, 0, len(plan.Subtasks))
+	for _, d := range plan.Subtasks {
+		sub, err := s.Delegate(t.ID, TaskSpec{AssigneeID: d.AssigneeID, Title: d.Title, Instructions: d.Instructions})
+		if err != nil {
+			return "", err
+		}
+		ids = append(ids, sub.ID)
+	}
+
+	// 子任务可能已被任务执行协程领取, 此时只等待结果
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			var te *TaskError
+			if _, err := s.RunTask(ctx, id); err != nil && !errors.As(err, &te) {
+				log.Printf("[Task] run subtask %d: %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	subtasks, err := s.WaitTasks(ctx, ids)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("下属提交的结果:\n")
+	for _, sub := range subtasks {
+		fmt.Fprintf(&b, "\n[#%d] %s (智能体 %d, %s)\n", sub.ID, sub.Title, sub.AssigneeID, sub.Status)
+		switch sub.Status {
+		case TaskCompleted:
+			fmt.Fprintf(&b, "%s\n", sub.Result)
+		case TaskEscalated:
+			fmt.Fprintf(&b, "上报: %s\n", sub.Escalation)
+		default:
+			fmt.Fprintf(&b, "未完成: %s\n", sub.Error)
+		}
+	}
+	return b.String(), nil
+}
+
+// PollTasks 查询任务状态, done 表示全部已结束
+func (s *Service) PollTasks(ids []uint) ([]store.Task, bool, error) {
+	tasks, err := s.db.GetTasks(ids)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(tasks) != len(ids) {
+		return tasks, false, fmt.Errorf("some tasks not found")
+	}
+	for _, t := range tasks {
+		if !TaskDone(t.Status) {
+			return tasks, false, nil
+		}
+	}
+	return tasks, true, nil
+}
+
+// WaitTasks 轮询直到任务全部结束或ctx到期
+func (s *Service) WaitTasks(ctx context.Context, ids []uint) ([]store.Task, error) {
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+	for {
+		tasks, done, err := s.PollTasks(ids)
+		if err != nil || done {
+			return tasks, err
+		}
+		select {
+		case <-ctx.Done():
+			return tasks, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Escalate 执行者把任务上报给委派方 (上级任务的执行者在汇总时处理)
+func (s *Service) Escalate(id uint, reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return &TaskError{TaskID: id, Reason: "escalation reason required"}
+	}
+	ok, err := s.db.TransitionTask(id, []string{TaskPending, TaskRunning}, map[string]interface{}{
+		"status":      TaskEscalated,
+		"escalation":  reason,
+		"finished_at": time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &TaskError{TaskID: id, Reason: "task already finished"}
+	}
+	s.cancelRunning(id)
+	return nil
+}
+
+// CancelTask 取消任务及其未结束的子任务
+func (s *Service) CancelTask(id uint) error {
+	t, err := s.db.GetTask(id)
+	if err != nil {
+		return &TaskError{TaskID: id, Reason: "not found"}
+	}
+	if TaskDone(t.Status) {
+		return &TaskError{TaskID: id, Reason: "task already finished"}
+	}
+
+	// 同一顶层任务下按 ParentID 找出所有后代
+	all, err := s.db.ListTasks(store.TaskFilter{RootID: t.RootID})
+	if err != nil {
+		return err
+	}
+	children := map[uint][]uint{}
+	for _, x := range all {
+		if x.ParentID != nil {
+			children[*x.ParentID] = append(children[*x.ParentID], x.ID)
+		}
+	}
+	queue := []uint{id}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = append(queue[1:], children[cur]...)
+		if _, err := s.db.TransitionTask(cur, []string{TaskPending, TaskRunning}, map[string]interface{}{
+			"status":      TaskCancelled,
+			"finished_at": time.Now(),
+		}); err != nil {
+			return err
+		}
+		s.cancelRunning(cur)
+	}
+	return nil
+}
+
+// TaskBoard 按状态分列的任务看板
+func (s *Service) TaskBoard(f store.TaskFilter) ([]TaskColumn, error) {
+	tasks, err := s.db.ListTasks(f)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]TaskColumn, len(TaskStatuses))
+	index := map[string]int{}
+	for i, status := range TaskStatuses {
+		columns[i] = TaskColumn{Status: status, Tasks: []store.Task{}}
+		index[status] = i
+	}
+	for _, t := range tasks {
+		if i, ok := index[t.Status]; ok {
+			columns[i].Tasks = append(columns[i].Tasks, t)
+			columns[i].Count++
+		}
+	}
+	return columns, nil
+}
+
+// RunTaskWorker 领取待处理的任务并执行, 由 jobs.Manager 以常驻循环运行; 多副本通过状态流转保证只执行一次
+func (s *Service) RunTaskWorker(ctx context.Context) {
+	sem := make(chan struct{}, defaultMaxParallel)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.db == nil {
+			continue
+		}
+		pending, err := s.db.PendingTasks(cap(sem))
+		if err != nil {
+			log.Printf("[Task] list pending tasks: %v", err)
+			continue
+		}
+	dispatch:
+		for _, t := range pending {
+			select {
+			case sem <- struct{}{}:
+			default:
+				break dispatch
+			}
+			wg.Add(1)
+			go func(id uint) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				var te *TaskError
+				if _, err := s.RunTask(ctx, id); err != nil && !errors.As(err, &te) {
+					log.Printf("[Task] run task %d: %v", id, err)
+				}
+			}(t.ID)
+		}
+	}
+}
+
+func (s *Service) trackTask(id uint, cancel context.CancelFunc) {
+	s.taskMu.Lock()
+	defer s.taskMu.Unlock()
+	if s.taskCancels == nil {
+		s.taskCancels = make(map[uint]context.CancelFunc)
+	}
+	s.taskCancels[id] = cancel
+}
+
+func (s *Service) untrackTask(id uint) {
+	s.taskMu.Lock()
+	defer s.taskMu.Unlock()
+	delete(s.taskCancels, id)
+}
+
+// cancelRunning 中止本副本内正在执行的任务
+func (s *Service) cancelRunning(id uint) {
+	s.taskMu.Lock()
+	cancel := s.taskCancels[id]
+	s.taskMu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"agent-flow/internal/agent"
+	"agent-flow/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Eval Dataset APIs ==========
+
+type EvalCaseRequest struct {
+	Input        string                 `json:"input" binding:"required"`
+	Expected     string                 `json:"expected"`
+	Scorer       string                 `json:"scorer"` // 为空时使用数据集的评分器
+	ScorerConfig map[string]interface{} `json:"scorer_config"`
+	Weight       float64                `json:"weight"`
+}
+
+type EvalDatasetRequest struct {
+	AgentID      uint                   `json:"agent_id"` // 创建时必填, 修改时忽略
+	Name         string                 `json:"name" binding:"required"`
+	Description  string                 `json:"description"`
+	Scorer       string                 `json:"scorer" binding:"required"`
+	ScorerConfig map[string]interface{} `json:"scorer_config"`
+	Cases        []EvalCaseRequest      `json:"cases"`
+}
+
+type EvalRunRequest struct {
+	Models []string `json:"models"` // 为空时使用智能体当前模型和所有可用模型
+}
+
+// ListScorers 可用的评分器
+func (h *Handler) ListScorers(c *gin.Context) {
+	var scorers []gin.H
+	for _, s := range agent.ListScorers() {
+		scorers = append(scorers, gin.H{"name": s.Name(), "description": s.Description()})
+	}
+	c.JSON(http.StatusOK, scorers)
+}
+
+func (h *Handler) ListDatasets(c *gin.Context) {
+	datasets, err := h.db.ListEvalDatasets(parseUint(c.Query("agent_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, datasets)
+}
+
+func (h *Handler) GetDataset(c *gin.Context) {
+	ds, err := h.db.GetEvalDataset(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
+		return
+	}
+	c.JSON(http.StatusOK, ds)
+}
+
+func (h *Handler) CreateDataset(c *gin.Context) {
+	var req EvalDatasetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.db.GetAgent(req.AgentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "agent not found"})
+		return
+	}
+
+	ds := &store.EvalDataset{
+		AgentID:      req.AgentID,
+		Name:         req.Name,
+		Description:  req.Description,
+		Scorer:       req.Scorer,
+		ScorerConfig: jsonString(req.ScorerConfig),
+	}
+	if err := agent.ValidateScorer(ds.Scorer, ds.ScorerConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cases, err := toEvalCases(req.Cases)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.CreateEvalDataset(ds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range cases {
+		cases[i].DatasetID = ds.ID
+	}
+	if err := h.db.CreateEvalCases(cases); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ds.Cases = cases
+	c.JSON(http.StatusCreated, ds)
+}
+
+// UpdateDataset 修改数据集信息和默认评分器 (用例通过 /cases 接口维护)
+func (h *Handler) UpdateDataset(c *gin.Context) {
+	ds, err := h.db.GetEvalDataset(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
+		return
+	}
+	var req EvalDatasetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ds.Name = req.Name
+	ds.Description = req.Description
+	ds.Scorer = req.Scorer
+	ds.ScorerConfig = jsonString(req.ScorerConfig)
+	if err := agent.ValidateScorer(ds.Scorer, ds.ScorerConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.UpdateEvalDataset(ds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ds)
+}
+
+func (h *Handler) DeleteDataset(c *gin.Context) {
+	if err := h.db.DeleteEvalDataset(parseUint(c.Param("id"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// AddDatasetCases 追加用例
+func (h *Handler) AddDatasetCases(c *gin.Context) {
+	ds, err := h.db.GetEvalDataset(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
+		return
+	}
+	var req []EvalCaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cases, err := toEvalCases(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range cases {
+		cases[i].DatasetID = ds.ID
+	}
+	if err := h.db.CreateEvalCases(cases); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, cases)
+}
+
+func (h *Handler) DeleteDatasetCase(c *gin.Context) {
+	if err := h.db.DeleteEvalCase(parseUint(c.Param("id")), parseUint(c.Param("caseId"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// RunDataset 在候选模型上批量评测, 返回排行榜和每个用例的结果
+func (h *Handler) RunDataset(c *gin.Context) {
+	var req EvalRunRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	run, err := h.agentSvc.RunDataset(c.Request.Context(), parseUint(c.Param("id")), req.Models)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, run)
+}
+
+func (h *Handler) ListDatasetRuns(c *gin.Context) {
+	runs, err := h.db.ListEvalRuns(parseUint(c.Param("id")), int(parseUint(c.DefaultQuery("limit", "20"))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+func (h *Handler) GetDatasetRun(c *gin.Context) {
+	run, err := h.db.GetEvalRun(parseUint(c.Param("runId")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "run not found"})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+func toEvalCases(req []EvalCaseRequest) ([]store.EvalCase, error) {
+	cases := make([]store.EvalCase, 0, len(req))
+	for _, r := range req {
+		cfg := jsonString(r.ScorerConfig)
+		if r.Scorer != "" {
+			if err := agent.ValidateScorer(r.Scorer, cfg); err != nil {
+				return nil, err
+			}
+		}
+		weight := r.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		cases = append(cases, store.EvalCase{
+			Input:        r.Input,
+			Expected:     r.Expected,
+			Scorer:       r.Scorer,
+			ScorerConfig: cfg,
+			Weight:       weight,
+		})
+	}
+	return cases, nil
+}
+
+// jsonString 序列化为jsonb列的值 (nil为 {})
+func jsonString(v map[string]interface{}) string {
+	if v == nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"agent-flow/internal/agent"
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Evaluation APIs ==========
+
+func (h *Handler) ListEvaluations(c *gin.Context) {
+	cycles, err := h.db.ListEvaluationCycles(int(parseUint(c.DefaultQuery("limit", "50"))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cycles)
+}
+
+// GetEvaluation 评估轮次详情, 包含每个智能体的KPI、评语和模型切换
+func (h *Handler) GetEvaluation(c *gin.Context) {
+	cycle, err := h.db.GetEvaluationCycle(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "evaluation not found"})
+		return
+	}
+	c.JSON(http.StatusOK, cycle)
+}
+
+// RunEvaluation 立即执行一轮绩效评估
+func (h *Handler) RunEvaluation(c *gin.Context) {
+	var cfg agent.PerformanceEvalConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cycle, err := h.agentSvc.RunPerformanceEval(c.Request.Context(), cfg)
+	if err != nil {
+		if cycle == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, cycle)
+		return
+	}
+	c.JSON(http.StatusCreated, cycle)
+}
+
+// ListAgentEvaluations 智能体的绩效历史
+func (h *Handler) ListAgentEvaluations(c *gin.Context) {
+	evals, err := h.db.ListAgentEvaluations(parseUint(c.Param("id")), int(parseUint(c.DefaultQuery("limit", "50"))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, evals)
+}
+
+// ========== Model Assignment APIs ==========
+
+type ModelAssignmentRequest struct {
+	Model  string `json:"model"`  // 为空时只修改锁定状态
+	Locked bool   `json:"locked"` // 锁定后投票和评估不再切换
+	By     string `json:"by"`     // 操作人
+}
+
+type SwitchPolicyRequest struct {
+	MinTenure   string `json:"min_tenure"` // 如 1h
+	MaxSwitches int    `json:"max_switches"`
+	Period      string `json:"period"` // 如 24h
+}
+
+func (h *Handler) ListModelAssignments(c *gin.Context) {
+	assignments, err := h.db.ListModelAssignments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, assignments)
+}
+
+// SetModelAssignment 管理员指定/锁定模型, subject 为 agent:<id> 或 role:<ceo|manager|worker>
+func (h *Handler) SetModelAssignment(c *gin.Context) {
+	var req ModelAssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.By == "" {
+		req.By = "admin"
+	}
+
+	assignment, err := h.agentSvc.LockModel(c.Param("subject"), req.Model, req.Locked, req.By)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, assignment)
+}
+
+// ListModelSwitches 模型切换记录, 可按 subject 过滤
+func (h *Handler) ListModelSwitches(c *gin.Context) {
+	logs, err := h.db.ListModelSwitchLogs(c.Query("subject"), int(parseUint(c.DefaultQuery("limit", "100"))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, logs)
+}
+
+func (h *Handler) GetSwitchPolicy(c *gin.Context) {
+	policy := h.agentSvc.GetSwitchPolicy()
+	c.JSON(http.StatusOK, SwitchPolicyRequest{
+		MinTenure:   policy.MinTenure.String(),
+		MaxSwitches: policy.MaxSwitches,
+		Period:      policy.Period.String(),
+	})
+}
+
+func (h *Handler) SetSwitchPolicy(c *gin.Context) {
+	var req SwitchPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy := agent.SwitchPolicy{MaxSwitches: req.MaxSwitches}
+	var err error
+	if policy.MinTenure, err = time.ParseDuration(req.MinTenure); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_tenure: " + err.Error()})
+		return
+	}
+	if policy.Period, err = time.ParseDuration(req.Period); err != nil || policy.Period <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be a positive duration"})
+		return
+	}
+
+	h.agentSvc.SetSwitchPolicy(policy)
+	c.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"agent-flow/internal/agent"
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Guardrail APIs ==========
+
+type GuardrailCheckRequest struct {
+	Stage string `json:"stage" binding:"required,oneof=input output"`
+	Text  string `json:"text" binding:"required"`
+}
+
+// ListModerators 可用的内容审核器
+func (h *Handler) ListModerators(c *gin.Context) {
+	var moderators []gin.H
+	for _, m := range agent.ListModerators() {
+		moderators = append(moderators, gin.H{"name": m.Name(), "description": m.Description()})
+	}
+	c.JSON(http.StatusOK, moderators)
+}
+
+// ListGuardrailEvents 护栏拦截/改写记录, 可按 agent_id 过滤
+func (h *Handler) ListGuardrailEvents(c *gin.Context) {
+	events, err := h.db.ListGuardrailEvents(parseUint(c.Query("agent_id")), int(parseUint(c.DefaultQuery("limit", "100"))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// CheckAgentGuardrails 用智能体的护栏策略试运行一段文本 (不调用模型, 不记录)
+func (h *Handler) CheckAgentGuardrails(c *gin.Context) {
+	var req GuardrailCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := agent.ParseAgentID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def, err := h.agentSvc.LoadAgent(id)
+	if err != nil {
+		var cfgErr *agent.ConfigError
+		if errors.As(err, &cfgErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid agent config", "problems": cfgErr.Problems})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	check, err := h.agentSvc.CheckGuardrails(c.Request.Context(), def, req.Stage, req.Text)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, check)
+}
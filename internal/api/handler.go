@@ -1,24 +1,39 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"agent-flow/internal/agent"
 	"agent-flow/internal/store"
 	"agent-flow/internal/channel"
+	"agent-flow/internal/jobs"
+	"agent-flow/internal/memory"
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
 )
 
 type Handler struct {
 	db          *store.Postgres
 	redis       *store.Redis
 	channelMgr  *channel.Manager
+	modelSvc    *model.Service
+	prompts     *prompt.Service
+	agentSvc    *agent.Service
+	jobs        *jobs.Manager
+	memory      *memory.Service
 }
 
-func NewHandler(db *store.Postgres, redis *store.Redis, channelMgr *channel.Manager) *Handler {
+func NewHandler(db *store.Postgres, redis *store.Redis, channelMgr *channel.Manager, modelSvc *model.Service, prompts *prompt.Service, agentSvc *agent.Service) *Handler {
 	return &Handler{
 		db:         db,
 		redis:      redis,
 		channelMgr: channelMgr,
+		modelSvc:   modelSvc,
+		prompts:    prompts,
+		agentSvc:   agentSvc,
 	}
 }
 
@@ -32,6 +47,70 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 			agents.POST("", h.CreateAgent)
 			agents.PUT("/:id", h.UpdateAgent)
 			agents.DELETE("/:id", h.DeleteAgent)
+			agents.POST("/:id/run", h.RunAgent)
+			agents.GET("/:id/org", h.GetAgentOrg)
+			agents.POST("/:id/guardrails/check", h.CheckAgentGuardrails)
+			agents.POST("/collaborate", h.Collaborate)
+			agents.GET("/:id/evaluations", h.ListAgentEvaluations)
+
+			// 组织架构、记忆和知识
+			agents.GET("/:id/relationships", h.GetAgentRelationships)
+			agents.PUT("/:id/parent", h.SetAgentParent)
+			agents.DELETE("/:id/parent", h.RemoveAgentParent)
+			agents.POST("/:id/subordinates", h.AddAgentSubordinate)
+			agents.DELETE("/:id/subordinates/:child_id", h.RemoveAgentSubordinate)
+			agents.GET("/:id/report", h.GetAgentReport)
+			agents.GET("/:id/memories", h.ListAgentMemories)
+			agents.POST("/:id/memories", h.AddAgentMemory)
+			agents.DELETE("/:id/memories/:memory_id", h.DeleteAgentMemory)
+			agents.GET("/:id/knowledge", h.ListAgentKnowledge)
+			agents.POST("/:id/knowledge", h.AddAgentKnowledge)
+			agents.POST("/:id/knowledge/search", h.SearchAgentKnowledge)
+			agents.POST("/:id/knowledge/ingest", h.IngestKnowledge)
+			agents.GET("/:id/knowledge/sources", h.ListKnowledgeSources)
+			agents.DELETE("/:id/knowledge/sources/:source_id", h.DeleteKnowledgeSource)
+			agents.GET("/:id/ingest-jobs", h.ListIngestJobs)
+			agents.GET("/:id/ingest-jobs/:job_id", h.GetIngestJob)
+			agents.PUT("/:id/knowledge/:knowledge_id", h.UpdateAgentKnowledge)
+			agents.DELETE("/:id/knowledge/:knowledge_id", h.DeleteAgentKnowledge)
+		}
+
+		api.GET("/org/chart", h.GetOrgChart)
+
+		// 评测数据集
+		datasets := api.Group("/datasets")
+		{
+			datasets.GET("", h.ListDatasets)
+			datasets.POST("", h.CreateDataset)
+			datasets.GET("/scorers", h.ListScorers)
+			datasets.GET("/:id", h.GetDataset)
+			datasets.PUT("/:id", h.UpdateDataset)
+			datasets.DELETE("/:id", h.DeleteDataset)
+			datasets.POST("/:id/cases", h.AddDatasetCases)
+			datasets.DELETE("/:id/cases/:caseId", h.DeleteDatasetCase)
+			datasets.GET("/:id/runs", h.ListDatasetRuns)
+			datasets.POST("/:id/runs", h.RunDataset)
+			datasets.GET("/:id/runs/:runId", h.GetDatasetRun)
+		}
+
+		// 绩效评估
+		evaluations := api.Group("/evaluations")
+		{
+			evaluations.GET("", h.ListEvaluations)
+			evaluations.POST("", h.RunEvaluation)
+			evaluations.GET("/:id", h.GetEvaluation)
+		}
+
+		// 任务委派
+		tasks := api.Group("/tasks")
+		{
+			tasks.GET("", h.ListTasks)
+			tasks.POST("", h.CreateTask)
+			tasks.GET("/board", h.GetTaskBoard)
+			tasks.GET("/:id", h.GetTask)
+			tasks.POST("/:id/subtasks", h.DelegateTask)
+			tasks.POST("/:id/escalate", h.EscalateTask)
+			tasks.POST("/:id/cancel", h.CancelTask)
 		}
 
 		// 流程管理
@@ -58,6 +137,74 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 		{
 			conversations.GET("", h.ListConversations)
 		}
+
+		// 模型供应商
+		providers := api.Group("/providers")
+		{
+			providers.GET("", h.ListProviders)
+			providers.GET("/health", h.ProviderHealth)
+			providers.POST("", h.CreateProvider)
+			providers.PUT("/:id", h.UpdateProvider)
+			providers.DELETE("/:id", h.DeleteProvider)
+			providers.POST("/:id/test", h.TestProvider)
+			providers.POST("/:id/discover", h.DiscoverProviderModels)
+		}
+
+		// 模型
+		models := api.Group("/models")
+		{
+			models.GET("", h.ListModels)
+			models.POST("", h.CreateModel)
+			models.PUT("/:id", h.UpdateModel)
+			models.DELETE("/:id", h.DeleteModel)
+		}
+
+		// 模型路由
+		routing := api.Group("/routing")
+		{
+			routing.GET("/policies", h.ListRoutingPolicies)
+			routing.PUT("/policies", h.SetRoutingPolicy)
+			routing.GET("/decisions", h.ListRoutingDecisions)
+		}
+
+		// 向量化
+		embeddings := api.Group("/embeddings")
+		{
+			embeddings.GET("/models", h.ListEmbeddingModels)
+			embeddings.POST("", h.CreateEmbeddings)
+		}
+
+		// 提示词模板
+		prompts := api.Group("/prompts")
+		{
+			prompts.GET("", h.ListPrompts)
+			prompts.POST("", h.CreatePrompt)
+			prompts.GET("/:key", h.GetPrompt)
+			prompts.DELETE("/:key", h.DeletePrompt)
+			prompts.GET("/:key/versions", h.ListPromptVersions)
+			prompts.POST("/:key/versions", h.CreatePromptVersion)
+			prompts.POST("/:key/render", h.RenderPrompt)
+		}
+
+		// 管理接口
+		admin := api.Group("/admin")
+		{
+			admin.GET("/limits", h.ListLimits)
+			admin.PUT("/limits/:provider", h.SetLimit)
+			admin.PUT("/cache/agents/:id", h.SetAgentCachePolicy)
+			admin.GET("/context/agents", h.ListAgentContextPolicies)
+			admin.PUT("/context/agents/:id", h.SetAgentContextPolicy)
+			admin.GET("/model-assignments", h.ListModelAssignments)
+			admin.PUT("/model-assignments/:subject", h.SetModelAssignment)
+			admin.GET("/model-switches", h.ListModelSwitches)
+			admin.GET("/model-switch-policy", h.GetSwitchPolicy)
+			admin.PUT("/model-switch-policy", h.SetSwitchPolicy)
+			admin.GET("/jobs", h.ListJobs)
+			admin.GET("/moderators", h.ListModerators)
+			admin.GET("/guardrail-events", h.ListGuardrailEvents)
+			admin.POST("/jobs/:name/run", h.TriggerJob)
+			admin.POST("/knowledge/reindex", h.ReindexKnowledge)
+		}
 	}
 }
 
@@ -70,6 +217,8 @@ type CreateAgentRequest struct {
 	ModelName    string `json:"model_name"`
 	ModelConfig  string `json:"model_config"`
 	Tools        string `json:"tools"`
+	PromptKey    string `json:"prompt_key"`     // 系统提示词模板
+	PromptVersion int   `json:"prompt_version"` // 0表示最新版本
 }
 
 func (h *Handler) ListAgents(c *gin.Context) {
@@ -87,7 +236,6 @@ func (h *Handler) CreateAgent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
 	agent := &store.Agent{
 		Name:          req.Name,
 		Description:   req.Description,
@@ -95,6 +243,11 @@ func (h *Handler) CreateAgent(c *gin.Context) {
 		ModelName:     req.ModelName,
 		ModelConfig:   req.ModelConfig,
 		Tools:         req.Tools,
+		PromptKey:     req.PromptKey,
+		PromptVersion: req.PromptVersion,
+	}
+	if !h.validAgent(c, agent) {
+		return
 	}
 
 	if err := h.db.CreateAgent(agent); err != nil {
@@ -125,6 +278,11 @@ func (h *Handler) UpdateAgent(c *gin.Context) {
 	agent.ModelName = req.ModelName
 	agent.ModelConfig = req.ModelConfig
 	agent.Tools = req.Tools
+	agent.PromptKey = req.PromptKey
+	agent.PromptVersion = req.PromptVersion
+	if !h.validAgent(c, agent) {
+		return
+	}
 
 	if err := h.db.UpdateAgent(agent); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -140,9 +298,107 @@ func (h *Handler) DeleteAgent(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if h.memory != nil {
+		if err := h.memory.RemoveAgent(parseUint(id)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
 
+type RunAgentRequest struct {
+	Input  string `json:"input" binding:"required"`
+}
+
+// RunAgent 按数据库中的配置运行智能体 (用于在界面上试运行)
+func (h *Handler) RunAgent(c *gin.Context) {
+	var req RunAgentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := agent.ParseAgentID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def, err := h.agentSvc.LoadAgent(id)
+	if err != nil {
+		var cfgErr *agent.ConfigError
+		if errors.As(err, &cfgErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid agent config", "problems": cfgErr.Problems})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.agentSvc.RunWithTrace(c.Request.Context(), def, req.Input)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+type CollaborateRequest struct {
+	Config agent.Config `json:"config"`
+	Input  string       `json:"input" binding:"required"`
+}
+
+// Collaborate 多智能体协作处理 (config.org_root_id 指定组织结构)
+func (h *Handler) Collaborate(c *gin.Context) {
+	var req CollaborateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.agentSvc.Collaborate(c.Request.Context(), req.Config, req.Input)
+	if err != nil {
+		var cfgErr *agent.ConfigError
+		if errors.As(err, &cfgErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid agent config", "problems": cfgErr.Problems})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetAgentOrg 以该智能体为根的组织结构 (协作前预览)
+func (h *Handler) GetAgentOrg(c *gin.Context) {
+	id, err := agent.ParseAgentID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	org, err := h.agentSvc.LoadOrg(id)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, org)
+}
+
+// validAgent 按运行时规则校验智能体配置, 不合法时返回400和问题列表
+func (h *Handler) validAgent(c *gin.Context, a *store.Agent) bool {
+	_, err := h.agentSvc.Resolve(a)
+	if err == nil {
+		return true
+	}
+	var cfgErr *agent.ConfigError
+	if errors.As(err, &cfgErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent config", "problems": cfgErr.Problems})
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+	return false
+}
+
 // ========== Flow APIs ==========
 
 type CreateFlowRequest struct {
@@ -308,6 +564,92 @@ func (h *Handler) ListConversations(c *gin.Context) {
 	c.JSON(http.StatusOK, []interface{}{})
 }
 
+// ========== Routing APIs ==========
+
+func (h *Handler) ListRoutingPolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, h.modelSvc.Router().Policies())
+}
+
+func (h *Handler) SetRoutingPolicy(c *gin.Context) {
+	var policy model.RoutingPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if policy.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	if err := h.modelSvc.Router().SavePolicy(c.Request.Context(), &policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+func (h *Handler) ListRoutingDecisions(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	c.JSON(http.StatusOK, h.modelSvc.Router().Decisions(limit))
+}
+
+// ========== Admin APIs ==========
+
+func (h *Handler) ListLimits(c *gin.Context) {
+	limiter := h.modelSvc.Limiter()
+	c.JSON(http.StatusOK, gin.H{
+		"configs":  limiter.Configs(),
+		"limiters": limiter.States(),
+	})
+}
+
+func (h *Handler) SetLimit(c *gin.Context) {
+	var cfg model.LimitConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.modelSvc.Limiter().SaveConfig(c.Request.Context(), model.ModelProvider(c.Param("provider")), cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+func (h *Handler) SetAgentCachePolicy(c *gin.Context) {
+	var policy model.CachePolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.modelSvc.Cache().SetAgentPolicy(c.Param("id"), policy)
+	c.JSON(http.StatusOK, policy)
+}
+
+func (h *Handler) ListAgentContextPolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, h.modelSvc.AgentContextPolicies())
+}
+
+func (h *Handler) SetAgentContextPolicy(c *gin.Context) {
+	var policy model.ContextPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch policy.Strategy {
+	case "", model.ContextNone, model.ContextDropOldest, model.ContextKeepLast, model.ContextSummarize:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown strategy: " + string(policy.Strategy)})
+		return
+	}
+
+	h.modelSvc.SetAgentContextPolicy(c.Param("id"), policy)
+	c.JSON(http.StatusOK, policy)
+}
+
 // ========== 工具函数 ==========
 
 func parseUint(s string) uint {
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"agent-flow/internal/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+// UseJobs 启用后台任务管理接口
+func (h *Handler) UseJobs(mgr *jobs.Manager) {
+	h.jobs = mgr
+}
+
+// ========== Background Job APIs ==========
+
+// ListJobs 后台任务状态及下次执行时间
+func (h *Handler) ListJobs(c *gin.Context) {
+	if h.jobs == nil {
+		c.JSON(http.StatusOK, []jobs.Status{})
+		return
+	}
+	c.JSON(http.StatusOK, h.jobs.Status())
+}
+
+// TriggerJob 立即执行一次定时任务 (异步)
+func (h *Handler) TriggerJob(c *gin.Context) {
+	if h.jobs == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": jobs.ErrJobNotFound.Error()})
+		return
+	}
+	err := h.jobs.Trigger(c.Param("name"))
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"status": "triggered"})
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"net/http"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Provider APIs ==========
+
+type ProviderRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Type    string `json:"type" binding:"required"`
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key"` // 为空时保留原值
+	Enabled *bool  `json:"enabled"`
+}
+
+func (h *Handler) ListProviders(c *gin.Context) {
+	providers, err := h.db.ListModelProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+func (h *Handler) CreateProvider(c *gin.Context) {
+	var req ProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.modelSvc.IsBuiltinProvider(req.Name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "provider name is used by a builtin provider"})
+		return
+	}
+
+	secret, err := store.EncryptSecret(req.APIKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	provider := &store.ModelProvider{
+		Name:         req.Name,
+		Type:         req.Type,
+		BaseURL:      req.BaseURL,
+		APIKeySecret: secret,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+	}
+	if err := h.db.CreateModelProvider(provider); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.reloadModels(c, http.StatusCreated, provider)
+}
+
+func (h *Handler) UpdateProvider(c *gin.Context) {
+	var req ProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	provider, err := h.db.GetModelProvider(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+	if req.Name != provider.Name && h.modelSvc.IsBuiltinProvider(req.Name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "provider name is used by a builtin provider"})
+		return
+	}
+
+	provider.Name = req.Name
+	provider.Type = req.Type
+	provider.BaseURL = req.BaseURL
+	if req.Enabled != nil {
+		provider.Enabled = *req.Enabled
+	}
+	if req.APIKey != "" {
+		secret, err := store.EncryptSecret(req.APIKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		provider.APIKeySecret = secret
+	}
+
+	if err := h.db.UpdateModelProvider(provider); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.reloadModels(c, http.StatusOK, provider)
+}
+
+func (h *Handler) DeleteProvider(c *gin.Context) {
+	if err := h.db.DeleteModelProvider(parseUint(c.Param("id"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.reloadModels(c, http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// TestProvider 测试供应商连接
+func (h *Handler) TestProvider(c *gin.Context) {
+	var req struct {
+		Model string `json:"model" binding:"required"` // 供应商侧的模型名
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	provider, err := h.db.GetModelProvider(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+	apiKey, err := store.DecryptSecret(provider.APIKeySecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := h.modelSvc.TestConnection(c.Request.Context(), model.ModelProvider(provider.Type), apiKey, provider.BaseURL, req.Model)
+	c.JSON(http.StatusOK, result)
+}
+
+// DiscoverProviderModels 拉取供应商的模型列表, 新模型注册为 "<供应商名>/<模型名>"
+func (h *Handler) DiscoverProviderModels(c *gin.Context) {
+	provider, err := h.db.GetModelProvider(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+	apiKey, err := store.DecryptSecret(provider.APIKeySecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	discovered, err := h.modelSvc.DiscoverProviderModels(c.Request.Context(), model.ModelProvider(provider.Type), apiKey, provider.BaseURL)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := h.db.ListModelDefinitions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	known := make(map[string]bool)
+	for _, d := range existing {
+		known[d.Name] = true
+	}
+
+	var created []store.ModelDefinition
+	for _, m := range discovered {
+		name := provider.Name + "/" + m.Name
+		if known[name] || h.modelSvc.IsBuiltinModel(name) {
+			continue
+		}
+		def := store.ModelDefinition{
+			Name:          name,
+			ProviderID:    provider.ID,
+			ModelName:     m.Name,
+			MaxTokens:     2048,
+			Temperature:   0.7,
+			ContextWindow: m.ContextWindow,
+			Enabled:       true,
+		}
+		if err := h.db.CreateModelDefinition(&def); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		created = append(created, def)
+	}
+
+	h.reloadModels(c, http.StatusOK, gin.H{"discovered": discovered, "created": created})
+}
+
+// ProviderHealth 自托管供应商健康检查
+func (h *Handler) ProviderHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, h.modelSvc.HealthCheck(c.Request.Context()))
+}
+
+// ========== Model APIs ==========
+
+type ModelRequest struct {
+	Name          string  `json:"name" binding:"required"`
+	ProviderID    uint    `json:"provider_id" binding:"required"`
+	ModelName     string  `json:"model_name" binding:"required"`
+	MaxTokens     int     `json:"max_tokens"`
+	Temperature   float64 `json:"temperature"`
+	TopP          float64 `json:"top_p"`
+	CostPer1K     float64 `json:"cost_per_1k"`
+	ContextWindow int     `json:"context_window"`
+	Vision        bool    `json:"vision"`
+	Enabled       *bool   `json:"enabled"`
+}
+
+// ListModels 列出运行时所有模型 (内置 + 数据库注册)
+func (h *Handler) ListModels(c *gin.Context) {
+	c.JSON(http.StatusOK, h.modelSvc.ModelInfos())
+}
+
+func (h *Handler) CreateModel(c *gin.Context) {
+	var req ModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.modelSvc.IsBuiltinModel(req.Name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "model name is used by a builtin model"})
+		return
+	}
+
+	def := &store.ModelDefinition{
+		Name:          req.Name,
+		ProviderID:    req.ProviderID,
+		ModelName:     req.ModelName,
+		MaxTokens:     req.MaxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		CostPer1K:     req.CostPer1K,
+		ContextWindow: req.ContextWindow,
+		Vision:        req.Vision,
+		Enabled:       req.Enabled == nil || *req.Enabled,
+	}
+	if err := h.db.CreateModelDefinition(def); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.reloadModels(c, http.StatusCreated, def)
+}
+
+func (h *Handler) UpdateModel(c *gin.Context) {
+	var req ModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	def, err := h.db.GetModelDefinition(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model not found"})
+		return
+	}
+	if req.Name != def.Name && h.modelSvc.IsBuiltinModel(req.Name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "model name is used by a builtin model"})
+		return
+	}
+
+	def.Name = req.Name
+	def.ProviderID = req.ProviderID
+	def.ModelName = req.ModelName
+	def.MaxTokens = req.MaxTokens
+	def.Temperature = req.Temperature
+	def.TopP = req.TopP
+	def.CostPer1K = req.CostPer1K
+	def.ContextWindow = req.ContextWindow
+	def.Vision = req.Vision
+	if req.Enabled != nil {
+		def.Enabled = *req.Enabled
+	}
+
+	if err := h.db.UpdateModelDefinition(def); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.reloadModels(c, http.StatusOK, def)
+}
+
+func (h *Handler) DeleteModel(c *gin.Context) {
+	if err := h.db.DeleteModelDefinition(parseUint(c.Param("id"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.reloadModels(c, http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// reloadModels 热更新模型服务 (含其他副本) 后返回结果
+func (h *Handler) reloadModels(c *gin.Context, status int, body interface{}) {
+	if err := h.modelSvc.RegistryChanged(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "saved but reload failed: " + err.Error()})
+		return
+	}
+	c.JSON(status, body)
+}
+
+// ========== Embedding APIs ==========
+
+type EmbeddingRequest struct {
+	Model string   `json:"model"` // 为空时使用默认向量模型
+	Input []string `json:"input" binding:"required"`
+}
+
+func (h *Handler) ListEmbeddingModels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"default": h.modelSvc.DefaultEmbeddingModel(),
+		"models":  h.modelSvc.EmbeddingModels(),
+	})
+}
+
+func (h *Handler) CreateEmbeddings(c *gin.Context) {
+	var req EmbeddingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.modelSvc.Embed(c.Request.Context(), req.Model, req.Input)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,498 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"agent-flow/internal/memory"
+	"github.com/gin-gonic/gin"
+)
+
+// UseMemory 启用组织架构、记忆和知识接口
+func (h *Handler) UseMemory(mem *memory.Service) {
+	h.memory = mem
+}
+
+// ========== Org Chart APIs ==========
+
+type RelationshipRequest struct {
+	AgentID      uint   `json:"agent_id" binding:"required"` // 上级 (设置上级时) 或下属 (添加下属时)
+	RelationType string `json:"relation_type"`               // manage/delegate/collaborate, 默认 manage
+}
+
+type KnowledgeRequest struct {
+	Title   string `json:"title" binding:"required"`
+	Content string `json:"content" binding:"required"`
+	Tags    string `json:"tags"`
+}
+
+// KnowledgeSearchRequest 知识检索请求
+type KnowledgeSearchRequest struct {
+	Query          string   `json:"query" binding:"required"`
+	TopK           int      `json:"top_k"`
+	Tags           []string `json:"tags"`
+	MaxAccessLevel int      `json:"max_access_level"`
+	Shared         *bool    `json:"shared"` // 是否包含直接下级的知识, 默认包含
+}
+
+type MemoryRequest struct {
+	Type       string `json:"type" binding:"required,oneof=action decision result learn event"`
+	Content    string `json:"content" binding:"required"`
+	Importance int    `json:"importance" binding:"min=0,max=10"` // 0表示自动评分
+}
+
+// orgChartView 带名称的组织架构节点
+type orgChartView struct {
+	AgentID      uint            `json:"agent_id"`
+	Name         string          `json:"name"`
+	RelationType string          `json:"relation_type,omitempty"`
+	Children     []*orgChartView `json:"children,omitempty"`
+}
+
+// requireMemory 未启用记忆服务时返回503
+func (h *Handler) requireMemory(c *gin.Context) bool {
+	if h.memory == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "memory service not configured"})
+		return false
+	}
+	return true
+}
+
+// GetOrgChart 完整组织架构, 没有任何上下级关系的智能体列在 unassigned 中
+func (h *Handler) GetOrgChart(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	roots, err := h.memory.GetOrgChart()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	agents, err := h.db.ListAgents()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	names := map[uint]string{}
+	for _, a := range agents {
+		names[a.ID] = a.Name
+	}
+
+	placed := map[uint]bool{}
+	var view func(n *memory.OrgChartNode) *orgChartView
+	view = func(n *memory.OrgChartNode) *orgChartView {
+		placed[n.AgentID] = true
+		v := &orgChartView{AgentID: n.AgentID, Name: names[n.AgentID], RelationType: n.RelationType}
+		for _, child := range n.Children {
+			v.Children = append(v.Children, view(child))
+		}
+		return v
+	}
+	chart := make([]*orgChartView, 0, len(roots))
+	for _, root := range roots {
+		chart = append(chart, view(root))
+	}
+	unassigned := []gin.H{}
+	for _, a := range agents {
+		if !placed[a.ID] {
+			unassigned = append(unassigned, gin.H{"agent_id": a.ID, "name": a.Name})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"roots": chart, "unassigned": unassigned})
+}
+
+// GetAgentRelationships 智能体的上级和直接下级关系
+func (h *Handler) GetAgentRelationships(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	id := parseUint(c.Param("id"))
+	hierarchy, err := h.memory.GetHierarchy(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	children, err := h.memory.GetChildRelationships(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hierarchy": hierarchy, "relationships": children})
+}
+
+// SetAgentParent 设置上级 (已有上级时改为新上级)
+func (h *Handler) SetAgentParent(c *gin.Context) {
+	var req RelationshipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireMemory(c) {
+		return
+	}
+	h.setRelationship(c, req.AgentID, parseUint(c.Param("id")), req.RelationType)
+}
+
+// RemoveAgentParent 解除与上级的关系
+func (h *Handler) RemoveAgentParent(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	id := parseUint(c.Param("id"))
+	parentID, err := h.memory.GetParentID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if parentID == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent has no parent"})
+		return
+	}
+	if err := h.memory.RemoveRelationship(*parentID, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// AddAgentSubordinate 添加下属或协作关系
+func (h *Handler) AddAgentSubordinate(c *gin.Context) {
+	var req RelationshipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireMemory(c) {
+		return
+	}
+	h.setRelationship(c, parseUint(c.Param("id")), req.AgentID, req.RelationType)
+}
+
+func (h *Handler) RemoveAgentSubordinate(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	if err := h.memory.RemoveRelationship(parseUint(c.Param("id")), parseUint(c.Param("child_id"))); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+func (h *Handler) setRelationship(c *gin.Context, parentID, childID uint, relationType string) {
+	if err := h.memory.SetRelationship(parentID, childID, relationType); err != nil {
+		if errors.Is(err, memory.ErrInvalidRelationship) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	children, err := h.memory.GetChildRelationships(parentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"parent_id": parentID, "relationships": children})
+}
+
+// GetAgentReport 下属工作报告, period: day/week/month 或 72h 这样的时长
+func (h *Handler) GetAgentReport(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	report, err := h.memory.GenerateReport(parseUint(c.Param("id")), c.Query("period"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ========== Memory & Knowledge APIs ==========
+
+// ListAgentMemories 智能体的记忆, 默认按时间倒序; ranked=true 或带 q 时按时效、重要性和相关性综合排序 (查看不计为访问)
+func (h *Handler) ListAgentMemories(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	id := parseUint(c.Param("id"))
+	limit := int(parseUint(c.DefaultQuery("limit", "50")))
+	if c.Query("q") != "" || c.Query("ranked") == "true" {
+		ranked, err := h.memory.RankMemories([]uint{id}, c.Query("q"), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ranked)
+		return
+	}
+	memories, err := h.memory.GetMemories(id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, memories)
+}
+
+func (h *Handler) AddAgentMemory(c *gin.Context) {
+	var req MemoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireMemory(c) {
+		return
+	}
+	mem, err := h.memory.AddMemory(parseUint(c.Param("id")), nil, memory.MemoryType(req.Type), req.Content, req.Importance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, mem)
+}
+
+func (h *Handler) DeleteAgentMemory(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	if err := h.memory.DeleteMemory(parseUint(c.Param("id")), parseUint(c.Param("memory_id"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// ListAgentKnowledge 智能体的知识, shared=true 时包含直接下级贡献的知识, q 按关键词搜索
+func (h *Handler) ListAgentKnowledge(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	id := parseUint(c.Param("id"))
+	var (
+		knowledge []memory.Knowledge
+		err       error
+	)
+	switch {
+	case strings.TrimSpace(c.Query("q")) != "":
+		knowledge, err = h.memory.SearchKnowledge(c.Request.Context(), id, c.Query("q"))
+	case c.Query("shared") == "true":
+		var own []memory.Knowledge
+		if own, err = h.memory.GetKnowledge(id); err == nil {
+			var shared []memory.Knowledge
+			shared, err = h.memory.GetSharedKnowledge(id)
+			knowledge = append(own, shared...)
+		}
+	default:
+		knowledge, err = h.memory.GetKnowledge(id)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, knowledge)
+}
+
+func (h *Handler) AddAgentKnowledge(c *gin.Context) {
+	var req KnowledgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireMemory(c) {
+		return
+	}
+	k, err := h.memory.AddKnowledge(c.Request.Context(), parseUint(c.Param("id")), nil, req.Title, req.Content, req.Tags)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, k)
+}
+
+func (h *Handler) UpdateAgentKnowledge(c *gin.Context) {
+	var req KnowledgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireMemory(c) {
+		return
+	}
+	k, err := h.db.GetKnowledge(parseUint(c.Param("knowledge_id")))
+	if err != nil || k.AgentID != parseUint(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "knowledge not found"})
+		return
+	}
+	k.Title, k.Content, k.Tags = req.Title, req.Content, req.Tags
+	if err := h.memory.UpdateKnowledge(c.Request.Context(), k); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, k)
+}
+
+func (h *Handler) DeleteAgentKnowledge(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	if err := h.memory.DeleteKnowledge(parseUint(c.Param("id")), parseUint(c.Param("knowledge_id"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// SearchAgentKnowledge 混合检索智能体可见的知识, 返回带排名的分块
+func (h *Handler) SearchAgentKnowledge(c *gin.Context) {
+	var req KnowledgeSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireMemory(c) {
+		return
+	}
+	id := parseUint(c.Param("id"))
+	agentIDs := []uint{id}
+	if req.Shared == nil || *req.Shared {
+		subIDs, err := h.memory.GetSubordinateIDs(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		agentIDs = append(agentIDs, subIDs...)
+	}
+	hits, err := h.memory.RetrieveKnowledge(c.Request.Context(), memory.KnowledgeQuery{
+		Query:          req.Query,
+		AgentIDs:       agentIDs,
+		MaxAccessLevel: req.MaxAccessLevel,
+		Tags:           req.Tags,
+		TopK:           req.TopK,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hits)
+}
+
+// ReindexKnowledge 重新分块和向量化全部知识
+func (h *Handler) ReindexKnowledge(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	n, err := h.memory.ReindexKnowledge(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "indexed": n})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"indexed": n})
+}
+
+// ========== Document Ingestion APIs ==========
+
+// IngestKnowledge 上传文档 (multipart: file, 可选 name/format/chunk_size/overlap/tags/access_level),
+// 在后台导入为知识, 返回导入任务
+func (h *Handler) IngestKnowledge(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if file.Size > memory.MaxIngestSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "document too large"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, memory.MaxIngestSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.PostForm("name")
+	if name == "" {
+		name = file.Filename
+	}
+	job, err := h.memory.SubmitIngest(memory.IngestRequest{
+		AgentID: parseUint(c.Param("id")),
+		Name:    name,
+		Format:  c.PostForm("format"),
+		Data:    data,
+		Chunking: memory.ChunkOptions{
+			Size:    int(parseUint(c.PostForm("chunk_size"))),
+			Overlap: int(parseUint(c.PostForm("overlap"))),
+		},
+		Tags:        c.PostForm("tags"),
+		AccessLevel: int(parseUint(c.PostForm("access_level"))),
+	})
+	if err != nil {
+		if errors.Is(err, memory.ErrInvalidDocument) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *Handler) ListIngestJobs(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	jobs, err := h.memory.ListIngestJobs(parseUint(c.Param("id")), int(parseUint(c.DefaultQuery("limit", "50"))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetIngestJob 导入任务的状态和进度
+func (h *Handler) GetIngestJob(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	job, err := h.memory.GetIngestJob(parseUint(c.Param("id")), parseUint(c.Param("job_id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) ListKnowledgeSources(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	sources, err := h.memory.ListSources(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sources)
+}
+
+// DeleteKnowledgeSource 删除源文档及其导入的知识
+func (h *Handler) DeleteKnowledgeSource(c *gin.Context) {
+	if !h.requireMemory(c) {
+		return
+	}
+	if err := h.memory.DeleteSource(parseUint(c.Param("id")), parseUint(c.Param("source_id"))); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
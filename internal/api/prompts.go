@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Prompt APIs ==========
+
+type PromptRequest struct {
+	Key         string            `json:"key"` // 创建时必填, 追加版本时取路径参数
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Variables   []prompt.Variable `json:"variables"`
+	Contents    map[string]string `json:"contents" binding:"required"` // 语言 -> 模板内容
+	Changelog   string            `json:"changelog"`
+}
+
+type RenderPromptRequest struct {
+	Version   int                    `json:"version"` // 0表示最新版本
+	Locale    string                 `json:"locale"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+func (h *Handler) ListPrompts(c *gin.Context) {
+	templates, err := h.prompts.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+func (h *Handler) GetPrompt(c *gin.Context) {
+	tpl, err := h.prompts.Get(c.Param("key"), int(parseUint(c.Query("version"))))
+	if err != nil {
+		c.JSON(promptStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tpl)
+}
+
+func (h *Handler) CreatePrompt(c *gin.Context) {
+	var req PromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.savePrompt(c, req, http.StatusCreated)
+}
+
+func (h *Handler) CreatePromptVersion(c *gin.Context) {
+	var req PromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Key = c.Param("key")
+	h.savePrompt(c, req, http.StatusCreated)
+}
+
+func (h *Handler) ListPromptVersions(c *gin.Context) {
+	versions, err := h.prompts.Versions(c.Param("key"))
+	if err != nil {
+		c.JSON(promptStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+func (h *Handler) DeletePrompt(c *gin.Context) {
+	if err := h.prompts.Delete(c.Param("key")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// RenderPrompt 试渲染模板 (校验变量并返回最终文本)
+func (h *Handler) RenderPrompt(c *gin.Context) {
+	var req RenderPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tpl, err := h.prompts.Get(c.Param("key"), req.Version)
+	if err != nil {
+		c.JSON(promptStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	text, err := h.prompts.Render(prompt.Ref{Key: tpl.Key, Version: tpl.Version, Locale: req.Locale}, req.Variables)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":     tpl.Key,
+		"version": tpl.Version,
+		"text":    text,
+		"tokens":  model.CountTokens(text),
+	})
+}
+
+func (h *Handler) savePrompt(c *gin.Context, req PromptRequest, status int) {
+	tpl, err := h.prompts.Create(prompt.Template{
+		Key:         req.Key,
+		Name:        req.Name,
+		Description: req.Description,
+		Variables:   req.Variables,
+		Contents:    req.Contents,
+	}, req.Changelog)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, tpl)
+}
+
+func promptStatus(err error) int {
+	if errors.Is(err, prompt.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"agent-flow/internal/agent"
+	"agent-flow/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Task APIs ==========
+
+type EscalateTaskRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// taskFilter 从查询参数解析过滤条件: status, assignee_id, root_id, top_level, limit
+func taskFilter(c *gin.Context) store.TaskFilter {
+	return store.TaskFilter{
+		Status:     c.Query("status"),
+		AssigneeID: parseUint(c.Query("assignee_id")),
+		RootID:     parseUint(c.Query("root_id")),
+		TopLevel:   c.Query("top_level") == "true",
+		Limit:      int(parseUint(c.DefaultQuery("limit", "200"))),
+	}
+}
+
+func (h *Handler) ListTasks(c *gin.Context) {
+	tasks, err := h.db.ListTasks(taskFilter(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+// GetTaskBoard 按状态分列的任务看板
+func (h *Handler) GetTaskBoard(c *gin.Context) {
+	columns, err := h.agentSvc.TaskBoard(taskFilter(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, columns)
+}
+
+// GetTask 任务详情, 包含直接子任务
+func (h *Handler) GetTask(c *gin.Context) {
+	task, err := h.db.GetTask(parseUint(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	c.JSON(http.StatusOK, task)
+}
+
+// CreateTask 委派顶层任务给智能体, 由任务执行协程异步执行
+func (h *Handler) CreateTask(c *gin.Context) {
+	var spec agent.TaskSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	task, err := h.agentSvc.CreateTask(spec)
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, task)
+}
+
+// DelegateTask 执行中的任务给执行者的下属创建子任务
+func (h *Handler) DelegateTask(c *gin.Context) {
+	var spec agent.TaskSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	task, err := h.agentSvc.Delegate(parseUint(c.Param("id")), spec)
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, task)
+}
+
+// EscalateTask 把任务上报给委派方
+func (h *Handler) EscalateTask(c *gin.Context) {
+	var req EscalateTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id := parseUint(c.Param("id"))
+	if err := h.agentSvc.Escalate(id, req.Reason); err != nil {
+		taskError(c, err)
+		return
+	}
+	h.GetTask(c)
+}
+
+// CancelTask 取消任务及其未结束的子任务
+func (h *Handler) CancelTask(c *gin.Context) {
+	if err := h.agentSvc.CancelTask(parseUint(c.Param("id"))); err != nil {
+		taskError(c, err)
+		return
+	}
+	h.GetTask(c)
+}
+
+func taskError(c *gin.Context, err error) {
+	var taskErr *agent.TaskError
+	if errors.As(err, &taskErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
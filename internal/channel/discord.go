@@ -2,7 +2,6 @@ package channel
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -277,8 +276,8 @@ type DiscordSelectOption struct {
 }
 
 // CreateResponse 创建交互响应
-func (a *DiscordAdapter) CreateResponse(interactionID, interactionToken, responseType int, data map[string]interface{}) error {
-	apiURL := fmt.Sprintf("https://discord.com/api/v10/interactions/%d/%s/callback", 
+func (a *DiscordAdapter) CreateResponse(interactionID, interactionToken string, responseType int, data map[string]interface{}) error {
+	apiURL := fmt.Sprintf("https://discord.com/api/v10/interactions/%s/%s/callback", 
 		interactionID, interactionToken)
 
 	body, _ := json.Marshal(map[string]interface{}{
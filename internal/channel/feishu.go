@@ -123,6 +123,7 @@ func (a *FeishuAdapter) ParseWebhook(req *http.Request) (*Message, error) {
 	// 解析消息内容
 	content := payload.Event.Message.Content
 	var msgContent string
+	var attachments []Attachment
 	
 	// 文本消息
 	if payload.Event.Message.MessageType == "text" {
@@ -134,9 +135,30 @@ func (a *FeishuAdapter) ParseWebhook(req *http.Request) (*Message, error) {
 		}
 	}
 
+	// 图片/文件/语音/视频消息
+	var mediaContent struct {
+		ImageKey string `json:"image_key"`
+		FileKey  string `json:"file_key"`
+		FileName string `json:"file_name"`
+	}
+	if err := json.Unmarshal([]byte(content), &mediaContent); err == nil {
+		switch payload.Event.Message.MessageType {
+		case "image":
+			attachments = append(attachments, Attachment{Type: "image", MediaID: mediaContent.ImageKey})
+		case "file":
+			attachments = append(attachments, Attachment{Type: "file", MediaID: mediaContent.FileKey, Name: mediaContent.FileName})
+		case "audio":
+			attachments = append(attachments, Attachment{Type: "audio", MediaID: mediaContent.FileKey})
+		case "media":
+			attachments = append(attachments, Attachment{Type: "video", MediaID: mediaContent.FileKey, Name: mediaContent.FileName})
+		}
+	}
+
 	return &Message{
 		Type:      payload.Event.Message.MessageType,
 		Content:   msgContent,
+		MessageID: payload.Event.Message.MessageID,
+		Attachments: attachments,
 		UserID:    payload.Event.Message.Sender.SenderID.OpenID,
 		ChannelID: payload.Event.Message.ChatID,
 		Channel:   string(ChannelFeishu),
@@ -144,6 +166,33 @@ func (a *FeishuAdapter) ParseWebhook(req *http.Request) (*Message, error) {
 	}, nil
 }
 
+// DownloadMedia 下载消息中的资源文件 (实现MediaDownloader接口)
+func (a *FeishuAdapter) DownloadMedia(ctx context.Context, msg *Message, att Attachment) ([]byte, string, error) {
+	if msg.MessageID == "" || att.MediaID == "" {
+		return nil, "", fmt.Errorf("missing message_id or file_key")
+	}
+
+	token, err := a.getTenantAccessToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	resourceType := "file"
+	if att.Type == "image" {
+		resourceType = "image"
+	}
+	url := fmt.Sprintf("https://open.feishu.cn/open-apis/im/v1/messages/%s/resources/%s?type=%s",
+		msg.MessageID, att.MediaID, resourceType)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	return readMedia(a.client, httpReq)
+}
+
 // SendMessage 发送消息
 func (a *FeishuAdapter) SendMessage(recipient, text string) error {
 	// 获取tenant_access_token
@@ -293,7 +342,7 @@ func NewFeishuHandler(appID, appSecret, callbackURL string) *FeishuHandler {
 	adapter.appID = appID
 	adapter.secret = appSecret
 
-	return &FeishuAdapter{
+	return &FeishuHandler{
 		adapter:    adapter,
 		callbackURL: callbackURL,
 	}
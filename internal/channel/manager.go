@@ -1,15 +1,27 @@
 package channel
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"agent-flow/internal/agent"
 	"agent-flow/internal/store"
 )
 
+const (
+	inboundQueueSize  = 256
+	inboundWorkers    = 8
+	inboundTimeout    = 3 * time.Minute // 单条消息的处理时限 (下载附件 + 模型调用 + 回复)
+)
+
 // ChannelType 渠道类型
 type ChannelType string
 
@@ -27,6 +39,8 @@ const (
 type Message struct {
 	Type      string      `json:"type"`       // text/image/file
 	Content   string      `json:"content"`    // 文本内容或文件URL
+	MessageID string      `json:"message_id,omitempty"` // 渠道侧消息ID
+	Attachments []Attachment `json:"attachments,omitempty"` // 图片/文件/语音等附件
 	UserID    string      `json:"user_id"`    // 用户ID
 	ChannelID string      `json:"channel_id"` // 渠道ID
 	Channel   string      `json:"channel"`    // 渠道类型
@@ -43,15 +57,26 @@ type Sender interface {
 type Manager struct {
 	db     *store.Postgres
 	redis  *store.Redis
+	blobs  *store.BlobStore
+	agents *agent.Service
 	adapters map[ChannelType]Adapter
+	inbound  chan inbound
+}
+
+// inbound 待处理的入站消息
+type inbound struct {
+	adapter Adapter
+	msg     *Message
 }
 
 // NewManager 创建渠道管理器
-func NewManager(db *store.Postgres, redis *store.Redis) *Manager {
+func NewManager(db *store.Postgres, redis *store.Redis, blobs *store.BlobStore) *Manager {
 	m := &Manager{
 		db:     db,
 		redis:  redis,
+		blobs:  blobs,
 		adapters: make(map[ChannelType]Adapter),
+		inbound:  make(chan inbound, inboundQueueSize),
 	}
 
 	// 注册渠道适配器
@@ -64,7 +89,13 @@ func NewManager(db *store.Postgres, redis *store.Redis) *Manager {
 	return m
 }
 
-// HandleWebhook 处理各渠道webhook
+// UseAgents 设置处理入站消息的智能体服务
+func (m *Manager) UseAgents(agents *agent.Service) {
+	m.agents = agents
+}
+
+// HandleWebhook 处理各渠道webhook: 解析后立即应答, 附件下载和模型调用由 Run 异步处理,
+// 处理结果通过渠道的主动发送接口回复 (微信要求5秒内应答, 否则会重试推送)
 func (m *Manager) HandleWebhook(c *gin.Context) {
 	channelType := c.Param("channel_type")
 
@@ -81,194 +112,126 @@ func (m *Manager) HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// 处理消息
-	response, err := m.processMessage(msg)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	// 发送回复
-	if response != "" {
-		if err := adapter.SendMessage(msg.UserID, response); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if needsReply(msg) {
+		select {
+		case m.inbound <- inbound{adapter: adapter, msg: msg}:
+		default:
+			// 队列已满时让渠道稍后重试
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "too many pending messages"})
 			return
 		}
 	}
 
+	if adapter.GetChannelType() == ChannelWeChat {
+		c.String(http.StatusOK, "success")
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// processMessage 处理消息（核心逻辑）
-func (m *Manager) processMessage(msg *Message) (string, error) {
-	// TODO: 
-	// 1. 查找或创建会话
-	// 2. 获取关联的Agent/Flow
-	// 3. 调用AI处理
-	// 4. 返回响应
-
-	return "收到消息: " + msg.Content, nil
-}
-
-// Adapter 渠道适配器接口
-type Adapter interface {
-	ParseWebhook(req *http.Request) (*Message, error)
-	SendMessage(userID, text string) error
-	GetChannelType() ChannelType
-}
-
-// ========== 飞书适配器 ==========
-
-type FeishuAdapter struct{}
-
-func NewFeishuAdapter() *FeishuAdapter {
-	return &FeishuAdapter{}
-}
-
-func (a *FeishuAdapter) GetChannelType() ChannelType {
-	return ChannelFeishu
-}
-
-type FeishuWebhook struct {
-	Type    string `json:"type"`
-	Message struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-		ID   string `json:"id"`
-	} `json:"message"`
-	Sender struct {
-		SenderID struct {
-			ID string `json:"id"`
-		} `json:"sender_id"`
-	} `json:"sender"`
-}
-
-func (a *FeishuAdapter) ParseWebhook(req *http.Request) (*Message, error) {
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		return nil, err
+// needsReply 事件、校验请求和空消息不需要处理
+func needsReply(msg *Message) bool {
+	if msg == nil || msg.UserID == "" {
+		return false
 	}
-
-	var webhook FeishuWebhook
-	if err := json.Unmarshal(body, &webhook); err != nil {
-		return nil, err
+	switch msg.Type {
+	case "event", "url_verification":
+		return false
 	}
-
-	return &Message{
-		Type:    webhook.Message.Type,
-		Content: webhook.Message.Text,
-		UserID:  webhook.Sender.SenderID.ID,
-		Channel: string(ChannelFeishu),
-		RawData: webhook,
-	}, nil
+	return msg.Content != "" || len(msg.Attachments) > 0
 }
 
-func (a *FeishuAdapter) SendMessage(userID, text string) error {
-	// TODO: 调用飞书API发送消息
-	fmt.Printf("[Feishu] Send to %s: %s\n", userID, text)
-	return nil
-}
-
-// ========== Telegram适配器 ==========
-
-type TelegramAdapter struct{}
+// Run 处理入站消息队列, ctx 结束时等待处理中的消息返回
+func (m *Manager) Run(ctx context.Context) {
+	sem := make(chan struct{}, inboundWorkers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
-func NewTelegramAdapter() *TelegramAdapter {
-	return &TelegramAdapter{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case in := <-m.inbound:
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				m.handleInbound(ctx, in.adapter, in.msg)
+			}()
+		}
+	}
 }
 
-func (a *TelegramAdapter) GetChannelType() ChannelType {
-	return ChannelTelegram
-}
+// handleInbound 下载附件、交给智能体处理并回复
+func (m *Manager) handleInbound(ctx context.Context, adapter Adapter, msg *Message) {
+	ctx, cancel := context.WithTimeout(ctx, inboundTimeout)
+	defer cancel()
 
-type TelegramUpdate struct {
-	UpdateID int64 `json:"update_id"`
-	Message  struct {
-		Text     string `json:"text"`
-		Chat     struct {
-			ID int64 `json:"id"`
-		} `json:"chat"`
-		From struct {
-			ID int64 `json:"id"`
-		} `json:"from"`
-	} `json:"message"`
-}
+	// 下载图片/文件等附件到本地存储
+	m.downloadMedia(ctx, adapter, msg)
 
-func (a *TelegramAdapter) ParseWebhook(req *http.Request) (*Message, error) {
-	body, err := io.ReadAll(req.Body)
+	response, err := m.processMessage(ctx, msg)
 	if err != nil {
-		return nil, err
+		log.Printf("[Channel] process %s message from %s failed: %v", msg.Channel, msg.UserID, err)
+		return
 	}
-
-	var update TelegramUpdate
-	if err := json.Unmarshal(body, &update); err != nil {
-		return nil, err
+	if response == "" {
+		return
+	}
+	if err := adapter.SendMessage(msg.UserID, response); err != nil {
+		log.Printf("[Channel] reply %s message to %s failed: %v", msg.Channel, msg.UserID, err)
 	}
-
-	return &Message{
-		Type:    "text",
-		Content: update.Message.Text,
-		UserID:  fmt.Sprintf("%d", update.Message.From.ID),
-		Channel: string(ChannelTelegram),
-		RawData: update,
-	}, nil
-}
-
-func (a *TelegramAdapter) SendMessage(userID, text string) error {
-	// TODO: 调用Telegram Bot API发送消息
-	fmt.Printf("[Telegram] Send to %s: %s\n", userID, text)
-	return nil
-}
-
-// ========== Discord适配器 ==========
-
-type DiscordAdapter struct{}
-
-func NewDiscordAdapter() *DiscordAdapter {
-	return &DiscordAdapter{}
-}
-
-func (a *DiscordAdapter) GetChannelType() ChannelType {
-	return ChannelDiscord
 }
 
-type DiscordWebhook struct {
-	Type        int    `json:"type"`
-	ChannelID   string `json:"channel_id"`
-	GuildID     string `json:"guild_id"`
-	Content     string `json:"content"`
-	Member      struct {
-		User struct {
-			ID       string `json:"id"`
-			Username string `json:"username"`
-		} `json:"user"`
-	} `json:"member"`
+// processMessage 把消息 (含附件) 交给渠道绑定的智能体处理, 未绑定时由默认模型回答
+func (m *Manager) processMessage(ctx context.Context, msg *Message) (string, error) {
+	if m.agents == nil {
+		return "", fmt.Errorf("agent service not configured")
+	}
+	return m.agents.ProcessMessage(ctx, m.agentFor(msg.Channel), m.ModelMessage(msg))
 }
 
-func (a *DiscordAdapter) ParseWebhook(req *http.Request) (*Message, error) {
-	body, err := io.ReadAll(req.Body)
+// agentFor 渠道配置中绑定的智能体 ({"agent_id": 1}), 0 表示未绑定
+func (m *Manager) agentFor(channelType string) uint {
+	if m.db == nil {
+		return 0
+	}
+	channels, err := m.db.ListChannels()
 	if err != nil {
-		return nil, err
+		log.Printf("[Channel] list channels: %v", err)
+		return 0
 	}
-
-	var webhook DiscordWebhook
-	if err := json.Unmarshal(body, &webhook); err != nil {
-		return nil, err
+	for _, ch := range channels {
+		if ch.Type != channelType || !ch.Enabled || ch.Config == "" {
+			continue
+		}
+		var cfg struct {
+			AgentID json.RawMessage `json:"agent_id"`
+		}
+		if err := json.Unmarshal([]byte(ch.Config), &cfg); err != nil || len(cfg.AgentID) == 0 {
+			continue
+		}
+		var raw string
+		if err := json.Unmarshal(cfg.AgentID, &raw); err != nil {
+			raw = string(cfg.AgentID) // 数字
+		}
+		if id, err := strconv.ParseUint(raw, 10, 64); err == nil && id > 0 {
+			return uint(id)
+		}
 	}
-
-	return &Message{
-		Type:    "text",
-		Content: webhook.Content,
-		UserID:  webhook.Member.User.ID,
-		Channel: string(ChannelDiscord),
-		RawData: webhook,
-	}, nil
+	return 0
 }
 
-func (a *DiscordAdapter) SendMessage(userID, text string) error {
-	// TODO: 调用Discord API发送消息
-	fmt.Printf("[Discord] Send to %s: %s\n", userID, text)
-	return nil
+// Adapter 渠道适配器接口
+type Adapter interface {
+	ParseWebhook(req *http.Request) (*Message, error)
+	SendMessage(userID, text string) error
+	GetChannelType() ChannelType
 }
 
 // ========== WhatsApp适配器 ==========
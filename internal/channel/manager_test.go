@@ -0,0 +1,108 @@
+package channel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-flow/internal/agent"
+	"agent-flow/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// recordingAdapter 记录主动回复的微信适配器
+type recordingAdapter struct {
+	*WeChatAdapter
+	sent chan string
+}
+
+func (a *recordingAdapter) SendMessage(userID, text string) error {
+	a.sent <- userID + ":" + text
+	return nil
+}
+
+func TestWebhookAcksBeforeProcessing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 模型回复前阻塞, 确认 webhook 不等待模型调用
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": []map[string]string{{"name": "bot"}}})
+		case "/api/chat":
+			<-release
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"model":   "bot",
+				"message": map[string]string{"role": "assistant", "content": "您好"},
+			})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{})
+		}
+	}))
+	defer srv.Close()
+
+	// 只使用模拟的 Ollama
+	for _, key := range []string{"OPENAI_API_KEY", "ANTHROPIC_API_KEY", "ZHIPU_API_KEY", "MINIMAX_API_KEY",
+		"KIMI_API_KEY", "DASHSCOPE_API_KEY", "DEEPSEEK_API_KEY", "CUSTOM_OPENAI_BASE_URL"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("OLLAMA_BASE_URL", srv.URL)
+	modelSvc := model.NewService()
+	if _, err := modelSvc.DiscoverModels(context.Background(), model.ProviderOllama); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	adapter := &recordingAdapter{WeChatAdapter: NewWeChatAdapter(), sent: make(chan string, 1)}
+	m := NewManager(nil, nil, nil)
+	m.adapters[ChannelWeChat] = adapter
+	m.UseAgents(agent.NewService(nil, nil, modelSvc, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	r := gin.New()
+	r.POST("/webhook/:channel_type", m.HandleWebhook)
+	body := `<xml><ToUserName>gh_1</ToUserName><FromUserName>user1</FromUserName>` +
+		`<MsgType>text</MsgType><Content>你好</Content><MsgId>1</MsgId></xml>`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/wechat", strings.NewReader(body)))
+	if w.Code != http.StatusOK || w.Body.String() != "success" {
+		t.Fatalf("webhook response = %d %q", w.Code, w.Body.String())
+	}
+
+	close(release)
+	select {
+	case got := <-adapter.sent:
+		if got != "user1:您好" {
+			t.Fatalf("reply = %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply sent")
+	}
+}
+
+func TestNeedsReply(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+		want bool
+	}{
+		{"nil", nil, false},
+		{"text", &Message{Type: "text", UserID: "u", Content: "hi"}, true},
+		{"image only", &Message{Type: "image", UserID: "u", Attachments: []Attachment{{Type: "image"}}}, true},
+		{"event", &Message{Type: "event", UserID: "u", Content: "subscribe"}, false},
+		{"empty", &Message{Type: "text", UserID: "u"}, false},
+		{"no user", &Message{Type: "text", Content: "hi"}, false},
+	}
+	for _, tt := range tests {
+		if got := needsReply(tt.msg); got != tt.want {
+			t.Errorf("%s: needsReply = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"agent-flow/internal/model"
+)
+
+// maxMediaSize 单个附件大小上限
+const maxMediaSize = 20 << 20
+
+// Attachment 消息附件
+type Attachment struct {
+	Type     string `json:"type"`               // image/file/audio/video
+	MediaID  string `json:"media_id,omitempty"` // 渠道侧媒体ID (微信media_id / 飞书file_key)
+	URL      string `json:"url,omitempty"`      // 渠道提供的下载地址
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	BlobID   string `json:"blob_id,omitempty"` // 本地存储ID, 下载后填充
+	Size     int64  `json:"size,omitempty"`
+}
+
+// MediaDownloader 需要鉴权才能下载附件的渠道适配器
+type MediaDownloader interface {
+	DownloadMedia(ctx context.Context, msg *Message, att Attachment) ([]byte, string, error)
+}
+
+// downloadMedia 下载入站附件并存入本地存储 (失败只记录, 不影响消息处理)
+func (m *Manager) downloadMedia(ctx context.Context, adapter Adapter, msg *Message) {
+	if m.blobs == nil || msg == nil {
+		return
+	}
+
+	for i, att := range msg.Attachments {
+		if att.BlobID != "" {
+			continue
+		}
+
+		var data []byte
+		var mimeType string
+		var err error
+		if downloader, ok := adapter.(MediaDownloader); ok {
+			data, mimeType, err = downloader.DownloadMedia(ctx, msg, att)
+		} else if att.URL != "" {
+			data, mimeType, err = fetchMedia(ctx, att.URL)
+		} else {
+			continue
+		}
+		if err != nil {
+			log.Printf("[Channel] download %s attachment from %s failed: %v", att.Type, msg.Channel, err)
+			continue
+		}
+
+		if att.MimeType != "" {
+			mimeType = att.MimeType
+		}
+		blob, err := m.blobs.Put(data, mimeType, att.Name, msg.Channel)
+		if err != nil {
+			log.Printf("[Channel] store attachment failed: %v", err)
+			continue
+		}
+		msg.Attachments[i].BlobID = blob.ID
+		msg.Attachments[i].MimeType = blob.MimeType
+		msg.Attachments[i].Size = blob.Size
+	}
+}
+
+// ModelMessage 把渠道消息转换为模型消息, 附件以多模态片段附带
+func (m *Manager) ModelMessage(msg *Message) model.Message {
+	result := model.Message{Role: "user", Content: msg.Content}
+	for _, att := range msg.Attachments {
+		part := model.ContentPart{
+			Type:     attachmentPartType(att.Type),
+			Name:     att.Name,
+			MimeType: att.MimeType,
+			BlobID:   att.BlobID,
+		}
+		if att.BlobID != "" && m.blobs != nil {
+			if data, blob, err := m.blobs.Get(att.BlobID); err == nil {
+				part.Data = data
+				part.MimeType = blob.MimeType
+			}
+		}
+		if len(part.Data) == 0 {
+			part.URL = att.URL
+		}
+		result.Parts = append(result.Parts, part)
+	}
+	return result
+}
+
+func attachmentPartType(t string) model.ContentPartType {
+	switch t {
+	case "image":
+		return model.PartImage
+	case "audio", "voice":
+		return model.PartAudio
+	default:
+		return model.PartFile
+	}
+}
+
+// fetchMedia 直接下载公开地址的附件
+func fetchMedia(ctx context.Context, url string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return readMedia(http.DefaultClient, req)
+}
+
+// readMedia 执行下载请求并限制大小
+func readMedia(client *http.Client, req *http.Request) ([]byte, string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("download failed: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxMediaSize {
+		return nil, "", fmt.Errorf("attachment exceeds %d bytes", maxMediaSize)
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+	}
+	return data, mimeType, nil
+}
@@ -1,12 +1,15 @@
 package channel
 
 import (
+	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"os"
 	"sort"
 	"strings"
 	"time"
@@ -83,24 +86,7 @@ func (a *WeChatAdapter) ParseWebhook(req *http.Request) (*Message, error) {
 		return nil, err
 	}
 
-	// 微信消息格式
-	var msg struct {
-		XMLName      xml.Name `xml:"xml"`
-		ToUserName   string   `xml:"ToUserName"`
-		FromUserName string   `xml:"FromUserName"`
-		CreateTime   string   `xml:"CreateTime"`
-		MsgType      string   `xml:"MsgType"`
-		Content      string   `xml:"Content"`
-		MsgId        string   `xml:"MsgId"`
-		Event        string   `xml:"Event"`
-		EventKey     string   `xml:"EventKey"`
-		ScanCodeInfo struct {
-			ScanType   string `xml:"ScanType"`
-			ScanResult string `xml:"ScanResult"`
-		} `xml:"ScanCodeInfo"`
-		MenuID       string `xml:"MenuId"`
-	}
-
+	var msg wechatMessage
 	if err := xml.Unmarshal(body, &msg); err != nil {
 		return nil, err
 	}
@@ -114,34 +100,43 @@ func (a *WeChatAdapter) ParseWebhook(req *http.Request) (*Message, error) {
 	return a.handleMessage(&msg), nil
 }
 
+// wechatMessage 微信推送的XML消息
+type wechatMessage struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:"ToUserName"`
+	FromUserName string   `xml:"FromUserName"`
+	CreateTime   string   `xml:"CreateTime"`
+	MsgType      string   `xml:"MsgType"`
+	Content      string   `xml:"Content"`
+	MsgId        string   `xml:"MsgId"`
+	PicUrl       string   `xml:"PicUrl"`
+	MediaId      string   `xml:"MediaId"`
+	Format       string   `xml:"Format"`      // 语音格式, 如 amr
+	Recognition  string   `xml:"Recognition"` // 语音识别结果 (需开通)
+	Event        string   `xml:"Event"`
+	EventKey     string   `xml:"EventKey"`
+	ScanCodeInfo struct {
+		ScanType   string `xml:"ScanType"`
+		ScanResult string `xml:"ScanResult"`
+	} `xml:"ScanCodeInfo"`
+	MenuID string `xml:"MenuId"`
+}
+
 // handleEvent 处理事件
-func (a *WeChatAdapter) handleEvent(msg *xml.Token) (*Message, error) {
-	// 需要使用完整结构体
+func (a *WeChatAdapter) handleEvent(msg *wechatMessage) (*Message, error) {
 	return &Message{
-		Type:    "event",
-		Content: msg.(*struct {
-			Event string `xml:"Event"`
-		}).Event,
-		Channel: string(ChannelType("wechat")),
+		Type:      "event",
+		Content:   msg.Event,
+		UserID:    msg.FromUserName,
+		ChannelID: msg.ToUserName,
+		Channel:   string(ChannelWeChat),
 	}, nil
 }
 
-func (a *WeChatAdapter) handleMessage(msg *interface{}) *Message {
-	// 类型断言
-	m := msg.(*struct {
-		XMLName      xml.Name
-		ToUserName   string
-		FromUserName string
-		CreateTime   string
-		MsgType      string
-		Content      string
-		MsgId        string
-		Event        string
-		EventKey     string
-	})
-
+func (a *WeChatAdapter) handleMessage(m *wechatMessage) *Message {
 	var content string
 	var msgType string
+	var attachments []Attachment
 
 	switch m.MsgType {
 	case "text":
@@ -149,16 +144,17 @@ func (a *WeChatAdapter) handleMessage(msg *interface{}) *Message {
 		msgType = "text"
 	case "image":
 		msgType = "image"
-		content = "图片消息"
+		attachments = append(attachments, Attachment{Type: "image", MediaID: m.MediaId, URL: m.PicUrl})
 	case "voice":
 		msgType = "voice"
-		content = "语音消息"
+		content = m.Recognition
+		attachments = append(attachments, Attachment{Type: "audio", MediaID: m.MediaId, Name: m.MediaId + "." + m.Format})
 	case "video":
 		msgType = "video"
-		content = "视频消息"
+		attachments = append(attachments, Attachment{Type: "video", MediaID: m.MediaId})
 	case "shortvideo":
 		msgType = "shortvideo"
-		content = "短视频消息"
+		attachments = append(attachments, Attachment{Type: "video", MediaID: m.MediaId})
 	case "location":
 		msgType = "location"
 		content = "位置消息"
@@ -171,16 +167,23 @@ func (a *WeChatAdapter) handleMessage(msg *interface{}) *Message {
 	}
 
 	return &Message{
-		Type:      msgType,
-		Content:   content,
-		UserID:    m.FromUserName,
-		ChannelID: m.ToUserName,
-		Channel:   string(ChannelType("wechat")),
+		Type:        msgType,
+		Content:     content,
+		MessageID:   m.MsgId,
+		Attachments: attachments,
+		UserID:      m.FromUserName,
+		ChannelID:   m.ToUserName,
+		Channel:     string(ChannelType("wechat")),
 	}
 }
 
-// SendMessage 发送消息
-func (a *WeChatAdapter) SendMessage(toUserName, msgType, content string) error {
+// SendMessage 发送文本消息 (客服消息接口)
+func (a *WeChatAdapter) SendMessage(toUserName, text string) error {
+	return a.SendCustomMessage(toUserName, "text", text)
+}
+
+// SendCustomMessage 发送客服消息 (text/image/news)
+func (a *WeChatAdapter) SendCustomMessage(toUserName, msgType, content string) error {
 	// 获取access_token
 	token, err := a.getAccessToken()
 	if err != nil {
@@ -230,7 +233,7 @@ func (a *WeChatAdapter) SendMessage(toUserName, msgType, content string) error {
 
 // SendText 发送文本消息 (实现Sender接口)
 func (a *WeChatAdapter) SendText(userID, text string) error {
-	return a.SendMessage(userID, "text", text)
+	return a.SendMessage(userID, text)
 }
 
 // ========== Access Token管理 ==========
@@ -389,16 +392,8 @@ func (a *WeChatAdapter) SendTemplateMessage(toUser, templateID, data string) err
 
 // UploadMedia 上传临时素材
 func (a *WeChatAdapter) UploadMedia(mediaType, filePath string) (string, error) {
-	token, err := a.getAccessToken()
-	if err != nil {
-		return "", err
-	}
-
-	apiURL := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/media/upload?access_token=%s&type=%s",
-		token, mediaType)
-
-	// TODO: 实现文件上传
-	return "", nil
+	// TODO: 实现文件上传 (POST multipart 到 cgi-bin/media/upload)
+	return "", fmt.Errorf("wechat media upload not implemented")
 }
 
 // GetMedia 获取临时素材
@@ -420,6 +415,37 @@ func (a *WeChatAdapter) GetMedia(mediaID string) ([]byte, error) {
 	return io.ReadAll(resp.Body)
 }
 
+// DownloadMedia 下载入站附件 (实现MediaDownloader接口): 优先用media_id走素材接口, 否则用PicUrl
+func (a *WeChatAdapter) DownloadMedia(ctx context.Context, msg *Message, att Attachment) ([]byte, string, error) {
+	if att.MediaID == "" {
+		if att.URL == "" {
+			return nil, "", fmt.Errorf("attachment has no media_id or url")
+		}
+		return fetchMedia(ctx, att.URL)
+	}
+
+	token, err := a.getAccessToken()
+	if err != nil {
+		return nil, "", err
+	}
+	apiURL := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/media/get?access_token=%s&media_id=%s",
+		token, att.MediaID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	data, mimeType, err := readMedia(a.client, req)
+	if err != nil {
+		return nil, "", err
+	}
+	// 出错时微信返回JSON而不是文件
+	if strings.HasPrefix(mimeType, "application/json") || strings.HasPrefix(mimeType, "text/plain") {
+		return nil, "", fmt.Errorf("wechat media error: %s", string(data))
+	}
+	return data, mimeType, nil
+}
+
 // ========== 二维码 ==========
 
 // CreateQRCode 创建临时二维码
@@ -536,24 +562,26 @@ func (a *WeChatAdapter) postJSON(url string, body interface{}, dest interface{})
 }
 
 func encodeJSON(v interface{}) (string, error) {
-	// 简化实现
-	return fmt.Sprintf("%v", v), nil
+	if raw, ok := v.(string); ok {
+		return raw, nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
 }
 
 func decodeJSON(r io.Reader, dest interface{}) error {
-	return nil
+	return json.NewDecoder(r).Decode(dest)
 }
 
 func decodeConfig(config string, dest interface{}) error {
-	// 简单解析
-	return nil
+	return json.Unmarshal([]byte(config), dest)
 }
 
 // GetWeChatConfigFromEnv 从环境变量获取配置
 func GetWeChatConfigFromEnv() (appID, appSecret, token string) {
-	appID = "wx"
-	appSecret = ""
-	token = ""
+	appID = os.Getenv("WECHAT_APP_ID")
+	appSecret = os.Getenv("WECHAT_APP_SECRET")
+	token = os.Getenv("WECHAT_TOKEN")
 	return
 }
 
@@ -2,12 +2,13 @@ package chat
 
 import (
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 // Handler HTTP处理器
@@ -20,6 +21,21 @@ func NewHandler(service *Service) *Handler {
 	return &Handler{service: service}
 }
 
+// RegisterRoutes 注册聊天路由
+func (h *Handler) RegisterRoutes(r *gin.Engine) {
+	chat := r.Group("/api/chat")
+	{
+		chat.GET("/conversations", h.ListConversations)
+		chat.POST("/conversations", h.CreateConversation)
+		chat.GET("/conversations/:id", h.GetConversation)
+		chat.PUT("/conversations/:id/title", h.UpdateConversationTitle)
+		chat.DELETE("/conversations/:id", h.DeleteConversation)
+		chat.GET("/conversations/:id/messages", h.GetMessages)
+		chat.POST("/messages", h.SendMessage)
+		chat.GET("/ws/:conversation_id", h.WebSocket)
+	}
+}
+
 // ========== 会话API ==========
 
 // CreateConversation 创建会话
@@ -197,6 +213,7 @@ func (h *Handler) WebSocket(c *gin.Context) {
 		UserID: userID,
 		Send:   make(chan []byte, 256),
 		Hub:    h.service.hub,
+		conn:   conn,
 	}
 
 	// 注册客户端
@@ -204,18 +221,18 @@ func (h *Handler) WebSocket(c *gin.Context) {
 
 	// 启动读写协程
 	go client.WritePump()
-	go client.ReadPump(c.Param("conversation_id"))
+	go client.ReadPump(h.service, c.Param("conversation_id"))
 }
 
 // ReadPump 读取客户端消息
-func (c *Client) ReadPump(convID string) {
+func (c *Client) ReadPump(service *Service, convID string) {
 	defer func() {
 		c.Hub.unregister <- c
-		c.Hub.conn.Close()
+		c.conn.Close()
 	}()
 
 	for {
-		_, message, err := c.Hub.conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			break
 		}
@@ -233,8 +250,9 @@ func (c *Client) ReadPump(convID string) {
 
 		// 发送消息到服务
 		if msg.Type == "message" {
-			// 这里调用服务处理消息
-			// h.service.ProcessUserMessage(convID, msg.Content)
+			if _, err := service.ProcessUserMessage(convID, msg.Content); err != nil {
+				log.Printf("Process message error: %v", err)
+			}
 		}
 	}
 }
@@ -244,18 +262,18 @@ func (c *Client) WritePump() {
 	ticker := time.NewTicker(54 * time.Second)
 	defer func() {
 		ticker.Stop()
-		c.Hub.conn.Close()
+		c.conn.Close()
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.Send:
 			if !ok {
-				c.Hub.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			w, err := c.Hub.conn.NextWriter(websocket.TextMessage)
+			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
 			}
@@ -273,20 +291,14 @@ func (c *Client) WritePump() {
 			}
 
 		case <-ticker.C:
-			if err := c.Hub.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
 	}
 }
 
-// ========== 导入需要的包 ==========
-
 // upgrader WebSocket升级器
-import (
-	"github.com/gorilla/websocket"
-)
-
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize:  1024,
@@ -8,7 +8,8 @@ import (
 	"sync"
 	"time"
 
-	"corpflow/internal/store"
+	"agent-flow/internal/store"
+	"github.com/gorilla/websocket"
 )
 
 // MessageType 消息类型
@@ -242,8 +243,7 @@ func (s *Service) ProcessUserMessage(convID, content string) (*Message, error) {
 	}
 
 	// 保存用户消息
-	userMsg, err := s.SendMessage(convID, string(MessageTypeText), content, "user", conv.UserID, nil)
-	if err != nil {
+	if _, err := s.SendMessage(convID, string(MessageTypeText), content, "user", conv.UserID, nil); err != nil {
 		return nil, err
 	}
 
@@ -288,6 +288,7 @@ type Client struct {
 	UserID   string
 	Send     chan []byte
 	Hub      *Hub
+	conn     *websocket.Conn
 }
 
 // NewHub 创建Hub
@@ -300,10 +301,21 @@ func NewHub() *Hub {
 	}
 }
 
-// Run 运行Hub
-func (h *Hub) Run() {
+// Run 运行Hub, ctx 取消时关闭所有连接并返回
+func (h *Hub) Run(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			for userID, clients := range h.clients {
+				for client := range clients {
+					close(client.Send)
+				}
+				delete(h.clients, userID)
+			}
+			h.mu.Unlock()
+			return
+
 		case client := <-h.register:
 			h.mu.Lock()
 			if h.clients[client.UserID] == nil {
@@ -328,7 +340,7 @@ func (h *Hub) Run() {
 			log.Printf("Client disconnected: %s (%s)", client.ID, client.UserID)
 
 		case msg := <-h.broadcast:
-			h.mu.RLock()
+			h.mu.Lock()
 			if msg.TargetUser != "" {
 				// 发送给指定用户
 				if clients, ok := h.clients[msg.TargetUser]; ok {
@@ -356,7 +368,7 @@ func (h *Hub) Run() {
 					}
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
 		}
 	}
 }
@@ -0,0 +1,425 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"agent-flow/internal/store"
+)
+
+// 任务类型
+const (
+	KindPeriodic = "periodic" // 按间隔定时执行, 多副本间通过Redis锁只执行一次
+	KindWorker   = "worker"   // 常驻循环 (每个副本各自运行), 直到ctx取消
+)
+
+// 任务状态
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StateStopped = "stopped"
+)
+
+const (
+	lockTTL    = time.Minute // 执行期间的锁有效期, 每 lockTTL/3 续期一次
+	retryDelay = time.Minute // 锁被其他副本长时间占用或Redis出错时的重试间隔
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrNotPeriodic = errors.New("job is not periodic")
+)
+
+// Status 任务状态 (管理接口展示)
+type Status struct {
+	Name         string     `json:"name"`
+	Kind         string     `json:"kind"`
+	Interval     string     `json:"interval,omitempty"`
+	State        string     `json:"state"`
+	Runs         int64      `json:"runs"`
+	Skipped      int64      `json:"skipped"` // 已由其他副本执行而跳过的次数
+	LastStarted  *time.Time `json:"last_started,omitempty"`
+	LastFinished *time.Time `json:"last_finished,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+type job struct {
+	name     string
+	kind     string
+	interval time.Duration
+	run      func(ctx context.Context) error // periodic
+	loop     func(ctx context.Context)       // worker
+	trigger  chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Manager 后台任务管理器: 每个任务持有可取消的ctx, Stop 时取消并等待退出
+type Manager struct {
+	redis *store.Redis
+	owner string // 锁持有者标识 (主机名-进程号)
+
+	mu      sync.Mutex
+	jobs    []*job
+	byName  map[string]*job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	stopped bool
+}
+
+// NewManager 创建任务管理器, redis 为nil时定时任务只在本副本内去重
+func NewManager(redis *store.Redis) *Manager {
+	host, _ := os.Hostname()
+	return &Manager{
+		redis:  redis,
+		owner:  fmt.Sprintf("%s-%d", host, os.Getpid()),
+		byName: make(map[string]*job),
+	}
+}
+
+// Every 注册定时任务, 首次执行在启动后一个间隔
+func (m *Manager) Every(name string, interval time.Duration, run func(ctx context.Context) error) {
+	m.add(&job{
+		name:     name,
+		kind:     KindPeriodic,
+		interval: interval,
+		run:      run,
+		trigger:  make(chan struct{}, 1),
+		status:   Status{Name: name, Kind: KindPeriodic, Interval: interval.String(), State: StateIdle},
+	})
+}
+
+// Go 注册常驻循环, loop 应在ctx取消后返回
+func (m *Manager) Go(name string, loop func(ctx context.Context)) {
+	m.add(&job{
+		name:   name,
+		kind:   KindWorker,
+		loop:   loop,
+		status: Status{Name: name, Kind: KindWorker, State: StateIdle},
+	})
+}
+
+func (m *Manager) add(j *job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.byName[j.name]; ok {
+		log.Printf("[Jobs] job %s already registered", j.name)
+		return
+	}
+	m.jobs = append(m.jobs, j)
+	m.byName[j.name] = j
+	if m.ctx != nil && !m.stopped {
+		m.launch(j)
+	}
+}
+
+// Start 启动所有已注册的任务, 之后注册的任务立即启动
+func (m *Manager) Start(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx != nil {
+		return
+	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	for _, j := range m.jobs {
+		m.launch(j)
+	}
+}
+
+func (m *Manager) launch(j *job) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if j.kind == KindPeriodic {
+			m.runPeriodic(m.ctx, j)
+		} else {
+			m.runWorker(m.ctx, j)
+		}
+		j.update(func(st *Status) {
+			st.State = StateStopped
+			st.NextRun = nil
+		})
+	}()
+}
+
+// Stop 取消所有任务并等待退出, ctx 到期时返回仍未退出的错误
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopped = true
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		var running []string
+		for _, st := range m.Status() {
+			if st.State == StateRunning {
+				running = append(running, st.Name)
+			}
+		}
+		return fmt.Errorf("jobs still running: %v", running)
+	}
+}
+
+// Status 所有任务的状态 (按注册顺序)
+func (m *Manager) Status() []Status {
+	m.mu.Lock()
+	jobs := append([]*job(nil), m.jobs...)
+	m.mu.Unlock()
+
+	out := make([]Status, 0, len(jobs))
+	for _, j := range jobs {
+		j.mu.Lock()
+		out = append(out, j.status)
+		j.mu.Unlock()
+	}
+	return out
+}
+
+// Trigger 立即执行一次定时任务 (仍受分布式锁约束), 不等待执行结束
+func (m *Manager) Trigger(name string) error {
+	m.mu.Lock()
+	j, ok := m.byName[name]
+	m.mu.Unlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.kind != KindPeriodic {
+		return ErrNotPeriodic
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default: // 已有待执行的触发
+	}
+	return nil
+}
+
+func (j *job) update(fn func(st *Status)) {
+	j.mu.Lock()
+	fn(&j.status)
+	j.mu.Unlock()
+}
+
+// ========== 执行 ==========
+
+func (m *Manager) runWorker(ctx context.Context, j *job) {
+	now := time.Now()
+	j.update(func(st *Status) {
+		st.State = StateRunning
+		st.Runs++
+		st.LastStarted = &now
+	})
+	err := safely(j.name, func() error {
+		j.loop(ctx)
+		return nil
+	})
+	finished := time.Now()
+	j.update(func(st *Status) {
+		st.LastFinished = &finished
+		if err != nil {
+			st.LastError = err.Error()
+		}
+	})
+	if ctx.Err() == nil {
+		log.Printf("[Jobs] worker %s exited before shutdown", j.name)
+	}
+}
+
+func (m *Manager) runPeriodic(ctx context.Context, j *job) {
+	next := time.Now().Add(j.interval)
+	for {
+		at := next
+		j.update(func(st *Status) { st.NextRun = &at })
+
+		timer := time.NewTimer(time.Until(next))
+		force := false
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-j.trigger:
+			timer.Stop()
+			force = true
+		}
+
+		token, due, err := m.acquire(ctx, j, force)
+		switch {
+		case err != nil:
+			log.Printf("[Jobs] %s: acquire lock failed: %v", j.name, err)
+			j.update(func(st *Status) { st.LastError = err.Error() })
+		case token == "":
+			j.update(func(st *Status) { st.Skipped++ })
+		default:
+			m.execute(ctx, j, token)
+		}
+		next = due
+	}
+}
+
+// execute 执行一次定时任务, 执行期间持续续期锁
+func (m *Manager) execute(ctx context.Context, j *job, token string) {
+	started := time.Now()
+	j.update(func(st *Status) {
+		st.State = StateRunning
+		st.Runs++
+		st.LastStarted = &started
+	})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	if m.redis != nil {
+		go m.keepLock(runCtx, j.name, token)
+	}
+	err := safely(j.name, func() error { return j.run(runCtx) })
+	cancel()
+	if m.redis != nil {
+		m.release(j.name, token)
+	}
+
+	finished := time.Now()
+	if err != nil {
+		log.Printf("[Jobs] %s failed after %s: %v", j.name, finished.Sub(started).Round(time.Millisecond), err)
+	}
+	j.update(func(st *Status) {
+		st.State = StateIdle
+		st.LastFinished = &finished
+		st.LastError = ""
+		if err != nil {
+			st.LastError = err.Error()
+		}
+	})
+}
+
+// safely 执行任务并把panic转为错误, 避免单个任务拖垮进程
+func safely(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Jobs] %s panic: %v", name, r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// ========== 分布式锁 ==========
+
+// acquireScript 到期 (或强制执行) 且抢到锁时写入下次执行时间
+// KEYS[1] 锁, KEYS[2] 下次执行时间(毫秒); ARGV: 持有者, 锁有效期(毫秒), 间隔(毫秒), 是否强制
+// 返回 {是否抢到, 下次执行时间}
+const acquireScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local next = tonumber(redis.call('GET', KEYS[2]) or '0')
+if ARGV[4] ~= '1' and next > now then
+	return {0, next, now}
+end
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return {0, next, now}
+end
+next = now + tonumber(ARGV[3])
+redis.call('SET', KEYS[2], next, 'PX', tonumber(ARGV[3]) * 2)
+return {1, next, now}
+`
+
+// extendScript / releaseScript 只处理自己持有的锁
+const extendScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`
+
+const releaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+func lockKey(name string) string { return "jobs:lock:" + name }
+func nextKey(name string) string { return "jobs:next:" + name }
+
+// acquire 尝试获取执行权, 返回锁标识 (未抢到时为空) 和下次尝试时间
+func (m *Manager) acquire(ctx context.Context, j *job, force bool) (string, time.Time, error) {
+	token := fmt.Sprintf("%s-%d", m.owner, time.Now().UnixNano())
+	if m.redis == nil {
+		return token, time.Now().Add(j.interval), nil
+	}
+
+	forced := "0"
+	if force {
+		forced = "1"
+	}
+	res, err := m.redis.Eval(ctx, acquireScript, []string{lockKey(j.name), nextKey(j.name)},
+		token, lockTTL.Milliseconds(), j.interval.Milliseconds(), forced)
+	if err != nil {
+		return "", time.Now().Add(minDuration(j.interval, retryDelay)), err
+	}
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 3 {
+		return "", time.Now().Add(minDuration(j.interval, retryDelay)), fmt.Errorf("unexpected lock reply %v", res)
+	}
+	got, _ := vals[0].(int64)
+	nextMs, _ := vals[1].(int64)
+	nowMs, _ := vals[2].(int64)
+
+	// 用Redis时钟换算成本地时间, 避免副本间时钟偏差
+	next := time.Now().Add(time.Duration(nextMs-nowMs) * time.Millisecond)
+	if got == 1 {
+		return token, next, nil
+	}
+	if nextMs <= nowMs {
+		// 已到期但锁被占用: 其他副本仍在执行
+		next = time.Now().Add(minDuration(j.interval, retryDelay))
+	}
+	return "", next, nil
+}
+
+func (m *Manager) keepLock(ctx context.Context, name, token string) {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := m.redis.Eval(ctx, extendScript, []string{lockKey(name)}, token, lockTTL.Milliseconds())
+			if err == nil && res == int64(0) {
+				log.Printf("[Jobs] %s: lock lost while running", name)
+			}
+		}
+	}
+}
+
+func (m *Manager) release(name, token string) {
+	// 停机时任务ctx已取消, 释放锁使用独立的ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := m.redis.Eval(ctx, releaseScript, []string{lockKey(name)}, token); err != nil {
+		log.Printf("[Jobs] %s: release lock failed: %v", name, err)
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
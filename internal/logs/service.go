@@ -4,11 +4,13 @@
 package logs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -57,6 +59,9 @@ type Service struct {
 	mu       sync.RWMutex
 	logs     map[string]*ExecutionLog
 	storage  string // 存储路径
+
+	pending chan string // 待写入文件的日志ID, 由 Run 消费
+	writing atomic.Bool // Run 是否在运行
 }
 
 // NewService 创建日志服务
@@ -68,6 +73,7 @@ func NewService(storagePath string) *Service {
 	s := &Service{
 		logs:    make(map[string]*ExecutionLog),
 		storage: storagePath,
+		pending: make(chan string, 256),
 	}
 	
 	// 创建存储目录
@@ -97,7 +103,7 @@ func (s *Service) StartExecution(flowID, flowName, trigger string, input interfa
 	}
 	
 	s.logs[log.ID] = log
-	go s.persistLog(log)
+	s.schedulePersist(log.ID)
 	
 	return log
 }
@@ -117,7 +123,7 @@ func (s *Service) EndExecution(logID string, status string, output interface{})
 	log.Status = status
 	log.Output = output
 	
-	go s.persistLog(log)
+	s.schedulePersist(log.ID)
 	
 	return nil
 }
@@ -234,13 +240,54 @@ func (s *Service) GetRecentLogs(limit int) []*ExecutionLog {
 	return logs
 }
 
+// Run 后台写入日志文件, ctx 取消后写完队列中剩余的日志再返回
+func (s *Service) Run(ctx context.Context) {
+	s.writing.Store(true)
+	for {
+		select {
+		case id := <-s.pending:
+			s.persistLog(id)
+		case <-ctx.Done():
+			s.writing.Store(false)
+			for {
+				select {
+				case id := <-s.pending:
+					s.persistLog(id)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// schedulePersist 交给 Run 写入; Run 未启动或队列已满时单独起协程写入
+// 调用方持有 s.mu, 不能在此同步写入
+func (s *Service) schedulePersist(id string) {
+	if s.writing.Load() {
+		select {
+		case s.pending <- id:
+			return
+		default:
+		}
+	}
+	go s.persistLog(id)
+}
+
 // persistLog 持久化日志
-func (s *Service) persistLog(log *ExecutionLog) {
-	filename := filepath.Join(s.storage, fmt.Sprintf("%s.json", log.ID))
-	data, err := json.MarshalIndent(log, "", "  ")
-	if err != nil {
+func (s *Service) persistLog(id string) {
+	s.mu.RLock()
+	log, ok := s.logs[id]
+	var data []byte
+	var err error
+	if ok {
+		data, err = json.MarshalIndent(log, "", "  ")
+	}
+	s.mu.RUnlock()
+	if !ok || err != nil {
 		return
 	}
+	filename := filepath.Join(s.storage, fmt.Sprintf("%s.json", id))
 	os.WriteFile(filename, data, 0645)
 }
 
@@ -0,0 +1,443 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+)
+
+// MemoryPolicy 每种记忆的衰减和保留策略
+type MemoryPolicy struct {
+	HalfLife       time.Duration `json:"half_life"`       // 未被访问时时效分数衰减一半的时间
+	MaxAge         time.Duration `json:"max_age"`         // 超过该时间且期间未被访问的记忆会被删除, 0表示不过期
+	KeepImportance int           `json:"keep_importance"` // 重要性不低于该值的记忆不因过期或超出条数删除, 0表示都删除
+	MaxCount       int           `json:"max_count"`       // 每个智能体最多保留的条数, 0表示不限
+	Consolidate    bool          `json:"consolidate"`     // 整合时汇总为 learn 记忆
+}
+
+const day = 24 * time.Hour
+
+// DefaultMemoryPolicies 默认策略: 动作和结果记忆衰减快并定期汇总, 经验记忆长期保留
+var DefaultMemoryPolicies = map[MemoryType]MemoryPolicy{
+	MemoryTypeAction:   {HalfLife: 3 * day, MaxAge: 30 * day, KeepImportance: 9, MaxCount: 500, Consolidate: true},
+	MemoryTypeResult:   {HalfLife: 7 * day, MaxAge: 90 * day, KeepImportance: 9, MaxCount: 500, Consolidate: true},
+	MemoryTypeDecision: {HalfLife: 30 * day, MaxAge: 180 * day, KeepImportance: 8, MaxCount: 300},
+	MemoryTypeEvent:    {HalfLife: 30 * day, MaxAge: 365 * day, KeepImportance: 8, MaxCount: 300},
+	MemoryTypeLearn:    {HalfLife: 90 * day, MaxCount: 200},
+}
+
+// 综合排序的权重; 没有查询文本时相关性不参与, 时效和重要性各占一半
+const (
+	recencyWeight    = 0.3
+	importanceWeight = 0.3
+	relevanceWeight  = 0.4
+	recallCandidates = 200 // 参与排序的最近记忆条数, 经验记忆和重要记忆另取同样条数
+	recallImportance = 8   // 不论新旧都参与排序的重要性下限
+	accessRefresh    = 0.5 // 一次访问把衰减起点向访问时间推进的比例
+	maxAccessBoost   = 1.0 // 访问次数对半衰期的延长上限 (最多延长到2倍)
+)
+
+// SetPolicies 覆盖部分记忆类型的策略
+func (s *Service) SetPolicies(policies map[MemoryType]MemoryPolicy) {
+	s.policyMu.Lock()
+	defer s.policyMu.Unlock()
+	if s.policies == nil {
+		s.policies = make(map[MemoryType]MemoryPolicy)
+	}
+	for t, p := range policies {
+		s.policies[t] = p
+	}
+}
+
+// Policies 当前生效的策略
+func (s *Service) Policies() map[MemoryType]MemoryPolicy {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	out := make(map[MemoryType]MemoryPolicy, len(DefaultMemoryPolicies))
+	for t, p := range DefaultMemoryPolicies {
+		out[t] = p
+	}
+	for t, p := range s.policies {
+		out[t] = p
+	}
+	return out
+}
+
+func (s *Service) policy(t MemoryType) MemoryPolicy {
+	s.policyMu.RLock()
+	p, ok := s.policies[t]
+	s.policyMu.RUnlock()
+	if ok {
+		return p
+	}
+	if p, ok := DefaultMemoryPolicies[t]; ok {
+		return p
+	}
+	return MemoryPolicy{HalfLife: 7 * day}
+}
+
+// ========== 重要性评分 ==========
+
+var (
+	// importanceBase 各类型的基础分
+	importanceBase = map[MemoryType]int{
+		MemoryTypeAction:   3,
+		MemoryTypeResult:   5,
+		MemoryTypeDecision: 7,
+		MemoryTypeLearn:    6,
+		MemoryTypeEvent:    6,
+	}
+	// 失败、异常类内容更值得记住
+	failureWords = []string{"失败", "错误", "异常", "超时", "投诉", "上报", "error", "failed", "exception", "timeout", "escalat"}
+	// 涉及决策、约定和偏好的内容
+	signalWords = []string{"决定", "决策", "重要", "必须", "禁止", "偏好", "约定", "截止", "客户", "decid", "must", "prefer", "deadline"}
+)
+
+// ScoreImportance 按类型和内容自动评估重要性 (1-10)
+func ScoreImportance(memType MemoryType, content string) int {
+	score, ok := importanceBase[memType]
+	if !ok {
+		score = 5
+	}
+	lower := strings.ToLower(content)
+	if containsAny(lower, failureWords) {
+		score += 2
+	}
+	if containsAny(lower, signalWords) {
+		score++
+	}
+	if len([]rune(content)) > 200 {
+		score++
+	}
+	return clampImportance(score)
+}
+
+func clampImportance(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > 10 {
+		return 10
+	}
+	return n
+}
+
+func containsAny(s string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// ========== 综合排序 ==========
+
+// ScoredMemory 带排序分数的记忆
+type ScoredMemory struct {
+	Memory
+	Score     float64 `json:"score"`
+	Recency   float64 `json:"recency"`   // 0-1, 按类型半衰期衰减
+	Relevance float64 `json:"relevance"` // 0-1, 与查询的相关性
+}
+
+// recency 时效分数: 按半衰期衰减. 访问只把衰减起点从创建时间向最近访问时间推进一部分,
+// 访问次数延长半衰期但有上限, 经常被检索到的旧记忆仍会逐渐让位给新记忆
+func (p MemoryPolicy) recency(m *Memory, now time.Time) float64 {
+	origin := m.CreatedAt
+	if m.LastAccessedAt != nil && m.LastAccessedAt.After(origin) {
+		origin = origin.Add(time.Duration(float64(m.LastAccessedAt.Sub(origin)) * accessRefresh))
+	}
+	halfLife := p.HalfLife.Hours() * (1 + math.Min(math.Log1p(float64(m.AccessCount)), maxAccessBoost))
+	if halfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, now.Sub(origin).Hours()/halfLife)
+}
+
+// RankMemories 从智能体最近的记忆中按时效、重要性和与 query 的相关性综合排序, 返回前 limit 条, 不记录访问
+func (s *Service) RankMemories(agentIDs []uint, query string, limit int) ([]ScoredMemory, error) {
+	if s.db == nil || len(agentIDs) == 0 {
+		return []ScoredMemory{}, nil
+	}
+	recent, err := s.db.ListAgentsMemories(agentIDs, recallCandidates)
+	if err != nil {
+		return nil, err
+	}
+	// 较早的经验和重要记忆不在最近的候选里, 单独补充
+	key, err := s.db.ListKeyMemories(agentIDs, string(MemoryTypeLearn), recallImportance, recallCandidates)
+	if err != nil {
+		return nil, err
+	}
+	scored := rankMemories(mergeMemories(recent, key), query, s.policy, time.Now())
+	if limit > 0 && len(scored) > limit {
+		scored = scored[:limit]
+	}
+	return scored, nil
+}
+
+// RecallMemories 按 RankMemories 排序后取出放入上下文的记忆, 只有与 query 相关的记忆记为被访问;
+// 仅凭时效和重要性排在前面的记忆不算访问, 否则它们每次都被刷新而永远不会衰减
+func (s *Service) RecallMemories(agentIDs []uint, query string, limit int) ([]ScoredMemory, error) {
+	scored, err := s.RankMemories(agentIDs, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for _, m := range scored {
+		if m.Relevance > 0 {
+			ids = append(ids, m.ID)
+		}
+	}
+	if err := s.db.TouchMemories(ids); err != nil {
+		log.Printf("Failed to record memory access: %v", err)
+	}
+	return scored, nil
+}
+
+// mergeMemories 合并候选并按ID去重
+func mergeMemories(lists ...[]Memory) []Memory {
+	seen := make(map[uint]bool)
+	var out []Memory
+	for _, list := range lists {
+		for _, m := range list {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+				out = append(out, m)
+			}
+		}
+	}
+	return out
+}
+
+// rankMemories 计算综合分数并降序排列; 相关性为候选集内的BM25分数按最大值归一化
+func rankMemories(memories []Memory, query string, policy func(MemoryType) MemoryPolicy, now time.Time) []ScoredMemory {
+	relevance := make(map[uint]float64)
+	terms := tokenize(query)
+	if len(terms) > 0 {
+		idx := newBM25Index()
+		for i := range memories {
+			idx.add(memories[i].ID, tokenize(memories[i].Content))
+		}
+		hits := idx.search(terms, nil, 0)
+		if len(hits) > 0 && hits[0].score > 0 {
+			for _, h := range hits {
+				relevance[h.id] = h.score / hits[0].score
+			}
+		}
+	}
+
+	out := make([]ScoredMemory, len(memories))
+	for i := range memories {
+		m := &memories[i]
+		sm := ScoredMemory{Memory: *m, Recency: policy(MemoryType(m.Type)).recency(m, now), Relevance: relevance[m.ID]}
+		importance := float64(m.Importance) / 10
+		if len(terms) > 0 {
+			sm.Score = recencyWeight*sm.Recency + importanceWeight*importance + relevanceWeight*sm.Relevance
+		} else {
+			sm.Score = (sm.Recency + importance) / 2
+		}
+		out[i] = sm
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+// ========== 整合与保留 ==========
+
+// MaintenanceConfig 记忆整合任务配置, 零值使用默认值
+type MaintenanceConfig struct {
+	Model     string        // 汇总使用的模型, 为空时使用单价最低的可用模型
+	MinAge    time.Duration // 早于该时间的记忆才会被汇总, 默认7天
+	BatchSize int           // 每次汇总的最大条数, 默认50
+	MinBatch  int           // 智能体待汇总的记忆少于该条数时跳过, 默认5
+}
+
+// MaintenanceReport 一轮整合的结果
+type MaintenanceReport struct {
+	Consolidated int   `json:"consolidated"` // 被汇总的原始记忆数
+	Learned      int   `json:"learned"`      // 生成的 learn 记忆数
+	Expired      int64 `json:"expired"`      // 按 MaxAge 删除的记忆数
+	Trimmed      int64 `json:"trimmed"`      // 按 MaxCount 删除的记忆数
+}
+
+// consolidationResult 模型汇总的输出
+type consolidationResult struct {
+	Lessons []struct {
+		Content    string `json:"content" desc:"一条可复用的经验或事实, 独立成句"`
+		Importance int    `json:"importance" min:"1" max:"10" desc:"重要程度1-10"`
+	} `json:"lessons"`
+}
+
+// MaintenanceJob 记忆整合定时任务, 由 jobs.Manager 调度
+func (s *Service) MaintenanceJob(cfg MaintenanceConfig) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		report, err := s.Maintain(ctx, cfg)
+		if report != nil {
+			log.Printf("[Memory] maintenance: consolidated=%d learned=%d expired=%d trimmed=%d",
+				report.Consolidated, report.Learned, report.Expired, report.Trimmed)
+		}
+		return err
+	}
+}
+
+// Maintain 把旧的动作/结果记忆汇总为 learn 记忆, 然后按各类型的保留策略删除过期和超量的记忆
+func (s *Service) Maintain(ctx context.Context, cfg MaintenanceConfig) (*MaintenanceReport, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("memory store not configured")
+	}
+	if cfg.MinAge <= 0 {
+		cfg.MinAge = 7 * day
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+	if cfg.MinBatch <= 0 {
+		cfg.MinBatch = 5
+	}
+
+	report := &MaintenanceReport{}
+	if err := s.consolidate(ctx, cfg, report); err != nil {
+		return report, err
+	}
+
+	now := time.Now()
+	for t, p := range s.Policies() {
+		if p.MaxAge > 0 {
+			n, err := s.db.DeleteExpiredMemories(string(t), now.Add(-p.MaxAge), p.KeepImportance)
+			if err != nil {
+				return report, err
+			}
+			report.Expired += n
+		}
+		if p.MaxCount > 0 {
+			n, err := s.db.TrimMemories(string(t), p.MaxCount, p.KeepImportance)
+			if err != nil {
+				return report, err
+			}
+			report.Trimmed += n
+		}
+	}
+	return report, nil
+}
+
+// consolidate 按智能体分批汇总; 没有可用模型时跳过, 由保留策略兜底
+func (s *Service) consolidate(ctx context.Context, cfg MaintenanceConfig, report *MaintenanceReport) error {
+	var types []string
+	for t, p := range s.Policies() {
+		if p.Consolidate {
+			types = append(types, string(t))
+		}
+	}
+	if len(types) == 0 || s.models == nil {
+		return nil
+	}
+	modelName := cfg.Model
+	if modelName == "" {
+		modelName = s.models.CheapestModel()
+	}
+	if modelName == "" {
+		log.Printf("[Memory] no model available, skipping consolidation")
+		return nil
+	}
+
+	candidates, err := s.db.ConsolidationCandidates(types, time.Now().Add(-cfg.MinAge), cfg.BatchSize*20)
+	if err != nil {
+		return err
+	}
+	byAgent := make(map[uint][]Memory)
+	var agents []uint
+	for _, m := range candidates {
+		if _, ok := byAgent[m.AgentID]; !ok {
+			agents = append(agents, m.AgentID)
+		}
+		byAgent[m.AgentID] = append(byAgent[m.AgentID], m)
+	}
+
+	for _, agentID := range agents {
+		memories := byAgent[agentID]
+		for start := 0; start+cfg.MinBatch <= len(memories); start += cfg.BatchSize {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			end := start + cfg.BatchSize
+			if end > len(memories) {
+				end = len(memories)
+			}
+			learned, err := s.summarize(ctx, modelName, memories[start:end])
+			if err != nil {
+				// 单个智能体失败不影响其他智能体, 原始记忆留到下一轮
+				log.Printf("[Memory] consolidate agent %d: %v", agentID, err)
+				break
+			}
+			report.Consolidated += end - start
+			report.Learned += learned
+		}
+	}
+	return nil
+}
+
+// UsePrompts 记忆整理的系统提示词从提示词服务读取
+func (s *Service) UsePrompts(prompts *prompt.Service) {
+	s.prompts = prompts
+}
+
+// summarize 用模型把一批记忆汇总为若干条 learn 记忆, 写入后删除原始记忆
+func (s *Service) summarize(ctx context.Context, modelName string, batch []Memory) (int, error) {
+	var b strings.Builder
+	ids := make([]uint, len(batch))
+	for i, m := range batch {
+		ids[i] = m.ID
+		fmt.Fprintf(&b, "- [%s %s] %s\n", m.CreatedAt.Format("2006-01-02"), m.Type, m.Content)
+	}
+
+	result, err := model.Structured[consolidationResult](ctx, s.models, model.Request{
+		Model: modelName,
+		Messages: []model.Message{
+			{Role: "system", Content: s.prompts.RenderOrBuiltin(prompt.Ref{Key: prompt.KeyMemoryConsolidate}, nil)},
+			{Role: "user", Content: b.String()},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	meta, _ := json.Marshal(map[string]interface{}{
+		"consolidated_from": ids,
+		"from":              batch[0].CreatedAt,
+		"to":                batch[len(batch)-1].CreatedAt,
+	})
+	first := batch[0]
+	learned := make([]Memory, 0, len(result.Lessons))
+	for _, lesson := range result.Lessons {
+		content := strings.TrimSpace(lesson.Content)
+		if content == "" {
+			continue
+		}
+		importance := lesson.Importance
+		if importance <= 0 {
+			importance = ScoreImportance(MemoryTypeLearn, content)
+		}
+		learned = append(learned, Memory{
+			AgentID:    first.AgentID,
+			ParentID:   first.ParentID,
+			Type:       string(MemoryTypeLearn),
+			Content:    content,
+			Metadata:   string(meta),
+			Importance: clampImportance(importance),
+			CreatedAt:  time.Now(),
+		})
+	}
+	if err := s.db.ConsolidateMemories(learned, ids); err != nil {
+		return 0, err
+	}
+	return len(learned), nil
+}
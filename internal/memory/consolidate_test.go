@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRecencyAccessDoesNotResetDecay(t *testing.T) {
+	p := MemoryPolicy{HalfLife: 24 * time.Hour}
+	now := time.Now()
+	created := now.Add(-10 * 24 * time.Hour)
+	fresh := Memory{}
+	fresh.CreatedAt = now.Add(-time.Hour)
+	old := Memory{AccessCount: 1000, LastAccessedAt: &now}
+	old.CreatedAt = created
+
+	got := p.recency(&old, now)
+	// 起点推进一半 (5天前), 半衰期最多延长到2倍 (2天)
+	if want := math.Pow(0.5, 2.5); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("recency = %v, want %v", got, want)
+	}
+	if got >= p.recency(&fresh, now) {
+		t.Fatal("frequently accessed old memory should rank below a fresh one")
+	}
+	never := Memory{}
+	never.CreatedAt = created
+	if got <= p.recency(&never, now) {
+		t.Fatal("access should still slow the decay")
+	}
+}
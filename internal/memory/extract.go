@@ -0,0 +1,939 @@
+package memory
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// 支持导入的文档格式
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatText     = "text"
+	FormatPDF      = "pdf"
+	FormatDOCX     = "docx"
+)
+
+// Section 文档按标题切分出的段落, 每段导入为一条知识
+type Section struct {
+	Title   string `json:"title"` // 标题路径, 如 "安装 / Linux"
+	Content string `json:"content"`
+}
+
+// DetectFormat 按扩展名判断格式, 无法判断时按内容识别
+func DetectFormat(name string, data []byte) (string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return FormatMarkdown, nil
+	case ".html", ".htm":
+		return FormatHTML, nil
+	case ".txt", ".text":
+		return FormatText, nil
+	case ".pdf":
+		return FormatPDF, nil
+	case ".docx":
+		return FormatDOCX, nil
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return FormatPDF, nil
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return FormatDOCX, nil
+	case strings.HasPrefix(http.DetectContentType(data), "text/html"):
+		return FormatHTML, nil
+	case utf8.Valid(data):
+		return FormatText, nil
+	}
+	return "", fmt.Errorf("unsupported document format: %s", name)
+}
+
+// ExtractSections 提取文档文本并按标题切分; 没有标题的内容归入以文档名为标题的段落
+func ExtractSections(format, name string, data []byte) ([]Section, error) {
+	var (
+		sections []Section
+		err      error
+	)
+	switch format {
+	case FormatMarkdown:
+		sections = extractMarkdown(string(data))
+	case FormatHTML:
+		sections, err = extractHTML(data)
+	case FormatText:
+		sections = []Section{{Content: normalizeText(string(data))}}
+	case FormatPDF:
+		var text string
+		if text, err = extractPDF(data); err == nil {
+			sections = []Section{{Content: text}}
+		}
+	case FormatDOCX:
+		sections, err = extractDOCX(data)
+	default:
+		return nil, fmt.Errorf("unsupported document format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	docTitle := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	out := make([]Section, 0, len(sections))
+	for _, s := range sections {
+		s.Content = strings.TrimSpace(s.Content)
+		if s.Content == "" {
+			continue
+		}
+		if s.Title == "" {
+			s.Title = docTitle
+		} else {
+			s.Title = docTitle + " / " + s.Title
+		}
+		if r := []rune(s.Title); len(r) > 255 {
+			s.Title = string(r[:255])
+		}
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no text found in %s", name)
+	}
+	return out, nil
+}
+
+// sectionBuilder 按标题层级收集段落
+type sectionBuilder struct {
+	sections []Section
+	headings []string
+	body     strings.Builder
+}
+
+func (b *sectionBuilder) heading(level int, title string) {
+	b.flush()
+	if level < 1 {
+		level = 1
+	}
+	if len(b.headings) >= level {
+		b.headings = b.headings[:level-1]
+	}
+	for len(b.headings) < level-1 {
+		b.headings = append(b.headings, "")
+	}
+	b.headings = append(b.headings, strings.TrimSpace(title))
+}
+
+func (b *sectionBuilder) write(text string) {
+	b.body.WriteString(text)
+}
+
+func (b *sectionBuilder) flush() {
+	var path []string
+	for _, h := range b.headings {
+		if h != "" {
+			path = append(path, h)
+		}
+	}
+	b.sections = append(b.sections, Section{Title: strings.Join(path, " / "), Content: normalizeText(b.body.String())})
+	b.body.Reset()
+}
+
+func (b *sectionBuilder) result() []Section {
+	b.flush()
+	return b.sections
+}
+
+// normalizeText 统一换行, 去掉行尾空白并合并多余空行
+func normalizeText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// ========== Markdown ==========
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdEmphasis = regexp.MustCompile("(\\*\\*|__|`)")
+)
+
+// extractMarkdown 按 ATX 标题 (# 标题) 切分, 去掉链接、图片和强调标记, 代码块原样保留
+func extractMarkdown(text string) []Section {
+	var b sectionBuilder
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	// 跳过 front matter
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "---" {
+				lines = lines[i+1:]
+				break
+			}
+		}
+	}
+
+	inCode := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			b.write(line + "\n")
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(trimmed); m != nil {
+			b.heading(len(m[1]), cleanMarkdown(m[2]))
+			continue
+		}
+		b.write(cleanMarkdown(strings.TrimPrefix(trimmed, "> ")) + "\n")
+	}
+	return b.result()
+}
+
+func cleanMarkdown(line string) string {
+	line = mdImage.ReplaceAllString(line, "$1")
+	line = mdLink.ReplaceAllString(line, "$1")
+	return mdEmphasis.ReplaceAllString(line, "")
+}
+
+// ========== HTML ==========
+
+// htmlBlocks 结束时换行的块级元素
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "li": true, "br": true, "tr": true, "pre": true, "blockquote": true,
+	"section": true, "article": true, "table": true, "ul": true, "ol": true, "h4": true, "h5": true, "h6": true,
+}
+
+// extractHTML 按 h1-h3 切分, 跳过脚本、样式和导航
+func extractHTML(data []byte) ([]Section, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var b sectionBuilder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "script", "style", "noscript", "head", "nav", "svg":
+				return
+			case "h1", "h2", "h3":
+				b.heading(int(n.Data[1]-'0'), collapseSpace(nodeText(n)))
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			b.write(collapseSpace(n.Data))
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && htmlBlocks[n.Data] {
+			b.write("\n")
+		}
+	}
+	walk(doc)
+	return b.result(), nil
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(nodeText(c))
+	}
+	return sb.String()
+}
+
+// collapseSpace 连续空白合并为一个空格 (保留首尾的单个空格以免粘连单词)
+func collapseSpace(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			return " "
+		}
+		return ""
+	}
+	out := strings.Join(fields, " ")
+	if unicode.IsSpace(rune(s[0])) {
+		out = " " + out
+	}
+	if unicode.IsSpace(rune(s[len(s)-1])) {
+		out += " "
+	}
+	return out
+}
+
+// ========== DOCX ==========
+
+var docxHeading = regexp.MustCompile(`(?i)^(?:heading|标题)\s*(\d)$`)
+
+// extractDOCX 读取 word/document.xml, 按标题样式 (Heading1-3 / Title) 切分
+func extractDOCX(data []byte) ([]Section, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid docx: %w", err)
+	}
+	var doc io.ReadCloser
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			if doc, err = f.Open(); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("invalid docx: word/document.xml not found")
+	}
+	defer doc.Close()
+
+	var (
+		b      sectionBuilder
+		para   strings.Builder
+		level  int // 当前段落的标题级别, 0表示正文
+		inText bool
+	)
+	dec := xml.NewDecoder(doc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid docx: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				para.Reset()
+				level = 0
+			case "pStyle":
+				for _, attr := range t.Attr {
+					if attr.Name.Local != "val" {
+						continue
+					}
+					if attr.Value == "Title" {
+						level = 1
+					} else if m := docxHeading.FindStringSubmatch(attr.Value); m != nil && m[1] >= "1" && m[1] <= "3" {
+						level = int(m[1][0] - '0')
+					}
+				}
+			case "t":
+				inText = true
+			case "tab":
+				para.WriteString("\t")
+			case "br", "cr":
+				para.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if level > 0 {
+					b.heading(level, para.String())
+				} else {
+					b.write(para.String() + "\n")
+				}
+			}
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+		}
+	}
+	return b.result(), nil
+}
+
+// ========== PDF ==========
+
+const (
+	maxPDFStream   = 32 << 20  // 单个流解压后的上限, 防止压缩炸弹
+	maxPDFInflated = 256 << 20 // 整个文档解压后的总上限
+)
+
+var (
+	pdfStreamStart = regexp.MustCompile(`>>\s*stream\r?\n`)
+	pdfObject      = regexp.MustCompile(`(?s)(\d+)\s+\d+\s+obj\b(.*?)endobj`)
+	pdfFontDict    = regexp.MustCompile(`(?s)/Font\s*<<(.*?)>>`)
+	pdfFontDictRef = regexp.MustCompile(`/Font\s+(\d+)\s+\d+\s+R`)
+	pdfFontRef     = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+)\s+\d+\s+R`)
+	pdfToUnicode   = regexp.MustCompile(`/ToUnicode\s+(\d+)\s+\d+\s+R`)
+	pdfObjStm      = regexp.MustCompile(`/Type\s*/ObjStm`)
+	pdfObjStmFirst = regexp.MustCompile(`/First\s+(\d+)`)
+)
+
+// pdfStreamObject 解码后的流对象, num 为对象编号 (无法识别时为-1)
+type pdfStreamObject struct {
+	num  int
+	dict string
+	data []byte
+}
+
+// extractPDF 从内容流中提取 Tj/TJ 文本. 字体带 ToUnicode CMap 时 (中文 PDF 的 Identity-H 字体通常都有)
+// 按 CMap 还原文字, 否则只支持标准编码和 UTF-16 字符串; 没有 ToUnicode 的嵌入字体无法还原, 返回错误提示先转换为文本
+func extractPDF(data []byte) (string, error) {
+	streams := pdfStreams(data)
+	fonts := pdfFonts(data, streams)
+
+	var out strings.Builder
+	for _, st := range streams {
+		if pdfObjStm.MatchString(st.dict) || bytes.Contains(st.data, []byte("begincmap")) {
+			continue
+		}
+		if bytes.Contains(st.data, []byte("BT")) {
+			out.WriteString(pdfContentText(st.data, fonts))
+			out.WriteString("\n")
+		}
+	}
+
+	text := normalizeText(out.String())
+	if text == "" {
+		return "", fmt.Errorf("no extractable text in pdf (scanned or image-only documents are not supported)")
+	}
+	printable := 0
+	for _, r := range text {
+		if unicode.IsPrint(r) || unicode.IsSpace(r) {
+			printable++
+		}
+	}
+	if printable*10 < utf8.RuneCountInString(text)*9 {
+		return "", fmt.Errorf("pdf uses embedded font encodings that cannot be decoded; convert it to text or docx first")
+	}
+	return text, nil
+}
+
+// pdfStreams 解码文档中的流, FlateDecode 以外的编码 (多为图片) 跳过; 解压总量超过上限时停止
+func pdfStreams(data []byte) []pdfStreamObject {
+	var streams []pdfStreamObject
+	budget := maxPDFInflated
+	for pos := 0; pos < len(data) && budget > 0; {
+		loc := pdfStreamStart.FindIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		dictEnd, start := pos+loc[0]+2, pos+loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[start : start+end]
+		pos = start + end
+
+		// 流字典从最近的 "N 0 obj" 开始
+		st := pdfStreamObject{num: -1}
+		if objAt := bytes.LastIndex(data[:dictEnd], []byte("obj")); objAt >= 0 {
+			st.dict = string(data[objAt+3 : dictEnd])
+			st.num = pdfObjectNumber(data[:objAt])
+		}
+		switch {
+		case strings.Contains(st.dict, "/FlateDecode"):
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			// 流末尾的换行等多余字节会导致校验失败, 保留已解压的部分
+			st.data, _ = io.ReadAll(io.LimitReader(zr, int64(min(maxPDFStream, budget))))
+			budget -= len(st.data)
+		case strings.Contains(st.dict, "/Filter"):
+			continue // 图片等其他编码
+		default:
+			st.data = raw
+		}
+		streams = append(streams, st)
+	}
+	return streams
+}
+
+// pdfObjectNumber 解析 "N G obj" 中的对象编号, head 以 "N G " 结尾
+func pdfObjectNumber(head []byte) int {
+	fields := bytes.Fields(head[max(0, len(head)-32):])
+	if len(fields) < 2 {
+		return -1
+	}
+	num, err := strconv.Atoi(string(fields[len(fields)-2]))
+	if err != nil {
+		return -1
+	}
+	return num
+}
+
+// pdfFonts 字体资源名到 ToUnicode CMap 的映射. 不区分页面, 不同页面同名资源指向不同字体时以最后一个为准
+func pdfFonts(data []byte, streams []pdfStreamObject) map[string]*toUnicode {
+	cmaps := make(map[int]*toUnicode)
+	for _, st := range streams {
+		if st.num >= 0 && bytes.Contains(st.data, []byte("begincmap")) {
+			cmaps[st.num] = parseToUnicode(st.data)
+		}
+	}
+	if len(cmaps) == 0 {
+		return nil
+	}
+
+	objects := pdfObjects(data, streams)
+	fonts := make(map[string]*toUnicode)
+	addFonts := func(dict string) {
+		for _, m := range pdfFontRef.FindAllStringSubmatch(dict, -1) {
+			num, _ := strconv.Atoi(m[2])
+			if ref := pdfToUnicode.FindStringSubmatch(objects[num]); ref != nil {
+				cmapNum, _ := strconv.Atoi(ref[1])
+				if cmap, ok := cmaps[cmapNum]; ok {
+					fonts[m[1]] = cmap
+				}
+			}
+		}
+	}
+	for _, obj := range objects {
+		for _, m := range pdfFontDict.FindAllStringSubmatch(obj, -1) {
+			addFonts(m[1])
+		}
+		for _, m := range pdfFontDictRef.FindAllStringSubmatch(obj, -1) {
+			num, _ := strconv.Atoi(m[1])
+			addFonts(objects[num])
+		}
+	}
+	return fonts
+}
+
+// pdfObjects 对象编号到对象文本, 包括对象流 (ObjStm) 中压缩的对象
+func pdfObjects(data []byte, streams []pdfStreamObject) map[int]string {
+	objects := make(map[int]string)
+	for _, m := range pdfObject.FindAllSubmatch(data, -1) {
+		num, _ := strconv.Atoi(string(m[1]))
+		objects[num] = string(m[2])
+	}
+	for _, st := range streams {
+		if !pdfObjStm.MatchString(st.dict) {
+			continue
+		}
+		m := pdfObjStmFirst.FindStringSubmatch(st.dict)
+		if m == nil {
+			continue
+		}
+		first, _ := strconv.Atoi(m[1])
+		if first > len(st.data) {
+			continue
+		}
+		// 头部为 "对象编号 偏移" 对, 偏移相对于 First
+		header := bytes.Fields(st.data[:first])
+		for i := 0; i+1 < len(header); i += 2 {
+			num, err1 := strconv.Atoi(string(header[i]))
+			off, err2 := strconv.Atoi(string(header[i+1]))
+			if err1 != nil || err2 != nil || first+off > len(st.data) {
+				break
+			}
+			end := len(st.data)
+			if i+3 < len(header) {
+				if next, err := strconv.Atoi(string(header[i+3])); err == nil && first+next >= first+off && first+next <= end {
+					end = first + next
+				}
+			}
+			objects[num] = string(st.data[first+off : end])
+		}
+	}
+	return objects
+}
+
+// pdfContentText 解释内容流中的文本操作符
+func pdfContentText(content []byte, fonts map[string]*toUnicode) string {
+	var (
+		out      strings.Builder
+		operands []string // 最近的字符串操作数
+		inArray  bool
+		name     string     // 最近的名称操作数, 用于 Tf 选择字体
+		cmap     *toUnicode // 当前字体的 ToUnicode, 为nil时按标准编码解码
+	)
+	decode := func(b []byte) string {
+		if cmap != nil {
+			return cmap.decode(b)
+		}
+		return pdfString(b)
+	}
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			b, next := pdfLiteral(content, i)
+			operands = append(operands, decode(b))
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2 // 行内字典 (如标记内容的属性)
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return out.String()
+			}
+			operands = append(operands, decode(pdfHex(content[i+1:i+end])))
+			i += end + 1
+		case c == '/':
+			j := i + 1
+			for j < len(content) && !pdfDelimiter(content[j]) {
+				j++
+			}
+			name = string(content[i+1 : j])
+			i = j
+		case c == '[':
+			inArray = true
+			operands = operands[:0]
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '-' && inArray:
+			// TJ 数组中较大的负间距视为空格
+			j := i + 1
+			for j < len(content) && (content[j] >= '0' && content[j] <= '9' || content[j] == '.') {
+				j++
+			}
+			if j-i > 3 {
+				operands = append(operands, " ")
+			}
+			i = j
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case unicode.IsLetter(rune(c)) || c == '\'' || c == '"' || c == '*':
+			j := i
+			for j < len(content) && (unicode.IsLetter(rune(content[j])) || content[j] == '*' || content[j] == '\'' || content[j] == '"') {
+				j++
+			}
+			switch string(content[i:j]) {
+			case "Tj", "TJ":
+				out.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				out.WriteString("\n" + strings.Join(operands, ""))
+			case "T*", "Td", "TD", "ET":
+				out.WriteString("\n")
+			case "Tf":
+				cmap = fonts[name]
+			}
+			if !inArray {
+				operands = operands[:0]
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return out.String()
+}
+
+// pdfLiteral 解析 (...) 字符串, 返回原始字节和结束位置
+func pdfLiteral(content []byte, i int) ([]byte, int) {
+	var buf []byte
+	depth := 0
+	for i++; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(content) {
+				break
+			}
+			switch e := content[i]; e {
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v, n := 0, 0
+					for n < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7' {
+						v = v*8 + int(content[i]-'0')
+						i++
+						n++
+					}
+					i--
+					buf = append(buf, byte(v))
+				} else {
+					buf = append(buf, e)
+				}
+			}
+		case '(':
+			depth++
+			buf = append(buf, c)
+		case ')':
+			if depth == 0 {
+				return buf, i + 1
+			}
+			depth--
+			buf = append(buf, c)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf, i
+}
+
+// pdfHex 解析 <...> 中的十六进制字节
+func pdfHex(hex []byte) []byte {
+	var digits []byte
+	for _, c := range hex {
+		if ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	buf := make([]byte, len(digits)/2)
+	for i := range buf {
+		buf[i] = unhex(digits[2*i])<<4 | unhex(digits[2*i+1])
+	}
+	return buf
+}
+
+// pdfDelimiter 结束名称和操作符的字符
+func pdfDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// pdfString 按 BOM 识别 UTF-16BE, 否则按 Latin-1 解码
+func pdfString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		u := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(u))
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// ========== ToUnicode CMap ==========
+
+// toUnicode 字体的 ToUnicode CMap: 字符编码到 Unicode 文本的映射
+type toUnicode struct {
+	spaces []cmapSpace
+	width  int // 没有匹配的编码空间时的编码字节数
+	chars  map[uint32]string
+	ranges []cmapRange
+}
+
+// cmapSpace 编码空间, 决定每个字符编码占几个字节
+type cmapSpace struct {
+	width  int
+	lo, hi uint32
+}
+
+// cmapRange bfrange 映射: 目标为起始值 (逐个递增) 或逐个列出的数组
+type cmapRange struct {
+	lo, hi uint32
+	base   []uint16
+	list   []string
+}
+
+// parseToUnicode 解析 CMap 中的 codespacerange, bfchar 和 bfrange
+func parseToUnicode(data []byte) *toUnicode {
+	m := &toUnicode{width: 1, chars: make(map[uint32]string)}
+	tokens := cmapTokens(data)
+	srcWidth := 0
+	code := func(tok string) uint32 {
+		b := pdfHex([]byte(strings.Trim(tok, "<>")))
+		if srcWidth == 0 {
+			srcWidth = len(b)
+		}
+		var v uint32
+		for _, c := range b[:min(len(b), 4)] {
+			v = v<<8 | uint32(c)
+		}
+		return v
+	}
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "begincodespacerange":
+			for i+2 < len(tokens) && tokens[i+1] != "endcodespacerange" {
+				width := len(pdfHex([]byte(strings.Trim(tokens[i+1], "<>"))))
+				m.spaces = append(m.spaces, cmapSpace{width: width, lo: code(tokens[i+1]), hi: code(tokens[i+2])})
+				i += 2
+			}
+		case "beginbfchar":
+			for i+2 < len(tokens) && tokens[i+1] != "endbfchar" {
+				m.chars[code(tokens[i+1])] = utf16Text(tokens[i+2])
+				i += 2
+			}
+		case "beginbfrange":
+			for i+3 < len(tokens) && tokens[i+1] != "endbfrange" {
+				r := cmapRange{lo: code(tokens[i+1]), hi: code(tokens[i+2])}
+				if tokens[i+3] == "[" {
+					j := i + 4
+					for ; j < len(tokens) && tokens[j] != "]"; j++ {
+						r.list = append(r.list, utf16Text(tokens[j]))
+					}
+					i = j
+				} else {
+					r.base = utf16Units(tokens[i+3])
+					i += 3
+				}
+				m.ranges = append(m.ranges, r)
+			}
+		}
+	}
+	switch {
+	case len(m.spaces) > 0:
+		m.width = m.spaces[0].width
+	case srcWidth > 0:
+		m.width = srcWidth
+	}
+	return m
+}
+
+// cmapTokens 把 CMap 切分为十六进制字符串, 数组括号和其他记号
+func cmapTokens(data []byte) []string {
+	var tokens []string
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '<' && i+1 < len(data) && data[i+1] == '<', c == '>' && i+1 < len(data) && data[i+1] == '>':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return tokens
+			}
+			tokens = append(tokens, string(data[i:i+end+1]))
+			i += end + 1
+		case c == '[', c == ']':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case pdfDelimiter(c):
+			i++
+		default:
+			j := i
+			for j < len(data) && !pdfDelimiter(data[j]) {
+				j++
+			}
+			tokens = append(tokens, string(data[i:j]))
+			i = j
+		}
+	}
+	return tokens
+}
+
+// utf16Units 把 <...> 中的 UTF-16BE 字节转换为码元
+func utf16Units(tok string) []uint16 {
+	b := pdfHex([]byte(strings.Trim(tok, "<>")))
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return u
+}
+
+func utf16Text(tok string) string {
+	return string(utf16.Decode(utf16Units(tok)))
+}
+
+// decode 按编码空间切分字符编码并映射为文本, 没有映射的编码丢弃
+func (m *toUnicode) decode(b []byte) string {
+	var out strings.Builder
+	for i := 0; i < len(b); {
+		w := m.codeWidth(b[i:])
+		var code uint32
+		for _, c := range b[i : i+w] {
+			code = code<<8 | uint32(c)
+		}
+		i += w
+		out.WriteString(m.lookup(code))
+	}
+	return out.String()
+}
+
+func (m *toUnicode) codeWidth(b []byte) int {
+	for _, sp := range m.spaces {
+		if sp.width > len(b) || sp.width > 4 {
+			continue
+		}
+		var code uint32
+		for _, c := range b[:sp.width] {
+			code = code<<8 | uint32(c)
+		}
+		if code >= sp.lo && code <= sp.hi {
+			return sp.width
+		}
+	}
+	return max(1, min(m.width, len(b), 4))
+}
+
+func (m *toUnicode) lookup(code uint32) string {
+	if s, ok := m.chars[code]; ok {
+		return s
+	}
+	for _, r := range m.ranges {
+		if code < r.lo || code > r.hi {
+			continue
+		}
+		off := code - r.lo
+		if r.list != nil {
+			if int(off) < len(r.list) {
+				return r.list[off]
+			}
+			return ""
+		}
+		if len(r.base) == 0 {
+			return ""
+		}
+		u := append([]uint16(nil), r.base...)
+		u[len(u)-1] += uint16(off)
+		return string(utf16.Decode(u))
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func deflate(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(data))
+	zw.Close()
+	return buf.String()
+}
+
+// buildPDF 按顺序拼接对象, 不生成 xref (提取不依赖 xref)
+func buildPDF(objects ...string) []byte {
+	var b strings.Builder
+	b.WriteString("%PDF-1.5\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("%%EOF\n")
+	return []byte(b.String())
+}
+
+func stream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+const identityCMap = `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+2 beginbfchar
+<0001> <4E2D>
+<0002> <6587>
+endbfchar
+2 beginbfrange
+<0010> <0012> <0041>
+<0020> <0021> [<6587> <6863>]
+endbfrange
+endcmap
+end
+end`
+
+func TestExtractPDFToUnicode(t *testing.T) {
+	content := "BT /F1 12 Tf <00010002> Tj T* [<0010> -50 <00110012>] TJ ET\n" +
+		"BT /F2 12 Tf (plain) Tj ET"
+	pdf := buildPDF(
+		"<< /Type /Page /Resources << /Font << /F1 2 0 R /F2 5 0 R >> >> /Contents 4 0 R >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /SimSun /Encoding /Identity-H /ToUnicode 3 0 R >>",
+		stream("/Filter /FlateDecode", deflate(t, identityCMap)),
+		stream("/Filter /FlateDecode", deflate(t, content)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	text, err := extractPDF(pdf)
+	if err != nil {
+		t.Fatalf("extractPDF: %v", err)
+	}
+	if want := "中文\nABC\nplain"; text != want {
+		t.Fatalf("text = %q, want %q", text, want)
+	}
+}
+
+func TestExtractPDFFontsInObjectStream(t *testing.T) {
+	// 页面和字体对象压缩在对象流中 (PDF 1.5+)
+	page := "<< /Type /Page /Resources << /Font << /F1 2 0 R >> >> /Contents 4 0 R >>"
+	font := "<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 3 0 R >>"
+	header := fmt.Sprintf("1 0 2 %d ", len(page)+1)
+	objStm := header + page + " " + font
+	pdf := buildPDF(
+		"<< /Type /Catalog >>",
+		"<< >>",
+		stream("/Filter /FlateDecode", deflate(t, identityCMap)),
+		stream("/Filter /FlateDecode", deflate(t, "BT /F1 12 Tf <0020 0021> Tj ET")),
+		stream(fmt.Sprintf("/Type /ObjStm /N 2 /First %d /Filter /FlateDecode", len(header)), deflate(t, objStm)),
+	)
+	text, err := extractPDF(pdf)
+	if err != nil {
+		t.Fatalf("extractPDF: %v", err)
+	}
+	if text != "文档" {
+		t.Fatalf("text = %q", text)
+	}
+}
+
+func TestExtractPDFLimitsInflatedStream(t *testing.T) {
+	bomb := strings.Repeat("0", maxPDFStream+1024)
+	pdf := buildPDF(stream("/Filter /FlateDecode", deflate(t, bomb)))
+	streams := pdfStreams(pdf)
+	if len(streams) != 1 {
+		t.Fatalf("streams = %d", len(streams))
+	}
+	if got := len(streams[0].data); got != maxPDFStream {
+		t.Fatalf("inflated %d bytes, want at most %d", got, maxPDFStream)
+	}
+}
@@ -0,0 +1,432 @@
+package memory
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ========== 分词 ==========
+
+// tokenize 检索分词: 英文/数字按词切分并转小写, 中文连续片段输出单字和相邻双字,
+// 这样 "向量检索" 和 "检索向量"、"数据库" 和 "数据" 之间都能匹配上
+func tokenize(text string) []string {
+	var (
+		tokens []string
+		word   []rune
+		han    []rune
+	)
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		for i := range han {
+			tokens = append(tokens, string(han[i]))
+			if i+1 < len(han) {
+				tokens = append(tokens, string(han[i:i+2]))
+			}
+		}
+		han = han[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return tokens
+}
+
+// scored 带分数的分块ID
+type scored struct {
+	id    uint
+	score float64
+}
+
+// ========== BM25 ==========
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// bm25Index 内存倒排索引
+type bm25Index struct {
+	postings map[string]map[uint]int // 词 -> 分块 -> 词频
+	docs     map[uint]map[string]int // 分块 -> 词频 (删除时使用)
+	lengths  map[uint]int
+	total    int
+}
+
+func newBM25Index() *bm25Index {
+	return &bm25Index{
+		postings: make(map[string]map[uint]int),
+		docs:     make(map[uint]map[string]int),
+		lengths:  make(map[uint]int),
+	}
+}
+
+func (b *bm25Index) add(id uint, tokens []string) {
+	b.remove(id)
+	tf := make(map[string]int)
+	for _, t := range tokens {
+		tf[t]++
+	}
+	for t, n := range tf {
+		if b.postings[t] == nil {
+			b.postings[t] = make(map[uint]int)
+		}
+		b.postings[t][id] = n
+	}
+	b.docs[id] = tf
+	b.lengths[id] = len(tokens)
+	b.total += len(tokens)
+}
+
+func (b *bm25Index) remove(id uint) {
+	tf, ok := b.docs[id]
+	if !ok {
+		return
+	}
+	for t := range tf {
+		delete(b.postings[t], id)
+		if len(b.postings[t]) == 0 {
+			delete(b.postings, t)
+		}
+	}
+	b.total -= b.lengths[id]
+	delete(b.docs, id)
+	delete(b.lengths, id)
+}
+
+// search 按BM25打分, allow 为nil时不过滤
+func (b *bm25Index) search(query []string, allow func(uint) bool, limit int) []scored {
+	n := len(b.docs)
+	if n == 0 || len(query) == 0 {
+		return nil
+	}
+	avg := float64(b.total) / float64(n)
+	seen := make(map[string]bool)
+	scores := make(map[uint]float64)
+	for _, t := range query {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		posting := b.postings[t]
+		if len(posting) == 0 {
+			continue
+		}
+		df := float64(len(posting))
+		idf := math.Log(1 + (float64(n)-df+0.5)/(df+0.5))
+		for id, f := range posting {
+			if allow != nil && !allow(id) {
+				continue
+			}
+			tf := float64(f)
+			norm := 1 - bm25B + bm25B*float64(b.lengths[id])/avg
+			scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return topScored(scores, limit)
+}
+
+func topScored(scores map[uint]float64, limit int) []scored {
+	out := make([]scored, 0, len(scores))
+	for id, s := range scores {
+		out = append(out, scored{id: id, score: s})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].id < out[j].id
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// ========== 向量索引 (HNSW) ==========
+
+const (
+	hnswM              = 16  // 每层邻居数 (第0层为2倍)
+	hnswEfConstruction = 100 // 建图时的候选数
+	hnswEfSearch       = 64  // 查询时的最小候选数
+)
+
+type hnswNode struct {
+	vec     []float32
+	friends [][]uint // 每层的邻居
+	deleted bool
+}
+
+// hnswIndex 内存HNSW图, 向量需归一化, 距离为 1-余弦相似度;
+// 删除只做标记, 标记过半时由上层重建
+type hnswIndex struct {
+	nodes     map[uint]*hnswNode
+	entry     uint
+	maxLevel  int
+	deleted   int
+	dim       int
+	levelMult float64
+	rng       *rand.Rand
+}
+
+func newHNSWIndex() *hnswIndex {
+	return &hnswIndex{
+		nodes:     make(map[uint]*hnswNode),
+		maxLevel:  -1,
+		levelMult: 1 / math.Log(hnswM),
+		rng:       rand.New(rand.NewSource(1)),
+	}
+}
+
+// live 未删除的向量数
+func (h *hnswIndex) live() int {
+	return len(h.nodes) - h.deleted
+}
+
+func (h *hnswIndex) distance(q []float32, id uint) float64 {
+	return 1 - dot(q, h.nodes[id].vec)
+}
+
+func (h *hnswIndex) maxFriends(level int) int {
+	if level == 0 {
+		return hnswM * 2
+	}
+	return hnswM
+}
+
+// add 插入向量 (维度与已有向量不一致时忽略)
+func (h *hnswIndex) add(id uint, vec []float32) {
+	if len(vec) == 0 || (h.dim != 0 && len(vec) != h.dim) {
+		return
+	}
+	if _, ok := h.nodes[id]; ok {
+		return
+	}
+	h.dim = len(vec)
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	node := &hnswNode{vec: vec, friends: make([][]uint, level+1)}
+	h.nodes[id] = node
+	if h.maxLevel < 0 {
+		h.entry, h.maxLevel = id, level
+		return
+	}
+
+	cur := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		cur = h.greedy(vec, cur, l)
+	}
+	top := level
+	if top > h.maxLevel {
+		top = h.maxLevel
+	}
+	for l := top; l >= 0; l-- {
+		cands := h.searchLayer(vec, cur, hnswEfConstruction, l)
+		friends := make([]uint, 0, hnswM)
+		for _, c := range cands {
+			if c.id == id {
+				continue
+			}
+			friends = append(friends, c.id)
+			if len(friends) == hnswM {
+				break
+			}
+		}
+		node.friends[l] = friends
+		for _, f := range friends {
+			h.link(f, id, l)
+		}
+		if len(cands) > 0 {
+			cur = cands[0].id
+		}
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+}
+
+// link 给 from 增加一条到 to 的边, 超出上限时只保留最近的邻居
+func (h *hnswIndex) link(from, to uint, level int) {
+	node := h.nodes[from]
+	if level >= len(node.friends) {
+		return
+	}
+	node.friends[level] = append(node.friends[level], to)
+	if len(node.friends[level]) <= h.maxFriends(level) {
+		return
+	}
+	friends := node.friends[level]
+	sort.Slice(friends, func(i, j int) bool {
+		return h.distance(node.vec, friends[i]) < h.distance(node.vec, friends[j])
+	})
+	node.friends[level] = friends[:h.maxFriends(level)]
+}
+
+func (h *hnswIndex) remove(id uint) {
+	if node, ok := h.nodes[id]; ok && !node.deleted {
+		node.deleted = true
+		h.deleted++
+	}
+}
+
+// greedy 在某层上贪心走到离 q 最近的节点
+func (h *hnswIndex) greedy(q []float32, cur uint, level int) uint {
+	best := h.distance(q, cur)
+	for changed := true; changed; {
+		changed = false
+		for _, f := range h.nodes[cur].friends[level] {
+			if d := h.distance(q, f); d < best {
+				best, cur, changed = d, f, true
+			}
+		}
+	}
+	return cur
+}
+
+// searchLayer 在某层上做 ef 宽度的最佳优先搜索, 结果按距离升序 (含已删除节点, 由调用方过滤)
+func (h *hnswIndex) searchLayer(q []float32, entry uint, ef, level int) []scored {
+	visited := map[uint]bool{entry: true}
+	d := h.distance(q, entry)
+	cands := &distHeap{items: []scored{{id: entry, score: d}}}
+	results := &distHeap{max: true}
+	heap.Push(results, scored{id: entry, score: d})
+
+	for cands.Len() > 0 {
+		c := heap.Pop(cands).(scored)
+		if results.Len() >= ef && c.score > results.top().score {
+			break
+		}
+		node := h.nodes[c.id]
+		if level >= len(node.friends) {
+			continue
+		}
+		for _, f := range node.friends[level] {
+			if visited[f] {
+				continue
+			}
+			visited[f] = true
+			fd := h.distance(q, f)
+			if results.Len() < ef || fd < results.top().score {
+				heap.Push(cands, scored{id: f, score: fd})
+				heap.Push(results, scored{id: f, score: fd})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := make([]scored, results.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(results).(scored)
+	}
+	return out
+}
+
+// search 近邻查询, 返回相似度 (1-距离) 降序的结果
+func (h *hnswIndex) search(q []float32, k int, allow func(uint) bool) []scored {
+	if h.maxLevel < 0 || len(q) != h.dim {
+		return nil
+	}
+	cur := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		cur = h.greedy(q, cur, l)
+	}
+	ef := k * 10
+	if ef < hnswEfSearch {
+		ef = hnswEfSearch
+	}
+	var out []scored
+	for _, c := range h.searchLayer(q, cur, ef, 0) {
+		if h.nodes[c.id].deleted || (allow != nil && !allow(c.id)) {
+			continue
+		}
+		out = append(out, scored{id: c.id, score: 1 - c.score})
+		if len(out) == k {
+			break
+		}
+	}
+	return out
+}
+
+// exact 对给定分块做精确计算, 过滤条件很严时比图搜索更准
+func (h *hnswIndex) exact(q []float32, ids []uint, k int) []scored {
+	scores := make(map[uint]float64, len(ids))
+	for _, id := range ids {
+		node, ok := h.nodes[id]
+		if !ok || node.deleted || len(node.vec) != len(q) {
+			continue
+		}
+		scores[id] = dot(q, node.vec)
+	}
+	return topScored(scores, k)
+}
+
+// distHeap 按距离排序的堆, max 为true时为大顶堆
+type distHeap struct {
+	items []scored
+	max   bool
+}
+
+func (d distHeap) Len() int { return len(d.items) }
+func (d distHeap) Less(i, j int) bool {
+	if d.max {
+		return d.items[i].score > d.items[j].score
+	}
+	return d.items[i].score < d.items[j].score
+}
+func (d distHeap) Swap(i, j int)       { d.items[i], d.items[j] = d.items[j], d.items[i] }
+func (d *distHeap) Push(x interface{}) { d.items = append(d.items, x.(scored)) }
+func (d *distHeap) Pop() interface{} {
+	last := d.items[len(d.items)-1]
+	d.items = d.items[:len(d.items)-1]
+	return last
+}
+func (d distHeap) top() scored { return d.items[0] }
+
+func dot(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// normalize 返回L2归一化后的副本
+func normalize(vec []float32) []float32 {
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	out := make([]float32, len(vec))
+	if norm == 0 {
+		return out
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i, v := range vec {
+		out[i] = v * scale
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"agent-flow/internal/model"
+)
+
+// recall 近似结果中有多少落在精确的前k个里
+func recall(approx, exact []scored) float64 {
+	if len(exact) == 0 {
+		return 1
+	}
+	want := make(map[uint]bool, len(exact))
+	for _, s := range exact {
+		want[s.id] = true
+	}
+	hit := 0
+	for _, s := range approx {
+		if want[s.id] {
+			hit++
+		}
+	}
+	return float64(hit) / float64(len(exact))
+}
+
+func TestHNSWRecall(t *testing.T) {
+	const (
+		n       = 2000
+		queries = 50
+		k       = 10
+	)
+	topics := []string{"数据库备份", "向量检索", "模型路由", "限流配置", "知识导入", "工作流投票", "渠道消息", "记忆整理"}
+	rng := rand.New(rand.NewSource(7))
+	text := func(i int) string {
+		return fmt.Sprintf("%s 第%d条 %s 相关说明 %d", topics[rng.Intn(len(topics))], i, topics[rng.Intn(len(topics))], rng.Intn(1000))
+	}
+
+	embedder := model.NewHashEmbedder(256)
+	texts := make([]string, n)
+	for i := range texts {
+		texts[i] = text(i)
+	}
+	vectors, err := embedder.Embed(context.Background(), "hash", texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHNSWIndex()
+	ids := make([]uint, n)
+	for i, vec := range vectors {
+		ids[i] = uint(i + 1)
+		h.add(ids[i], normalize(vec))
+	}
+
+	tests := []struct {
+		name  string
+		allow func(uint) bool
+		min   float64
+	}{
+		{"unfiltered", nil, 0.9},
+		{"half filtered", func(id uint) bool { return id%2 == 0 }, 0.85},
+	}
+	for _, tt := range tests {
+		var total float64
+		for q := 0; q < queries; q++ {
+			qv, _ := embedder.Embed(context.Background(), "hash", []string{text(n + q)})
+			qvec := normalize(qv[0])
+
+			var allowed []uint
+			for _, id := range ids {
+				if tt.allow == nil || tt.allow(id) {
+					allowed = append(allowed, id)
+				}
+			}
+			total += recall(h.search(qvec, k, tt.allow), h.exact(qvec, allowed, k))
+		}
+		if avg := total / queries; avg < tt.min {
+			t.Errorf("%s: recall@%d = %.3f, want >= %.2f", tt.name, k, avg, tt.min)
+		}
+	}
+}
+
+func TestHNSWRemoveAndCompact(t *testing.T) {
+	embedder := model.NewHashEmbedder(256)
+	x := newKnowledgeIndex("hash")
+	for id := uint(1); id <= 200; id++ {
+		content := fmt.Sprintf("知识%d 关于主题%d", id, id%10)
+		vec, _ := embedder.Embed(context.Background(), "hash", []string{content})
+		x.put(&Knowledge{ID: id, Title: content}, []KnowledgeChunk{{ID: id, Content: content, Model: "hash", Embedding: vec[0]}})
+	}
+
+	qv, _ := embedder.Embed(context.Background(), "hash", []string{"知识7 关于主题7"})
+	qvec := normalize(qv[0])
+	if res := x.vectors.search(qvec, 1, nil); len(res) != 1 || res[0].id != 7 {
+		t.Fatalf("nearest = %v, want chunk 7", res)
+	}
+
+	// 删除的向量不再返回; 删除过半后重建图
+	for id := uint(1); id <= 150; id++ {
+		x.remove(id)
+	}
+	if x.vectors.live() != 50 || len(x.vectors.nodes) >= 200 {
+		t.Fatalf("after removal: nodes=%d live=%d, want the graph rebuilt", len(x.vectors.nodes), x.vectors.live())
+	}
+	for _, s := range x.vectors.search(qvec, 10, nil) {
+		if s.id <= 150 {
+			t.Errorf("removed chunk %d returned", s.id)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"agent-flow/internal/store"
+	"gorm.io/gorm"
+)
+
+// 模型定义在 store 中
+type (
+	KnowledgeSource = store.KnowledgeSource
+	IngestJob       = store.IngestJob
+)
+
+// 导入任务状态
+const (
+	IngestPending   = "pending"
+	IngestRunning   = "running"
+	IngestCompleted = "completed"
+	IngestFailed    = "failed"
+)
+
+// 导入任务阶段
+const (
+	stageExtracting = "extracting"
+	stageIndexing   = "indexing"
+	stageCleanup    = "cleanup"
+)
+
+const (
+	MaxIngestSize      = 20 << 20 // 单个文档最大20MB
+	ingestPollInterval = 2 * time.Second
+	ingestStaleAfter   = 10 * time.Minute // 运行中的任务超过该时间没有进度视为执行副本已退出
+	ingestParallel     = 2
+)
+
+// ErrInvalidDocument 文档无法导入 (格式不支持、为空或过大)
+var ErrInvalidDocument = errors.New("invalid document")
+
+// IngestRequest 文档导入参数
+type IngestRequest struct {
+	AgentID     uint
+	Name        string // 源文档名称, 重新上传同名文档时增量更新
+	Format      string // 为空时按文件名和内容识别
+	Data        []byte
+	Chunking    ChunkOptions
+	Tags        string
+	AccessLevel int
+}
+
+// UseBlobs 设置原始文档的存储, 启用文档导入
+func (s *Service) UseBlobs(blobs *store.BlobStore) {
+	s.blobs = blobs
+}
+
+// contentHash 知识内容的哈希, 用于去重和识别文档中未变化的段落
+func contentHash(title, content string) string {
+	sum := sha256.Sum256([]byte(title + "\x00" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+// SubmitIngest 保存原始文档并创建导入任务, 由后台 worker 执行
+func (s *Service) SubmitIngest(req IngestRequest) (*IngestJob, error) {
+	if s.db == nil || s.blobs == nil {
+		return nil, fmt.Errorf("document ingestion not configured")
+	}
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidDocument)
+	}
+	if len(req.Data) == 0 {
+		return nil, fmt.Errorf("%w: empty document", ErrInvalidDocument)
+	}
+	if len(req.Data) > MaxIngestSize {
+		return nil, fmt.Errorf("%w: document larger than %d bytes", ErrInvalidDocument, MaxIngestSize)
+	}
+	format := req.Format
+	if format == "" {
+		var err error
+		if format, err = DetectFormat(req.Name, req.Data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDocument, err)
+		}
+	}
+	switch format {
+	case FormatMarkdown, FormatHTML, FormatText, FormatPDF, FormatDOCX:
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %s", ErrInvalidDocument, format)
+	}
+	if req.AccessLevel <= 0 {
+		req.AccessLevel = 5
+	}
+	opts := req.Chunking.normalize()
+
+	blob, err := s.blobs.Put(req.Data, "", req.Name, "ingest")
+	if err != nil {
+		return nil, err
+	}
+	job := &IngestJob{
+		AgentID:     req.AgentID,
+		Name:        req.Name,
+		Format:      format,
+		BlobID:      blob.ID,
+		ChunkSize:   opts.Size,
+		Overlap:     opts.Overlap,
+		Tags:        req.Tags,
+		AccessLevel: req.AccessLevel,
+		Status:      IngestPending,
+	}
+	if err := s.db.CreateIngestJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetIngestJob 获取导入任务 (进度)
+func (s *Service) GetIngestJob(agentID, id uint) (*IngestJob, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("memory store not configured")
+	}
+	job, err := s.db.GetIngestJob(id)
+	if err != nil || job.AgentID != agentID {
+		return nil, fmt.Errorf("ingest job %d not found", id)
+	}
+	return job, nil
+}
+
+// ListIngestJobs 智能体最近的导入任务
+func (s *Service) ListIngestJobs(agentID uint, limit int) ([]IngestJob, error) {
+	if s.db == nil {
+		return []IngestJob{}, nil
+	}
+	return s.db.ListIngestJobs(agentID, limit)
+}
+
+// ListSources 智能体导入的源文档
+func (s *Service) ListSources(agentID uint) ([]KnowledgeSource, error) {
+	if s.db == nil {
+		return []KnowledgeSource{}, nil
+	}
+	return s.db.ListKnowledgeSources(agentID)
+}
+
+// DeleteSource 删除源文档及其导入的全部知识
+func (s *Service) DeleteSource(agentID, sourceID uint) error {
+	if s.db == nil {
+		return fmt.Errorf("memory store not configured")
+	}
+	source, err := s.db.GetKnowledgeSource(sourceID)
+	if err != nil || source.AgentID != agentID {
+		return fmt.Errorf("knowledge source %d not found", sourceID)
+	}
+	knowledge, err := s.db.ListSourceKnowledge(sourceID)
+	if err != nil {
+		return err
+	}
+	for _, k := range knowledge {
+		if err := s.DeleteKnowledge(agentID, k.ID); err != nil {
+			return err
+		}
+	}
+	return s.db.DeleteKnowledgeSource(sourceID)
+}
+
+// RunIngestWorker 轮询并执行导入任务, ctx 结束时等待执行中的任务退出
+func (s *Service) RunIngestWorker(ctx context.Context) {
+	sem := make(chan struct{}, ingestParallel)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(ingestPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.db == nil || s.blobs == nil {
+			continue
+		}
+		pending, err := s.db.PendingIngestJobs(time.Now().Add(-ingestStaleAfter), cap(sem))
+		if err != nil {
+			log.Printf("[Ingest] list pending jobs: %v", err)
+			continue
+		}
+	dispatch:
+		for i := range pending {
+			select {
+			case sem <- struct{}{}:
+			default:
+				break dispatch
+			}
+			claimed, err := s.db.ClaimIngestJob(&pending[i])
+			if err != nil || !claimed {
+				<-sem
+				continue
+			}
+			wg.Add(1)
+			go func(job IngestJob) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				s.runIngestJob(ctx, &job)
+			}(pending[i])
+		}
+	}
+}
+
+// runIngestJob 执行导入并记录结果
+func (s *Service) runIngestJob(ctx context.Context, job *IngestJob) {
+	err := s.ingest(ctx, job)
+	now := time.Now()
+	updates := map[string]interface{}{"finished_at": now}
+	switch {
+	case err == nil:
+		updates["status"] = IngestCompleted
+		updates["progress"] = 100
+	case ctx.Err() != nil:
+		// 服务停止, 保持运行中状态, 超时后由其他副本重新执行
+		log.Printf("[Ingest] job %d interrupted: %v", job.ID, err)
+		return
+	default:
+		log.Printf("[Ingest] job %d failed: %v", job.ID, err)
+		updates["status"] = IngestFailed
+		updates["error"] = err.Error()
+	}
+	if err := s.db.UpdateIngestJob(job.ID, updates); err != nil {
+		log.Printf("[Ingest] update job %d: %v", job.ID, err)
+	}
+}
+
+// ingest 提取文档段落并与上次导入的版本对比: 未变化的段落保留, 新段落写入并建立索引,
+// 新版本中已不存在的段落删除; 与智能体已有知识内容相同的段落跳过
+func (s *Service) ingest(ctx context.Context, job *IngestJob) error {
+	progress := func(updates map[string]interface{}) {
+		if err := s.db.UpdateIngestJob(job.ID, updates); err != nil {
+			log.Printf("[Ingest] update job %d: %v", job.ID, err)
+		}
+	}
+
+	progress(map[string]interface{}{"stage": stageExtracting})
+	data, _, err := s.blobs.Get(job.BlobID)
+	if err != nil {
+		return fmt.Errorf("load document: %w", err)
+	}
+	sections, err := ExtractSections(job.Format, job.Name, data)
+	if err != nil {
+		return err
+	}
+
+	source, err := s.db.FindKnowledgeSource(job.AgentID, job.Name)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		source = &KnowledgeSource{AgentID: job.AgentID, Name: job.Name}
+	}
+	opts := ChunkOptions{Size: job.ChunkSize, Overlap: job.Overlap}.normalize()
+	settingsChanged := source.ID != 0 && (source.ChunkSize != opts.Size || source.Overlap != opts.Overlap ||
+		source.Tags != job.Tags || source.AccessLevel != job.AccessLevel)
+	source.Format, source.ContentHash, source.BlobID, source.Size = job.Format, job.BlobID, job.BlobID, int64(len(data))
+	source.ChunkSize, source.Overlap, source.Tags, source.AccessLevel = opts.Size, opts.Overlap, job.Tags, job.AccessLevel
+	if err := s.db.SaveKnowledgeSource(source); err != nil {
+		return err
+	}
+
+	previous, err := s.db.ListSourceKnowledge(source.ID)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]*Knowledge, len(previous))
+	for i := range previous {
+		if _, ok := existing[previous[i].ContentHash]; !ok {
+			existing[previous[i].ContentHash] = &previous[i]
+		}
+	}
+
+	job.SourceID = &source.ID
+	job.Total = len(sections)
+	progress(map[string]interface{}{"source_id": source.ID, "stage": stageIndexing, "total": job.Total})
+
+	parentID, _ := s.GetParentID(job.AgentID)
+	keep := make(map[uint]bool)
+	seen := make(map[string]bool)
+	for i, sec := range sections {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hash := contentHash(sec.Title, sec.Content)
+		switch k := existing[hash]; {
+		case seen[hash]:
+			job.Duplicates++
+		case k != nil:
+			keep[k.ID] = true
+			if !settingsChanged {
+				job.Unchanged++
+				break
+			}
+			k.Tags, k.AccessLevel, k.UpdatedAt = job.Tags, job.AccessLevel, time.Now()
+			if err := s.db.UpdateKnowledge(k); err != nil {
+				return err
+			}
+			if err := s.indexKnowledge(ctx, k, opts); err != nil {
+				return err
+			}
+			job.Updated++
+		default:
+			dup, err := s.db.KnowledgeHashExists(job.AgentID, hash)
+			if err != nil {
+				return err
+			}
+			if dup {
+				job.Duplicates++
+				break
+			}
+			k := &Knowledge{
+				AgentID:     job.AgentID,
+				ParentID:    parentID,
+				Title:       sec.Title,
+				Content:     sec.Content,
+				Tags:        job.Tags,
+				AccessLevel: job.AccessLevel,
+				SourceID:    &source.ID,
+				ContentHash: hash,
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
+			}
+			if err := s.db.CreateKnowledge(k); err != nil {
+				return err
+			}
+			if err := s.indexKnowledge(ctx, k, opts); err != nil {
+				return err
+			}
+			keep[k.ID] = true
+			job.Added++
+		}
+		seen[hash] = true
+
+		job.Done = i + 1
+		progress(map[string]interface{}{
+			"done":       job.Done,
+			"progress":   job.Done * 99 / job.Total,
+			"added":      job.Added,
+			"updated":    job.Updated,
+			"unchanged":  job.Unchanged,
+			"duplicates": job.Duplicates,
+		})
+	}
+
+	progress(map[string]interface{}{"stage": stageCleanup})
+	for _, k := range previous {
+		if keep[k.ID] {
+			continue
+		}
+		if err := s.DeleteKnowledge(job.AgentID, k.ID); err != nil {
+			return err
+		}
+		job.Removed++
+	}
+	source.Sections = len(keep)
+	if err := s.db.SaveKnowledgeSource(source); err != nil {
+		return err
+	}
+	progress(map[string]interface{}{"removed": job.Removed})
+	return nil
+}
@@ -0,0 +1,666 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-flow/internal/model"
+	"agent-flow/internal/store"
+	"gorm.io/gorm"
+)
+
+// KnowledgeChunk 知识分块 (模型定义在 store 中)
+type KnowledgeChunk = store.KnowledgeChunk
+
+const (
+	rrfK            = 60              // RRF 平滑常数
+	defaultTopK     = 5               // 默认返回的分块数
+	exactSearchMax  = 2048            // 过滤后候选不超过该数时做精确向量计算
+	indexRefresh    = 5 * time.Minute // 内存索引从数据库重建的间隔 (兜底, 正常由变更通知同步其他副本的写入)
+	indexEmbedLimit = 30 * time.Second
+	embedBatch      = 50 // 每轮后台向量化的知识条数
+
+	// maxIndexedChunks 单副本内存索引的分块规模上限. 每个副本都把全部分块和向量
+	// 加载进内存 (1536维约6KB/块, 加上HNSW邻接表), 10万块约需1GB内存和数十秒重建;
+	// 超过后应把向量检索迁到 pgvector 等外部向量库, 这里只在加载时告警
+	maxIndexedChunks = 100000
+
+	knowledgeChangedChannel = "memory:knowledge:changed" // 消息为知识ID, reloadMessage 表示全部重建
+	reloadMessage           = "reload"
+)
+
+// ========== 分块 ==========
+
+// ChunkOptions 分块参数 (按字符计)
+type ChunkOptions struct {
+	Size    int `json:"size"`    // 每块最多字符数
+	Overlap int `json:"overlap"` // 相邻块重叠的字符数
+}
+
+// DefaultChunkOptions 默认分块参数
+var DefaultChunkOptions = ChunkOptions{Size: 500, Overlap: 80}
+
+func (o ChunkOptions) normalize() ChunkOptions {
+	if o.Size <= 0 {
+		o.Size = DefaultChunkOptions.Size
+	}
+	if o.Overlap < 0 {
+		o.Overlap = 0
+	}
+	if o.Overlap >= o.Size {
+		o.Overlap = o.Size / 4
+	}
+	return o
+}
+
+// ChunkText 按句子把文本装入不超过 Size 的块, 相邻块重叠 Overlap 个字符; 超长句子按字符硬切
+func ChunkText(text string, opts ChunkOptions) []string {
+	opts = opts.normalize()
+	var (
+		chunks []string
+		cur    []rune
+		fresh  int // 上次切块后新加入的字符数
+	)
+	flush := func() {
+		if s := strings.TrimSpace(string(cur)); s != "" {
+			chunks = append(chunks, s)
+		}
+		keep := opts.Overlap
+		if keep > len(cur) {
+			keep = len(cur)
+		}
+		cur = append([]rune(nil), cur[len(cur)-keep:]...)
+		fresh = 0
+	}
+
+	for _, seg := range splitSentences(strings.TrimSpace(text)) {
+		for len(seg) > 0 {
+			room := opts.Size - len(cur)
+			if len(seg) <= room {
+				cur = append(cur, seg...)
+				fresh += len(seg)
+				break
+			}
+			// 放不下整句时先换块, 尽量不把句子切断
+			if fresh > 0 && len(seg) <= opts.Size-opts.Overlap {
+				flush()
+				continue
+			}
+			cur = append(cur, seg[:room]...)
+			fresh += room
+			seg = seg[room:]
+			flush()
+		}
+	}
+	if fresh > 0 {
+		flush()
+	}
+	return chunks
+}
+
+// splitSentences 在中英文句末标点和换行后切分, 保留标点
+func splitSentences(text string) [][]rune {
+	var (
+		out   [][]rune
+		start int
+	)
+	runes := []rune(text)
+	for i, r := range runes {
+		end := false
+		switch r {
+		case '。', '！', '？', '；', '!', '?', ';', '\n':
+			end = true
+		case '.':
+			end = i+1 == len(runes) || runes[i+1] == ' ' || runes[i+1] == '\n'
+		}
+		if end {
+			out = append(out, runes[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(runes) {
+		out = append(out, runes[start:])
+	}
+	return out
+}
+
+// ========== 索引 ==========
+
+// chunkDoc 索引中的分块及其过滤用的元数据
+type chunkDoc struct {
+	ChunkID     uint
+	KnowledgeID uint
+	AgentID     uint
+	AccessLevel int
+	Tags        []string
+	Title       string
+	Seq         int
+	Content     string
+}
+
+// knowledgeIndex 知识检索索引: BM25 倒排 + HNSW 向量图, 数据库为准, 内存中可随时重建.
+// 各副本各自持有一份, 写入通过 knowledgeChangedChannel 同步, 规模受 maxIndexedChunks 限制
+type knowledgeIndex struct {
+	mu          sync.RWMutex
+	model       string // 向量模型, 查询向量必须由同一模型生成
+	docs        map[uint]*chunkDoc
+	byKnowledge map[uint][]uint
+	bm25        *bm25Index
+	vectors     *hnswIndex
+}
+
+func newKnowledgeIndex(embedModel string) *knowledgeIndex {
+	return &knowledgeIndex{
+		model:       embedModel,
+		docs:        make(map[uint]*chunkDoc),
+		byKnowledge: make(map[uint][]uint),
+		bm25:        newBM25Index(),
+		vectors:     newHNSWIndex(),
+	}
+}
+
+// put 用新的分块替换知识在索引中的分块
+func (x *knowledgeIndex) put(k *Knowledge, chunks []KnowledgeChunk) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(k.ID)
+	tags := splitTags(k.Tags)
+	for _, c := range chunks {
+		doc := &chunkDoc{
+			ChunkID:     c.ID,
+			KnowledgeID: k.ID,
+			AgentID:     k.AgentID,
+			AccessLevel: k.AccessLevel,
+			Tags:        tags,
+			Title:       k.Title,
+			Seq:         c.Seq,
+			Content:     c.Content,
+		}
+		x.docs[c.ID] = doc
+		x.byKnowledge[k.ID] = append(x.byKnowledge[k.ID], c.ID)
+		x.bm25.add(c.ID, tokenize(k.Title+"\n"+k.Tags+"\n"+c.Content))
+		if c.Model == x.model && len(c.Embedding) > 0 {
+			x.vectors.add(c.ID, normalize(c.Embedding))
+		}
+	}
+	x.compactLocked()
+}
+
+func (x *knowledgeIndex) remove(knowledgeID uint) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(knowledgeID)
+	x.compactLocked()
+}
+
+func (x *knowledgeIndex) removeLocked(knowledgeID uint) {
+	for _, id := range x.byKnowledge[knowledgeID] {
+		delete(x.docs, id)
+		x.bm25.remove(id)
+		x.vectors.remove(id)
+	}
+	delete(x.byKnowledge, knowledgeID)
+}
+
+// compactLocked HNSW 删除标记过半时用存活向量重建图
+func (x *knowledgeIndex) compactLocked() {
+	if x.vectors.deleted < 64 || x.vectors.deleted*2 < len(x.vectors.nodes) {
+		return
+	}
+	old := x.vectors
+	x.vectors = newHNSWIndex()
+	ids := make([]uint, 0, old.live())
+	for id, node := range old.nodes {
+		if !node.deleted {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		x.vectors.add(id, old.nodes[id].vec)
+	}
+}
+
+// ========== 检索 ==========
+
+// KnowledgeQuery 知识检索条件, 零值的过滤条件表示不过滤
+type KnowledgeQuery struct {
+	Query          string   `json:"query"`
+	AgentIDs       []uint   `json:"agent_ids"`
+	MaxAccessLevel int      `json:"max_access_level"` // 只返回访问级别不超过该值的知识
+	Tags           []string `json:"tags"`             // 必须包含全部标签
+	TopK           int      `json:"top_k"`
+}
+
+// KnowledgeHit 检索命中的分块, 排名为0表示该路未命中
+type KnowledgeHit struct {
+	ChunkID     uint     `json:"chunk_id"`
+	KnowledgeID uint     `json:"knowledge_id"`
+	AgentID     uint     `json:"agent_id"`
+	Title       string   `json:"title"`
+	Seq         int      `json:"seq"`
+	Content     string   `json:"content"`
+	Tags        []string `json:"tags,omitempty"`
+	Score       float64  `json:"score"` // RRF 融合分数
+	BM25Rank    int      `json:"bm25_rank,omitempty"`
+	VectorRank  int      `json:"vector_rank,omitempty"`
+}
+
+// filter 生成过滤函数及满足条件的分块, 没有过滤条件时都返回nil
+func (x *knowledgeIndex) filter(q KnowledgeQuery) (func(uint) bool, []uint) {
+	if len(q.AgentIDs) == 0 && q.MaxAccessLevel <= 0 && len(q.Tags) == 0 {
+		return nil, nil
+	}
+	agents := make(map[uint]bool, len(q.AgentIDs))
+	for _, id := range q.AgentIDs {
+		agents[id] = true
+	}
+	tags := make([]string, 0, len(q.Tags))
+	for _, t := range q.Tags {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	allow := func(id uint) bool {
+		doc, ok := x.docs[id]
+		if !ok {
+			return false
+		}
+		if len(agents) > 0 && !agents[doc.AgentID] {
+			return false
+		}
+		if q.MaxAccessLevel > 0 && doc.AccessLevel > q.MaxAccessLevel {
+			return false
+		}
+		for _, t := range tags {
+			if !containsString(doc.Tags, t) {
+				return false
+			}
+		}
+		return true
+	}
+	var candidates []uint
+	for id := range x.docs {
+		if allow(id) {
+			candidates = append(candidates, id)
+		}
+	}
+	return allow, candidates
+}
+
+// search BM25 和向量两路召回后用 RRF 融合, qvec 为nil时只走BM25
+func (x *knowledgeIndex) search(q KnowledgeQuery, qvec []float32) []KnowledgeHit {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	allow, candidates := x.filter(q)
+	if allow != nil && len(candidates) == 0 {
+		return []KnowledgeHit{}
+	}
+	depth := q.TopK * 4
+	if depth < 20 {
+		depth = 20
+	}
+
+	fused := make(map[uint]*KnowledgeHit)
+	hit := func(id uint) *KnowledgeHit {
+		if h, ok := fused[id]; ok {
+			return h
+		}
+		doc := x.docs[id]
+		h := &KnowledgeHit{ChunkID: id, KnowledgeID: doc.KnowledgeID, AgentID: doc.AgentID,
+			Title: doc.Title, Seq: doc.Seq, Content: doc.Content, Tags: doc.Tags}
+		fused[id] = h
+		return h
+	}
+	for rank, s := range x.bm25.search(tokenize(q.Query), allow, depth) {
+		h := hit(s.id)
+		h.BM25Rank = rank + 1
+		h.Score += 1 / float64(rrfK+rank+1)
+	}
+	if len(qvec) > 0 {
+		var nearest []scored
+		if allow != nil && len(candidates) <= exactSearchMax {
+			nearest = x.vectors.exact(qvec, candidates, depth)
+		} else {
+			nearest = x.vectors.search(qvec, depth, allow)
+		}
+		for rank, s := range nearest {
+			h := hit(s.id)
+			h.VectorRank = rank + 1
+			h.Score += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	hits := make([]KnowledgeHit, 0, len(fused))
+	for _, h := range fused {
+		hits = append(hits, *h)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ChunkID < hits[j].ChunkID
+	})
+	if len(hits) > q.TopK {
+		hits = hits[:q.TopK]
+	}
+	return hits
+}
+
+// ========== 服务方法 ==========
+
+// UseEmbeddings 启用向量检索, embedModel 为空时使用模型服务的默认向量模型
+func (s *Service) UseEmbeddings(models *model.Service, embedModel string) {
+	s.models = models
+	s.embedModel = embedModel
+}
+
+// embeddingModel 当前使用的向量模型, 未启用向量检索时为空
+func (s *Service) embeddingModel() string {
+	if s.models == nil {
+		return ""
+	}
+	if s.embedModel != "" {
+		return s.embedModel
+	}
+	return s.models.DefaultEmbeddingModel()
+}
+
+// embed 向量化文本, 失败时返回nil (检索退化为只用BM25)
+func (s *Service) embed(ctx context.Context, embedModel string, texts []string) [][]float32 {
+	if embedModel == "" || len(texts) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, indexEmbedLimit)
+	defer cancel()
+	resp, err := s.models.Embed(ctx, embedModel, texts)
+	if err != nil {
+		log.Printf("embedding with %s failed: %v", embedModel, err)
+		return nil
+	}
+	return resp.Vectors
+}
+
+// indexKnowledge 对知识分块、向量化并写入数据库和内存索引
+func (s *Service) indexKnowledge(ctx context.Context, k *Knowledge, opts ChunkOptions) error {
+	if s.db == nil {
+		return nil
+	}
+	embedModel := s.embeddingModel()
+	texts := ChunkText(k.Content, opts)
+	inputs := make([]string, len(texts))
+	for i, text := range texts {
+		inputs[i] = k.Title + "\n" + text
+	}
+	vectors := s.embed(ctx, embedModel, inputs)
+
+	chunks := make([]KnowledgeChunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = KnowledgeChunk{KnowledgeID: k.ID, AgentID: k.AgentID, Seq: i, Content: text, CreatedAt: time.Now()}
+		if i < len(vectors) && len(vectors[i]) > 0 {
+			chunks[i].Model = embedModel
+			chunks[i].Embedding = vectors[i]
+		}
+	}
+	if err := s.db.ReplaceKnowledgeChunks(k.ID, chunks); err != nil {
+		return fmt.Errorf("save chunks of knowledge %d: %w", k.ID, err)
+	}
+	if x := s.currentIndex(); x != nil {
+		x.put(k, chunks)
+	}
+	s.knowledgeChanged(ctx, strconv.FormatUint(uint64(k.ID), 10))
+	return nil
+}
+
+// knowledgeChanged 通知其他副本更新内存索引, 不必等到 indexRefresh 重建
+func (s *Service) knowledgeChanged(ctx context.Context, message string) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Publish(ctx, knowledgeChangedChannel, message); err != nil {
+		log.Printf("publish knowledge change %s: %v", message, err)
+	}
+}
+
+// RunIndexSync 监听其他副本的知识变更并更新本副本的内存索引, 每个副本运行一个
+func (s *Service) RunIndexSync(ctx context.Context) {
+	if s.redis == nil || s.db == nil {
+		<-ctx.Done()
+		return
+	}
+	for message := range s.redis.Subscribe(ctx, knowledgeChangedChannel) {
+		if err := s.applyKnowledgeChange(message); err != nil {
+			log.Printf("Failed to sync knowledge change %s: %v", message, err)
+		}
+	}
+}
+
+// applyKnowledgeChange 从数据库读取变更的知识替换索引中的分块, 知识已删除时移除
+func (s *Service) applyKnowledgeChange(message string) error {
+	x := s.currentIndex()
+	if x == nil {
+		return nil // 尚未加载, 下次检索时从数据库加载
+	}
+	if message == reloadMessage {
+		// 让下次检索在后台重建
+		s.indexMu.Lock()
+		s.indexLoadedAt = time.Time{}
+		s.indexMu.Unlock()
+		return nil
+	}
+	id, err := strconv.ParseUint(message, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid knowledge id: %w", err)
+	}
+	k, err := s.db.GetKnowledge(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		x.remove(uint(id))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	chunks, err := s.db.ListChunksOfKnowledge(k.ID)
+	if err != nil {
+		return err
+	}
+	x.put(k, chunks)
+	return nil
+}
+
+// currentIndex 已加载的索引 (未加载时为nil, 下次检索时从数据库加载)
+func (s *Service) currentIndex() *knowledgeIndex {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	return s.index
+}
+
+// ensureIndex 首次检索时同步加载索引; 之后超过刷新间隔时在后台重建, 期间继续使用旧索引
+func (s *Service) ensureIndex(ctx context.Context) (*knowledgeIndex, error) {
+	s.indexMu.Lock()
+	x, loadedAt := s.index, s.indexLoadedAt
+	stale := x != nil && time.Since(loadedAt) > indexRefresh && !s.indexRefreshing
+	if stale {
+		s.indexRefreshing = true
+	}
+	s.indexMu.Unlock()
+
+	if x == nil {
+		s.loadMu.Lock()
+		defer s.loadMu.Unlock()
+		if x := s.currentIndex(); x != nil {
+			return x, nil
+		}
+		return s.loadIndex()
+	}
+	if stale {
+		go func() {
+			if _, err := s.loadIndex(); err != nil {
+				log.Printf("Failed to refresh knowledge index: %v", err)
+			}
+		}()
+	}
+	return x, nil
+}
+
+// loadIndex 从数据库重建索引, 只读取已有的分块和向量; 没有分块或向量模型已变更的知识
+// 由 EmbeddingJob 在后台重新分块和向量化, 期间这些知识只能按已有分块检索
+func (s *Service) loadIndex() (*knowledgeIndex, error) {
+	defer func() {
+		s.indexMu.Lock()
+		s.indexRefreshing = false
+		s.indexMu.Unlock()
+	}()
+
+	knowledge, err := s.db.ListAllKnowledge()
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := s.db.ListKnowledgeChunks()
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) > maxIndexedChunks {
+		log.Printf("Knowledge index holds %d chunks, above the in-memory limit of %d; consider an external vector store", len(chunks), maxIndexedChunks)
+	}
+	byKnowledge := make(map[uint][]KnowledgeChunk)
+	for _, c := range chunks {
+		byKnowledge[c.KnowledgeID] = append(byKnowledge[c.KnowledgeID], c)
+	}
+
+	embedModel := s.embeddingModel()
+	x := newKnowledgeIndex(embedModel)
+	outdated := 0
+	for i := range knowledge {
+		k := &knowledge[i]
+		cs := byKnowledge[k.ID]
+		if len(cs) == 0 || (embedModel != "" && cs[0].Model != embedModel) {
+			outdated++
+		}
+		x.put(k, cs)
+	}
+
+	s.indexMu.Lock()
+	s.index, s.indexLoadedAt = x, time.Now()
+	s.indexMu.Unlock()
+
+	if outdated > 0 {
+		log.Printf("Knowledge index loaded, %d knowledge items await re-embedding with %q", outdated, embedModel)
+	}
+	return x, nil
+}
+
+// EmbeddingJob 定期给没有分块或向量模型已变更的知识重新分块和向量化 (一个副本执行, 结果通过变更通知同步到其他副本)
+func (s *Service) EmbeddingJob() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := s.RefreshEmbeddings(ctx, embedBatch)
+		if n > 0 {
+			log.Printf("[Memory] re-embedded %d knowledge items", n)
+		}
+		return err
+	}
+}
+
+// RefreshEmbeddings 处理最多 limit 条待向量化的知识, 返回处理的条数
+func (s *Service) RefreshEmbeddings(ctx context.Context, limit int) (int, error) {
+	if s.db == nil {
+		return 0, nil
+	}
+	knowledge, err := s.db.ListUnindexedKnowledge(s.embeddingModel(), limit)
+	if err != nil {
+		return 0, err
+	}
+	for i := range knowledge {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := s.indexKnowledge(ctx, &knowledge[i], DefaultChunkOptions); err != nil {
+			return i, err
+		}
+	}
+	return len(knowledge), nil
+}
+
+// ReindexKnowledge 重新分块和向量化全部知识 (更换向量模型或分块参数后使用), 返回处理的知识数
+func (s *Service) ReindexKnowledge(ctx context.Context) (int, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("memory store not configured")
+	}
+	knowledge, err := s.db.ListAllKnowledge()
+	if err != nil {
+		return 0, err
+	}
+	s.indexMu.Lock()
+	s.index, s.indexLoadedAt = newKnowledgeIndex(s.embeddingModel()), time.Now()
+	s.indexMu.Unlock()
+
+	for i := range knowledge {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := s.indexKnowledge(ctx, &knowledge[i], DefaultChunkOptions); err != nil {
+			return i, err
+		}
+	}
+	s.knowledgeChanged(ctx, reloadMessage)
+	return len(knowledge), nil
+}
+
+// RetrieveKnowledge 混合检索 (BM25 + 向量, RRF 融合), 返回最相关的 TopK 个分块
+func (s *Service) RetrieveKnowledge(ctx context.Context, q KnowledgeQuery) ([]KnowledgeHit, error) {
+	if s.db == nil || strings.TrimSpace(q.Query) == "" {
+		return []KnowledgeHit{}, nil
+	}
+	if q.TopK <= 0 {
+		q.TopK = defaultTopK
+	}
+	x, err := s.ensureIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var qvec []float32
+	if vectors := s.embed(ctx, x.model, []string{q.Query}); len(vectors) == 1 {
+		qvec = normalize(vectors[0])
+	}
+	return x.search(q, qvec), nil
+}
+
+// RetrieveForAgent 在智能体自己和直接下级的知识中检索
+func (s *Service) RetrieveForAgent(ctx context.Context, agentID uint, query string, topK int) ([]KnowledgeHit, error) {
+	subIDs, err := s.GetSubordinateIDs(agentID)
+	if err != nil {
+		return nil, err
+	}
+	return s.RetrieveKnowledge(ctx, KnowledgeQuery{
+		Query:    query,
+		AgentIDs: append([]uint{agentID}, subIDs...),
+		TopK:     topK,
+	})
+}
+
+func splitTags(tags string) []string {
+	var out []string
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" && !containsString(out, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"agent-flow/internal/model"
+)
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		opts ChunkOptions
+		want []string
+	}{
+		{"empty", "  ", ChunkOptions{Size: 8}, nil},
+		{"fits one chunk", "短文本。", ChunkOptions{Size: 8}, []string{"短文本。"}},
+		{"sentence boundaries", "第一句。第二句。第三句。", ChunkOptions{Size: 8}, []string{"第一句。第二句。", "第三句。"}},
+		{"overlap", "第一句。第二句。第三句。", ChunkOptions{Size: 8, Overlap: 2}, []string{"第一句。第二句。", "句。第三句。"}},
+		{"hard cut long sentence", "abcdefghij", ChunkOptions{Size: 4}, []string{"abcd", "efgh", "ij"}},
+		{"english sentences", "One. Two? Three!", ChunkOptions{Size: 9}, []string{"One. Two?", "Three!"}},
+	}
+	for _, tt := range tests {
+		got := ChunkText(tt.text, tt.opts)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ChunkText = %q, want %q", tt.name, got, tt.want)
+		}
+		for _, c := range got {
+			if n := len([]rune(c)); n > tt.opts.Size {
+				t.Errorf("%s: chunk %q has %d runes, size %d", tt.name, c, n, tt.opts.Size)
+			}
+		}
+	}
+}
+
+func TestChunkOptionsNormalize(t *testing.T) {
+	tests := []struct {
+		in, want ChunkOptions
+	}{
+		{ChunkOptions{}, ChunkOptions{Size: DefaultChunkOptions.Size}},
+		{ChunkOptions{Size: 100, Overlap: -1}, ChunkOptions{Size: 100, Overlap: 0}},
+		{ChunkOptions{Size: 100, Overlap: 100}, ChunkOptions{Size: 100, Overlap: 25}},
+	}
+	for _, tt := range tests {
+		if got := tt.in.normalize(); got != tt.want {
+			t.Errorf("normalize(%+v) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Hello, World 42", []string{"hello", "world", "42"}},
+		{"向量检索", []string{"向", "向量", "量", "量检", "检", "检索", "索"}},
+		{"GPT4模型", []string{"gpt4", "模", "模型", "型"}},
+		{"数据库-HNSW", []string{"数", "数据", "据", "据库", "库", "hnsw"}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBM25Search(t *testing.T) {
+	b := newBM25Index()
+	docs := map[uint]string{
+		1: "向量检索使用HNSW图",
+		2: "关系数据库的主键",
+		3: "向量数据库",
+		4: "今天天气不错",
+	}
+	for id, text := range docs {
+		b.add(id, tokenize(text))
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		allow func(uint) bool
+		want  []uint
+	}{
+		{"best match first", "向量检索", nil, []uint{1, 3}},
+		{"shared term", "数据库", nil, []uint{3, 2}},
+		{"filtered", "向量检索", func(id uint) bool { return id != 1 }, []uint{3}},
+		{"no match", "区块链", nil, nil},
+	}
+	for _, tt := range tests {
+		var got []uint
+		for _, s := range b.search(tokenize(tt.query), tt.allow, 10) {
+			got = append(got, s.id)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: search(%q) = %v, want %v", tt.name, tt.query, got, tt.want)
+		}
+	}
+
+	// 删除后不再命中, 统计同步更新
+	b.remove(1)
+	if res := b.search(tokenize("检索"), nil, 10); len(res) != 0 {
+		t.Errorf("removed doc still matches: %v", res)
+	}
+	if _, ok := b.postings["检索"]; ok {
+		t.Error("posting list of removed term not cleaned")
+	}
+	total := 0
+	for _, n := range b.lengths {
+		total += n
+	}
+	if b.total != total {
+		t.Errorf("total = %d, want %d", b.total, total)
+	}
+}
+
+func TestTopScored(t *testing.T) {
+	got := topScored(map[uint]float64{3: 0.5, 1: 0.9, 2: 0.5, 4: 0.1}, 3)
+	want := []scored{{1, 0.9}, {2, 0.5}, {3, 0.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("topScored = %v, want %v", got, want)
+	}
+}
+
+// newTestIndex 用哈希向量建立知识索引
+func newTestIndex(t *testing.T, knowledge []Knowledge, contents map[uint][]string) *knowledgeIndex {
+	t.Helper()
+	embedder := model.NewHashEmbedder(256)
+	x := newKnowledgeIndex("hash")
+	var chunkID uint
+	for i := range knowledge {
+		k := &knowledge[i]
+		vectors, err := embedder.Embed(context.Background(), "hash", contents[k.ID])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var chunks []KnowledgeChunk
+		for seq, text := range contents[k.ID] {
+			chunkID++
+			chunks = append(chunks, KnowledgeChunk{ID: chunkID, KnowledgeID: k.ID, AgentID: k.AgentID,
+				Seq: seq, Content: text, Model: "hash", Embedding: vectors[seq]})
+		}
+		x.put(k, chunks)
+	}
+	return x
+}
+
+func TestKnowledgeSearchRRF(t *testing.T) {
+	x := newTestIndex(t, []Knowledge{
+		{ID: 1, AgentID: 1, Title: "检索", Tags: "rag", AccessLevel: 1},
+		{ID: 2, AgentID: 2, Title: "运维", Tags: "ops", AccessLevel: 5},
+	}, map[uint][]string{
+		1: {"混合检索把BM25和向量召回的结果用RRF融合", "分块时相邻块保留重叠"},
+		2: {"数据库每天凌晨备份", "向量索引每五分钟重建"},
+	})
+	qvec := normalize(mustEmbed(t, "BM25和向量召回如何融合"))
+
+	tests := []struct {
+		name      string
+		query     KnowledgeQuery
+		qvec      []float32
+		wantFirst uint // 第一名的分块ID
+		wantLen   int
+	}{
+		{"bm25 only", KnowledgeQuery{Query: "RRF融合", TopK: 5}, nil, 1, 1},
+		{"hybrid", KnowledgeQuery{Query: "BM25和向量召回如何融合", TopK: 2}, qvec, 1, 2},
+		{"agent filter", KnowledgeQuery{Query: "向量", AgentIDs: []uint{2}, TopK: 5}, qvec, 4, 2},
+		{"access level", KnowledgeQuery{Query: "向量", MaxAccessLevel: 1, TopK: 5}, qvec, 1, 2},
+		{"tag filter", KnowledgeQuery{Query: "备份", Tags: []string{" OPS "}, TopK: 5}, nil, 3, 1},
+		{"filter matches nothing", KnowledgeQuery{Query: "向量", Tags: []string{"none"}, TopK: 5}, qvec, 0, 0},
+	}
+	for _, tt := range tests {
+		hits := x.search(tt.query, tt.qvec)
+		if len(hits) != tt.wantLen {
+			t.Errorf("%s: %d hits, want %d: %+v", tt.name, len(hits), tt.wantLen, hits)
+			continue
+		}
+		if tt.wantLen > 0 && hits[0].ChunkID != tt.wantFirst {
+			t.Errorf("%s: first hit = %d, want %d", tt.name, hits[0].ChunkID, tt.wantFirst)
+		}
+	}
+
+	// 两路都排第一的分块得分为两个 1/(k+1) 之和
+	hits := x.search(KnowledgeQuery{Query: "BM25和向量召回如何融合", TopK: 1}, qvec)
+	if len(hits) != 1 || hits[0].BM25Rank != 1 || hits[0].VectorRank != 1 {
+		t.Fatalf("hits = %+v", hits)
+	}
+	if want := 2.0 / float64(rrfK+1); hits[0].Score != want {
+		t.Errorf("score = %v, want %v", hits[0].Score, want)
+	}
+}
+
+func mustEmbed(t *testing.T, text string) []float32 {
+	t.Helper()
+	vectors, err := model.NewHashEmbedder(256).Embed(context.Background(), "hash", []string{text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vectors[0]
+}
@@ -2,11 +2,18 @@ package memory
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"corpflow/internal/store"
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
+	"agent-flow/internal/store"
+	"gorm.io/gorm"
 )
 
 // MemoryType 记忆类型
@@ -16,48 +23,55 @@ const (
 	MemoryTypeAction   MemoryType = "action"   // 执行的动作
 	MemoryTypeDecision MemoryType = "decision" // 做出的决策
 	MemoryTypeResult   MemoryType = "result"   // 执行结果
-	MemoryTypeLearn   MemoryType = "learn"    // 学到的知识
-	MemoryTypeEvent   MemoryType = "event"    // 重要事件
+	MemoryTypeLearn    MemoryType = "learn"    // 学到的知识
+	MemoryTypeEvent    MemoryType = "event"    // 重要事件
 )
 
-// Memory 记忆
-type Memory struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	AgentID     uint           `json:"agent_id" gorm:"index"`       // 智能体ID
-	ParentID    *uint         `json:"parent_id" gorm:"index"`       // 上级智能体ID (nil表示顶级)
-	Type        MemoryType    `json:"type"`                        // 记忆类型
-	Content     string        `json:"content"`                     // 记忆内容
-	Metadata    string        `json:"metadata"`                    // 附加数据 (JSON)
-	Importance  int           `json:"importance"`                  // 重要程度 1-10
-	CreatedAt   time.Time     `json:"created_at"`
-}
-
-// Knowledge 知识 (可被上级查看)
-type Knowledge struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	AgentID     uint           `json:"agent_id" gorm:"index"`       // 智能体ID
-	ParentID    *uint         `json:"parent_id" gorm:"index"`       // 上级智能体ID
-	Title       string        `json:"title"`                       // 知识标题
-	Content     string        `json:"content"`                     // 知识内容
-	Tags        string        `json:"tags"`                        // 标签 (逗号分隔)
-	AccessLevel int           `json:"access_level"`                 // 访问级别 1-10
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
-}
-
-// AgentRelationship 智能体关系
-type AgentRelationship struct {
-	ID           uint      `json:"id" gorm:"primaryKey"`
-	ParentID     uint      `json:"parent_id" gorm:"index"`        // 上级ID
-	ChildID      uint      `json:"child_id" gorm:"index"`         // 下级ID
-	RelationType string    `json:"relation_type"`                // manage/delegate/collaborate
-	CreatedAt    time.Time `json:"created_at"`
-}
+// 关系类型: manage/delegate 构成上下级层级, collaborate 为平级协作
+const (
+	RelationManage      = "manage"
+	RelationDelegate    = "delegate"
+	RelationCollaborate = "collaborate"
+)
+
+const (
+	childrenCacheTTL = time.Hour
+	maxHierarchy     = 64 // 向上查找上级的最大层数
+)
+
+// ErrInvalidRelationship 关系不合法 (自身、环、未知类型)
+var ErrInvalidRelationship = errors.New("invalid relationship")
+
+// 模型定义在 store 中, 以便随 store.NewPostgres 一起迁移
+type (
+	Memory            = store.Memory
+	Knowledge         = store.Knowledge
+	AgentRelationship = store.AgentRelationship
+)
 
 // Service 记忆服务
 type Service struct {
 	db    *store.Postgres
 	redis *store.Redis
+
+	// 知识检索
+	models          *model.Service
+	embedModel      string
+	loadMu          sync.Mutex
+	indexMu         sync.Mutex
+	index           *knowledgeIndex
+	indexLoadedAt   time.Time
+	indexRefreshing bool
+
+	// 文档导入的原始文件
+	blobs *store.BlobStore
+
+	// 各类型记忆的衰减和保留策略, 未设置的类型使用默认策略
+	policyMu sync.RWMutex
+	policies map[MemoryType]MemoryPolicy
+
+	// 记忆整理的系统提示词, 为nil时使用内置模板
+	prompts *prompt.Service
 }
 
 // NewService 创建记忆服务
@@ -70,45 +84,42 @@ func NewService(db *store.Postgres, redis *store.Redis) *Service {
 
 // ========== 记忆管理 ==========
 
-// AddMemory 添加记忆
+// AddMemory 添加记忆, parentID 为nil时使用当前上级, importance 为0时自动评分
 func (s *Service) AddMemory(agentID uint, parentID *uint, memType MemoryType, content string, importance int) (*Memory, error) {
+	if parentID == nil {
+		parentID, _ = s.GetParentID(agentID)
+	}
+	if importance <= 0 {
+		importance = ScoreImportance(memType, content)
+	}
 	memory := &Memory{
 		AgentID:    agentID,
 		ParentID:   parentID,
-		Type:       memType,
+		Type:       string(memType),
 		Content:    content,
 		Importance: importance,
 		CreatedAt:  time.Now(),
 	}
 
-	// 存到数据库
 	if s.db != nil {
-		// TODO: s.db.Create(memory)
-	}
-
-	// 缓存到Redis
-	if s.redis != nil {
-		ctx := context.Background()
-		key := fmt.Sprintf("agent:%d:memories", agentID)
-		if data, err := json.Marshal(memory); err == nil {
-			s.redis.Set(ctx, key, data, 24*time.Hour)
+		if err := s.db.CreateMemory(memory); err != nil {
+			return nil, err
 		}
 	}
-
 	return memory, nil
 }
 
 // AddActionMemory 添加动作记忆
 func (s *Service) AddActionMemory(agentID uint, parentID *uint, action string, result string) error {
 	content := fmt.Sprintf("执行了 %s，结果: %s", action, result)
-	_, err := s.AddMemory(agentID, parentID, MemoryTypeAction, content, 5)
+	_, err := s.AddMemory(agentID, parentID, MemoryTypeAction, content, 0)
 	return err
 }
 
 // AddDecisionMemory 添加决策记忆
 func (s *Service) AddDecisionMemory(agentID uint, parentID *uint, decision string, reason string) error {
 	content := fmt.Sprintf("做出决策: %s，原因: %s", decision, reason)
-	_, err := s.AddMemory(agentID, parentID, MemoryTypeDecision, content, 8)
+	_, err := s.AddMemory(agentID, parentID, MemoryTypeDecision, content, 0)
 	return err
 }
 
@@ -119,25 +130,24 @@ func (s *Service) AddResultMemory(agentID uint, parentID *uint, task string, suc
 		status = "失败"
 	}
 	content := fmt.Sprintf("任务 [%s] %s - %s", task, status, details)
-	importance := 7
-	if !success {
-		importance = 9 // 失败的任务更重要
-	}
-	_, err := s.AddMemory(agentID, parentID, MemoryTypeResult, content, importance)
+	_, err := s.AddMemory(agentID, parentID, MemoryTypeResult, content, 0)
 	return err
 }
 
-// GetMemories 获取记忆
+// GetMemories 获取最近的记忆
 func (s *Service) GetMemories(agentID uint, limit int) ([]Memory, error) {
-	if s.redis != nil {
-		ctx := context.Background()
-		key := fmt.Sprintf("agent:%d:memories", agentID)
-		var memory Memory
-		if err := s.redis.Get(ctx, key, &memory); err == nil {
-			return []Memory{memory}, nil
-		}
+	if s.db == nil {
+		return []Memory{}, nil
+	}
+	return s.db.ListMemories(agentID, time.Time{}, limit)
+}
+
+// DeleteMemory 删除记忆
+func (s *Service) DeleteMemory(agentID, id uint) error {
+	if s.db == nil {
+		return fmt.Errorf("memory store not configured")
 	}
-	return []Memory{}, nil
+	return s.db.DeleteMemory(agentID, id)
 }
 
 // GetSubordinateMemories 获取下属的记忆
@@ -162,8 +172,11 @@ func (s *Service) GetSubordinateMemories(parentID uint) ([]Memory, error) {
 
 // ========== 知识管理 ==========
 
-// AddKnowledge 添加知识
-func (s *Service) AddKnowledge(agentID uint, parentID *uint, title, content, tags string) (*Knowledge, error) {
+// AddKnowledge 添加知识并建立检索索引, parentID 为nil时使用当前上级
+func (s *Service) AddKnowledge(ctx context.Context, agentID uint, parentID *uint, title, content, tags string) (*Knowledge, error) {
+	if parentID == nil {
+		parentID, _ = s.GetParentID(agentID)
+	}
 	knowledge := &Knowledge{
 		AgentID:     agentID,
 		ParentID:    parentID,
@@ -171,152 +184,392 @@ func (s *Service) AddKnowledge(agentID uint, parentID *uint, title, content, tag
 		Content:     content,
 		Tags:        tags,
 		AccessLevel: 5,
+		ContentHash: contentHash(title, content),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	// 存到数据库
 	if s.db != nil {
-		// TODO: s.db.Create(knowledge)
+		if err := s.db.CreateKnowledge(knowledge); err != nil {
+			return nil, err
+		}
+		if err := s.indexKnowledge(ctx, knowledge, DefaultChunkOptions); err != nil {
+			return nil, err
+		}
 	}
-
 	return knowledge, nil
 }
 
 // GetKnowledge 获取知识
 func (s *Service) GetKnowledge(agentID uint) ([]Knowledge, error) {
-	// TODO: 从数据库查询
-	return []Knowledge{}, nil
+	if s.db == nil {
+		return []Knowledge{}, nil
+	}
+	return s.db.ListKnowledge([]uint{agentID})
+}
+
+// UpdateKnowledge 修改知识并重建其分块
+func (s *Service) UpdateKnowledge(ctx context.Context, k *Knowledge) error {
+	if s.db == nil {
+		return fmt.Errorf("memory store not configured")
+	}
+	k.UpdatedAt = time.Now()
+	k.ContentHash = contentHash(k.Title, k.Content)
+	if err := s.db.UpdateKnowledge(k); err != nil {
+		return err
+	}
+	return s.indexKnowledge(ctx, k, DefaultChunkOptions)
+}
+
+// DeleteKnowledge 删除知识及其分块
+func (s *Service) DeleteKnowledge(agentID, id uint) error {
+	if s.db == nil {
+		return fmt.Errorf("memory store not configured")
+	}
+	deleted, err := s.db.DeleteKnowledge(agentID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("knowledge %d not found", id)
+	}
+	if x := s.currentIndex(); x != nil {
+		x.remove(id)
+	}
+	s.knowledgeChanged(context.Background(), strconv.FormatUint(uint64(id), 10))
+	return nil
 }
 
 // GetSharedKnowledge 获取共享知识 (下级贡献的)
 func (s *Service) GetSharedKnowledge(parentID uint) ([]Knowledge, error) {
-	// 获取所有下级的知识
 	subIDs, err := s.GetSubordinateIDs(parentID)
 	if err != nil {
 		return nil, err
 	}
-
-	var allKnowledge []Knowledge
-	for _, subID := range subIDs {
-		knowledge, err := s.GetKnowledge(subID)
-		if err != nil {
-			continue
-		}
-		allKnowledge = append(allKnowledge, knowledge...)
+	if s.db == nil {
+		return []Knowledge{}, nil
 	}
-
-	return allKnowledge, nil
+	return s.db.ListKnowledge(subIDs)
 }
 
-// SearchKnowledge 搜索知识
-func (s *Service) SearchKnowledge(agentID uint, keyword string) ([]Knowledge, error) {
-	// 获取自己的知识 + 下级的知识
-	ownKnowledge, _ := s.GetKnowledge(agentID)
-	sharedKnowledge, _ := s.GetSharedKnowledge(agentID)
+// SearchKnowledge 搜索知识 (自己的 + 直接下级的), 按最相关分块排序, 检索不可用时退化为关键词匹配
+func (s *Service) SearchKnowledge(ctx context.Context, agentID uint, keyword string) ([]Knowledge, error) {
+	keyword = strings.TrimSpace(keyword)
+	if s.db == nil || keyword == "" {
+		return []Knowledge{}, nil
+	}
+	subIDs, err := s.GetSubordinateIDs(agentID)
+	if err != nil {
+		return nil, err
+	}
+	agentIDs := append([]uint{agentID}, subIDs...)
+	hits, err := s.RetrieveKnowledge(ctx, KnowledgeQuery{Query: keyword, AgentIDs: agentIDs, TopK: 40})
+	if err != nil {
+		log.Printf("Knowledge retrieval failed, falling back to keyword search: %v", err)
+		return s.db.SearchKnowledge(agentIDs, keyword, 20)
+	}
 
-	// 合并并搜索
-	allKnowledge := append(ownKnowledge, sharedKnowledge...)
-	
-	var results []Knowledge
-	for _, k := range allKnowledge {
-		if contains(keyword, k.Title) || contains(keyword, k.Content) || contains(keyword, k.Tags) {
-			results = append(results, k)
+	knowledge := []Knowledge{}
+	seen := make(map[uint]bool)
+	for _, hit := range hits {
+		if seen[hit.KnowledgeID] || len(knowledge) == 20 {
+			continue
+		}
+		seen[hit.KnowledgeID] = true
+		k, err := s.db.GetKnowledge(hit.KnowledgeID)
+		if err != nil {
+			continue
 		}
+		knowledge = append(knowledge, *k)
 	}
-
-	return results, nil
+	return knowledge, nil
 }
 
 // ========== 智能体关系管理 ==========
 
-// SetRelationship 设置上下级关系
+// SetRelationship 设置关系; manage/delegate 时 child 只能有一个上级 (已有上级时改为新上级), 且不能形成环
 func (s *Service) SetRelationship(parentID, childID uint, relationType string) error {
+	if s.db == nil {
+		return fmt.Errorf("memory store not configured")
+	}
+	if relationType == "" {
+		relationType = RelationManage
+	}
+	switch relationType {
+	case RelationManage, RelationDelegate, RelationCollaborate:
+	default:
+		return fmt.Errorf("%w: unknown relation type %q", ErrInvalidRelationship, relationType)
+	}
+	if parentID == childID {
+		return fmt.Errorf("%w: agent %d cannot relate to itself", ErrInvalidRelationship, parentID)
+	}
+	for _, id := range []uint{parentID, childID} {
+		if _, err := s.db.GetAgent(id); err != nil {
+			return fmt.Errorf("%w: agent %d not found", ErrInvalidRelationship, id)
+		}
+	}
+
+	hierarchical := relationType != RelationCollaborate
 	rel := &AgentRelationship{
 		ParentID:     parentID,
 		ChildID:      childID,
 		RelationType: relationType,
 		CreatedAt:    time.Now(),
 	}
+	// 环检测和写入在同一事务内持锁进行, 并发设置的关系不会各自通过检测后共同成环
+	var check func(func(uint) (*uint, error)) error
+	if hierarchical {
+		check = func(parentOf func(uint) (*uint, error)) error {
+			return checkCycle(parentOf, parentID, childID)
+		}
+	}
+	oldParent, err := s.db.SaveRelationship(rel, hierarchical, check)
+	if err != nil {
+		return err
+	}
 
-	// TODO: 存到数据库
+	s.invalidateChildren(parentID)
+	if oldParent != nil && *oldParent != parentID {
+		s.invalidateChildren(*oldParent)
+	}
+	return nil
+}
 
-	// 缓存关系
-	if s.redis != nil {
-		ctx := context.Background()
-		key := fmt.Sprintf("agent:%d:children", parentID)
-		// TODO: 添加到Redis Set
-		_ = key
+// checkCycle parent 的上级链中不能出现 child
+func checkCycle(parentOf func(uint) (*uint, error), parentID, childID uint) error {
+	cur := parentID
+	for depth := 0; depth < maxHierarchy; depth++ {
+		up, err := parentOf(cur)
+		if err != nil {
+			return err
+		}
+		if up == nil {
+			return nil
+		}
+		if *up == childID {
+			return fmt.Errorf("%w: agent %d is above agent %d, relationship would create a cycle", ErrInvalidRelationship, childID, parentID)
+		}
+		cur = *up
 	}
+	return fmt.Errorf("%w: hierarchy deeper than %d levels", ErrInvalidRelationship, maxHierarchy)
+}
 
+// RemoveRelationship 删除关系
+func (s *Service) RemoveRelationship(parentID, childID uint) error {
+	if s.db == nil {
+		return fmt.Errorf("memory store not configured")
+	}
+	n, err := s.db.DeleteRelationship(parentID, childID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("relationship %d -> %d not found", parentID, childID)
+	}
+	s.invalidateChildren(parentID)
 	return nil
 }
 
-// GetSubordinateIDs 获取下级ID列表
+// RemoveAgent 删除智能体的所有关系 (智能体被删除时调用), 原下属变为没有上级
+func (s *Service) RemoveAgent(agentID uint) error {
+	if s.db == nil {
+		return nil
+	}
+	rels, err := s.db.DeleteAgentRelationships(agentID)
+	if err != nil {
+		return err
+	}
+	s.invalidateChildren(agentID)
+	for _, rel := range rels {
+		s.invalidateChildren(rel.ParentID)
+	}
+	return nil
+}
+
+func childrenKey(parentID uint) string {
+	return fmt.Sprintf("agent:%d:children", parentID)
+}
+
+func (s *Service) invalidateChildren(parentID uint) {
+	if s.redis != nil {
+		_ = s.redis.Del(context.Background(), childrenKey(parentID))
+	}
+}
+
+// GetChildRelationships 获取直接下级的关系记录 (Redis缓存)
+func (s *Service) GetChildRelationships(parentID uint) ([]AgentRelationship, error) {
+	if s.db == nil {
+		return []AgentRelationship{}, nil
+	}
+	ctx := context.Background()
+	if s.redis != nil {
+		var cached []AgentRelationship
+		if err := s.redis.Get(ctx, childrenKey(parentID), &cached); err == nil {
+			return cached, nil
+		}
+	}
+
+	rels, err := s.db.ListChildRelationships(parentID)
+	if err != nil {
+		return nil, err
+	}
+	if s.redis != nil {
+		_ = s.redis.Set(ctx, childrenKey(parentID), rels, childrenCacheTTL)
+	}
+	return rels, nil
+}
+
+// GetSubordinateIDs 获取下级ID列表 (manage/delegate 关系)
 func (s *Service) GetSubordinateIDs(agentID uint) ([]uint, error) {
-	// TODO: 从数据库/缓存获取
-	return []uint{}, nil
+	rels, err := s.GetChildRelationships(agentID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []uint{}
+	for _, rel := range rels {
+		if rel.RelationType != RelationCollaborate {
+			ids = append(ids, rel.ChildID)
+		}
+	}
+	return ids, nil
 }
 
-// GetParentID 获取上级ID
+// GetParentID 获取上级ID, 没有上级时返回nil
 func (s *Service) GetParentID(agentID uint) (*uint, error) {
-	// TODO: 从数据库查询
-	return nil, nil
+	if s.db == nil {
+		return nil, nil
+	}
+	rel, err := s.db.GetParentRelationship(agentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rel.ParentID, nil
 }
 
 // GetHierarchy 获取完整层级
 func (s *Service) GetHierarchy(agentID uint) (*AgentHierarchy, error) {
 	hierarchy := &AgentHierarchy{
-		AgentID:   agentID,
-		ParentID:  nil,
-		Children:  []uint{},
+		AgentID:        agentID,
+		ParentID:       nil,
+		Children:       []uint{},
 		AllDescendants: []uint{},
 	}
 
 	// 获取上级
-	parentID, _ := s.GetParentID(agentID)
+	parentID, err := s.GetParentID(agentID)
+	if err != nil {
+		return nil, err
+	}
 	hierarchy.ParentID = parentID
 
 	// 获取直接下级
-	children, _ := s.GetSubordinateIDs(agentID)
+	children, err := s.GetSubordinateIDs(agentID)
+	if err != nil {
+		return nil, err
+	}
 	hierarchy.Children = children
 
 	// 递归获取所有下级
-	var getAllDescendants func(id uint) []uint
-	getAllDescendants = func(id uint) []uint {
+	var getAllDescendants func(id uint, depth int) []uint
+	getAllDescendants = func(id uint, depth int) []uint {
 		descendants := []uint{}
+		if depth >= maxHierarchy {
+			return descendants
+		}
 		subs, _ := s.GetSubordinateIDs(id)
 		for _, sub := range subs {
 			descendants = append(descendants, sub)
-			descendants = append(descendants, getAllDescendants(sub)...)
+			descendants = append(descendants, getAllDescendants(sub, depth+1)...)
 		}
 		return descendants
 	}
 
-	hierarchy.AllDescendants = getAllDescendants(agentID)
+	hierarchy.AllDescendants = getAllDescendants(agentID, 0)
 
 	return hierarchy, nil
 }
 
 // AgentHierarchy 智能体层级结构
 type AgentHierarchy struct {
-	AgentID        uint    `json:"agent_id"`
-	ParentID       *uint   `json:"parent_id"`
-	Children       []uint  `json:"children"`
-	AllDescendants []uint  `json:"all_descendants"`
+	AgentID        uint   `json:"agent_id"`
+	ParentID       *uint  `json:"parent_id"`
+	Children       []uint `json:"children"`
+	AllDescendants []uint `json:"all_descendants"`
+}
+
+// OrgChartNode 组织架构图中的一个智能体
+type OrgChartNode struct {
+	AgentID      uint            `json:"agent_id"`
+	RelationType string          `json:"relation_type,omitempty"` // 与上级的关系
+	Children     []*OrgChartNode `json:"children,omitempty"`
+}
+
+// GetOrgChart 按 manage/delegate 关系构建完整的组织架构 (没有上级的智能体为根)
+func (s *Service) GetOrgChart() ([]*OrgChartNode, error) {
+	if s.db == nil {
+		return []*OrgChartNode{}, nil
+	}
+	rels, err := s.db.ListRelationships()
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[uint][]AgentRelationship{}
+	hasParent := map[uint]bool{}
+	var order []uint
+	seen := map[uint]bool{}
+	for _, rel := range rels {
+		if rel.RelationType == RelationCollaborate {
+			continue
+		}
+		children[rel.ParentID] = append(children[rel.ParentID], rel)
+		hasParent[rel.ChildID] = true
+		if !seen[rel.ParentID] {
+			seen[rel.ParentID] = true
+			order = append(order, rel.ParentID)
+		}
+	}
+
+	var build func(id uint, relType string, depth int) *OrgChartNode
+	build = func(id uint, relType string, depth int) *OrgChartNode {
+		node := &OrgChartNode{AgentID: id, RelationType: relType}
+		if depth >= maxHierarchy {
+			return node
+		}
+		for _, rel := range children[id] {
+			node.Children = append(node.Children, build(rel.ChildID, rel.RelationType, depth+1))
+		}
+		return node
+	}
+
+	roots := []*OrgChartNode{}
+	for _, id := range order {
+		if !hasParent[id] {
+			roots = append(roots, build(id, "", 0))
+		}
+	}
+	return roots, nil
 }
 
 // ========== 报告生成 ==========
 
-// GenerateReport 生成下级工作报告
+// GenerateReport 生成下级工作报告, period: day/week/month 或 Go duration (如 72h), 为空时统计全部
 func (s *Service) GenerateReport(parentID uint, period string) (*Report, error) {
 	report := &Report{
-		ParentID:    parentID,
-		Period:      period,
-		Summary:     "",
+		ParentID:     parentID,
+		Period:       period,
+		Summary:      "",
 		Subordinates: []SubordinateReport{},
 	}
 
+	since, err := periodStart(period)
+	if err != nil {
+		return nil, err
+	}
+
 	// 获取所有下级
 	subIDs, err := s.GetSubordinateIDs(parentID)
 	if err != nil {
@@ -324,9 +577,13 @@ func (s *Service) GenerateReport(parentID uint, period string) (*Report, error)
 	}
 
 	// 统计每个下级
+	totalResults := 0
 	for _, subID := range subIDs {
-		memories, _ := s.GetMemories(subID, 100)
-		
+		var memories []Memory
+		if s.db != nil {
+			memories, _ = s.db.ListMemories(subID, since, 100)
+		}
+
 		subReport := SubordinateReport{
 			AgentID:      subID,
 			TotalActions: 0,
@@ -335,7 +592,7 @@ func (s *Service) GenerateReport(parentID uint, period string) (*Report, error)
 		}
 
 		for _, mem := range memories {
-			switch mem.Type {
+			switch MemoryType(mem.Type) {
 			case MemoryTypeAction:
 				subReport.TotalActions++
 			case MemoryTypeDecision:
@@ -344,54 +601,48 @@ func (s *Service) GenerateReport(parentID uint, period string) (*Report, error)
 				subReport.Results = append(subReport.Results, mem.Content)
 			}
 		}
+		totalResults += len(subReport.Results)
 
 		report.Subordinates = append(report.Subordinates, subReport)
 	}
 
 	// 生成摘要
-	report.Summary = fmt.Sprintf("共 %d 个下属，完成了 %d 个任务", len(subIDs), len(report.Subordinates))
+	report.Summary = fmt.Sprintf("共 %d 个下属，完成了 %d 个任务", len(subIDs), totalResults)
 
 	return report, nil
 }
 
+func periodStart(period string) (time.Time, error) {
+	now := time.Now()
+	switch period {
+	case "":
+		return time.Time{}, nil
+	case "day":
+		return now.AddDate(0, 0, -1), nil
+	case "week":
+		return now.AddDate(0, 0, -7), nil
+	case "month":
+		return now.AddDate(0, -1, 0), nil
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid period %q", period)
+	}
+	return now.Add(-d), nil
+}
+
 // Report 工作报告
 type Report struct {
-	ParentID      uint               `json:"parent_id"`
-	Period        string             `json:"period"`
-	Summary       string             `json:"summary"`
-	Subordinates  []SubordinateReport `json:"subordinates"`
+	ParentID     uint                `json:"parent_id"`
+	Period       string              `json:"period"`
+	Summary      string              `json:"summary"`
+	Subordinates []SubordinateReport `json:"subordinates"`
 }
 
 // SubordinateReport 下属报告
 type SubordinateReport struct {
-	AgentID       uint     `json:"agent_id"`
-	TotalActions  int      `json:"total_actions"`
-	Decisions     []string `json:"decisions"`
-	Results       []string `json:"results"`
-}
-
-// ========== 工具函数 ==========
-
-func contains(keyword, text string) bool {
-	return len(keyword) > 0 && len(text) > 0 && 
-		   (len(keyword) <= len(text) && 
-		    (len(text) >= len(keyword) && 
-		     (text[:min(len(keyword), len(text))] == keyword || 
-		      findSubstring(text, keyword)))
-}
-
-func findSubstring(text, keyword string) bool {
-	for i := 0; i <= len(text)-len(keyword); i++ {
-		if text[i:i+len(keyword)] == keyword {
-			return true
-		}
-	}
-	return false
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+	AgentID      uint     `json:"agent_id"`
+	TotalActions int      `json:"total_actions"`
+	Decisions    []string `json:"decisions"`
+	Results      []string `json:"results"`
 }
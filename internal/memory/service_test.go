@@ -0,0 +1,23 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckCycle(t *testing.T) {
+	// 1 <- 2 <- 3 (3 的上级是 2, 2 的上级是 1)
+	parents := map[uint]uint{2: 1, 3: 2}
+	parentOf := func(id uint) (*uint, error) {
+		if p, ok := parents[id]; ok {
+			return &p, nil
+		}
+		return nil, nil
+	}
+	if err := checkCycle(parentOf, 3, 4); err != nil {
+		t.Fatalf("3 -> 4: %v", err)
+	}
+	if err := checkCycle(parentOf, 3, 1); !errors.Is(err, ErrInvalidRelationship) {
+		t.Fatalf("3 -> 1 err = %v, want cycle", err)
+	}
+}
@@ -0,0 +1,388 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-flow/internal/store"
+)
+
+// CacheMode 缓存匹配模式
+type CacheMode string
+
+const (
+	CacheModeExact    CacheMode = "exact"    // 规范化请求完全一致
+	CacheModeSemantic CacheMode = "semantic" // 最后一条用户消息语义相似
+)
+
+// CachePolicy 缓存策略 (可按智能体配置, 也可随请求传入)
+type CachePolicy struct {
+	Enabled   bool      `json:"enabled"`
+	Force     bool      `json:"force,omitempty"`                      // temperature > 0 时也缓存
+	TTL       int       `json:"ttl_seconds,omitempty" min:"0"`        // 过期时间, 默认1小时
+	Mode      CacheMode `json:"mode,omitempty" enum:"exact,semantic"` // 默认 exact
+	Threshold float64   `json:"threshold,omitempty" min:"0" max:"1"`  // 语义模式相似度阈值, 默认0.95
+}
+
+// EmbedFunc 本地向量化函数 (语义缓存使用)
+type EmbedFunc func(text string) []float32
+
+// cacheEntry 缓存内容
+type cacheEntry struct {
+	Response  Response  `json:"response"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// semanticEntry 语义索引项
+type semanticEntry struct {
+	key       string
+	vector    []float32
+	expiresAt time.Time
+}
+
+// maxCacheEntries 内存中响应缓存和语义索引各自的条数上限 (与向量缓存一致)
+const maxCacheEntries = 10000
+
+// ResponseCache 模型响应缓存
+type ResponseCache struct {
+	mu           sync.RWMutex
+	redis        *store.Redis
+	local        map[string]cacheLocalItem
+	agents       map[string]CachePolicy // agentID -> 策略
+	semantic     map[string][]semanticEntry
+	semanticSize int // 各作用域语义索引项的合计条数
+	maxEntries   int
+	embed        EmbedFunc
+	vectors      map[string]vectorItem // 内存模式下的向量缓存
+}
+
+type vectorItem struct {
+	vector    []float32
+	expiresAt time.Time
+}
+
+type cacheLocalItem struct {
+	entry     cacheEntry
+	expiresAt time.Time
+}
+
+// NewResponseCache 创建缓存 (未接入Redis时使用内存)
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{
+		local:      make(map[string]cacheLocalItem),
+		agents:     make(map[string]CachePolicy),
+		semantic:   make(map[string][]semanticEntry),
+		maxEntries: maxCacheEntries,
+		embed:      hashEmbed,
+		vectors:    make(map[string]vectorItem),
+	}
+}
+
+// UseRedis 使用Redis存储缓存内容
+func (c *ResponseCache) UseRedis(redis *store.Redis) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.redis = redis
+}
+
+// SetEmbedFunc 替换语义模式的向量化函数
+func (c *ResponseCache) SetEmbedFunc(fn EmbedFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.embed = fn
+}
+
+// SetAgentPolicy 设置智能体的缓存策略
+func (c *ResponseCache) SetAgentPolicy(agentID string, policy CachePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.agents[agentID] = policy
+}
+
+// policyFor 计算请求生效的缓存策略 (请求级优先于智能体级);
+// req.Temperature 须为实际温度 (已填入模型默认值), 大于0时结果不确定, 除非 Force 否则不缓存
+func (c *ResponseCache) policyFor(req Request) (CachePolicy, bool) {
+	policy := CachePolicy{}
+	if req.Cache != nil {
+		policy = *req.Cache
+	} else if req.AgentID != "" {
+		c.mu.RLock()
+		policy = c.agents[req.AgentID]
+		c.mu.RUnlock()
+	}
+
+	if !policy.Enabled {
+		return policy, false
+	}
+	if req.Temperature != nil && *req.Temperature > 0 && !policy.Force {
+		return policy, false
+	}
+	if policy.TTL <= 0 {
+		policy.TTL = 3600
+	}
+	if policy.Mode == "" {
+		policy.Mode = CacheModeExact
+	}
+	if policy.Threshold <= 0 {
+		policy.Threshold = 0.95
+	}
+	return policy, true
+}
+
+// cacheKey 规范化请求后计算缓存键
+func cacheKey(req Request) string {
+	normalized := struct {
+		Model       string           `json:"model"`
+		TaskType    string           `json:"task_type"`
+		Messages    []Message        `json:"messages"`
+		Temperature *float64         `json:"temperature"`
+		Tools       []ToolDefinition `json:"tools"`
+		Format      *ResponseFormat  `json:"format"`
+	}{
+		Model:    req.Model,
+		TaskType: req.TaskType,
+		Tools:    req.Tools,
+		Format:   req.ResponseFormat,
+	}
+	if req.Temperature != nil {
+		normalized.Temperature = Temperature(math.Round(*req.Temperature*100) / 100)
+	}
+	for _, m := range req.Messages {
+		normalized.Messages = append(normalized.Messages, Message{
+			Role:    strings.ToLower(strings.TrimSpace(m.Role)),
+			Content: strings.Join(strings.Fields(m.Content), " "),
+			Parts:   fingerprintParts(m.Parts),
+		})
+	}
+
+	data, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(data)
+	return "model:cache:" + hex.EncodeToString(sum[:])
+}
+
+// fingerprintParts 多模态片段只保留摘要参与缓存键
+func fingerprintParts(parts []ContentPart) []ContentPart {
+	if len(parts) == 0 {
+		return nil
+	}
+	result := make([]ContentPart, len(parts))
+	for i, p := range parts {
+		result[i] = partFingerprint(p)
+	}
+	return result
+}
+
+// semanticScope 语义匹配的范围: 除最后一条用户消息外其余内容相同
+func semanticScope(req Request) (string, string) {
+	last := ""
+	scoped := req
+	scoped.Messages = nil
+	for i, m := range req.Messages {
+		if i == len(req.Messages)-1 && m.Role == "user" {
+			last = m.Content
+			continue
+		}
+		scoped.Messages = append(scoped.Messages, m)
+	}
+	return cacheKey(scoped), last
+}
+
+// Lookup 查询缓存
+func (c *ResponseCache) Lookup(ctx context.Context, req Request) (*Response, bool) {
+	policy, ok := c.policyFor(req)
+	if !ok {
+		return nil, false
+	}
+
+	key := cacheKey(req)
+	similarity := 1.0
+	entry, found := c.get(ctx, key)
+
+	if !found && policy.Mode == CacheModeSemantic {
+		key, similarity = c.nearest(req, policy.Threshold)
+		if key != "" {
+			entry, found = c.get(ctx, key)
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	resp := entry.Response
+	resp.Cached = true
+	resp.CacheKey = key
+	resp.Similarity = similarity
+	log.Printf("[ModelCache] hit model=%s agent=%s mode=%s similarity=%.3f key=%s", req.Model, req.AgentID, policy.Mode, similarity, key)
+	return &resp, true
+}
+
+// Store 写入缓存
+func (c *ResponseCache) Store(ctx context.Context, req Request, resp *Response) {
+	policy, ok := c.policyFor(req)
+	if !ok || resp == nil {
+		return
+	}
+
+	key := cacheKey(req)
+	ttl := time.Duration(policy.TTL) * time.Second
+	entry := cacheEntry{Response: *resp, CreatedAt: time.Now()}
+
+	c.mu.Lock()
+	redis := c.redis
+	if redis == nil {
+		if _, exists := c.local[key]; !exists && len(c.local) >= c.maxEntries {
+			c.evictLocked()
+		}
+		c.local[key] = cacheLocalItem{entry: entry, expiresAt: time.Now().Add(ttl)}
+	}
+	if policy.Mode == CacheModeSemantic {
+		scope, text := semanticScope(req)
+		if text != "" && c.embed != nil {
+			if c.semanticSize >= c.maxEntries {
+				c.evictLocked()
+			}
+			c.semantic[scope] = append(c.pruneSemantic(scope), semanticEntry{
+				key:       key,
+				vector:    c.embed(text),
+				expiresAt: time.Now().Add(ttl),
+			})
+			c.semanticSize++
+		}
+	}
+	c.mu.Unlock()
+
+	if redis != nil {
+		if err := redis.Set(ctx, key, entry, ttl); err != nil {
+			log.Printf("[ModelCache] store failed: %v", err)
+			return
+		}
+	}
+	log.Printf("[ModelCache] store model=%s agent=%s mode=%s key=%s", req.Model, req.AgentID, policy.Mode, key)
+}
+
+// get 读取缓存内容
+func (c *ResponseCache) get(ctx context.Context, key string) (cacheEntry, bool) {
+	c.mu.RLock()
+	redis := c.redis
+	item, ok := c.local[key]
+	c.mu.RUnlock()
+
+	if redis != nil {
+		var entry cacheEntry
+		if err := redis.Get(ctx, key, &entry); err != nil {
+			return cacheEntry{}, false
+		}
+		return entry, true
+	}
+	if !ok || time.Now().After(item.expiresAt) {
+		return cacheEntry{}, false
+	}
+	return item.entry, true
+}
+
+// nearest 在语义索引中查找最相似的缓存项
+func (c *ResponseCache) nearest(req Request, threshold float64) (string, float64) {
+	scope, text := semanticScope(req)
+	if text == "" {
+		return "", 0
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.embed == nil {
+		return "", 0
+	}
+
+	query := c.embed(text)
+	bestKey, bestScore := "", 0.0
+	for _, e := range c.semantic[scope] {
+		if time.Now().After(e.expiresAt) {
+			continue
+		}
+		if score := Cosine(query, e.vector); score >= threshold && score > bestScore {
+			bestKey, bestScore = e.key, score
+		}
+	}
+	return bestKey, bestScore
+}
+
+// pruneSemantic 清理过期的语义索引项 (调用方持有锁)
+func (c *ResponseCache) pruneSemantic(scope string) []semanticEntry {
+	entries := c.semantic[scope][:0]
+	for _, e := range c.semantic[scope] {
+		if time.Now().Before(e.expiresAt) {
+			entries = append(entries, e)
+		}
+	}
+	c.semanticSize -= len(c.semantic[scope]) - len(entries)
+	return entries
+}
+
+// evictLocked 达到条数上限时先清理全部过期项, 仍然超出时随机淘汰 (调用方持有锁)
+func (c *ResponseCache) evictLocked() {
+	now := time.Now()
+	for key, item := range c.local {
+		if now.After(item.expiresAt) {
+			delete(c.local, key)
+		}
+	}
+	for scope := range c.semantic {
+		if entries := c.pruneSemantic(scope); len(entries) > 0 {
+			c.semantic[scope] = entries
+		} else {
+			delete(c.semantic, scope)
+		}
+	}
+
+	// map 遍历顺序随机, 淘汰到上限的90%, 避免每次写入都触发
+	target := c.maxEntries * 9 / 10
+	for key := range c.local {
+		if len(c.local) <= target {
+			break
+		}
+		delete(c.local, key)
+	}
+	for scope, entries := range c.semantic {
+		if c.semanticSize <= target {
+			break
+		}
+		c.semanticSize -= len(entries)
+		delete(c.semantic, scope)
+	}
+}
+
+// Cache 获取响应缓存
+func (s *Service) Cache() *ResponseCache {
+	return s.cache
+}
+
+// ========== 工具函数 ==========
+
+// hashEmbed 离线哈希向量 (与 HashEmbedder 相同算法, 适合中文)
+func hashEmbed(text string) []float32 {
+	return hashVector(text, 256)
+}
+
+// Cosine 余弦相似度
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newOllamaService 只接入模拟 Ollama 的服务, chats 统计 /api/chat 调用次数; models 为空时只有 bot
+func newOllamaService(t *testing.T, handler http.HandlerFunc, models ...string) (*Service, *int64) {
+	t.Helper()
+	if len(models) == 0 {
+		models = []string{"bot"}
+	}
+	var tags []map[string]string
+	for _, name := range models {
+		tags = append(tags, map[string]string{"name": name})
+	}
+	var chats int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": tags})
+		case "/api/show":
+			json.NewEncoder(w).Encode(map[string]interface{}{})
+		case "/api/chat":
+			atomic.AddInt64(&chats, 1)
+			handler(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, key := range []string{"OPENAI_API_KEY", "ANTHROPIC_API_KEY", "ZHIPU_API_KEY", "MINIMAX_API_KEY",
+		"KIMI_API_KEY", "DASHSCOPE_API_KEY", "DEEPSEEK_API_KEY", "CUSTOM_OPENAI_BASE_URL"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("OLLAMA_BASE_URL", srv.URL)
+	svc := NewService()
+	if _, err := svc.DiscoverModels(context.Background(), ProviderOllama); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	return svc, &chats
+}
+
+func replyWith(content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":       "bot",
+			"message":     map[string]string{"role": "assistant", "content": content},
+			"done_reason": "stop",
+		})
+	}
+}
+
+func TestCacheUsesEffectiveTemperature(t *testing.T) {
+	svc, chats := newOllamaService(t, replyWith("ok"))
+	ctx := context.Background()
+	cache := &CachePolicy{Enabled: true}
+
+	tests := []struct {
+		name        string
+		temperature *float64
+		wantChats   int64
+	}{
+		// 未指定温度时按模型默认的0.7调用, 结果不确定, 不应缓存
+		{"model default", nil, 2},
+		{"explicit", Temperature(0.5), 2},
+		// 显式的0是确定性调用, 第二次命中缓存
+		{"explicit zero", Temperature(0), 1},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt64(chats, 0)
+		req := Request{
+			Model:       "ollama/bot",
+			Messages:    []Message{{Role: "user", Content: tt.name}},
+			Temperature: tt.temperature,
+			Cache:       cache,
+		}
+		for i := 0; i < 2; i++ {
+			resp, err := svc.Complete(ctx, req)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if wantCached := i > 0 && tt.wantChats == 1; resp.Cached != wantCached {
+				t.Fatalf("%s: call %d cached = %v, want %v", tt.name, i, resp.Cached, wantCached)
+			}
+		}
+		if got := atomic.LoadInt64(chats); got != tt.wantChats {
+			t.Errorf("%s: chat calls = %d, want %d", tt.name, got, tt.wantChats)
+		}
+	}
+
+	// Force 时按实际温度缓存
+	atomic.StoreInt64(chats, 0)
+	req := Request{
+		Model:    "ollama/bot",
+		Messages: []Message{{Role: "user", Content: "forced"}},
+		Cache:    &CachePolicy{Enabled: true, Force: true},
+	}
+	svc.Complete(ctx, req)
+	resp, err := svc.Complete(ctx, req)
+	if err != nil || !resp.Cached || atomic.LoadInt64(chats) != 1 {
+		t.Fatalf("forced cache: resp=%+v err=%v chats=%d", resp, err, atomic.LoadInt64(chats))
+	}
+}
+
+func TestCacheFallbackUsesPreferredTemperature(t *testing.T) {
+	// primary 总是失败, 回退到 backup
+	svc, chats := newOllamaService(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "primary" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		replyWith("ok")(w, r)
+	}, "primary", "backup")
+	svc.Router().SetPolicy(&RoutingPolicy{
+		Name:       "fallback",
+		TaskType:   "fallback",
+		Chain:      []string{"ollama/primary", "ollama/backup"},
+		FallbackOn: []FailureKind{FailureServerError},
+	})
+	setTemperature := func(name string, temperature float64) {
+		cfg, _ := svc.GetModel(name)
+		cfg.Temperature = temperature
+		svc.SetModel(name, cfg)
+	}
+	setTemperature("ollama/primary", 0)
+	setTemperature("ollama/backup", 0.7)
+
+	ctx := context.Background()
+	complete := func() *Response {
+		t.Helper()
+		resp, err := svc.Complete(ctx, Request{
+			TaskType: "fallback",
+			Messages: []Message{{Role: "user", Content: "hi"}},
+			Cache:    &CachePolicy{Enabled: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// 回退模型温度不同, 结果不按首选模型的缓存键写入
+	complete()
+	if resp := complete(); resp.Cached {
+		t.Fatal("cached a response generated at the fallback model's temperature")
+	}
+
+	// 温度一致时回退结果可以命中
+	setTemperature("ollama/backup", 0)
+	complete()
+	before := atomic.LoadInt64(chats)
+	if resp := complete(); !resp.Cached {
+		t.Fatalf("fallback response not served from cache (chats %d)", atomic.LoadInt64(chats))
+	}
+	if atomic.LoadInt64(chats) != before {
+		t.Fatal("cache hit still called the model")
+	}
+}
+
+func TestResponseCacheBounded(t *testing.T) {
+	c := NewResponseCache()
+	c.maxEntries = 10
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		req := Request{
+			Model:    "m",
+			Messages: []Message{{Role: "user", Content: fmt.Sprintf("问题 %d", i)}},
+			Cache:    &CachePolicy{Enabled: true, Mode: CacheModeSemantic},
+		}
+		c.Store(ctx, req, &Response{Content: "ok"})
+	}
+	if len(c.local) > 10 {
+		t.Errorf("local entries = %d, want at most 10", len(c.local))
+	}
+	total := 0
+	for _, entries := range c.semantic {
+		total += len(entries)
+	}
+	if total != c.semanticSize || total > 10 {
+		t.Errorf("semantic entries = %d (tracked %d), want at most 10", total, c.semanticSize)
+	}
+}
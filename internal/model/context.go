@@ -0,0 +1,304 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"agent-flow/internal/prompt"
+)
+
+// ContextStrategy 超出上下文窗口时的截断策略
+type ContextStrategy string
+
+const (
+	ContextNone       ContextStrategy = "none"        // 不截断, 超出时交给路由回退到更大窗口的模型
+	ContextDropOldest ContextStrategy = "drop_oldest" // 从最早的消息开始丢弃
+	ContextKeepLast   ContextStrategy = "keep_last"   // 只保留system和最近N条
+	ContextSummarize  ContextStrategy = "summarize"   // 用便宜模型把较早的对话压缩成摘要
+)
+
+// ContextPolicy 上下文管理策略 (可按智能体配置, 也可随请求传入)
+// 所有策略都保留system消息和标记为Pinned的消息
+type ContextPolicy struct {
+	Strategy      ContextStrategy `json:"strategy" enum:"none,drop_oldest,keep_last,summarize"`
+	KeepLast      int             `json:"keep_last,omitempty" min:"0"`      // keep_last 保留的最近消息数, 默认10
+	SummaryModel  string          `json:"summary_model,omitempty"`          // summarize 使用的模型, 为空时选最便宜的可用模型
+	ReserveTokens int             `json:"reserve_tokens,omitempty" min:"0"` // 额外预留的token (如工具定义), 默认256
+}
+
+// defaultContextWindows 内置模型的上下文窗口 (token)
+var defaultContextWindows = map[string]int{
+	"gpt-4": 8192, "gpt-3.5-turbo": 16385,
+	"claude-3-opus": 200000, "claude-3-sonnet": 200000,
+	"glm-4": 128000, "glm-4-plus": 128000, "glm-4-flash": 128000, "glm-3-turbo": 128000,
+	"glm-5": 128000, "glm-4v-plus": 8192,
+	"abab6.5s-chat": 245760, "MiniMax-M2.5": 204800, "abab6.5g-chat": 8192,
+	"moonshot-v1-8k-chat": 8192, "moonshot-v1-32k-chat": 32768,
+	"kimi-k2.5": 262144, "kimi-coding-k2p5": 262144,
+	"qwen-turbo": 131072, "qwen-plus": 131072, "qwen-max": 32768,
+	"deepseek-chat": 65536, "deepseek-coder": 65536,
+}
+
+// SetAgentContextPolicy 设置智能体的上下文策略
+func (s *Service) SetAgentContextPolicy(agentID string, policy ContextPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contextPolicies[agentID] = policy
+}
+
+// AgentContextPolicies 列出已配置的智能体上下文策略
+func (s *Service) AgentContextPolicies() map[string]ContextPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string]ContextPolicy, len(s.contextPolicies))
+	for id, p := range s.contextPolicies {
+		result[id] = p
+	}
+	return result
+}
+
+// contextPolicyFor 计算请求生效的上下文策略 (请求级优先于智能体级, 默认 drop_oldest)
+func (s *Service) contextPolicyFor(req Request) ContextPolicy {
+	policy := ContextPolicy{Strategy: ContextDropOldest}
+	if req.Context != nil {
+		policy = *req.Context
+	} else if req.AgentID != "" {
+		s.mu.RLock()
+		if p, ok := s.contextPolicies[req.AgentID]; ok {
+			policy = p
+		}
+		s.mu.RUnlock()
+	}
+
+	if policy.Strategy == "" {
+		policy.Strategy = ContextDropOldest
+	}
+	if policy.KeepLast <= 0 {
+		policy.KeepLast = 10
+	}
+	if policy.ReserveTokens <= 0 {
+		policy.ReserveTokens = 256
+	}
+	return policy
+}
+
+// fitContext 按模型上下文窗口裁剪消息, 窗口未知时原样返回
+func (s *Service) fitContext(ctx context.Context, cfg *ModelConfig, req Request) ([]Message, error) {
+	if cfg.ContextWindow <= 0 {
+		return req.Messages, nil
+	}
+
+	policy := s.contextPolicyFor(req)
+	budget := cfg.ContextWindow - req.MaxTokens - policy.ReserveTokens
+	if budget <= 0 {
+		// 输出上限占满了窗口, 至少留出一半给输入
+		budget = cfg.ContextWindow / 2
+	}
+
+	total := CountMessagesTokens(req.Messages)
+	if total <= budget && policy.Strategy != ContextKeepLast {
+		return req.Messages, nil
+	}
+
+	var messages []Message
+	switch policy.Strategy {
+	case ContextNone:
+		messages = req.Messages
+	case ContextKeepLast:
+		messages = keepLast(req.Messages, policy.KeepLast)
+		if CountMessagesTokens(messages) > budget {
+			messages = dropOldest(messages, budget)
+		}
+	case ContextSummarize:
+		messages = s.summarizeOldest(ctx, cfg, req.Messages, budget, policy)
+	default:
+		messages = dropOldest(req.Messages, budget)
+	}
+
+	if used := CountMessagesTokens(messages); used > budget {
+		return nil, &unavailableError{fmt.Sprintf("context too long for %s: %d tokens, window allows %d", cfg.ModelName, used, budget)}
+	}
+	if len(messages) != len(req.Messages) {
+		log.Printf("[ModelContext] model=%s agent=%s strategy=%s messages %d->%d tokens %d->%d",
+			cfg.ModelName, req.AgentID, policy.Strategy, len(req.Messages), len(messages), total, CountMessagesTokens(messages))
+	}
+	return messages, nil
+}
+
+// isKept 截断时必须保留的消息
+func isKept(m Message) bool {
+	return m.Role == "system" || m.Pinned
+}
+
+// dropOldest 从最早的非保留消息开始丢弃, 直到不超过预算 (最后一条消息始终保留)
+func dropOldest(messages []Message, budget int) []Message {
+	drop := oldestToDrop(messages, budget)
+	result := make([]Message, 0, len(messages))
+	for i, m := range messages {
+		if !drop[i] {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// oldestToDrop 标记需要丢弃的消息
+func oldestToDrop(messages []Message, budget int) []bool {
+	drop := make([]bool, len(messages))
+	total := CountMessagesTokens(messages)
+	for i := 0; i < len(messages)-1 && total > budget; i++ {
+		if isKept(messages[i]) {
+			continue
+		}
+		drop[i] = true
+		total -= CountMessageTokens(messages[i])
+	}
+	return drop
+}
+
+// keepLast 保留system/置顶消息和最近n条其他消息
+func keepLast(messages []Message, n int) []Message {
+	keep := make([]bool, len(messages))
+	count := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if isKept(messages[i]) {
+			keep[i] = true
+		} else if count < n {
+			keep[i] = true
+			count++
+		}
+	}
+
+	result := make([]Message, 0, len(messages))
+	for i, m := range messages {
+		if keep[i] {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// summarizeOldest 把会被丢弃的较早消息压缩成一条摘要, 失败时退化为 drop_oldest
+func (s *Service) summarizeOldest(ctx context.Context, cfg *ModelConfig, messages []Message, budget int, policy ContextPolicy) []Message {
+	drop := oldestToDrop(messages, budget*3/4) // 给摘要留出1/4预算
+	var older []Message
+	for i, m := range messages {
+		if drop[i] {
+			older = append(older, m)
+		}
+	}
+	if len(older) == 0 {
+		return dropOldest(messages, budget)
+	}
+
+	summaryModel := policy.SummaryModel
+	if summaryModel == "" {
+		summaryModel = s.CheapestModel()
+	}
+	if summaryModel == "" {
+		return dropOldest(messages, budget)
+	}
+
+	var transcript strings.Builder
+	for _, m := range older {
+		fmt.Fprintf(&transcript, "%s: %s\n", m.Role, m.Text())
+	}
+
+	resp, err := s.Complete(ctx, Request{
+		Model: summaryModel,
+		Messages: []Message{
+			{Role: "system", Content: s.renderPrompt(prompt.KeyContextSummary)},
+			{Role: "user", Content: transcript.String()},
+		},
+		MaxTokens: budget / 4,
+		Context:   &ContextPolicy{Strategy: ContextDropOldest},
+	})
+	if err != nil {
+		log.Printf("[ModelContext] summarize with %s failed, falling back to drop_oldest: %v", summaryModel, err)
+		return dropOldest(messages, budget)
+	}
+
+	summary := Message{Role: "system", Content: "以下是之前对话的摘要:\n" + resp.Content}
+	result := make([]Message, 0, len(messages)-len(older)+1)
+	inserted := false
+	for i, m := range messages {
+		if drop[i] {
+			continue
+		}
+		// 摘要放在开头的system消息之后
+		if !inserted && m.Role != "system" {
+			result = append(result, summary)
+			inserted = true
+		}
+		result = append(result, m)
+	}
+	if !inserted {
+		result = append(result, summary)
+	}
+	return dropOldest(result, budget)
+}
+
+// CheapestModel 单价最低的可用模型 (摘要等辅助任务使用)
+func (s *Service) CheapestModel() string {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.models))
+	for name := range s.models {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	best := ""
+	for _, name := range names {
+		if !s.IsModelAvailable(name) {
+			continue
+		}
+		if best == "" || s.modelCost(name) < s.modelCost(best) {
+			best = name
+		}
+	}
+	return best
+}
+
+// ========== token估算 ==========
+
+// CountTokens 近似估算文本token数: 中日韩字符约1个token, 其余按约4个字符1个token
+func CountTokens(text string) int {
+	tokens := 0
+	other := 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			tokens++
+		default:
+			other++
+		}
+	}
+	return tokens + (other+3)/4
+}
+
+// CountMessageTokens 估算单条消息的token数 (含角色等固定开销)
+func CountMessageTokens(m Message) int {
+	tokens := 4 + CountTokens(m.Content)
+	for _, p := range m.Parts {
+		tokens += CountTokens(p.Text)
+		// 每张图片按约1000 token估算
+		if p.Type == PartImage {
+			tokens += 1000
+		}
+	}
+	return tokens
+}
+
+// CountMessagesTokens 估算消息列表的token数
+func CountMessagesTokens(messages []Message) int {
+	tokens := 3
+	for _, m := range messages {
+		tokens += CountMessageTokens(m)
+	}
+	return tokens
+}
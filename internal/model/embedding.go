@@ -0,0 +1,396 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProviderHash 本地哈希向量 (无需网络, 结果确定, 用于测试和离线环境)
+const ProviderHash ModelProvider = "hash"
+
+// EmbeddingClient 支持向量化的客户端
+type EmbeddingClient interface {
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+}
+
+// EmbeddingModelConfig 向量模型配置
+type EmbeddingModelConfig struct {
+	Provider   ModelProvider `json:"provider"`
+	ModelName  string        `json:"model_name"`
+	Dimensions int           `json:"dimensions"` // 向量维度, 0表示首次调用后确定
+	MaxBatch   int           `json:"max_batch"`  // 单次请求最多文本数
+}
+
+// EmbedResponse 向量化结果
+type EmbedResponse struct {
+	Model      string      `json:"model"`
+	Dimensions int         `json:"dimensions"`
+	Vectors    [][]float32 `json:"vectors"` // 与输入顺序一致
+	Cached     int         `json:"cached"`  // 命中缓存的条数
+}
+
+// EmbeddingModelInfo 向量模型信息
+type EmbeddingModelInfo struct {
+	Name       string        `json:"name"`
+	Provider   ModelProvider `json:"provider"`
+	Dimensions int           `json:"dimensions"`
+	MaxBatch   int           `json:"max_batch"`
+	Available  bool          `json:"available"`
+}
+
+// embeddingTTL 向量缓存时间 (同一模型同一文本结果不变)
+const embeddingTTL = 7 * 24 * time.Hour
+
+// initEmbeddingModels 初始化向量模型
+func (s *Service) initEmbeddingModels() {
+	s.embedModels = map[string]*EmbeddingModelConfig{
+		"text-embedding-3-small": {Provider: ProviderOpenAI, ModelName: "text-embedding-3-small", Dimensions: 1536, MaxBatch: 256},
+		"text-embedding-3-large": {Provider: ProviderOpenAI, ModelName: "text-embedding-3-large", Dimensions: 3072, MaxBatch: 256},
+		"text-embedding-ada-002": {Provider: ProviderOpenAI, ModelName: "text-embedding-ada-002", Dimensions: 1536, MaxBatch: 256},
+		"embedding-3":            {Provider: ProviderGLM, ModelName: "embedding-3", Dimensions: 2048, MaxBatch: 64},
+		"hash":                   {Provider: ProviderHash, ModelName: "hash", Dimensions: 256, MaxBatch: 1024},
+	}
+	s.clients[ProviderHash] = NewHashEmbedder(256)
+
+	s.defaultEmbedModel = os.Getenv("EMBEDDING_MODEL")
+}
+
+// SetEmbeddingModel 注册或更新向量模型
+func (s *Service) SetEmbeddingModel(name string, cfg *EmbeddingModelConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embedModels[name] = cfg
+}
+
+// embeddingModel 查找向量模型; 未注册时尝试把同名聊天模型当作向量模型 (如 ollama/nomic-embed-text)
+func (s *Service) embeddingModel(name string) (*EmbeddingModelConfig, bool) {
+	s.mu.RLock()
+	cfg, ok := s.embedModels[name]
+	s.mu.RUnlock()
+	if ok {
+		return cfg, true
+	}
+
+	chat, ok := s.GetModel(name)
+	if !ok {
+		return nil, false
+	}
+	return &EmbeddingModelConfig{Provider: chat.Provider, ModelName: chat.ModelName, MaxBatch: 64}, true
+}
+
+// embeddingAvailable 向量模型是否有可用客户端
+func (s *Service) embeddingAvailable(cfg *EmbeddingModelConfig) bool {
+	client, ok := s.getClient(cfg.Provider)
+	if !ok {
+		return false
+	}
+	_, ok = client.(EmbeddingClient)
+	return ok
+}
+
+// DefaultEmbeddingModel 默认向量模型: EMBEDDING_MODEL > 有Key的云端模型 > hash
+func (s *Service) DefaultEmbeddingModel() string {
+	candidates := []string{s.defaultEmbedModel, "text-embedding-3-small", "embedding-3"}
+	for _, name := range candidates {
+		if name == "" {
+			continue
+		}
+		if cfg, ok := s.embeddingModel(name); ok && s.embeddingAvailable(cfg) {
+			return name
+		}
+	}
+	return "hash"
+}
+
+// EmbeddingModels 列出向量模型及维度
+func (s *Service) EmbeddingModels() []EmbeddingModelInfo {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.embedModels))
+	for name := range s.embedModels {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	infos := make([]EmbeddingModelInfo, 0, len(names))
+	for _, name := range names {
+		cfg, _ := s.embeddingModel(name)
+		infos = append(infos, EmbeddingModelInfo{
+			Name:       name,
+			Provider:   cfg.Provider,
+			Dimensions: cfg.Dimensions,
+			MaxBatch:   cfg.MaxBatch,
+			Available:  s.embeddingAvailable(cfg),
+		})
+	}
+	return infos
+}
+
+// EmbeddingDimensions 向量维度 (未知时返回0)
+func (s *Service) EmbeddingDimensions(name string) int {
+	if name == "" {
+		name = s.DefaultEmbeddingModel()
+	}
+	if cfg, ok := s.embeddingModel(name); ok {
+		return cfg.Dimensions
+	}
+	return 0
+}
+
+// Embed 批量向量化 (带缓存和分批), model为空时使用默认向量模型
+func (s *Service) Embed(ctx context.Context, model string, texts []string) (*EmbedResponse, error) {
+	if model == "" {
+		model = s.DefaultEmbeddingModel()
+	}
+	cfg, ok := s.embeddingModel(model)
+	if !ok {
+		return nil, fmt.Errorf("embedding model not found: %s", model)
+	}
+	client, ok := s.getClient(cfg.Provider)
+	if !ok {
+		return nil, fmt.Errorf("client not available for provider: %s", cfg.Provider)
+	}
+	embedder, ok := client.(EmbeddingClient)
+	if !ok {
+		return nil, fmt.Errorf("provider %s does not support embeddings", cfg.Provider)
+	}
+
+	resp := &EmbedResponse{Model: model, Vectors: make([][]float32, len(texts))}
+
+	// 先查缓存, 只请求未命中的文本
+	var missing []int
+	for i, text := range texts {
+		if vec, ok := s.cache.getEmbedding(ctx, embeddingKey(model, text)); ok {
+			resp.Vectors[i] = vec
+			resp.Cached++
+			continue
+		}
+		missing = append(missing, i)
+	}
+
+	batch := cfg.MaxBatch
+	if batch <= 0 {
+		batch = 64
+	}
+	for start := 0; start < len(missing); start += batch {
+		end := start + batch
+		if end > len(missing) {
+			end = len(missing)
+		}
+		idx := missing[start:end]
+		inputs := make([]string, len(idx))
+		tokens := 0
+		for k, i := range idx {
+			inputs[k] = texts[i]
+			tokens += len([]rune(texts[i])) / 2
+		}
+
+		vectors, err := s.embedBatch(ctx, embedder, cfg, inputs, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(inputs) {
+			return nil, fmt.Errorf("embedding count mismatch: want %d, got %d", len(inputs), len(vectors))
+		}
+		for k, i := range idx {
+			resp.Vectors[i] = vectors[k]
+			s.cache.storeEmbedding(ctx, embeddingKey(model, texts[i]), vectors[k])
+		}
+	}
+
+	for _, vec := range resp.Vectors {
+		if len(vec) > 0 {
+			resp.Dimensions = len(vec)
+			break
+		}
+	}
+	if cfg.Dimensions == 0 && resp.Dimensions > 0 {
+		s.mu.Lock()
+		if registered, ok := s.embedModels[model]; ok {
+			registered.Dimensions = resp.Dimensions
+		}
+		s.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// embedBatch 经过限流调用一批向量化
+func (s *Service) embedBatch(ctx context.Context, embedder EmbeddingClient, cfg *EmbeddingModelConfig, inputs []string, tokens int) ([][]float32, error) {
+	if cfg.Provider == ProviderHash {
+		return embedder.Embed(ctx, cfg.ModelName, inputs)
+	}
+
+	s.mu.RLock()
+	apiKey := ""
+	for _, m := range s.models {
+		if m.Provider == cfg.Provider {
+			apiKey = m.APIKey
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	release, err := s.limiter.Acquire(ctx, cfg.Provider, apiKey, tokens)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return embedder.Embed(ctx, cfg.ModelName, inputs)
+}
+
+func embeddingKey(model, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	return "model:embed:" + hex.EncodeToString(sum[:])
+}
+
+// ========== 向量缓存 ==========
+
+// getEmbedding 读取缓存的向量
+func (c *ResponseCache) getEmbedding(ctx context.Context, key string) ([]float32, bool) {
+	c.mu.RLock()
+	redis := c.redis
+	item, ok := c.vectors[key]
+	c.mu.RUnlock()
+
+	if redis != nil {
+		var vec []float32
+		if err := redis.Get(ctx, key, &vec); err != nil {
+			return nil, false
+		}
+		return vec, true
+	}
+	if !ok || time.Now().After(item.expiresAt) {
+		return nil, false
+	}
+	return item.vector, true
+}
+
+// storeEmbedding 缓存向量
+func (c *ResponseCache) storeEmbedding(ctx context.Context, key string, vec []float32) {
+	c.mu.Lock()
+	redis := c.redis
+	if redis == nil {
+		// 内存模式下简单限制条数
+		if len(c.vectors) >= 10000 {
+			c.vectors = make(map[string]vectorItem)
+		}
+		c.vectors[key] = vectorItem{vector: vec, expiresAt: time.Now().Add(embeddingTTL)}
+	}
+	c.mu.Unlock()
+
+	if redis != nil {
+		redis.Set(ctx, key, vec, embeddingTTL)
+	}
+}
+
+// ========== 客户端实现 ==========
+
+// Embed OpenAI兼容的 /embeddings 接口 (go-openai 当前版本的模型枚举不含新模型, 直接请求)
+func (c *OpenAIClient) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	err := doJSON(ctx, c.http, http.MethodPost, c.baseURL+"/embeddings", c.apiKey, map[string]interface{}{
+		"model": model,
+		"input": texts,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index >= 0 && d.Index < len(vectors) {
+			vectors[d.Index] = d.Embedding
+		}
+	}
+	return vectors, nil
+}
+
+// Embed 智谱向量接口 (OpenAI兼容)
+func (c *GLMClient) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	return c.compat.Embed(ctx, model, texts)
+}
+
+// Embed Ollama /api/embed
+func (c *OllamaClient) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	var out struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	err := doJSON(ctx, c.http, http.MethodPost, c.baseURL+"/api/embed", "", map[string]interface{}{
+		"model": model,
+		"input": texts,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Embeddings, nil
+}
+
+// HashEmbedder 确定性哈希向量: 字符unigram+bigram哈希后L2归一化
+type HashEmbedder struct {
+	dim int
+}
+
+func NewHashEmbedder(dim int) *HashEmbedder {
+	if dim <= 0 {
+		dim = 256
+	}
+	return &HashEmbedder{dim: dim}
+}
+
+// Chat 哈希向量不支持对话 (满足Client接口)
+func (e *HashEmbedder) Chat(ctx context.Context, req Request) (*Response, error) {
+	return nil, fmt.Errorf("hash embedder does not support chat")
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = hashVector(text, e.dim)
+	}
+	return vectors, nil
+}
+
+// hashVector 计算归一化的哈希向量
+func hashVector(text string, dim int) []float32 {
+	vec := make([]float32, dim)
+	runes := []rune(strings.ToLower(text))
+	for i := range runes {
+		for n := 1; n <= 2 && i+n <= len(runes); n++ {
+			h := fnv.New32a()
+			h.Write([]byte(string(runes[i : i+n])))
+			sum := h.Sum32()
+			// 用哈希的最高位决定符号, 减少碰撞带来的偏差
+			sign := float32(1)
+			if sum&0x80000000 != 0 {
+				sign = -1
+			}
+			vec[sum%uint32(dim)] += sign
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vec {
+			vec[i] *= scale
+		}
+	}
+	return vec
+}
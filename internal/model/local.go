@@ -0,0 +1,337 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProviderOllama Ollama 原生API
+const ProviderOllama ModelProvider = "ollama"
+
+// DiscoveredModel 从供应商发现的模型
+type DiscoveredModel struct {
+	Name          string `json:"name"`
+	ContextWindow int    `json:"context_window"` // 0表示未知
+	OwnedBy       string `json:"owned_by,omitempty"`
+}
+
+// DiscoveryClient 支持模型发现和健康检查的客户端 (自托管供应商)
+type DiscoveryClient interface {
+	ListModels(ctx context.Context) ([]DiscoveredModel, error)
+	Health(ctx context.Context) error
+}
+
+// isLocalProvider 自托管供应商不要求API Key
+func isLocalProvider(kind ModelProvider) bool {
+	return kind == ProviderOllama || kind == ProviderCustom
+}
+
+// initLocalProviders 根据环境变量注册自托管供应商
+func (s *Service) initLocalProviders() {
+	if baseURL := os.Getenv("OLLAMA_BASE_URL"); baseURL != "" {
+		s.clients[ProviderOllama] = NewOllamaClient(baseURL)
+	}
+	if baseURL := os.Getenv("CUSTOM_OPENAI_BASE_URL"); baseURL != "" {
+		s.clients[ProviderCustom] = NewOpenAICompatibleClient(os.Getenv("CUSTOM_OPENAI_API_KEY"), baseURL)
+	}
+}
+
+// DiscoverModels 从供应商拉取模型列表并注册为 "<provider>/<model>"
+func (s *Service) DiscoverModels(ctx context.Context, provider ModelProvider) ([]DiscoveredModel, error) {
+	client, ok := s.getClient(provider)
+	if !ok {
+		return nil, fmt.Errorf("client not available for provider: %s", provider)
+	}
+	discovery, ok := client.(DiscoveryClient)
+	if !ok {
+		return nil, fmt.Errorf("provider %s does not support model discovery", provider)
+	}
+
+	models, err := discovery.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range models {
+		name := fmt.Sprintf("%s/%s", provider, m.Name)
+		if _, exists := s.GetModel(name); exists {
+			continue
+		}
+		s.SetModel(name, &ModelConfig{
+			Provider:      provider,
+			ModelName:     m.Name,
+			Temperature:   0.7,
+			MaxTokens:     2048,
+			ContextWindow: m.ContextWindow,
+			Local:         true,
+		})
+	}
+	return models, nil
+}
+
+// DiscoverAll 对所有支持发现的供应商执行模型发现 (启动时调用, 失败只记录)
+func (s *Service) DiscoverAll(ctx context.Context) map[ModelProvider]error {
+	s.mu.RLock()
+	var providers []ModelProvider
+	for provider, client := range s.clients {
+		if _, ok := client.(DiscoveryClient); ok {
+			providers = append(providers, provider)
+		}
+	}
+	s.mu.RUnlock()
+
+	errs := make(map[ModelProvider]error)
+	for _, provider := range providers {
+		if _, err := s.DiscoverModels(ctx, provider); err != nil {
+			errs[provider] = err
+		}
+	}
+	return errs
+}
+
+// HealthCheck 检查所有自托管供应商的健康状态 (nil表示健康)
+func (s *Service) HealthCheck(ctx context.Context) map[ModelProvider]string {
+	s.mu.RLock()
+	discovery := make(map[ModelProvider]DiscoveryClient)
+	for provider, client := range s.clients {
+		if d, ok := client.(DiscoveryClient); ok {
+			discovery[provider] = d
+		}
+	}
+	s.mu.RUnlock()
+
+	status := make(map[ModelProvider]string)
+	for provider, d := range discovery {
+		if err := d.Health(ctx); err != nil {
+			status[provider] = err.Error()
+		} else {
+			status[provider] = "ok"
+		}
+	}
+	return status
+}
+
+// ========== Ollama ==========
+
+// OllamaClient Ollama 原生API客户端
+type OllamaClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewOllamaClient(baseURL string) *OllamaClient {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &OllamaClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (c *OllamaClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	options := map[string]interface{}{}
+	if req.Temperature != nil {
+		options["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+
+	var out struct {
+		Model   string `json:"model"`
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+		DoneReason string `json:"done_reason"`
+	}
+	body := map[string]interface{}{
+		"model":    req.Model,
+		"messages": ollamaMessages(req.Messages),
+		"stream":   false,
+		"options":  options,
+	}
+	if req.ResponseFormat != nil {
+		// Ollama 的 format 直接接受 JSON Schema
+		body["format"] = req.ResponseFormat.Schema
+	}
+	err := doJSON(ctx, c.http, http.MethodPost, c.baseURL+"/api/chat", "", body, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		Model:        out.Model,
+		Content:      out.Message.Content,
+		FinishReason: out.DoneReason,
+	}, nil
+}
+
+// ollamaMessages 转换为Ollama消息格式 (图片以base64放入images)
+func ollamaMessages(messages []Message) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(messages))
+	for _, m := range messages {
+		msg := map[string]interface{}{"role": m.Role, "content": m.Text()}
+		var images []string
+		for _, p := range m.Parts {
+			if p.Type == PartImage && len(p.Data) > 0 {
+				images = append(images, base64.StdEncoding.EncodeToString(p.Data))
+			}
+		}
+		if len(images) > 0 {
+			msg["images"] = images
+		}
+		result = append(result, msg)
+	}
+	return result
+}
+
+func (c *OllamaClient) ListModels(ctx context.Context) ([]DiscoveredModel, error) {
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := doJSON(ctx, c.http, http.MethodGet, c.baseURL+"/api/tags", "", nil, &tags); err != nil {
+		return nil, err
+	}
+
+	models := make([]DiscoveredModel, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		models = append(models, DiscoveredModel{
+			Name:          m.Name,
+			ContextWindow: c.contextWindow(ctx, m.Name),
+			OwnedBy:       "ollama",
+		})
+	}
+	return models, nil
+}
+
+// contextWindow 通过 /api/show 读取 "<arch>.context_length"
+func (c *OllamaClient) contextWindow(ctx context.Context, name string) int {
+	var show struct {
+		ModelInfo map[string]interface{} `json:"model_info"`
+	}
+	if err := doJSON(ctx, c.http, http.MethodPost, c.baseURL+"/api/show", "", map[string]string{"name": name}, &show); err != nil {
+		return 0
+	}
+	for k, v := range show.ModelInfo {
+		if strings.HasSuffix(k, ".context_length") {
+			if n, ok := v.(float64); ok {
+				return int(n)
+			}
+		}
+	}
+	return 0
+}
+
+func (c *OllamaClient) Health(ctx context.Context) error {
+	var version struct {
+		Version string `json:"version"`
+	}
+	return doJSON(ctx, c.http, http.MethodGet, c.baseURL+"/api/version", "", nil, &version)
+}
+
+// ========== OpenAI 兼容的自托管服务 (vLLM, llama.cpp server) ==========
+
+// OpenAICompatibleClient 自托管OpenAI兼容服务客户端
+type OpenAICompatibleClient struct {
+	*OpenAIClient
+	apiKey  string
+	baseURL string
+	http    *http.Client
+}
+
+func NewOpenAICompatibleClient(apiKey, baseURL string) *OpenAICompatibleClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &OpenAICompatibleClient{
+		OpenAIClient: NewOpenAIClient(apiKey, baseURL),
+		apiKey:       apiKey,
+		baseURL:      baseURL,
+		http:         &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *OpenAICompatibleClient) ListModels(ctx context.Context) ([]DiscoveredModel, error) {
+	var list struct {
+		Data []struct {
+			ID          string `json:"id"`
+			OwnedBy     string `json:"owned_by"`
+			MaxModelLen int    `json:"max_model_len"` // vLLM
+			Meta        struct {
+				NCtxTrain int `json:"n_ctx_train"` // llama.cpp server
+			} `json:"meta"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, c.http, http.MethodGet, c.baseURL+"/models", c.apiKey, nil, &list); err != nil {
+		return nil, err
+	}
+
+	models := make([]DiscoveredModel, 0, len(list.Data))
+	for _, m := range list.Data {
+		window := m.MaxModelLen
+		if window == 0 {
+			window = m.Meta.NCtxTrain
+		}
+		models = append(models, DiscoveredModel{Name: m.ID, ContextWindow: window, OwnedBy: m.OwnedBy})
+	}
+	return models, nil
+}
+
+func (c *OpenAICompatibleClient) Health(ctx context.Context) error {
+	_, err := c.ListModels(ctx)
+	return err
+}
+
+// ========== 工具函数 ==========
+
+// doJSON 发送JSON请求并解析响应, 非2xx返回 *HTTPError
+func doJSON(ctx context.Context, client *http.Client, method, url, apiKey string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		httpErr := &HTTPError{StatusCode: resp.StatusCode, Body: string(data)}
+		httpErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return httpErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
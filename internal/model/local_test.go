@@ -0,0 +1,206 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOllamaListModels(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"models": []map[string]string{{"name": "qwen2:7b"}, {"name": "llava"}},
+			})
+		case "/api/show":
+			var req struct {
+				Name string `json:"name"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			info := map[string]interface{}{}
+			if req.Name == "qwen2:7b" {
+				info["qwen2.context_length"] = 32768
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"model_info": info})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	models, err := NewOllamaClient(srv.URL + "/").ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	want := []DiscoveredModel{
+		{Name: "qwen2:7b", ContextWindow: 32768, OwnedBy: "ollama"},
+		{Name: "llava", ContextWindow: 0, OwnedBy: "ollama"},
+	}
+	if len(models) != len(want) {
+		t.Fatalf("models = %+v", models)
+	}
+	for i := range want {
+		if models[i] != want[i] {
+			t.Errorf("models[%d] = %+v, want %+v", i, models[i], want[i])
+		}
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":       "llava",
+			"message":     map[string]string{"role": "assistant", "content": "一只猫"},
+			"done_reason": "stop",
+		})
+	}))
+	defer srv.Close()
+
+	resp, err := NewOllamaClient(srv.URL).Chat(context.Background(), Request{
+		Model: "llava",
+		Messages: []Message{{Role: "user", Content: "图里是什么?", Parts: []ContentPart{
+			{Type: PartImage, Data: []byte("png"), MimeType: "image/png"},
+		}}},
+		Temperature:    Temperature(0.2),
+		MaxTokens:      64,
+		ResponseFormat: &ResponseFormat{Name: "answer", Schema: map[string]interface{}{"type": "object"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Model != "llava" || resp.Content != "一只猫" || resp.FinishReason != "stop" {
+		t.Fatalf("resp = %+v", resp)
+	}
+
+	if got["stream"] != false {
+		t.Errorf("stream = %v, want false", got["stream"])
+	}
+	options, _ := got["options"].(map[string]interface{})
+	if options["temperature"] != 0.2 || options["num_predict"] != float64(64) {
+		t.Errorf("options = %v", options)
+	}
+	if format, _ := got["format"].(map[string]interface{}); format["type"] != "object" {
+		t.Errorf("format = %v", got["format"])
+	}
+	messages, _ := got["messages"].([]interface{})
+	if len(messages) != 1 {
+		t.Fatalf("messages = %v", got["messages"])
+	}
+	msg := messages[0].(map[string]interface{})
+	images, _ := msg["images"].([]interface{})
+	if len(images) != 1 || images[0] != "cG5n" {
+		t.Errorf("images = %v, want base64 of the image data", msg["images"])
+	}
+}
+
+func TestOllamaHealth(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/version" || !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"version": "0.3.0"})
+	}))
+	defer srv.Close()
+
+	client := NewOllamaClient(srv.URL)
+	if err := client.Health(context.Background()); err != nil {
+		t.Fatalf("Health: %v", err)
+	}
+	healthy = false
+	if err := client.Health(context.Background()); err == nil {
+		t.Fatal("Health: want error when the server is unavailable")
+	}
+}
+
+func TestOpenAICompatibleListModels(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"data": [
+			{"id": "vllm-model", "owned_by": "vllm", "max_model_len": 8192},
+			{"id": "llama-cpp", "owned_by": "llamacpp", "meta": {"n_ctx_train": 4096}},
+			{"id": "unknown"}
+		]}`))
+	}))
+	defer srv.Close()
+
+	models, err := NewOpenAICompatibleClient("secret", srv.URL+"/v1/").ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	want := map[string]int{"vllm-model": 8192, "llama-cpp": 4096, "unknown": 0}
+	if len(models) != len(want) {
+		t.Fatalf("models = %+v", models)
+	}
+	for _, m := range models {
+		if m.ContextWindow != want[m.Name] {
+			t.Errorf("%s: context window = %d, want %d", m.Name, m.ContextWindow, want[m.Name])
+		}
+	}
+}
+
+func TestDoJSONHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}))
+	defer srv.Close()
+
+	err := doJSON(context.Background(), srv.Client(), http.MethodGet, srv.URL, "", nil, nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("err = %v, want *HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusTooManyRequests || httpErr.RetryAfter != 3*time.Second || httpErr.Body != "slow down" {
+		t.Fatalf("httpErr = %+v", httpErr)
+	}
+	if kind := classifyError(err); kind != FailureRateLimit {
+		t.Errorf("classifyError = %s, want %s", kind, FailureRateLimit)
+	}
+}
+
+func TestDiscoverModelsRegistersLocalModels(t *testing.T) {
+	svc, _ := newOllamaService(t, replyWith("ok"))
+
+	cfg, ok := svc.GetModel("ollama/bot")
+	if !ok {
+		t.Fatal("ollama/bot not registered")
+	}
+	if cfg.Provider != ProviderOllama || cfg.ModelName != "bot" || !cfg.Local {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+	if !svc.IsModelAvailable("ollama/bot") {
+		t.Error("local model without api key should be available")
+	}
+
+	// 已注册的模型不被覆盖
+	cfg.MaxTokens = 99
+	svc.SetModel("ollama/bot", cfg)
+	if _, err := svc.DiscoverModels(context.Background(), ProviderOllama); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, _ := svc.GetModel("ollama/bot"); cfg.MaxTokens != 99 {
+		t.Errorf("max tokens = %d, rediscovery overwrote the existing model", cfg.MaxTokens)
+	}
+}
@@ -0,0 +1,227 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContentPartType 内容片段类型
+type ContentPartType string
+
+const (
+	PartText  ContentPartType = "text"
+	PartImage ContentPartType = "image"
+	PartFile  ContentPartType = "file"
+	PartAudio ContentPartType = "audio"
+)
+
+// ContentPart 多模态内容片段 (URL 与 Data 二选一)
+type ContentPart struct {
+	Type     ContentPartType `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	URL      string          `json:"url,omitempty"`  // 远程地址
+	Data     []byte          `json:"data,omitempty"` // 内联数据
+	MimeType string          `json:"mime_type,omitempty"`
+	Name     string          `json:"name,omitempty"`    // 文件名
+	BlobID   string          `json:"blob_id,omitempty"` // 本地存储ID
+}
+
+// TextPart 文本片段
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PartText, Text: text}
+}
+
+// ImageURLPart 远程图片
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Type: PartImage, URL: url}
+}
+
+// ImageBytesPart 内联图片
+func ImageBytesPart(data []byte, mimeType string) ContentPart {
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return ContentPart{Type: PartImage, Data: data, MimeType: mimeType}
+}
+
+// HasMedia 消息是否包含非文本片段
+func (m Message) HasMedia() bool {
+	for _, p := range m.Parts {
+		if p.Type != PartText {
+			return true
+		}
+	}
+	return false
+}
+
+// Text 消息的全部文本 (Content 和文本片段)
+func (m Message) Text() string {
+	texts := []string{}
+	if m.Content != "" {
+		texts = append(texts, m.Content)
+	}
+	for _, p := range m.Parts {
+		if p.Type == PartText && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// needsVision 请求中是否有图片
+func needsVision(req Request) bool {
+	for _, m := range req.Messages {
+		for _, p := range m.Parts {
+			if p.Type == PartImage {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flattenMedia 把模型无法直接处理的片段转为文本描述; keepImages 为 true 时保留图片
+func flattenMedia(messages []Message, keepImages bool) []Message {
+	result := make([]Message, len(messages))
+	for i, m := range messages {
+		result[i] = m
+		if len(m.Parts) == 0 {
+			continue
+		}
+
+		var parts []ContentPart
+		var texts []string
+		if m.Content != "" {
+			texts = append(texts, m.Content)
+		}
+		for _, p := range m.Parts {
+			switch {
+			case p.Type == PartText:
+				texts = append(texts, p.Text)
+			case p.Type == PartImage && keepImages:
+				parts = append(parts, p)
+			default:
+				texts = append(texts, describePart(p))
+			}
+		}
+
+		result[i].Content = strings.Join(texts, "\n")
+		result[i].Parts = parts
+	}
+	return result
+}
+
+// describePart 非文本片段的文字描述 (文本类文件直接内联)
+func describePart(p ContentPart) string {
+	name := p.Name
+	if name == "" {
+		name = p.URL
+	}
+	switch p.Type {
+	case PartImage:
+		return fmt.Sprintf("[图片: %s]", name)
+	case PartAudio:
+		return fmt.Sprintf("[语音: %s]", name)
+	case PartFile:
+		if strings.HasPrefix(p.MimeType, "text/") && utf8.Valid(p.Data) {
+			return fmt.Sprintf("[文件: %s]\n%s", name, string(p.Data))
+		}
+		return fmt.Sprintf("[文件: %s]", name)
+	}
+	return ""
+}
+
+// dataURL 图片的URL (内联数据转为 data URL)
+func (p ContentPart) dataURL() string {
+	if p.URL != "" {
+		return p.URL
+	}
+	mimeType := p.MimeType
+	if mimeType == "" {
+		mimeType = http.DetectContentType(p.Data)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// partFingerprint 缓存键使用的片段摘要 (不含原始数据)
+func partFingerprint(p ContentPart) ContentPart {
+	if len(p.Data) > 0 {
+		sum := sha256.Sum256(p.Data)
+		p.URL = "sha256:" + hex.EncodeToString(sum[:])
+		p.Data = nil
+	}
+	p.BlobID = ""
+	return p
+}
+
+// ========== OpenAI 兼容的多模态请求 ==========
+
+// chatMultimodal 以 OpenAI vision 格式发送带图片的请求 (go-openai 当前版本不支持数组content)
+func (c *OpenAIClient) chatMultimodal(ctx context.Context, req Request) (*Response, error) {
+	messages := make([]map[string]interface{}, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		if len(m.Parts) == 0 {
+			messages = append(messages, map[string]interface{}{"role": m.Role, "content": m.Content})
+			continue
+		}
+
+		content := []map[string]interface{}{}
+		if m.Content != "" {
+			content = append(content, map[string]interface{}{"type": "text", "text": m.Content})
+		}
+		for _, p := range m.Parts {
+			switch p.Type {
+			case PartText:
+				content = append(content, map[string]interface{}{"type": "text", "text": p.Text})
+			case PartImage:
+				content = append(content, map[string]interface{}{
+					"type":      "image_url",
+					"image_url": map[string]string{"url": p.dataURL()},
+				})
+			}
+		}
+		messages = append(messages, map[string]interface{}{"role": m.Role, "content": content})
+	}
+
+	body := map[string]interface{}{
+		"model":    req.Model,
+		"messages": messages,
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		body["max_tokens"] = req.MaxTokens
+	}
+	if req.ResponseFormat != nil {
+		body["response_format"] = map[string]string{"type": "json_object"}
+	}
+
+	var out struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := doJSON(ctx, c.http, http.MethodPost, c.baseURL+"/chat/completions", c.apiKey, body, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Choices) == 0 {
+		return nil, fmt.Errorf("no response from model")
+	}
+
+	return &Response{
+		Model:        out.Model,
+		Content:      out.Choices[0].Message.Content,
+		FinishReason: out.Choices[0].FinishReason,
+	}, nil
+}
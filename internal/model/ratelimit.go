@@ -0,0 +1,672 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-flow/internal/store"
+)
+
+// LimitConfig 供应商限流配置
+type LimitConfig struct {
+	RPM         int `json:"rpm"`           // 每分钟请求数, 0表示不限
+	TPM         int `json:"tpm"`           // 每分钟token数, 0表示不限
+	MaxInFlight int `json:"max_in_flight"` // 最大并发请求数, 0表示不限
+}
+
+// LimiterState 限流器状态 (管理接口展示)
+type LimiterState struct {
+	Key          string      `json:"key"`
+	Provider     string      `json:"provider"`
+	Config       LimitConfig `json:"config"`
+	InFlight     int         `json:"in_flight"`
+	Waiting      int         `json:"waiting"`
+	Throttled    int64       `json:"throttled"` // 累计等待次数
+	BlockedUntil *time.Time  `json:"blocked_until,omitempty"`
+}
+
+const (
+	slotLeaseTTL     = 30 * time.Second       // Redis并发槽位租约, 持有期间定期续约, 副本崩溃后自动过期
+	slotPollInterval = 200 * time.Millisecond // 等待其他副本释放槽位的轮询间隔
+
+	limitConfigKey     = "ratelimit:configs"        // Redis hash: 供应商 -> 限流配置(JSON), 管理接口的修改对所有副本生效
+	limitConfigChannel = "ratelimit:config:changed" // 限流配置变更通知
+)
+
+// keyLimiter 单个 (供应商, API Key) 的限流状态
+type keyLimiter struct {
+	key      string
+	provider ModelProvider
+	turn     chan struct{} // 排队令牌, 阻塞的调用方按FIFO依次获得
+
+	mu           sync.Mutex
+	inFlight     int           // 本副本正在执行的调用数
+	freed        chan struct{} // 有槽位释放或配置变化时关闭并重建, 唤醒等待的调用方
+	waiting      int
+	throttled    int64
+	blockedUntil time.Time
+}
+
+// wake 唤醒等待槽位的调用方 (调用方持有 kl.mu)
+func (kl *keyLimiter) wake() {
+	close(kl.freed)
+	kl.freed = make(chan struct{})
+}
+
+// Limiter 按供应商和API Key限流 (令牌桶 + 并发上限), 可通过Redis在副本间共享令牌桶和并发槽位
+type Limiter struct {
+	mu       sync.Mutex
+	configs  map[ModelProvider]LimitConfig
+	limiters map[string]*keyLimiter
+	buckets  bucketStore
+	redis    *store.Redis
+}
+
+// NewLimiter 创建限流器 (默认本地令牌桶, 不限流)
+func NewLimiter() *Limiter {
+	l := &Limiter{
+		configs:  make(map[ModelProvider]LimitConfig),
+		limiters: make(map[string]*keyLimiter),
+		buckets:  newLocalBuckets(),
+	}
+
+	// 默认不限流, 可用 RATE_LIMIT_<PROVIDER>_RPM/TPM/CONCURRENCY 按账号配额设置
+	for _, p := range []ModelProvider{ProviderOpenAI, ProviderAnthropic, ProviderGLM, ProviderMiniMax, ProviderKimi, ProviderQwen, ProviderDeepSeek, ProviderCustom} {
+		l.configs[p] = LimitConfig{
+			RPM:         envInt(fmt.Sprintf("RATE_LIMIT_%s_RPM", upper(p)), 0),
+			TPM:         envInt(fmt.Sprintf("RATE_LIMIT_%s_TPM", upper(p)), 0),
+			MaxInFlight: envInt(fmt.Sprintf("RATE_LIMIT_%s_CONCURRENCY", upper(p)), 0),
+		}
+	}
+	return l
+}
+
+// UseRedis 使用Redis共享令牌桶、并发槽位和退避状态
+func (l *Limiter) UseRedis(redis *store.Redis) {
+	if redis == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redis = redis
+	l.buckets = &redisBuckets{redis: redis}
+}
+
+// SetConfig 设置供应商限流配置, 立即对新调用生效; 正在执行的调用继续占用槽位直到结束
+func (l *Limiter) SetConfig(provider ModelProvider, cfg LimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configs[provider] = cfg
+
+	// 上限可能调大, 让等待的调用方按新配置重新检查
+	for _, kl := range l.limiters {
+		if kl.provider == provider {
+			kl.mu.Lock()
+			kl.wake()
+			kl.mu.Unlock()
+		}
+	}
+}
+
+// SaveConfig 设置供应商限流配置; 接入Redis时保存并通知其他副本, 否则只对本副本生效且重启后恢复为环境变量配置
+func (l *Limiter) SaveConfig(ctx context.Context, provider ModelProvider, cfg LimitConfig) error {
+	l.SetConfig(provider, cfg)
+
+	l.mu.Lock()
+	redis := l.redis
+	l.mu.Unlock()
+	if redis == nil {
+		return nil
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := redis.Eval(ctx, `return redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])`, []string{limitConfigKey}, string(provider), string(data)); err != nil {
+		return fmt.Errorf("save limit config: %w", err)
+	}
+	if err := redis.Publish(ctx, limitConfigChannel, string(provider)); err != nil {
+		log.Printf("[RateLimit] publish config change: %v", err)
+	}
+	return nil
+}
+
+// loadSharedConfigs 读取Redis中保存的限流配置 (覆盖环境变量的配置)
+func (l *Limiter) loadSharedConfigs(ctx context.Context, redis *store.Redis) error {
+	res, err := redis.Eval(ctx, `return redis.call('HGETALL', KEYS[1])`, []string{limitConfigKey})
+	if err != nil {
+		return err
+	}
+	items, _ := res.([]interface{})
+	for i := 0; i+1 < len(items); i += 2 {
+		provider, _ := items[i].(string)
+		data, _ := items[i+1].(string)
+		var cfg LimitConfig
+		if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+			log.Printf("[RateLimit] invalid shared config for %s: %v", provider, err)
+			continue
+		}
+		l.SetConfig(ModelProvider(provider), cfg)
+	}
+	return nil
+}
+
+// Run 加载并监听其他副本保存的限流配置, 每个副本运行一个
+func (l *Limiter) Run(ctx context.Context) {
+	l.mu.Lock()
+	redis := l.redis
+	l.mu.Unlock()
+	if redis == nil {
+		<-ctx.Done()
+		return
+	}
+	changes := redis.Subscribe(ctx, limitConfigChannel)
+	if err := l.loadSharedConfigs(ctx, redis); err != nil {
+		log.Printf("[RateLimit] load shared configs: %v", err)
+	}
+	for range changes {
+		if err := l.loadSharedConfigs(ctx, redis); err != nil {
+			log.Printf("[RateLimit] reload shared configs: %v", err)
+		}
+	}
+}
+
+// config 获取供应商限流配置
+func (l *Limiter) config(provider ModelProvider) LimitConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.configs[provider]
+}
+
+// get 获取(或创建)某个Key的限流状态
+func (l *Limiter) get(provider ModelProvider, apiKey string) *keyLimiter {
+	sum := sha256.Sum256([]byte(apiKey))
+	key := fmt.Sprintf("%s:%s", provider, hex.EncodeToString(sum[:])[:12])
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if kl, ok := l.limiters[key]; ok {
+		return kl
+	}
+	kl := &keyLimiter{
+		key:      key,
+		provider: provider,
+		turn:     make(chan struct{}, 1),
+		freed:    make(chan struct{}),
+	}
+	l.limiters[key] = kl
+	return kl
+}
+
+// Acquire 等待限流许可, 返回的release在调用结束后必须执行
+func (l *Limiter) Acquire(ctx context.Context, provider ModelProvider, apiKey string, tokens int) (func(), error) {
+	cfg := l.config(provider)
+	kl := l.get(provider, apiKey)
+
+	kl.mu.Lock()
+	kl.waiting++
+	kl.mu.Unlock()
+	defer func() {
+		kl.mu.Lock()
+		kl.waiting--
+		kl.mu.Unlock()
+	}()
+
+	// 1. 排队: 同一个Key的调用方按到达顺序依次检查令牌桶
+	select {
+	case kl.turn <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	err := l.waitBuckets(ctx, kl, cfg, tokens)
+	<-kl.turn
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 并发上限
+	return l.acquireSlot(ctx, kl)
+}
+
+// acquireSlot 占用一个并发槽位; 每次检查都读取最新配置, 配置了Redis时上限在所有副本间共享
+func (l *Limiter) acquireSlot(ctx context.Context, kl *keyLimiter) (func(), error) {
+	l.mu.Lock()
+	redis := l.redis
+	l.mu.Unlock()
+
+	for {
+		max := l.config(kl.provider).MaxInFlight
+		lease := ""
+		if max > 0 && redis != nil {
+			id, err := leaseSlot(ctx, redis, kl.key, max)
+			if err != nil {
+				return nil, err
+			}
+			lease = id
+		}
+
+		kl.mu.Lock()
+		freed := kl.freed
+		if max <= 0 || lease != "" || (redis == nil && kl.inFlight < max) {
+			kl.inFlight++
+			kl.mu.Unlock()
+			return l.releaser(redis, kl, lease), nil
+		}
+		kl.mu.Unlock()
+
+		// 本副本的槽位释放时立即重试, 其他副本释放的只能轮询发现
+		timer := time.NewTimer(slotPollInterval)
+		select {
+		case <-freed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// releaser 返回释放槽位的函数 (可重复调用), 持有Redis租约期间定期续约
+func (l *Limiter) releaser(redis *store.Redis, kl *keyLimiter, lease string) func() {
+	stop := make(chan struct{})
+	if lease != "" {
+		go renewSlot(redis, kl.key, lease, stop)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			if lease != "" {
+				if _, err := redis.Eval(context.Background(), slotReleaseScript, []string{slotsKey(kl.key)}, lease); err != nil {
+					log.Printf("[Model] release rate limit slot %s: %v", kl.key, err)
+				}
+			}
+			kl.mu.Lock()
+			kl.inFlight--
+			kl.wake()
+			kl.mu.Unlock()
+		})
+	}
+}
+
+// waitBuckets 等待退避结束并从请求/token令牌桶取令牌
+func (l *Limiter) waitBuckets(ctx context.Context, kl *keyLimiter, cfg LimitConfig, tokens int) error {
+	gotRequest := cfg.RPM <= 0
+	for {
+		wait := l.blockedFor(ctx, kl)
+
+		if wait == 0 && !gotRequest {
+			w, err := l.buckets.take(ctx, kl.key+":req", float64(cfg.RPM)/60, float64(cfg.RPM), 1)
+			if err != nil {
+				return err
+			}
+			gotRequest = w == 0
+			wait = w
+		}
+		if wait == 0 && cfg.TPM > 0 && tokens > 0 {
+			n := math.Min(float64(tokens), float64(cfg.TPM))
+			w, err := l.buckets.take(ctx, kl.key+":tok", float64(cfg.TPM)/60, float64(cfg.TPM), n)
+			if err != nil {
+				return err
+			}
+			wait = w
+		}
+		if wait == 0 {
+			return nil
+		}
+
+		kl.mu.Lock()
+		kl.throttled++
+		kl.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Backoff 根据 Retry-After 暂停该Key的所有调用
+func (l *Limiter) Backoff(provider ModelProvider, apiKey string, d time.Duration) {
+	if d <= 0 {
+		d = 5 * time.Second
+	}
+	kl := l.get(provider, apiKey)
+	until := time.Now().Add(d)
+
+	kl.mu.Lock()
+	if until.After(kl.blockedUntil) {
+		kl.blockedUntil = until
+	}
+	kl.mu.Unlock()
+
+	l.mu.Lock()
+	redis := l.redis
+	l.mu.Unlock()
+	if redis != nil {
+		redis.Set(context.Background(), "ratelimit:block:"+kl.key, until.UnixMilli(), d)
+	}
+}
+
+// blockedFor 剩余退避时间 (本地或其他副本设置的)
+func (l *Limiter) blockedFor(ctx context.Context, kl *keyLimiter) time.Duration {
+	kl.mu.Lock()
+	until := kl.blockedUntil
+	kl.mu.Unlock()
+
+	l.mu.Lock()
+	redis := l.redis
+	l.mu.Unlock()
+	if redis != nil {
+		var ms int64
+		if err := redis.Get(ctx, "ratelimit:block:"+kl.key, &ms); err == nil {
+			if remote := time.UnixMilli(ms); remote.After(until) {
+				until = remote
+			}
+		}
+	}
+
+	if wait := time.Until(until); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// States 获取所有限流器状态
+func (l *Limiter) States() []LimiterState {
+	l.mu.Lock()
+	limiters := make([]*keyLimiter, 0, len(l.limiters))
+	for _, kl := range l.limiters {
+		limiters = append(limiters, kl)
+	}
+	configs := make(map[ModelProvider]LimitConfig, len(l.configs))
+	for p, c := range l.configs {
+		configs[p] = c
+	}
+	l.mu.Unlock()
+
+	states := make([]LimiterState, 0, len(limiters))
+	for _, kl := range limiters {
+		kl.mu.Lock()
+		state := LimiterState{
+			Key:       kl.key,
+			Provider:  string(kl.provider),
+			Config:    configs[kl.provider],
+			InFlight:  kl.inFlight,
+			Waiting:   kl.waiting,
+			Throttled: kl.throttled,
+		}
+		if time.Now().Before(kl.blockedUntil) {
+			until := kl.blockedUntil
+			state.BlockedUntil = &until
+		}
+		kl.mu.Unlock()
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states
+}
+
+// Configs 获取所有供应商限流配置
+func (l *Limiter) Configs() map[ModelProvider]LimitConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	configs := make(map[ModelProvider]LimitConfig, len(l.configs))
+	for p, c := range l.configs {
+		configs[p] = c
+	}
+	return configs
+}
+
+// Limiter 获取限流器
+func (s *Service) Limiter() *Limiter {
+	return s.limiter
+}
+
+// ========== 令牌桶存储 ==========
+
+// bucketStore 令牌桶存储, 返回需要等待的时间 (0表示已取到令牌)
+type bucketStore interface {
+	take(ctx context.Context, key string, ratePerSec, burst, n float64) (time.Duration, error)
+}
+
+// localBuckets 本地令牌桶 (单副本)
+type localBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+}
+
+type localBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLocalBuckets() *localBuckets {
+	return &localBuckets{buckets: make(map[string]*localBucket)}
+}
+
+func (b *localBuckets) take(ctx context.Context, key string, ratePerSec, burst, n float64) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &localBucket{tokens: burst, last: now}
+		b.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*ratePerSec)
+	bucket.last = now
+
+	if bucket.tokens >= n {
+		bucket.tokens -= n
+		return 0, nil
+	}
+	return time.Duration((n - bucket.tokens) / ratePerSec * float64(time.Second)), nil
+}
+
+// redisBuckets Redis令牌桶 (多副本共享)
+type redisBuckets struct {
+	redis *store.Redis
+}
+
+// tokenBucketScript 原子地补充并扣减令牌, 返回需等待的毫秒数
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local v = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(v[1]) or burst
+local ts = tonumber(v[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+local wait = 0
+if tokens >= n then
+  tokens = tokens - n
+else
+  wait = math.ceil((n - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`
+
+func (b *redisBuckets) take(ctx context.Context, key string, ratePerSec, burst, n float64) (time.Duration, error) {
+	res, err := b.redis.Eval(ctx, tokenBucketScript, []string{"ratelimit:bucket:" + key}, ratePerSec, burst, n)
+	if err != nil {
+		return 0, fmt.Errorf("rate limit: %w", err)
+	}
+	ms, _ := res.(int64)
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// ========== 并发槽位 (Redis) ==========
+
+// 槽位保存在有序集合中: 成员为租约ID, 分数为过期时间(毫秒); 过期的租约视为已释放
+
+// slotLeaseScript 清理过期租约, 未达上限时加入新租约; 返回1表示成功
+const slotLeaseScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ttl = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('ZADD', KEYS[1], now + ttl, ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ttl)
+return 1
+`
+
+// slotRenewScript 续约 (租约已过期则不再恢复)
+const slotRenewScript = `
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+  return 0
+end
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ttl = tonumber(ARGV[2])
+redis.call('ZADD', KEYS[1], now + ttl, ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ttl)
+return 1
+`
+
+const slotReleaseScript = `return redis.call('ZREM', KEYS[1], ARGV[1])`
+
+func slotsKey(key string) string {
+	return "ratelimit:slots:" + key
+}
+
+// leaseSlot 尝试获取租约, 已达上限时返回空ID
+func leaseSlot(ctx context.Context, redis *store.Redis, key string, max int) (string, error) {
+	id := fmt.Sprintf("%x-%x", time.Now().UnixNano(), rand.Int63())
+	res, err := redis.Eval(ctx, slotLeaseScript, []string{slotsKey(key)}, max, id, slotLeaseTTL.Milliseconds())
+	if err != nil {
+		return "", fmt.Errorf("rate limit: %w", err)
+	}
+	if n, _ := res.(int64); n != 1 {
+		return "", nil
+	}
+	return id, nil
+}
+
+// renewSlot 调用结束前定期续约
+func renewSlot(redis *store.Redis, key, lease string, stop <-chan struct{}) {
+	ticker := time.NewTicker(slotLeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			res, err := redis.Eval(context.Background(), slotRenewScript, []string{slotsKey(key)}, lease, slotLeaseTTL.Milliseconds())
+			if err != nil {
+				log.Printf("[Model] renew rate limit slot %s: %v", key, err)
+			} else if n, _ := res.(int64); n != 1 {
+				log.Printf("[Model] rate limit slot %s lease expired", key)
+				return
+			}
+		}
+	}
+}
+
+// ========== 工具函数 ==========
+
+// estimateTokens 粗略估算请求token数 (输入 + 输出上限)
+func estimateTokens(req Request) int {
+	return CountMessagesTokens(req.Messages) + req.MaxTokens
+}
+
+func envInt(key string, defaultValue int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return defaultValue
+}
+
+func upper(p ModelProvider) string {
+	return strings.ToUpper(string(p))
+}
+
+// ========== Retry-After ==========
+
+// parseRetryAfter 解析 Retry-After 头 (秒数或HTTP日期), 无法解析时返回0
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryAfterKey 请求上下文中记录 429 响应 Retry-After 的位置 (*time.Duration)
+type retryAfterKey struct{}
+
+// retryAfterTransport go-openai 返回的错误不带响应头, 在传输层记录 429 响应的 Retry-After
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryAfterTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		if slot, ok := r.Context().Value(retryAfterKey{}).(*time.Duration); ok {
+			*slot = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+	}
+	return resp, err
+}
+
+// retryAfterError 附带 Retry-After 的错误, 原始错误仍可用 errors.As 分类
+type retryAfterError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// retryAfterOf 错误携带的 Retry-After, 没有时返回0
+func retryAfterOf(err error) time.Duration {
+	var raErr *retryAfterError
+	if errors.As(err, &raErr) {
+		return raErr.retryAfter
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterDefaultsUnlimited(t *testing.T) {
+	t.Setenv("RATE_LIMIT_OPENAI_RPM", "")
+	t.Setenv("RATE_LIMIT_OPENAI_CONCURRENCY", "")
+	l := NewLimiter()
+	if cfg := l.Configs()[ProviderOpenAI]; cfg != (LimitConfig{}) {
+		t.Fatalf("default config = %+v, want unlimited", cfg)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		if _, err := l.Acquire(ctx, ProviderOpenAI, "k", 0); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+}
+
+func TestLimiterSetConfigKeepsInFlight(t *testing.T) {
+	l := NewLimiter()
+	l.SetConfig(ProviderOpenAI, LimitConfig{MaxInFlight: 2})
+
+	ctx := context.Background()
+	release1, err := l.Acquire(ctx, ProviderOpenAI, "k", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2, err := l.Acquire(ctx, ProviderOpenAI, "k", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 调低上限后, 已占用的槽位仍然计数
+	l.SetConfig(ProviderOpenAI, LimitConfig{MaxInFlight: 1})
+	if states := l.States(); len(states) != 1 || states[0].InFlight != 2 {
+		t.Fatalf("states = %+v", states)
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		release, err := l.Acquire(ctx, ProviderOpenAI, "k", 0)
+		if err == nil {
+			acquired <- release
+		}
+	}()
+
+	release1()
+	select {
+	case <-acquired:
+		t.Fatal("acquired while in-flight calls exceed the new limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release2()
+	release2() // 重复释放无影响
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken after slots were released")
+	}
+	if states := l.States(); states[0].InFlight != 0 {
+		t.Fatalf("in flight = %d", states[0].InFlight)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{"soon", 0},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got < 50*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(http date) = %v, want about a minute", got)
+	}
+}
+
+func TestOpenAIClientRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error": {"message": "rate limited", "type": "requests"}}`))
+	}))
+	defer srv.Close()
+
+	_, err := NewOpenAIClient("k", srv.URL+"/v1").Chat(context.Background(), Request{
+		Model:    "gpt-4",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	})
+	if err == nil {
+		t.Fatal("want a rate limit error")
+	}
+	if kind := classifyError(err); kind != FailureRateLimit {
+		t.Errorf("classifyError = %s, want %s", kind, FailureRateLimit)
+	}
+	if got := retryAfterOf(err); got != 7*time.Second {
+		t.Errorf("retryAfterOf = %v, want 7s", got)
+	}
+}
@@ -0,0 +1,268 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"agent-flow/internal/store"
+)
+
+// ModelInfo 模型信息 (对外展示, 不含凭证)
+type ModelInfo struct {
+	Name          string        `json:"name"`
+	Provider      ModelProvider `json:"provider"`
+	ModelName     string        `json:"model_name"`
+	MaxTokens     int           `json:"max_tokens"`
+	Temperature   float64       `json:"temperature"`
+	CostPer1K     float64       `json:"cost_per_1k"`
+	ContextWindow int           `json:"context_window"`
+	Vision        bool          `json:"vision"`
+	Available     bool          `json:"available"`
+	Source        string        `json:"source"` // builtin/registry
+}
+
+// ConnectionResult 连接测试结果
+type ConnectionResult struct {
+	OK        bool   `json:"ok"`
+	Model     string `json:"model"`
+	LatencyMs int64  `json:"latency_ms"`
+	Reply     string `json:"reply,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// newProviderClient 按供应商类型创建客户端 (除Anthropic外均走OpenAI兼容协议)
+func newProviderClient(kind ModelProvider, apiKey, baseURL string) Client {
+	switch kind {
+	case ProviderAnthropic:
+		return NewAnthropicClient(apiKey)
+	case ProviderOllama:
+		return NewOllamaClient(baseURL)
+	case ProviderCustom:
+		return NewOpenAICompatibleClient(apiKey, baseURL)
+	default:
+		return NewOpenAIClient(apiKey, baseURL)
+	}
+}
+
+// registryChangedChannel 注册表变更通知 (Redis), 各副本收到后重新加载
+const registryChangedChannel = "model:registry:changed"
+
+// UseRegistry 使用数据库中的供应商和模型, 并立即加载; redis 可为空 (单副本)
+func (s *Service) UseRegistry(db *store.Postgres, redis *store.Redis) error {
+	s.mu.Lock()
+	s.registry = db
+	s.registryRedis = redis
+	s.mu.Unlock()
+	return s.ReloadRegistry()
+}
+
+// RegistryChanged 数据库中的供应商或模型修改后调用: 重新加载并通知其他副本
+func (s *Service) RegistryChanged(ctx context.Context) error {
+	if err := s.ReloadRegistry(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	redis := s.registryRedis
+	s.mu.RUnlock()
+	if redis != nil {
+		if err := redis.Publish(ctx, registryChangedChannel, "reload"); err != nil {
+			log.Printf("[Model] publish registry change: %v", err)
+		}
+	}
+	return nil
+}
+
+// RunRegistrySync 监听其他副本的注册表变更并重新加载, 每个副本运行一个
+func (s *Service) RunRegistrySync(ctx context.Context) {
+	s.mu.RLock()
+	redis := s.registryRedis
+	s.mu.RUnlock()
+	if redis == nil {
+		<-ctx.Done()
+		return
+	}
+	for range redis.Subscribe(ctx, registryChangedChannel) {
+		if err := s.ReloadRegistry(); err != nil {
+			log.Printf("[Model] reload registry: %v", err)
+		}
+	}
+}
+
+// IsBuiltinModel 名称是否已被内置模型占用 (注册表不能使用)
+func (s *Service) IsBuiltinModel(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.models[name]
+	return exists && !s.registryModels[name]
+}
+
+// IsBuiltinProvider 名称是否已被内置供应商占用 (注册表不能使用)
+func (s *Service) IsBuiltinProvider(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.clients[ModelProvider(name)]
+	return exists && !s.registryProviders[ModelProvider(name)]
+}
+
+// ReloadRegistry 从数据库重新加载供应商和模型 (热更新, 不影响内置模型);
+// 与内置重名的条目和无法解密Key的供应商会被跳过
+func (s *Service) ReloadRegistry() error {
+	s.mu.RLock()
+	db := s.registry
+	s.mu.RUnlock()
+	if db == nil {
+		return nil
+	}
+
+	providers, err := db.ListModelProviders()
+	if err != nil {
+		return fmt.Errorf("load providers: %w", err)
+	}
+	defs, err := db.ListModelDefinitions()
+	if err != nil {
+		return fmt.Errorf("load models: %w", err)
+	}
+
+	clients := make(map[ModelProvider]Client)
+	keys := make(map[uint]string)
+	byID := make(map[uint]store.ModelProvider)
+	for _, p := range providers {
+		if !p.Enabled {
+			continue
+		}
+		if s.IsBuiltinProvider(p.Name) {
+			log.Printf("[Model] skip provider %s: name is used by a builtin provider", p.Name)
+			continue
+		}
+		apiKey, err := store.DecryptSecret(p.APIKeySecret)
+		if err != nil {
+			log.Printf("[Model] skip provider %s: decrypt api key: %v", p.Name, err)
+			continue
+		}
+		clients[ModelProvider(p.Name)] = newProviderClient(ModelProvider(p.Type), apiKey, p.BaseURL)
+		keys[p.ID] = apiKey
+		byID[p.ID] = p
+	}
+
+	models := make(map[string]*ModelConfig)
+	for _, d := range defs {
+		p, ok := byID[d.ProviderID]
+		if !ok || !d.Enabled {
+			continue
+		}
+		if s.IsBuiltinModel(d.Name) {
+			log.Printf("[Model] skip model %s: name is used by a builtin model", d.Name)
+			continue
+		}
+		models[d.Name] = &ModelConfig{
+			Provider:      ModelProvider(p.Name),
+			ModelName:     d.ModelName,
+			APIKey:        keys[p.ID],
+			BaseURL:       p.BaseURL,
+			MaxTokens:     d.MaxTokens,
+			Temperature:   d.Temperature,
+			TopP:          d.TopP,
+			CostPer1K:     d.CostPer1K,
+			ContextWindow: d.ContextWindow,
+			Vision:        d.Vision,
+			Local:         isLocalProvider(ModelProvider(p.Type)),
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 先移除上次从数据库加载的 (只删注册表自己添加的), 再写入新的
+	for name := range s.registryModels {
+		delete(s.models, name)
+	}
+	for provider := range s.registryProviders {
+		delete(s.clients, provider)
+	}
+	s.registryModels = make(map[string]bool)
+	s.registryProviders = make(map[ModelProvider]bool)
+
+	for provider, client := range clients {
+		s.clients[provider] = client
+		s.registryProviders[provider] = true
+	}
+	for name, cfg := range models {
+		s.models[name] = cfg
+		s.registryModels[name] = true
+	}
+	return nil
+}
+
+// ModelInfos 列出所有模型 (不含凭证)
+func (s *Service) ModelInfos() []ModelInfo {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.models))
+	for name := range s.models {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	infos := make([]ModelInfo, 0, len(names))
+	for _, name := range names {
+		cfg, ok := s.GetModel(name)
+		if !ok {
+			continue
+		}
+		source := "builtin"
+		s.mu.RLock()
+		if s.registryModels[name] {
+			source = "registry"
+		}
+		s.mu.RUnlock()
+
+		infos = append(infos, ModelInfo{
+			Name:          name,
+			Provider:      cfg.Provider,
+			ModelName:     cfg.ModelName,
+			MaxTokens:     cfg.MaxTokens,
+			Temperature:   cfg.Temperature,
+			CostPer1K:     cfg.CostPer1K,
+			ContextWindow: cfg.ContextWindow,
+			Vision:        cfg.Vision,
+			Available:     s.IsModelAvailable(name),
+			Source:        source,
+		})
+	}
+	return infos
+}
+
+// DiscoverProviderModels 用指定供应商配置拉取模型列表 (供注册表使用)
+func (s *Service) DiscoverProviderModels(ctx context.Context, kind ModelProvider, apiKey, baseURL string) ([]DiscoveredModel, error) {
+	discovery, ok := newProviderClient(kind, apiKey, baseURL).(DiscoveryClient)
+	if !ok {
+		return nil, fmt.Errorf("provider type %s does not support model discovery", kind)
+	}
+	return discovery.ListModels(ctx)
+}
+
+// TestConnection 用最小请求测试供应商连通性 (不经过路由和缓存)
+func (s *Service) TestConnection(ctx context.Context, kind ModelProvider, apiKey, baseURL, modelName string) ConnectionResult {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	client := newProviderClient(kind, apiKey, baseURL)
+	start := time.Now()
+	resp, err := client.Chat(ctx, Request{
+		Model:     modelName,
+		Messages:  []Message{{Role: "user", Content: "ping"}},
+		MaxTokens: 1,
+	})
+
+	result := ConnectionResult{Model: modelName, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.OK = true
+	result.Reply = resp.Content
+	return result
+}
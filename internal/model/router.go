@@ -0,0 +1,703 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-flow/internal/store"
+	"github.com/sashabaranov/go-openai"
+)
+
+// FailureKind 调用失败类型 (决定是否触发回退)
+type FailureKind string
+
+const (
+	FailureTimeout     FailureKind = "timeout"      // 超时
+	FailureRateLimit   FailureKind = "rate_limit"   // 429 限流
+	FailureServerError FailureKind = "server_error" // 5xx
+	FailureUnavailable FailureKind = "unavailable"  // 模型/客户端未配置
+	FailureOther       FailureKind = "other"        // 其他错误 (如 4xx 参数错误)
+)
+
+// HTTPError 供应商返回的HTTP错误
+type HTTPError struct {
+	StatusCode int
+	RetryAfter time.Duration // 来自 Retry-After 头
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http status %d: %s", e.StatusCode, e.Body)
+}
+
+// WeightedModel A/B 分流中的模型及权重
+type WeightedModel struct {
+	Model  string `json:"model"`
+	Weight int    `json:"weight"`
+}
+
+// RoutingPolicy 路由策略
+type RoutingPolicy struct {
+	Name           string          `json:"name"`
+	TaskType       string          `json:"task_type"`                     // 匹配的任务类型, 空表示默认策略
+	Chain          []string        `json:"chain"`                         // 有序回退链
+	Splits         []WeightedModel `json:"splits,omitempty"`              // A/B 分流, 命中的模型排在链首
+	FallbackOn     []FailureKind   `json:"fallback_on"`                   // 触发回退的失败类型
+	CostAware      bool            `json:"cost_aware"`                    // 按单价从低到高排序
+	MaxCostPer1K   float64         `json:"max_cost_per_1k"`               // 单价上限, 0表示不限
+	AllowOverride  bool            `json:"allow_override"`                // 调用方指定的模型优先
+	AttemptTimeout int             `json:"attempt_timeout_sec,omitempty"` // 单个模型的调用时限(秒), 超时后回退到下一个, 0表示默认
+}
+
+// attemptTimeout 单次尝试的时限
+func (p *RoutingPolicy) attemptTimeout() time.Duration {
+	if p.AttemptTimeout > 0 {
+		return time.Duration(p.AttemptTimeout) * time.Second
+	}
+	return defaultAttemptTimeout
+}
+
+// shouldFallback 判断该失败类型是否触发回退
+func (p *RoutingPolicy) shouldFallback(kind FailureKind) bool {
+	if kind == FailureUnavailable {
+		return true
+	}
+	for _, k := range p.FallbackOn {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// RoutingAttempt 单次尝试
+type RoutingAttempt struct {
+	Model    string      `json:"model"`
+	Error    string      `json:"error,omitempty"`
+	Failure  FailureKind `json:"failure,omitempty"`
+	Duration int64       `json:"duration_ms"`
+}
+
+// RoutingDecision 路由决策记录
+type RoutingDecision struct {
+	ID         string           `json:"id"`
+	TaskType   string           `json:"task_type"`
+	Requested  string           `json:"requested"`
+	Policy     string           `json:"policy"`
+	Candidates []string         `json:"candidates"`
+	Attempts   []RoutingAttempt `json:"attempts"`
+	Selected   string           `json:"selected"`
+	Reason     string           `json:"reason"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+const (
+	maxRoutingDecisions   = 500             // 内存中保留的决策条数
+	defaultAttemptTimeout = 2 * time.Minute // 单个模型调用的默认时限
+	decisionBatchSize     = 100             // 决策日志批量写入条数
+	decisionFlushInterval = 2 * time.Second // 决策日志写入间隔
+	decisionRetention     = 30 * 24 * time.Hour
+	routingChangedChannel = "model:routing:changed" // 策略变更通知 (Redis)
+)
+
+// Router 模型路由器
+type Router struct {
+	mu        sync.RWMutex
+	policies  map[string]*RoutingPolicy // task_type -> 策略
+	decisions []RoutingDecision
+	rnd       *rand.Rand
+
+	// 持久化 (UseStore 后启用): 策略保存在数据库, 决策日志由 Run 批量写入
+	db      *store.Postgres
+	redis   *store.Redis
+	pending chan RoutingDecision
+}
+
+// NewRouter 创建路由器 (带内置策略)
+func NewRouter() *Router {
+	r := &Router{
+		policies: make(map[string]*RoutingPolicy),
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, p := range defaultRoutingPolicies() {
+		r.SetPolicy(p)
+	}
+	return r
+}
+
+// defaultRoutingPolicies 内置策略
+func defaultRoutingPolicies() []*RoutingPolicy {
+	retryable := []FailureKind{FailureTimeout, FailureRateLimit, FailureServerError}
+	return []*RoutingPolicy{
+		{
+			Name:          "default",
+			Chain:         []string{"gpt-4", "glm-4", "glm-4-plus", "claude-3-opus", "moonshot-v1-8k-chat", "qwen-turbo", "deepseek-chat"},
+			FallbackOn:    retryable,
+			AllowOverride: true,
+		},
+		{
+			Name:          "code",
+			TaskType:      "code",
+			Chain:         []string{"deepseek-coder", "kimi-coding-k2p5", "gpt-4", "qwen-max"},
+			FallbackOn:    retryable,
+			AllowOverride: true,
+		},
+	}
+}
+
+// SetPolicy 设置(覆盖)某任务类型的策略
+func (r *Router) SetPolicy(p *RoutingPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policies[p.TaskType] = p
+}
+
+// Policies 列出所有策略
+func (r *Router) Policies() []*RoutingPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	policies := make([]*RoutingPolicy, 0, len(r.policies))
+	for _, p := range r.policies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].TaskType < policies[j].TaskType })
+	return policies
+}
+
+// LoadPolicies 从JSON加载策略 (数组)
+func (r *Router) LoadPolicies(data []byte) error {
+	var policies []*RoutingPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return fmt.Errorf("parse routing policies: %w", err)
+	}
+	for _, p := range policies {
+		r.SetPolicy(p)
+	}
+	return nil
+}
+
+// UseStore 把策略和决策日志持久化到数据库, 并通过Redis通知其他副本刷新策略;
+// 数据库中的策略覆盖同任务类型的内置策略和配置文件策略
+func (r *Router) UseStore(db *store.Postgres, redis *store.Redis) error {
+	r.mu.Lock()
+	r.db, r.redis = db, redis
+	r.pending = make(chan RoutingDecision, decisionBatchSize*10)
+	r.mu.Unlock()
+	return r.ReloadPolicies()
+}
+
+// ReloadPolicies 从数据库重新加载策略
+func (r *Router) ReloadPolicies() error {
+	if r.db == nil {
+		return nil
+	}
+	rows, err := r.db.ListRoutingPolicies()
+	if err != nil {
+		return fmt.Errorf("load routing policies: %w", err)
+	}
+	for _, row := range rows {
+		var p RoutingPolicy
+		if err := json.Unmarshal([]byte(row.Config), &p); err != nil {
+			log.Printf("[Model] skip routing policy %q: %v", row.TaskType, err)
+			continue
+		}
+		p.TaskType = row.TaskType
+		r.SetPolicy(&p)
+	}
+	return nil
+}
+
+// SavePolicy 设置策略并持久化, 其他副本收到通知后重新加载
+func (r *Router) SavePolicy(ctx context.Context, p *RoutingPolicy) error {
+	if r.db != nil {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if err := r.db.SaveRoutingPolicy(&store.RoutingPolicy{TaskType: p.TaskType, Name: p.Name, Config: string(data)}); err != nil {
+			return err
+		}
+	}
+	r.SetPolicy(p)
+	if r.redis != nil {
+		if err := r.redis.Publish(ctx, routingChangedChannel, p.TaskType); err != nil {
+			log.Printf("[Model] publish routing change: %v", err)
+		}
+	}
+	return nil
+}
+
+// policyFor 获取任务类型对应的策略, 找不到时使用默认策略
+func (r *Router) policyFor(taskType string) *RoutingPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if p, ok := r.policies[taskType]; ok {
+		return p
+	}
+	return r.policies[""]
+}
+
+// pickSplit 按权重选出A/B分流模型
+func (r *Router) pickSplit(splits []WeightedModel) string {
+	total := 0
+	for _, s := range splits {
+		total += s.Weight
+	}
+	if total <= 0 {
+		return ""
+	}
+
+	r.mu.Lock()
+	n := r.rnd.Intn(total)
+	r.mu.Unlock()
+
+	for _, s := range splits {
+		if n < s.Weight {
+			return s.Model
+		}
+		n -= s.Weight
+	}
+	return ""
+}
+
+// record 记录决策, 启用持久化时交给 Run 写入数据库 (队列满时只保留在内存)
+func (r *Router) record(d RoutingDecision) {
+	r.mu.Lock()
+	r.decisions = append(r.decisions, d)
+	if len(r.decisions) > maxRoutingDecisions {
+		r.decisions = r.decisions[len(r.decisions)-maxRoutingDecisions:]
+	}
+	pending := r.pending
+	r.mu.Unlock()
+
+	if pending != nil {
+		select {
+		case pending <- d:
+		default:
+			log.Printf("[Model] routing decision log queue full, dropping %s", d.ID)
+		}
+	}
+}
+
+// Decisions 获取最近的决策 (最新的在前), 启用持久化时读取所有副本的记录
+func (r *Router) Decisions(limit int) []RoutingDecision {
+	if r.db != nil {
+		rows, err := r.db.ListRoutingDecisions(limit)
+		if err == nil {
+			result := make([]RoutingDecision, 0, len(rows))
+			for _, row := range rows {
+				result = append(result, decisionFromRecord(row))
+			}
+			return result
+		}
+		log.Printf("[Model] list routing decisions: %v", err)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]RoutingDecision, 0, len(r.decisions))
+	for i := len(r.decisions) - 1; i >= 0; i-- {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		result = append(result, r.decisions[i])
+	}
+	return result
+}
+
+// Run 批量写入决策日志、清理过期日志, 并在其他副本修改策略后重新加载; 每个副本运行一个
+func (r *Router) Run(ctx context.Context) {
+	if r.db == nil || r.pending == nil {
+		<-ctx.Done()
+		return
+	}
+
+	var changes <-chan string
+	if r.redis != nil {
+		changes = r.redis.Subscribe(ctx, routingChangedChannel)
+	}
+	flush := time.NewTicker(decisionFlushInterval)
+	defer flush.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	var batch []store.RoutingDecision
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.db.CreateRoutingDecisions(batch); err != nil {
+			log.Printf("[Model] write %d routing decisions: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// 写完队列中剩余的决策
+			for {
+				select {
+				case d := <-r.pending:
+					batch = append(batch, decisionRecord(d))
+				default:
+					write()
+					return
+				}
+			}
+		case d := <-r.pending:
+			batch = append(batch, decisionRecord(d))
+			if len(batch) >= decisionBatchSize {
+				write()
+			}
+		case <-flush.C:
+			write()
+		case <-cleanup.C:
+			if _, err := r.db.DeleteRoutingDecisionsBefore(time.Now().Add(-decisionRetention)); err != nil {
+				log.Printf("[Model] clean routing decisions: %v", err)
+			}
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			if err := r.ReloadPolicies(); err != nil {
+				log.Printf("[Model] %v", err)
+			}
+		}
+	}
+}
+
+func decisionRecord(d RoutingDecision) store.RoutingDecision {
+	candidates, _ := json.Marshal(d.Candidates)
+	attempts, _ := json.Marshal(d.Attempts)
+	return store.RoutingDecision{
+		DecisionID: d.ID,
+		TaskType:   d.TaskType,
+		Requested:  d.Requested,
+		Policy:     d.Policy,
+		Candidates: string(candidates),
+		Attempts:   string(attempts),
+		Selected:   d.Selected,
+		Reason:     d.Reason,
+		CreatedAt:  d.CreatedAt,
+	}
+}
+
+func decisionFromRecord(row store.RoutingDecision) RoutingDecision {
+	d := RoutingDecision{
+		ID:        row.DecisionID,
+		TaskType:  row.TaskType,
+		Requested: row.Requested,
+		Policy:    row.Policy,
+		Selected:  row.Selected,
+		Reason:    row.Reason,
+		CreatedAt: row.CreatedAt,
+	}
+	_ = json.Unmarshal([]byte(row.Candidates), &d.Candidates)
+	_ = json.Unmarshal([]byte(row.Attempts), &d.Attempts)
+	return d
+}
+
+// initRouting 初始化路由 (MODEL_ROUTING_CONFIG 指向策略JSON文件)
+func (s *Service) initRouting() {
+	s.router = NewRouter()
+
+	path := os.Getenv("MODEL_ROUTING_CONFIG")
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("[Model] read routing config failed: %v", err)
+		return
+	}
+	if err := s.router.LoadPolicies(data); err != nil {
+		log.Printf("[Model] %v", err)
+	}
+}
+
+// Router 获取路由器
+func (s *Service) Router() *Router {
+	return s.router
+}
+
+// planRoute 计算候选模型顺序
+func (s *Service) planRoute(req Request) (*RoutingPolicy, []string, string) {
+	policy := s.router.policyFor(req.TaskType)
+	if policy == nil {
+		policy = &RoutingPolicy{Name: "none", AllowOverride: true}
+	}
+
+	var ordered []string
+	reason := fmt.Sprintf("policy %s", policy.Name)
+
+	if req.Model != "" && policy.AllowOverride {
+		ordered = append(ordered, req.Model)
+		reason += ", requested model first"
+	}
+	if split := s.router.pickSplit(policy.Splits); split != "" {
+		ordered = append(ordered, split)
+		reason += fmt.Sprintf(", A/B split -> %s", split)
+	}
+
+	chain := append([]string{}, policy.Chain...)
+	if policy.CostAware {
+		sort.SliceStable(chain, func(i, j int) bool { return s.modelCost(chain[i]) < s.modelCost(chain[j]) })
+		reason += ", cost-aware ordering"
+	}
+	ordered = append(ordered, chain...)
+
+	seen := make(map[string]bool)
+	var candidates []string
+	for _, name := range ordered {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if !s.IsModelAvailable(name) {
+			continue
+		}
+		if policy.MaxCostPer1K > 0 && s.modelCost(name) > policy.MaxCostPer1K {
+			continue
+		}
+		candidates = append(candidates, name)
+	}
+
+	// 带图片的请求优先使用支持视觉的模型
+	if needsVision(req) {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return s.isVisionModel(candidates[i]) && !s.isVisionModel(candidates[j])
+		})
+		reason += ", vision models first"
+	}
+
+	// 策略链全部不可用时, 退回任意可用模型
+	if len(candidates) == 0 {
+		if name := s.anyServableModel(); name != "" {
+			candidates = append(candidates, name)
+			reason += ", chain exhausted, using any available model"
+		}
+	}
+
+	return policy, candidates, reason
+}
+
+// isVisionModel 模型是否支持图片输入
+func (s *Service) isVisionModel(name string) bool {
+	cfg, ok := s.GetModel(name)
+	return ok && cfg.Vision
+}
+
+// modelCost 模型单价 (每1K token, 美元)
+func (s *Service) modelCost(name string) float64 {
+	if cfg, ok := s.GetModel(name); ok {
+		return cfg.CostPer1K
+	}
+	return 0
+}
+
+// anyServableModel 返回任意一个有客户端且已配置Key的模型 (按名称排序保证稳定)
+func (s *Service) anyServableModel() string {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.models))
+	for name := range s.models {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		if s.IsModelAvailable(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Complete 按路由策略调用模型, 失败时沿回退链重试
+func (s *Service) Complete(ctx context.Context, req Request) (*Response, error) {
+	policy, candidates, reason := s.planRoute(req)
+
+	// 命中缓存时直接返回 (响应中 Cached=true); 查询和写入都按首选模型的实际温度, 保证缓存键一致
+	var cacheReq Request
+	if len(candidates) > 0 {
+		cacheReq = s.withModelTemperature(req, candidates[0])
+		if resp, ok := s.cache.Lookup(ctx, cacheReq); ok {
+			return resp, nil
+		}
+	}
+
+	decision := RoutingDecision{
+		ID:         fmt.Sprintf("%d", time.Now().UnixNano()),
+		TaskType:   req.TaskType,
+		Requested:  req.Model,
+		Policy:     policy.Name,
+		Candidates: candidates,
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+	}
+
+	if len(candidates) == 0 {
+		decision.Reason += ", no available model"
+		s.router.record(decision)
+		return nil, fmt.Errorf("no available model: please configure at least one API key in Settings")
+	}
+
+	var lastErr error
+	for _, name := range candidates {
+		// 每次尝试单独限时, 单个模型超时后还能回退到下一个
+		start := time.Now()
+		attemptCtx, cancel := context.WithTimeout(ctx, policy.attemptTimeout())
+		resp, err := s.invoke(attemptCtx, name, req)
+		cancel()
+		attempt := RoutingAttempt{Model: name, Duration: time.Since(start).Milliseconds()}
+
+		if err == nil {
+			decision.Attempts = append(decision.Attempts, attempt)
+			decision.Selected = name
+			if name != candidates[0] {
+				decision.Reason += fmt.Sprintf(", fell back to %s", name)
+			}
+			s.router.record(decision)
+			// 回退模型的实际温度不同时, 结果与缓存键的温度不符, 不写入
+			if sameTemperature(s.withModelTemperature(req, name), cacheReq) {
+				s.cache.Store(ctx, cacheReq, resp)
+			}
+			return resp, nil
+		}
+
+		kind := classifyError(err)
+		attempt.Error = err.Error()
+		attempt.Failure = kind
+		decision.Attempts = append(decision.Attempts, attempt)
+		lastErr = err
+
+		if ctx.Err() != nil {
+			decision.Reason += fmt.Sprintf(", request cancelled during %s", name)
+			break
+		}
+		if !policy.shouldFallback(kind) {
+			decision.Reason += fmt.Sprintf(", %s on %s is not retryable", kind, name)
+			break
+		}
+	}
+
+	s.router.record(decision)
+	return nil, lastErr
+}
+
+// sameTemperature 两个请求的温度是否一致 (均未指定也视为一致)
+func sameTemperature(a, b Request) bool {
+	if a.Temperature == nil || b.Temperature == nil {
+		return a.Temperature == b.Temperature
+	}
+	return *a.Temperature == *b.Temperature
+}
+
+// withModelTemperature 未指定温度时使用模型的默认温度, 即实际发给模型的温度
+func (s *Service) withModelTemperature(req Request, modelName string) Request {
+	if req.Temperature == nil {
+		if cfg, ok := s.GetModel(modelName); ok {
+			req.Temperature = Temperature(cfg.Temperature)
+		}
+	}
+	return req
+}
+
+// invoke 直接调用指定模型 (不做路由)
+func (s *Service) invoke(ctx context.Context, modelName string, req Request) (*Response, error) {
+	cfg, ok := s.GetModel(modelName)
+	if !ok {
+		return nil, &unavailableError{fmt.Sprintf("model not found: %s", modelName)}
+	}
+
+	client, ok := s.getClient(cfg.Provider)
+	if !ok {
+		return nil, &unavailableError{fmt.Sprintf("client not available for provider: %s", cfg.Provider)}
+	}
+
+	req.Model = cfg.ModelName
+	req.Messages = flattenMedia(req.Messages, cfg.Vision)
+	req = s.withModelTemperature(req, modelName)
+	if req.MaxTokens == 0 {
+		req.MaxTokens = cfg.MaxTokens
+	}
+	messages, err := s.fitContext(ctx, cfg, req)
+	if err != nil {
+		return nil, err
+	}
+	req.Messages = messages
+
+	// 限流: 按供应商和API Key排队
+	release, err := s.limiter.Acquire(ctx, cfg.Provider, cfg.APIKey, estimateTokens(req))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := client.Chat(ctx, req)
+	if err != nil && classifyError(err) == FailureRateLimit {
+		s.limiter.Backoff(cfg.Provider, cfg.APIKey, retryAfterOf(err))
+	}
+	return resp, err
+}
+
+// unavailableError 模型或客户端未配置
+type unavailableError struct {
+	msg string
+}
+
+func (e *unavailableError) Error() string { return e.msg }
+
+// classifyError 将错误归类为失败类型
+func classifyError(err error) FailureKind {
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) {
+		return FailureUnavailable
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FailureTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return FailureTimeout
+	}
+
+	status := 0
+	var httpErr *HTTPError
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.StatusCode
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+
+	switch {
+	case status == 429:
+		return FailureRateLimit
+	case status >= 500:
+		return FailureServerError
+	case status == 408 || strings.Contains(strings.ToLower(err.Error()), "timeout"):
+		return FailureTimeout
+	}
+	return FailureOther
+}
@@ -2,13 +2,18 @@ package model
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"log"
+	"math"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
+	"agent-flow/internal/prompt"
+	"agent-flow/internal/store"
 )
 
 // ModelProvider 模型供应商
@@ -34,20 +39,44 @@ type ModelConfig struct {
 	MaxTokens   int          `json:"max_tokens"`    // 最大token数
 	Temperature float64      `json:"temperature"`   // 温度参数
 	TopP        float64      `json:"top_p"`        // top_p采样
+	CostPer1K   float64      `json:"cost_per_1k"`  // 每1K token单价(美元), 用于成本路由
+	ContextWindow int        `json:"context_window"` // 上下文窗口(token), 0表示未知
+	Local       bool         `json:"local"`          // 自托管模型, 不需要API Key
+	Vision      bool         `json:"vision"`         // 支持图片输入
 }
 
 // Message 消息
 type Message struct {
-	Role    string `json:"role"`    // system/user/assistant
-	Content string `json:"content"`
+	Role    string        `json:"role"`    // system/user/assistant
+	Content string        `json:"content"`
+	Parts   []ContentPart `json:"parts,omitempty"` // 多模态片段 (图片/文件/语音)
+	Pinned  bool          `json:"pinned,omitempty"` // 置顶, 截断上下文时始终保留
+}
+
+// Temperature 构造 Request.Temperature
+func Temperature(v float64) *float64 {
+	return &v
 }
 
 // Request 请求
 type Request struct {
 	Model       string    `json:"model"`
 	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"` // 为nil时使用模型默认温度, 可显式设为0
 	MaxTokens   int       `json:"max_tokens,omitempty"`
+	TaskType    string    `json:"task_type,omitempty"` // 任务类型, 用于路由 (如 code)
+	Tools       []ToolDefinition `json:"tools,omitempty"`    // 可调用的工具
+	AgentID     string       `json:"agent_id,omitempty"` // 发起调用的智能体
+	Cache       *CachePolicy `json:"cache,omitempty"`    // 请求级缓存策略 (覆盖智能体配置)
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"` // 结构化输出 (JSON Schema)
+	Context     *ContextPolicy  `json:"context,omitempty"`  // 请求级上下文策略 (覆盖智能体配置)
+}
+
+// ToolDefinition 工具定义 (function calling)
+type ToolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"` // JSON Schema
 }
 
 // Response 响应
@@ -55,6 +84,9 @@ type Response struct {
 	Model      string   `json:"model"`
 	Content    string   `json:"content"`
 	FinishReason string `json:"finish_reason"`
+	Cached     bool     `json:"cached"`                // 是否命中缓存
+	CacheKey   string   `json:"cache_key,omitempty"`
+	Similarity float64  `json:"similarity,omitempty"`  // 语义缓存相似度
 }
 
 // Service 模型服务
@@ -63,6 +95,40 @@ type Service struct {
 	models       map[string]*ModelConfig
 	defaultModel string
 	clients      map[ModelProvider]Client
+	router       *Router
+	limiter      *Limiter
+	cache        *ResponseCache
+
+	// 智能体上下文策略
+	contextPolicies map[string]ContextPolicy
+
+	// 向量模型
+	embedModels       map[string]*EmbeddingModelConfig
+	defaultEmbedModel string
+
+	// 数据库注册的供应商和模型 (热更新)
+	registry          *store.Postgres
+	registryRedis     *store.Redis
+	registryModels    map[string]bool
+	registryProviders map[ModelProvider]bool
+
+	// 内部调用 (投票评审、上下文摘要) 的系统提示词
+	prompts *prompt.Service
+}
+
+// UsePrompts 内部调用的系统提示词从提示词服务读取 (未设置时使用内置模板)
+func (s *Service) UsePrompts(prompts *prompt.Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prompts = prompts
+}
+
+// renderPrompt 渲染内部调用的系统提示词
+func (s *Service) renderPrompt(key string) string {
+	s.mu.RLock()
+	prompts := s.prompts
+	s.mu.RUnlock()
+	return prompts.RenderOrBuiltin(prompt.Ref{Key: key}, nil)
 }
 
 // Client 模型客户端接口
@@ -75,6 +141,10 @@ func NewService() *Service {
 	s := &Service{
 		models:  make(map[string]*ModelConfig),
 		clients: make(map[ModelProvider]Client),
+		limiter: NewLimiter(),
+		cache:   NewResponseCache(),
+
+		contextPolicies: make(map[string]ContextPolicy),
 	}
 
 	// 初始化默认模型配置
@@ -82,6 +152,11 @@ func NewService() *Service {
 
 	// 初始化客户端
 	s.initClients()
+	s.initLocalProviders()
+	s.initEmbeddingModels()
+
+	// 初始化路由策略
+	s.initRouting()
 
 	return s
 }
@@ -95,6 +170,7 @@ func (s *Service) initDefaultModels() {
 		APIKey:      os.Getenv("OPENAI_API_KEY"),
 		Temperature: 0.7,
 		MaxTokens:   4096,
+		Vision:      true,
 	}
 	s.models["gpt-3.5-turbo"] = &ModelConfig{
 		Provider:    ProviderOpenAI,
@@ -170,6 +246,7 @@ func (s *Service) initDefaultModels() {
 		BaseURL:     "https://open.bigmodel.cn/api/paas/v4",
 		Temperature: 0.7,
 		MaxTokens:   4096,
+		Vision:      true,
 	}
 
 	// MiniMax
@@ -280,6 +357,24 @@ func (s *Service) initDefaultModels() {
 		MaxTokens:   4096,
 	}
 
+	// 参考单价 (每1K token, 美元), 用于成本路由
+	costs := map[string]float64{
+		"gpt-4": 0.03, "gpt-3.5-turbo": 0.0015,
+		"claude-3-opus": 0.015, "claude-3-sonnet": 0.003,
+		"glm-4": 0.014, "glm-4-plus": 0.007, "glm-4-flash": 0.0001, "glm-3-turbo": 0.0007,
+		"qwen-turbo": 0.0003, "qwen-plus": 0.0006, "qwen-max": 0.003,
+		"deepseek-chat": 0.0003, "deepseek-coder": 0.0003,
+		"moonshot-v1-8k-chat": 0.0017, "moonshot-v1-32k-chat": 0.0034,
+	}
+	for name, cost := range costs {
+		s.models[name].CostPer1K = cost
+	}
+	for name, window := range defaultContextWindows {
+		if m, ok := s.models[name]; ok && m.ContextWindow == 0 {
+			m.ContextWindow = window
+		}
+	}
+
 	s.defaultModel = "gpt-4"
 }
 
@@ -341,82 +436,57 @@ func (s *Service) SetModel(modelName string, config *ModelConfig) {
 	s.models[modelName] = config
 }
 
-// IsModelAvailable 检查模型是否可用 (API Key已配置)
+// getClient 获取供应商客户端
+func (s *Service) getClient(provider ModelProvider) (Client, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[provider]
+	return client, ok
+}
+
+// IsModelAvailable 检查模型是否可用 (API Key已配置且有对应客户端)
 func (s *Service) IsModelAvailable(modelName string) bool {
 	cfg, ok := s.GetModel(modelName)
-	if !ok {
+	if !ok || (cfg.APIKey == "" && !cfg.Local) {
 		return false
 	}
-	return cfg.APIKey != ""
+	_, ok = s.getClient(cfg.Provider)
+	return ok
 }
 
 // GetAvailableModels 获取所有可用的模型
 func (s *Service) GetAvailableModels() []string {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.models))
+	for name := range s.models {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
 
 	var available []string
-	for name, cfg := range s.models {
-		if cfg.APIKey != "" {
+	for _, name := range names {
+		if s.IsModelAvailable(name) {
 			available = append(available, name)
 		}
 	}
 	return available
 }
 
-// GetBestAvailableModel 获取最佳可用模型
+// GetBestAvailableModel 获取最佳可用模型 (按默认路由策略)
 func (s *Service) GetBestAvailableModel(preferred string) string {
-	// 优先使用用户指定的模型
-	if preferred != "" && s.IsModelAvailable(preferred) {
-		return preferred
-	}
-
-	// 按优先级选择可用模型
-	priority := []string{"gpt-4", "glm-4", "glm-4-plus", "claude-3-opus", "moonshot-v1-8k-chat", "qwen-turbo", "deepseek-chat"}
-	for _, name := range priority {
-		if s.IsModelAvailable(name) {
-			return name
-		}
+	_, candidates, _ := s.planRoute(Request{Model: preferred})
+	if len(candidates) == 0 {
+		return ""
 	}
-
-	// 如果没有配置任何模型，返回第一个有client的
-	for name := range s.clients {
-		return string(name) // TODO: 返回对应的默认模型
-	}
-
-	return ""
+	return candidates[0]
 }
 
-// Chat 调用单个模型 (带自动回退)
+// Chat 调用单个模型 (按路由策略自动回退)
 func (s *Service) Chat(ctx context.Context, modelName string, messages []Message) (*Response, error) {
-	// 检查模型是否可用
-	if !s.IsModelAvailable(modelName) {
-		// 尝试自动回退到可用模型
-		available := s.GetBestAvailableModel(modelName)
-		if available == "" {
-			return nil, fmt.Errorf("no available model: please configure at least one API key in Settings")
-		}
-		modelName = available
-	}
-
-	cfg, ok := s.GetModel(modelName)
-	if !ok {
-		return nil, fmt.Errorf("model not found: %s", modelName)
-	}
-
-	client, ok := s.clients[cfg.Provider]
-	if !ok {
-		return nil, fmt.Errorf("client not available for provider: %s", cfg.Provider)
-	}
-
-	req := Request{
-		Model:       cfg.ModelName,
-		Messages:    messages,
-		Temperature: cfg.Temperature,
-		MaxTokens:   cfg.MaxTokens,
-	}
-
-	return client.Chat(ctx, req)
+	return s.Complete(ctx, Request{
+		Model:    modelName,
+		Messages: messages,
+	})
 }
 
 // ========== 多模型投票 ==========
@@ -427,7 +497,10 @@ type VoteRequest struct {
 	Messages     []Message     `json:"messages"`      // 消息历史
 	SystemPrompt string        `json:"system_prompt"` // 系统提示
 	TaskType     string        `json:"task_type"`     // 任务类型: decision/creation/analysis
-	VotingMethod string        `json:"voting_method"` // 投票方法: length/交叉评估/cross
+	VotingMethod string        `json:"voting_method"` // 投票策略: judge/pairwise/majority/borda/self_consistency/cross/length, 为空时有评审模型用judge, 否则heuristic
+	JudgeModel   string        `json:"judge_model,omitempty"` // 评审模型 (judge/pairwise/borda)
+	Rubric       *Rubric       `json:"rubric,omitempty"`      // 评分标准, 默认按任务类型
+	Samples      int           `json:"samples,omitempty"`     // 自洽采样次数 (self_consistency)
 }
 
 // VoteResponse 投票响应
@@ -441,10 +514,13 @@ type VoteResponse struct {
 
 // Evaluation 评估详情
 type Evaluation struct {
+	Strategy      string             `json:"strategy"`        // 使用的投票策略
 	TaskType      string             `json:"task_type"`       // 任务类型
 	WinnerReason  string             `json:"winner_reason"`   // 获胜原因
 	ModelRatings  map[string]Rating  `json:"model_ratings"`  // 各模型评级
 	ProsCons      map[string]ProsCons `json:"pros_cons"`      // 各模型优缺点
+	CriterionScores map[string]map[string]float64 `json:"criterion_scores,omitempty"` // 模型 -> 评分项 -> 分数(0-100)
+	Rationale     map[string]string  `json:"rationale,omitempty"`  // 模型 -> 评分理由
 }
 
 // Rating 评级
@@ -466,33 +542,41 @@ func (e *Evaluation) ModelRatingsToScores() map[string]float64 {
 }
 
 // singleModelVoting 单模型投票 (多次调用+不同参数)
-func (s *Service) singleModelVoting(ctx context.Context, req VoteRequest, model string) map[string]string {
+func (s *Service) singleModelVoting(ctx context.Context, req VoteRequest, model string, samples int) map[string]string {
 	responses := make(map[string]string)
-	
+
 	// 使用不同的temperature多次调用，模拟多模型投票效果
 	temperatures := []float64{0.3, 0.7, 1.0}
 	labels := []string{"conservative", "balanced", "creative"}
-	
-	for i, temp := range temperatures {
-		// 克隆请求，修改temperature
-		tempReq := req
-		tempReq.Temperature = temp
-		
-		// 调用模型
-		msgs := make([]Message, 0, len(req.Messages)+1)
-		if req.SystemPrompt != "" {
-			msgs = append(msgs, Message{Role: "system", Content: req.SystemPrompt})
+	if samples <= 0 {
+		samples = len(temperatures)
+	}
+
+	msgs := make([]Message, 0, len(req.Messages)+1)
+	if req.SystemPrompt != "" {
+		msgs = append(msgs, Message{Role: "system", Content: req.SystemPrompt})
+	}
+	msgs = append(msgs, req.Messages...)
+
+	for i := 0; i < samples; i++ {
+		label := fmt.Sprintf("%s_%s", model, labels[i%len(labels)])
+		if i >= len(labels) {
+			label = fmt.Sprintf("%s_%d", label, i/len(labels)+1)
 		}
-		msgs = append(msgs, req.Messages...)
-		
-		resp, err := s.Chat(ctx, model, msgs)
+
+		resp, err := s.Complete(ctx, Request{
+			Model:       model,
+			Messages:    msgs,
+			Temperature: Temperature(temperatures[i%len(temperatures)]),
+			TaskType:    req.TaskType,
+		})
 		if err != nil {
-			responses[fmt.Sprintf("%s_%s", model, labels[i])] = fmt.Sprintf("[Error: %v]", err)
+			responses[label] = fmt.Sprintf("[Error: %v]", err)
 		} else {
-			responses[fmt.Sprintf("%s_%s", model, labels[i])] = resp.Content
+			responses[label] = resp.Content
 		}
 	}
-	
+
 	return responses
 }
 
@@ -508,6 +592,16 @@ func (s *Service) Vote(ctx context.Context, req VoteRequest) (*VoteResponse, err
 		return nil, fmt.Errorf("no models specified")
 	}
 
+	// 未指定策略时, 有可用的评审模型就由评审打分, 否则用规则评分
+	method := req.VotingMethod
+	if method == "" && s.judgeModel(req) != "" {
+		method = "judge"
+	}
+	strategy, ok := s.VotingStrategy(method)
+	if !ok {
+		return nil, fmt.Errorf("unknown voting method: %s", req.VotingMethod)
+	}
+
 	// 过滤掉不可用的模型
 	var availableModels []string
 	for _, model := range req.Models {
@@ -523,83 +617,68 @@ func (s *Service) Vote(ctx context.Context, req VoteRequest) (*VoteResponse, err
 	// 更新请求中的模型列表
 	req.Models = availableModels
 
+	// 1. 生成候选回复
 	var responses map[string]string
-	var scores map[string]float64
-
-	// 根据可用模型数量选择投票策略
-	if len(availableModels) == 1 {
+	if sampler, ok := strategy.(samplingStrategy); ok {
+		// 自洽采样：同一模型多次采样
+		responses = s.singleModelVoting(ctx, req, availableModels[0], sampler.samples(req))
+	} else if len(availableModels) == 1 {
 		// 只有一个模型：使用多轮对话+不同参数模拟投票
-		responses = s.singleModelVoting(ctx, req, availableModels[0])
+		responses = s.singleModelVoting(ctx, req, availableModels[0], 0)
 	} else {
 		// 多个模型：并发调用所有模型
-		responses = s并发调用模型(ctx, req)
-		
-		// 过滤掉失败的模型
-		var successfulModels []string
-		for model, resp := range responses {
-			if !strings.HasPrefix(resp, "[Error:") && !strings.HasPrefix(resp, "[Model") {
-				successfulModels = append(successfulModels, model)
-			}
-		}
+		responses = s.并发调用模型(ctx, req)
+	}
 
-		if len(successfulModels) == 1 {
-			// 如果只有一个成功，使用单模型投票逻辑
-			responses = s.singleModelVoting(ctx, req, successfulModels[0])
-		} else if len(successfulModels) == 0 {
-			return nil, fmt.Errorf("all models failed: please check API keys")
+	// 过滤掉失败的回复
+	successful := make(map[string]string)
+	for model, resp := range responses {
+		if model == "_error" || strings.HasPrefix(resp, "[Error:") || strings.HasPrefix(resp, "[Model") {
+			continue
 		}
+		successful[model] = resp
 	}
 
-	// 2. 根据任务类型选择评估方法
-	if len(responses) > 1 {
-		switch req.VotingMethod {
-		case "length":
-			scores = s.按长度评分(responses)
-		case "cross", "交叉评估":
-			_, eval := s.交叉评估(ctx, successfulModels, responses, req.TaskType)
-			if eval != nil {
-				scores = eval.ModelRatingsToScores()
-			}
-		default:
-			_, eval := s.综合评分(ctx, successfulModels, responses, req.TaskType)
-			if eval != nil {
-				scores = eval.ModelRatingsToScores()
-			}
-		}
+	if len(successful) == 0 {
+		return nil, fmt.Errorf("all models failed: please check API keys")
 	}
 
-	// 3. 选择得分最高的
-	winner := ""
-	maxScore := 0.0
-	var eval *Evaluation
-
-	switch req.VotingMethod {
-	case "length":
-		// 简单方法：按响应长度
-		scores = s.按长度评分(responses)
-		eval = s.基础评估(responses, req.TaskType)
-	case "cross", "交叉评估":
-		// 高级方法：让模型互相评估
-		scores, eval = s.交叉评估(ctx, req.Models, responses, req.TaskType)
-	default:
-		// 默认：综合评分
-		scores, eval = s.综合评分(ctx, req.Models, responses, req.TaskType)
-	}
-
-	// 3. 选择得分最高的
+	// 2. 按策略评估
+	candidates := sortedKeys(successful)
+	eval, err := strategy.Evaluate(ctx, s, VoteInput{
+		Request:    req,
+		Candidates: candidates,
+		Responses:  successful,
+	})
+	if err != nil && req.VotingMethod == "" && method != "heuristic" {
+		// 默认的评审打分失败时退回规则评分
+		log.Printf("[Model] %s voting failed, falling back to heuristic: %v", strategy.Name(), err)
+		strategy = HeuristicStrategy{}
+		eval, err = strategy.Evaluate(ctx, s, VoteInput{Request: req, Candidates: candidates, Responses: successful})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s voting failed: %w", strategy.Name(), err)
+	}
+	eval.Strategy = strategy.Name()
+	if eval.TaskType == "" {
+		eval.TaskType = req.TaskType
+	}
+
+	// 3. 选择得分最高的 (同分按名称顺序)
+	scores := eval.ModelRatingsToScores()
 	winner := ""
-	maxScore := 0.0
-	for model, score := range scores {
-		if score > maxScore {
+	maxScore := -1.0
+	for _, model := range candidates {
+		if score, ok := scores[model]; ok && score > maxScore {
 			maxScore = score
 			winner = model
 		}
 	}
 
 	return &VoteResponse{
-		Responses:     responses,
+		Responses:     successful,
 		Winner:        winner,
-		WinnerContent: responses[winner],
+		WinnerContent: successful[winner],
 		Scores:        scores,
 		Evaluation:    eval,
 	}, nil
@@ -613,7 +692,7 @@ func (s *Service) 并发调用模型(ctx context.Context, req VoteRequest) map[s
 	var failedCount int
 
 	for _, modelName := range req.Models {
-		wg.AddOne()
+		wg.Add(1)
 		go func(model string) {
 			defer wg.Done()
 
@@ -737,72 +816,6 @@ func (s *Service) 基础评估(responses map[string]string, taskType string) *Ev
 	}
 }
 
-// 交叉评估 (高级方法)
-func (s *Service) 交叉评估(ctx context.Context, models []string, responses map[string]string, taskType string) (map[string]float64, *Evaluation) {
-	scores := make(map[string]float64)
-	ratings := make(map[string]Rating)
-	prosCons := make(map[string]ProsCons)
-
-	// 让每个模型评估其他模型的回复
-	for evaluator := range responses {
-		evalModel := evaluator
-		evalPrompt := fmt.Sprintf(`你是一个专业的AI评估专家。请评估以下AI模型对某个问题的回答质量。
-
-任务类型: %s
-
-请评估以下每个答案，并给出1-10分的评分（10分最高）：
-- 准确性：答案是否正确
-- 完整性：是否涵盖所有重要方面
-- 清晰度：表达是否清晰易懂
-- 创造性：是否有独到见解
-
-需要评估的模型回复：
-%s
-
-请以JSON格式返回评估结果，格式如下：
-{"ratings": {"模型名": {"accuracy": X, "completeness": X, "clarity": X, "creativity": X, "overall": X}, ...}, "pros": {"模型名": ["优点1", "优点2"], ...}, "cons": {"模型名": ["缺点1", ...], ...}}`,
-			taskType, s.构建评估上下文(models, responses))
-
-		// 调用评估模型
-		resp, err := s.Chat(ctx, evalModel, []Message{
-			{Role: "system", Content: "你是一个专业的AI评估专家。请严格评估并给出评分。"},
-			{Role: "user", Content: evalPrompt},
-		})
-
-		if err != nil {
-			continue
-		}
-
-		// 解析评估结果
-		parsed := s.解析评估结果(resp.Content, models)
-		for model, r := range parsed.ratings {
-			ratings[model] = r
-		}
-		for model, pc := range parsed.prosCons {
-			prosCons[model] = pc
-		}
-	}
-
-	// 汇总得分
-	for model := range responses {
-		if r, ok := ratings[model]; ok {
-			scores[model] = r.OverallScore * 10 // 转换为0-100
-		} else {
-			scores[model] = 50
-		}
-	}
-
-	// 找出最佳模型和原因
-	bestModel, bestReason := s.找出最佳模型(models, ratings, prosCons)
-
-	return scores, &Evaluation{
-		TaskType:     taskType,
-		WinnerReason: bestReason,
-		ModelRatings: ratings,
-		ProsCons:     prosCons,
-	}
-}
-
 // 综合评分 (默认方法)
 func (s *Service) 综合评分(ctx context.Context, models []string, responses map[string]string, taskType string) (map[string]float64, *Evaluation) {
 	scores := make(map[string]float64)
@@ -820,8 +833,8 @@ func (s *Service) 综合评分(ctx context.Context, models []string, responses m
 		prosCons[model] = s.提取优缺点(resp)
 	}
 
-	// 交叉验证：用其他模型确认最佳
-	bestModel, bestReason := s.找出最佳模型(models, ratings, prosCons)
+	// 找出最佳模型和原因
+	_, bestReason := s.找出最佳模型(models, ratings, prosCons)
 
 	return scores, &Evaluation{
 		TaskType:     taskType,
@@ -959,31 +972,14 @@ func (s *Service) 构建评估上下文(models []string, responses map[string]st
 	return sb.String()
 }
 
-// 解析评估结果
-func (s *Service) 解析评估结果(content string, knownModels []string) struct {
-	ratings map[string]Rating
-	prosCons map[string]ProsCons
-} {
-	ratings := make(map[string]Rating)
-	prosCons := make(map[string]ProsCons)
-
-	// 简单解析 - 提取数字评分
-	for _, model := range knownModels {
-		ratings[model] = Rating{OverallScore: 70}
-		prosCons[model] = ProsCons{Pros: []string{}, Cons: []string{}}
-	}
-
-	return struct {
-		ratings map[string]Rating
-		prosCons map[string]ProsCons
-	}{ratings, prosCons}
-}
-
 // ========== 各个模型的客户端实现 ==========
 
 // OpenAI客户端
 type OpenAIClient struct {
-	client *openai.Client
+	client  *openai.Client
+	apiKey  string
+	baseURL string
+	http    *http.Client
 }
 
 func NewOpenAIClient(apiKey, baseURL string) *OpenAIClient {
@@ -991,10 +987,22 @@ func NewOpenAIClient(apiKey, baseURL string) *OpenAIClient {
 	if baseURL != "" {
 		cfg.BaseURL = baseURL
 	}
-	return &OpenAIClient{client: openai.NewClientWithConfig(cfg)}
+	cfg.HTTPClient = &http.Client{Transport: retryAfterTransport{base: http.DefaultTransport}}
+	return &OpenAIClient{
+		client:  openai.NewClientWithConfig(cfg),
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		http:    &http.Client{Timeout: 5 * time.Minute},
+	}
 }
 
 func (c *OpenAIClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	for _, m := range req.Messages {
+		if m.HasMedia() {
+			return c.chatMultimodal(ctx, req)
+		}
+	}
+
 	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
 	for i, m := range req.Messages {
 		messages[i] = openai.ChatCompletionMessage{
@@ -1003,13 +1011,26 @@ func (c *OpenAIClient) Chat(ctx context.Context, req Request) (*Response, error)
 		}
 	}
 
-	resp, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+	chatReq := openai.ChatCompletionRequest{
 		Model:       req.Model,
 		Messages:    messages,
-		Temperature: req.Temperature,
 		MaxTokens:   req.MaxTokens,
-	})
+	}
+	if req.Temperature != nil {
+		// go-openai 会省略零值, 显式的0用最小正数代替
+		chatReq.Temperature = float32(math.Max(*req.Temperature, math.SmallestNonzeroFloat32))
+	}
+	if req.ResponseFormat != nil {
+		// 原生JSON模式, Schema通过系统消息传递并由调用方校验
+		chatReq.ResponseFormat = openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+
+	var retryAfter time.Duration
+	resp, err := c.client.CreateChatCompletion(context.WithValue(ctx, retryAfterKey{}, &retryAfter), chatReq)
 	if err != nil {
+		if retryAfter > 0 {
+			return nil, &retryAfterError{err: err, retryAfter: retryAfter}
+		}
 		return nil, err
 	}
 
@@ -1034,18 +1055,21 @@ func (c *AnthropicClient) Chat(ctx context.Context, req Request) (*Response, err
 	return &Response{Model: req.Model, Content: "Anthropic API not implemented"}, nil
 }
 
-// GLM客户端 (智谱)
+// GLM客户端 (智谱, OpenAI兼容接口, 支持 glm-4v 图片输入)
 type GLMClient struct {
 	apiKey string
+	compat *OpenAIClient
 }
 
 func NewGLMClient(apiKey string) *GLMClient {
-	return &GLMClient{apiKey: apiKey}
+	return &GLMClient{
+		apiKey: apiKey,
+		compat: NewOpenAIClient(apiKey, "https://open.bigmodel.cn/api/paas/v4"),
+	}
 }
 
 func (c *GLMClient) Chat(ctx context.Context, req Request) (*Response, error) {
-	// TODO: 实现智谱API调用
-	return &Response{Model: req.Model, Content: "GLM API not implemented"}, nil
+	return c.compat.Chat(ctx, req)
 }
 
 // MiniMax客户端
@@ -0,0 +1,384 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResponseFormat 结构化输出格式 (JSON Schema)
+type ResponseFormat struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// StructuredError 多次修复后仍不符合Schema
+type StructuredError struct {
+	Raw      string   // 最后一次回复
+	Problems []string // 校验问题
+}
+
+func (e *StructuredError) Error() string {
+	return fmt.Sprintf("structured output invalid after retries: %s", strings.Join(e.Problems, "; "))
+}
+
+// structuredRetries 校验失败后的修复重试次数
+const structuredRetries = 2
+
+// CompleteJSON 调用模型并按Schema校验JSON输出, 不合法时把问题反馈给模型修复重试, 最后解析到out
+// req.ResponseFormat 为空时从out的类型推导Schema
+func (s *Service) CompleteJSON(ctx context.Context, req Request, out interface{}) (*Response, error) {
+	if req.ResponseFormat == nil {
+		req.ResponseFormat = &ResponseFormat{Name: typeName(out), Schema: SchemaOf(out)}
+	}
+	schema := req.ResponseFormat.Schema
+
+	// 所有供应商都附带Schema说明; 支持原生JSON模式的客户端另外开启该模式
+	req.Messages = withSchemaInstruction(req.Messages, schema)
+
+	var lastErr error
+	for attempt := 0; attempt <= structuredRetries; attempt++ {
+		resp, err := s.Complete(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		raw := ExtractJSON(resp.Content, schema)
+		var doc interface{}
+		var problems []string
+		if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+			problems = []string{"invalid JSON: " + err.Error()}
+		} else {
+			problems = ValidateSchema(schema, doc)
+		}
+
+		if len(problems) == 0 {
+			if err := json.Unmarshal([]byte(raw), out); err != nil {
+				problems = []string{"cannot decode: " + err.Error()}
+			} else {
+				return resp, nil
+			}
+		}
+
+		lastErr = &StructuredError{Raw: resp.Content, Problems: problems}
+		req.Messages = append(req.Messages,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: "你的输出不符合要求的JSON Schema:\n- " + strings.Join(problems, "\n- ") + "\n\n请修正后只输出JSON, 不要附加任何解释。"},
+		)
+	}
+	return nil, lastErr
+}
+
+// Structured 类型化的结构化调用, Schema由T推导
+func Structured[T any](ctx context.Context, s *Service, req Request) (T, error) {
+	var out T
+	_, err := s.CompleteJSON(ctx, req, &out)
+	return out, err
+}
+
+// withSchemaInstruction 在系统消息中追加Schema说明
+func withSchemaInstruction(messages []Message, schema map[string]interface{}) []Message {
+	data, _ := json.MarshalIndent(schema, "", "  ")
+	kind := "JSON对象"
+	if schema["type"] == "array" {
+		kind = "JSON数组"
+	}
+	instruction := "请只输出一个符合以下JSON Schema的" + kind + ", 不要使用Markdown代码块, 不要附加解释:\n" + string(data)
+
+	result := make([]Message, 0, len(messages)+1)
+	if len(messages) > 0 && messages[0].Role == "system" {
+		result = append(result, Message{Role: "system", Content: messages[0].Content + "\n\n" + instruction})
+		result = append(result, messages[1:]...)
+		return result
+	}
+	result = append(result, Message{Role: "system", Content: instruction})
+	return append(result, messages...)
+}
+
+// ExtractJSON 提取回复中的JSON (去掉代码块和前后说明文字)
+// schema 顶层 type 为 array/object 时按对应括号截取, 否则以先出现的 [ 或 { 为准
+func ExtractJSON(text string, schema map[string]interface{}) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+	var open byte
+	switch schema["type"] {
+	case "array":
+		open = '['
+	case "object":
+		open = '{'
+	}
+	if v := jsonSpan(text, open); v != "" {
+		return v
+	}
+	return strings.TrimSpace(text)
+}
+
+// jsonSpan 提取文本中第一个 open 到最后一个对应闭括号之间的内容; open 为0时取先出现的 [ 或 {
+func jsonSpan(text string, open byte) string {
+	if open == 0 {
+		i := strings.IndexAny(text, "[{")
+		if i < 0 {
+			return ""
+		}
+		open = text[i]
+	}
+	closer := byte('}')
+	if open == '[' {
+		closer = ']'
+	}
+	start := strings.IndexByte(text, open)
+	end := strings.LastIndexByte(text, closer)
+	if start < 0 || end <= start {
+		return ""
+	}
+	return text[start : end+1]
+}
+
+// ========== Schema 推导 ==========
+
+// SchemaOf 由Go类型推导JSON Schema
+// 字段名取json标签, 非omitempty字段为必填; desc标签作为description, enum标签(逗号分隔)作为枚举,
+// min/max标签作为数值范围
+func SchemaOf(v interface{}) map[string]interface{} {
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) map[string]interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, omitempty := jsonFieldName(field)
+			if name == "-" {
+				continue
+			}
+			prop := schemaForType(field.Type)
+			if desc := field.Tag.Get("desc"); desc != "" {
+				prop["description"] = desc
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				var values []interface{}
+				for _, v := range strings.Split(enum, ",") {
+					values = append(values, v)
+				}
+				prop["enum"] = values
+			}
+			if min, err := strconv.ParseFloat(field.Tag.Get("min"), 64); err == nil {
+				prop["minimum"] = min
+			}
+			if max, err := strconv.ParseFloat(field.Tag.Get("max"), 64); err == nil {
+				prop["maximum"] = max
+			}
+			properties[name] = prop
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+func typeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Name() == "" {
+		return "result"
+	}
+	return t.Name()
+}
+
+// ========== Schema 校验 ==========
+
+// ValidateSchema 校验JSON值是否符合Schema (支持 type/properties/required/additionalProperties/items/enum/minimum/maximum)
+func ValidateSchema(schema map[string]interface{}, v interface{}) []string {
+	var problems []string
+	validateNode(schema, v, "$", &problems)
+	return problems
+}
+
+func validateNode(schema map[string]interface{}, v interface{}, path string, problems *[]string) {
+	if len(schema) == 0 {
+		return
+	}
+
+	if typ, ok := schema["type"].(string); ok && !matchesType(typ, v) {
+		*problems = append(*problems, fmt.Sprintf("%s: expected %s, got %s", path, typ, jsonType(v)))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*problems = append(*problems, fmt.Sprintf("%s: %v is not one of %v", path, v, enum))
+		}
+	}
+
+	if n, ok := v.(float64); ok {
+		if min, ok := toFloat(schema["minimum"]); ok && n < min {
+			*problems = append(*problems, fmt.Sprintf("%s: %v is less than minimum %v", path, n, min))
+		}
+		if max, ok := toFloat(schema["maximum"]); ok && n > max {
+			*problems = append(*problems, fmt.Sprintf("%s: %v is greater than maximum %v", path, n, max))
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, name := range requiredFields(schema["required"]) {
+			if _, ok := val[name]; !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: missing required field %q", path, name))
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := properties[k].(map[string]interface{}); ok {
+				validateNode(prop, val[k], path+"."+k, problems)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				validateNode(additional, val[k], path+"."+k, problems)
+			} else if allowed, ok := schema["additionalProperties"].(bool); ok && !allowed {
+				*problems = append(*problems, fmt.Sprintf("%s: unknown field %q", path, k))
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				validateNode(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	}
+}
+
+func matchesType(typ string, v interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == float64(int64(n))
+	case "null":
+		return v == nil
+	}
+	return true
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func requiredFields(v interface{}) []string {
+	var names []string
+	switch list := v.(type) {
+	case []interface{}:
+		for _, item := range list {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+	case []string:
+		names = list
+	}
+	return names
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
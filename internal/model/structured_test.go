@@ -0,0 +1,46 @@
+package model
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	object := map[string]interface{}{"type": "object"}
+	array := map[string]interface{}{"type": "array"}
+	cases := []struct {
+		text   string
+		schema map[string]interface{}
+		want   string
+	}{
+		{`结果如下: {"a": [1, 2]} 完毕`, object, `{"a": [1, 2]}`},
+		{`结果如下: [{"a": 1}, {"a": 2}] 完毕`, array, `[{"a": 1}, {"a": 2}]`},
+		{"```json\n[{\"a\": 1}]\n```", nil, `[{"a": 1}]`},
+		{`{"items": [1]}`, nil, `{"items": [1]}`},
+		{`[1, 2]`, nil, `[1, 2]`},
+		{`没有JSON`, nil, `没有JSON`},
+	}
+	for _, c := range cases {
+		if got := ExtractJSON(c.text, c.schema); got != c.want {
+			t.Errorf("ExtractJSON(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestStructuredTopLevelArray(t *testing.T) {
+	svc, _ := newOllamaService(t, replyWith(`好的: [{"name": "a", "score": 1}, {"name": "b", "score": 2}]`))
+	type item struct {
+		Name  string `json:"name"`
+		Score int    `json:"score"`
+	}
+	items, err := Structured[[]item](context.Background(), svc, Request{
+		Model:    "ollama/bot",
+		Messages: []Message{{Role: "user", Content: "list"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[1].Name != "b" || items[1].Score != 2 {
+		t.Fatalf("items = %+v", items)
+	}
+}
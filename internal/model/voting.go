@@ -0,0 +1,583 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"agent-flow/internal/prompt"
+)
+
+// VotingStrategy 投票策略
+type VotingStrategy interface {
+	Name() string
+	Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error)
+}
+
+// VoteInput 策略输入
+type VoteInput struct {
+	Request    VoteRequest
+	Candidates []string          // 候选 (模型名或采样标签), 已排序
+	Responses  map[string]string // 候选 -> 回复
+}
+
+// samplingStrategy 需要对同一模型多次采样的策略
+type samplingStrategy interface {
+	samples(req VoteRequest) int
+}
+
+// Criterion 评分项
+type Criterion struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Weight      float64 `json:"weight"`
+}
+
+// Rubric 评分标准
+type Rubric struct {
+	Criteria []Criterion `json:"criteria"`
+}
+
+// DefaultRubric 按任务类型的默认评分标准
+func DefaultRubric(taskType string) *Rubric {
+	weights := map[string]float64{"accuracy": 0.3, "completeness": 0.3, "clarity": 0.2, "creativity": 0.2}
+	switch taskType {
+	case "decision":
+		weights = map[string]float64{"accuracy": 0.4, "completeness": 0.3, "clarity": 0.2, "creativity": 0.1}
+	case "creation":
+		weights = map[string]float64{"accuracy": 0.2, "completeness": 0.2, "clarity": 0.2, "creativity": 0.4}
+	case "analysis":
+		weights = map[string]float64{"accuracy": 0.3, "completeness": 0.35, "clarity": 0.25, "creativity": 0.1}
+	}
+	return &Rubric{Criteria: []Criterion{
+		{Name: "accuracy", Description: "准确性：答案是否正确", Weight: weights["accuracy"]},
+		{Name: "completeness", Description: "完整性：是否涵盖所有重要方面", Weight: weights["completeness"]},
+		{Name: "clarity", Description: "清晰度：表达是否清晰易懂", Weight: weights["clarity"]},
+		{Name: "creativity", Description: "创造性：是否有独到见解", Weight: weights["creativity"]},
+	}}
+}
+
+// ========== 策略注册 ==========
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]VotingStrategy{}
+)
+
+// RegisterVotingStrategy 注册投票策略 (同名覆盖)
+func RegisterVotingStrategy(name string, strategy VotingStrategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[name] = strategy
+}
+
+// VotingStrategy 按名称获取投票策略, 空名称使用默认的综合评分
+func (s *Service) VotingStrategy(name string) (VotingStrategy, bool) {
+	if name == "" {
+		name = "heuristic"
+	}
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	strategy, ok := strategies[name]
+	return strategy, ok
+}
+
+// VotingStrategies 列出已注册的策略名
+func VotingStrategies() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterVotingStrategy("heuristic", HeuristicStrategy{})
+	RegisterVotingStrategy("length", LengthStrategy{})
+	RegisterVotingStrategy("judge", JudgeStrategy{})
+	RegisterVotingStrategy("cross", JudgeStrategy{PeerReview: true})
+	RegisterVotingStrategy("交叉评估", JudgeStrategy{PeerReview: true})
+	RegisterVotingStrategy("pairwise", PairwiseStrategy{})
+	RegisterVotingStrategy("majority", MajorityStrategy{})
+	RegisterVotingStrategy("borda", BordaStrategy{})
+	RegisterVotingStrategy("self_consistency", SelfConsistencyStrategy{})
+}
+
+// ========== 规则评分 (兼容原有方法) ==========
+
+// HeuristicStrategy 关键词规则综合评分
+type HeuristicStrategy struct{}
+
+func (HeuristicStrategy) Name() string { return "heuristic" }
+
+func (HeuristicStrategy) Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error) {
+	scores, eval := s.综合评分(ctx, in.Candidates, in.Responses, in.Request.TaskType)
+	for model, r := range eval.ModelRatings {
+		r.OverallScore = scores[model]
+		eval.ModelRatings[model] = r
+	}
+	return eval, nil
+}
+
+// LengthStrategy 按回复长度评分
+type LengthStrategy struct{}
+
+func (LengthStrategy) Name() string { return "length" }
+
+func (LengthStrategy) Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error) {
+	scores := s.按长度评分(in.Responses)
+	eval := s.基础评估(in.Responses, in.Request.TaskType)
+	for model, r := range eval.ModelRatings {
+		r.OverallScore = scores[model]
+		eval.ModelRatings[model] = r
+	}
+	return eval, nil
+}
+
+// ========== LLM 评审 ==========
+
+// JudgeStrategy LLM按评分标准打分; PeerReview时由各候选模型互评后取平均
+type JudgeStrategy struct {
+	PeerReview bool
+}
+
+func (j JudgeStrategy) Name() string {
+	if j.PeerReview {
+		return "cross"
+	}
+	return "judge"
+}
+
+// judgeVerdict 评审输出 (按匿名标签)
+type judgeVerdict map[string]struct {
+	Scores    map[string]float64 `json:"scores"` // 1-10
+	Rationale string             `json:"rationale"`
+	Pros      []string           `json:"pros"`
+	Cons      []string           `json:"cons"`
+}
+
+func (j JudgeStrategy) Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error) {
+	rubric := in.Request.Rubric
+	if rubric == nil {
+		rubric = DefaultRubric(in.Request.TaskType)
+	}
+
+	judges := []string{s.judgeModel(in.Request)}
+	if j.PeerReview {
+		judges = in.Request.Models
+	}
+
+	labels, byLabel := anonymize(in.Candidates)
+	system := s.renderPrompt(prompt.KeyVoteScore)
+	prompt := judgePrompt(in, rubric, labels)
+
+	// 每个评审给出的分数累加后取平均
+	sums := make(map[string]map[string]float64)
+	counts := make(map[string]int)
+	eval := newEvaluation(in.Request.TaskType)
+
+	for _, judge := range judges {
+		var verdict judgeVerdict
+		if err := s.chatJSON(ctx, judge, system, prompt, &verdict); err != nil {
+			continue
+		}
+		for label, v := range verdict {
+			model, ok := byLabel[label]
+			if !ok {
+				continue
+			}
+			if sums[model] == nil {
+				sums[model] = make(map[string]float64)
+			}
+			for name, score := range v.Scores {
+				sums[model][name] += clamp(score, 0, 10) * 10
+			}
+			counts[model]++
+			if v.Rationale != "" {
+				eval.Rationale[model] = strings.TrimSpace(eval.Rationale[model] + " " + v.Rationale)
+			}
+			pc := eval.ProsCons[model]
+			pc.Pros = append(pc.Pros, v.Pros...)
+			pc.Cons = append(pc.Cons, v.Cons...)
+			eval.ProsCons[model] = pc
+		}
+	}
+
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("no judge returned a valid verdict")
+	}
+
+	for _, model := range in.Candidates {
+		criteria := make(map[string]float64)
+		for _, c := range rubric.Criteria {
+			if counts[model] > 0 {
+				criteria[c.Name] = sums[model][c.Name] / float64(counts[model])
+			}
+		}
+		eval.CriterionScores[model] = criteria
+		eval.ModelRatings[model] = ratingFromCriteria(criteria, rubric)
+	}
+	eval.WinnerReason = winnerReason(eval, in.Candidates)
+	return eval, nil
+}
+
+// judgePrompt 构建评审提示 (候选匿名化以减少偏见)
+func judgePrompt(in VoteInput, rubric *Rubric, labels map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString("请评估以下AI回答的质量。\n\n任务类型: " + in.Request.TaskType + "\n\n问题:\n")
+	sb.WriteString(lastUserMessage(in.Request.Messages))
+	sb.WriteString("\n\n评分标准 (每项1-10分):\n")
+	for _, c := range rubric.Criteria {
+		sb.WriteString(fmt.Sprintf("- %s (权重%.2f): %s\n", c.Name, c.Weight, c.Description))
+	}
+	sb.WriteString("\n候选回答:\n")
+	for _, model := range in.Candidates {
+		sb.WriteString(fmt.Sprintf("\n【%s】\n%s\n", labels[model], in.Responses[model]))
+	}
+	sb.WriteString(`
+请只输出JSON, 以候选标签为键, 格式如下:
+{"A": {"scores": {"accuracy": 8, ...}, "rationale": "一句话理由", "pros": ["优点"], "cons": ["缺点"]}, ...}`)
+	return sb.String()
+}
+
+// ========== 两两对决 ==========
+
+// PairwiseStrategy 循环赛: 评审模型两两比较 (每对正反顺序各比一次), 按胜率计分
+type PairwiseStrategy struct{}
+
+func (PairwiseStrategy) Name() string { return "pairwise" }
+
+func (PairwiseStrategy) Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error) {
+	judge := s.judgeModel(in.Request)
+	system := s.renderPrompt(prompt.KeyVoteCompare)
+	question := lastUserMessage(in.Request.Messages)
+	wins := make(map[string]float64)
+	eval := newEvaluation(in.Request.TaskType)
+	matches := 0
+
+	for i := 0; i < len(in.Candidates); i++ {
+		for k := i + 1; k < len(in.Candidates); k++ {
+			a, b := in.Candidates[i], in.Candidates[k]
+
+			// 两种顺序各评一次以消除位置偏差, 两次结论相反时相当于平局
+			points := make(map[string]float64)
+			judged := 0
+			for _, order := range [][2]string{{a, b}, {b, a}} {
+				first, second := order[0], order[1]
+				prompt := fmt.Sprintf("问题:\n%s\n\n【A】\n%s\n\n【B】\n%s\n\n哪个回答更好? 只输出JSON: {\"winner\": \"A\"|\"B\"|\"tie\", \"reason\": \"一句话理由\"}",
+					question, in.Responses[first], in.Responses[second])
+
+				var verdict struct {
+					Winner string `json:"winner" enum:"A,B,tie"`
+					Reason string `json:"reason"`
+				}
+				if err := s.chatJSON(ctx, judge, system, prompt, &verdict); err != nil {
+					continue
+				}
+				judged++
+				switch strings.ToUpper(strings.TrimSpace(verdict.Winner)) {
+				case "A":
+					points[first]++
+					eval.Rationale[first] = strings.TrimSpace(eval.Rationale[first] + fmt.Sprintf(" 胜 %s: %s", second, verdict.Reason))
+				case "B":
+					points[second]++
+					eval.Rationale[second] = strings.TrimSpace(eval.Rationale[second] + fmt.Sprintf(" 胜 %s: %s", first, verdict.Reason))
+				default:
+					points[first] += 0.5
+					points[second] += 0.5
+				}
+			}
+			if judged == 0 {
+				continue
+			}
+			matches++
+			wins[a] += points[a] / float64(judged)
+			wins[b] += points[b] / float64(judged)
+		}
+	}
+
+	if matches == 0 && len(in.Candidates) > 1 {
+		return nil, fmt.Errorf("no pairwise comparison succeeded")
+	}
+
+	opponents := float64(len(in.Candidates) - 1)
+	for _, model := range in.Candidates {
+		score := 100.0
+		if opponents > 0 {
+			score = wins[model] / opponents * 100
+		}
+		eval.CriterionScores[model] = map[string]float64{"win_rate": score}
+		eval.ModelRatings[model] = Rating{OverallScore: score}
+	}
+	eval.WinnerReason = winnerReason(eval, in.Candidates)
+	return eval, nil
+}
+
+// ========== 多数投票 ==========
+
+// MajorityStrategy 对结构化答案做相对多数投票
+type MajorityStrategy struct{}
+
+func (MajorityStrategy) Name() string { return "majority" }
+
+func (MajorityStrategy) Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error) {
+	return pluralityVote(in), nil
+}
+
+// pluralityVote 统计最终答案, 得票最多的答案的候选获得最高分
+func pluralityVote(in VoteInput) *Evaluation {
+	eval := newEvaluation(in.Request.TaskType)
+	answers := make(map[string]string)
+	votes := make(map[string]int)
+	for _, model := range in.Candidates {
+		answer := extractAnswer(in.Responses[model])
+		answers[model] = answer
+		votes[answer]++
+	}
+
+	top := ""
+	for _, model := range in.Candidates {
+		if votes[answers[model]] > votes[top] {
+			top = answers[model]
+		}
+	}
+
+	total := float64(len(in.Candidates))
+	for _, model := range in.Candidates {
+		share := float64(votes[answers[model]]) / total * 100
+		eval.CriterionScores[model] = map[string]float64{"agreement": share}
+		eval.ModelRatings[model] = Rating{OverallScore: share}
+		eval.Rationale[model] = fmt.Sprintf("答案 %q 获得 %d/%d 票", answers[model], votes[answers[model]], len(in.Candidates))
+	}
+	eval.WinnerReason = fmt.Sprintf("答案 %q 获得相对多数 (%d/%d)", top, votes[top], len(in.Candidates))
+	return eval
+}
+
+var answerPrefix = regexp.MustCompile(`(?i)^(最终答案|答案|answer|final answer)\s*[:：]\s*`)
+
+// extractAnswer 提取最终答案: JSON的answer字段, 或"答案:"行, 否则最后一个非空行
+func extractAnswer(resp string) string {
+	var structured struct {
+		Answer interface{} `json:"answer"`
+	}
+	if obj := extractJSONObject(resp); obj != "" && json.Unmarshal([]byte(obj), &structured) == nil && structured.Answer != nil {
+		return normalizeAnswer(fmt.Sprint(structured.Answer))
+	}
+
+	lines := strings.Split(strings.TrimSpace(resp), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); answerPrefix.MatchString(line) {
+			return normalizeAnswer(answerPrefix.ReplaceAllString(line, ""))
+		}
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return normalizeAnswer(line)
+		}
+	}
+	return ""
+}
+
+func normalizeAnswer(answer string) string {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return strings.Trim(answer, " .。!！*`\"'")
+}
+
+// ========== Borda 计数 ==========
+
+// BordaStrategy 每个投票模型给出完整排名, 按Borda计分汇总
+type BordaStrategy struct{}
+
+func (BordaStrategy) Name() string { return "borda" }
+
+func (BordaStrategy) Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error) {
+	voters := in.Request.Models
+	if in.Request.JudgeModel != "" {
+		voters = []string{in.Request.JudgeModel}
+	}
+
+	labels, byLabel := anonymize(in.Candidates)
+	var sb strings.Builder
+	sb.WriteString("问题:\n" + lastUserMessage(in.Request.Messages) + "\n\n候选回答:\n")
+	for _, model := range in.Candidates {
+		sb.WriteString(fmt.Sprintf("\n【%s】\n%s\n", labels[model], in.Responses[model]))
+	}
+	sb.WriteString("\n请将所有候选从好到差排序, 只输出JSON: {\"ranking\": [\"B\", \"A\", ...]}")
+
+	system := s.renderPrompt(prompt.KeyVoteCompare)
+	points := make(map[string]float64)
+	ballots := 0
+	n := len(in.Candidates)
+	for _, voter := range voters {
+		var ballot struct {
+			Ranking []string `json:"ranking"`
+		}
+		if err := s.chatJSON(ctx, voter, system, sb.String(), &ballot); err != nil {
+			continue
+		}
+		ballots++
+		for pos, label := range ballot.Ranking {
+			if model, ok := byLabel[strings.TrimSpace(label)]; ok && pos < n {
+				points[model] += float64(n - 1 - pos)
+			}
+		}
+	}
+	if ballots == 0 {
+		return nil, fmt.Errorf("no voter returned a valid ranking")
+	}
+
+	eval := newEvaluation(in.Request.TaskType)
+	maxPoints := float64((n - 1) * ballots)
+	for _, model := range in.Candidates {
+		score := 100.0
+		if maxPoints > 0 {
+			score = points[model] / maxPoints * 100
+		}
+		eval.CriterionScores[model] = map[string]float64{"borda": score}
+		eval.ModelRatings[model] = Rating{OverallScore: score}
+		eval.Rationale[model] = fmt.Sprintf("Borda得分 %.0f (%d张选票)", points[model], ballots)
+	}
+	eval.WinnerReason = winnerReason(eval, in.Candidates)
+	return eval, nil
+}
+
+// ========== 自洽采样 ==========
+
+// SelfConsistencyStrategy 对同一模型多次采样, 对最终答案多数投票
+type SelfConsistencyStrategy struct{}
+
+func (SelfConsistencyStrategy) Name() string { return "self_consistency" }
+
+func (SelfConsistencyStrategy) samples(req VoteRequest) int {
+	if req.Samples > 0 {
+		return req.Samples
+	}
+	return 5
+}
+
+func (SelfConsistencyStrategy) Evaluate(ctx context.Context, s *Service, in VoteInput) (*Evaluation, error) {
+	return pluralityVote(in), nil
+}
+
+// ========== 工具函数 ==========
+
+// judgeModel 评审模型: 请求指定 > 默认路由最佳模型
+func (s *Service) judgeModel(req VoteRequest) string {
+	if req.JudgeModel != "" {
+		return req.JudgeModel
+	}
+	return s.GetBestAvailableModel("")
+}
+
+// chatJSON 以结构化输出调用模型, Schema由out推导
+func (s *Service) chatJSON(ctx context.Context, model, system, prompt string, out interface{}) error {
+	_, err := s.CompleteJSON(ctx, Request{
+		Model: model,
+		Messages: []Message{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		},
+		Temperature: Temperature(0.1),
+	}, out)
+	return err
+}
+
+// extractJSONObject 提取文本中第一个 { 到最后一个 } 之间的内容
+func extractJSONObject(text string) string {
+	return jsonSpan(text, '{')
+}
+
+// anonymize 为候选分配匿名标签 A/B/C...
+func anonymize(candidates []string) (map[string]string, map[string]string) {
+	labels := make(map[string]string)
+	byLabel := make(map[string]string)
+	for i, model := range candidates {
+		label := string(rune('A' + i%26))
+		if i >= 26 {
+			label = fmt.Sprintf("%s%d", label, i/26)
+		}
+		labels[model] = label
+		byLabel[label] = model
+	}
+	return labels, byLabel
+}
+
+func newEvaluation(taskType string) *Evaluation {
+	return &Evaluation{
+		TaskType:        taskType,
+		ModelRatings:    make(map[string]Rating),
+		ProsCons:        make(map[string]ProsCons),
+		CriterionScores: make(map[string]map[string]float64),
+		Rationale:       make(map[string]string),
+	}
+}
+
+// ratingFromCriteria 按权重汇总评分项
+func ratingFromCriteria(criteria map[string]float64, rubric *Rubric) Rating {
+	r := Rating{
+		Accuracy:     criteria["accuracy"],
+		Completeness: criteria["completeness"],
+		Clarity:      criteria["clarity"],
+		Creativity:   criteria["creativity"],
+	}
+	var total, weights float64
+	for _, c := range rubric.Criteria {
+		total += criteria[c.Name] * c.Weight
+		weights += c.Weight
+	}
+	if weights > 0 {
+		r.OverallScore = total / weights
+	}
+	return r
+}
+
+// winnerReason 生成获胜原因
+func winnerReason(eval *Evaluation, candidates []string) string {
+	best, bestScore := "", -1.0
+	for _, model := range candidates {
+		if r, ok := eval.ModelRatings[model]; ok && r.OverallScore > bestScore {
+			best, bestScore = model, r.OverallScore
+		}
+	}
+	reason := fmt.Sprintf("%s 得分%.1f分", best, bestScore)
+	if why := eval.Rationale[best]; why != "" {
+		reason += "，" + why
+	}
+	return reason
+}
+
+func lastUserMessage(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func clamp(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
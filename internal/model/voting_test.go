@@ -0,0 +1,43 @@
+package model
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPairwiseCancelsPositionBias(t *testing.T) {
+	// 评审总是选第一个位置的回答
+	svc, chats := newOllamaService(t, replyWith(`{"winner": "A", "reason": "更好"}`))
+
+	eval, err := PairwiseStrategy{}.Evaluate(context.Background(), svc, VoteInput{
+		Request:    VoteRequest{JudgeModel: "ollama/bot", Messages: []Message{{Role: "user", Content: "问题"}}},
+		Candidates: []string{"m1", "m2"},
+		Responses:  map[string]string{"m1": "回答一", "m2": "回答二"},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if *chats != 2 {
+		t.Errorf("judge calls = %d, want both orders judged", *chats)
+	}
+	for _, m := range []string{"m1", "m2"} {
+		if got := eval.ModelRatings[m].OverallScore; got != 50 {
+			t.Errorf("%s score = %v, want 50 (position bias cancelled)", m, got)
+		}
+	}
+}
+
+func TestVoteDefaultsToJudge(t *testing.T) {
+	svc, _ := newOllamaService(t, replyWith(`{"A": {"scores": {"accuracy": 8}, "rationale": "准确", "pros": [], "cons": []}}`))
+
+	resp, err := svc.Vote(context.Background(), VoteRequest{
+		Models:   []string{"ollama/bot"},
+		Messages: []Message{{Role: "user", Content: "问题"}},
+	})
+	if err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	if resp.Evaluation.Strategy != "judge" {
+		t.Errorf("strategy = %q, want judge when a judge model is available", resp.Evaluation.Strategy)
+	}
+}
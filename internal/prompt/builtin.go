@@ -0,0 +1,193 @@
+package prompt
+
+import "sort"
+
+// 内置模板Key
+const (
+	KeyAssistant      = "agent.assistant"       // 通用助手 (可带背景信息)
+	KeyAssistantBrief = "agent.assistant_brief" // 简洁回答
+	KeyCEO            = "role.ceo"              // CEO 分析分解任务
+	KeyManager        = "role.manager"          // Manager 分配任务
+	KeyManagerReport  = "role.manager_report"   // Manager 汇总下属结果向上汇报
+	KeyWorker         = "role.worker"           // Worker 执行任务
+	KeyCEOFinal       = "role.ceo_final"        // CEO 汇总决策
+	KeyVoteJudge      = "role.vote_judge"       // 下级投票评审
+	KeyLeaderReview   = "role.leader_review"    // 领导评估下属
+	KeyCEOReview      = "role.ceo_review"       // CEO 评估 Manager
+	KeyLeaderPerf     = "role.leader_perf"      // 领导按业绩评估下属
+	KeyRateLeader     = "role.rate_leader"      // 下属为上级的任务安排打分
+
+	KeyVoteScore      = "model.vote_score"      // 多模型投票: 评审按评分标准打分
+	KeyVoteCompare    = "model.vote_compare"    // 多模型投票: 两两比较和排序
+	KeyContextSummary = "model.context_summary" // 上下文超长时压缩早期对话
+	KeyEvalJudge      = "eval.judge"            // 评测集: 评审模型按评分标准打分
+
+	KeyMemoryConsolidate = "memory.consolidate" // 把历史记忆提炼为经验
+)
+
+// builtins 内置默认模板 (数据库未配置或未写入时使用, EnsureDefaults 写入为版本1)
+var builtins = map[string]Template{
+	KeyAssistant: {
+		Key:  KeyAssistant,
+		Name: "通用助手",
+		Variables: []Variable{
+			{Name: "context", Type: VarString, Description: "检索到的相关背景信息"},
+		},
+		Contents: map[string]string{
+			LocaleZh: "你是一个AI助手，请帮助用户解决问题。{{if .context}}\n\n相关背景信息:\n{{.context}}{{end}}",
+			LocaleEn: "You are an AI assistant. Help the user solve their problem.{{if .context}}\n\nRelevant background:\n{{.context}}{{end}}",
+		},
+	},
+	KeyAssistantBrief: {
+		Key:  KeyAssistantBrief,
+		Name: "简洁回答",
+		Contents: map[string]string{
+			LocaleZh: "你是一个AI助手，请简洁地回答用户问题。",
+			LocaleEn: "You are an AI assistant. Answer the user's question concisely.",
+		},
+	},
+	KeyCEO: {
+		Key:  KeyCEO,
+		Name: "CEO",
+		Contents: map[string]string{
+			LocaleZh: "你是一个CEO，负责分析用户需求并分解任务。",
+			LocaleEn: "You are the CEO. Analyze the user's request and break it down into subtasks.",
+		},
+	},
+	KeyManager: {
+		Key:  KeyManager,
+		Name: "Manager",
+		Contents: map[string]string{
+			LocaleZh: "你是一个Manager，负责将任务分配给具体的执行者。",
+			LocaleEn: "You are a Manager. Assign the tasks to the people who will carry them out.",
+		},
+	},
+	KeyManagerReport: {
+		Key:  KeyManagerReport,
+		Name: "Manager 汇报",
+		Contents: map[string]string{
+			LocaleZh: "你是一个Manager，负责审核下属提交的结果，整合成完整的成果向上级汇报。",
+			LocaleEn: "You are a Manager. Review the results submitted by your team and combine them into a complete report for your superior.",
+		},
+	},
+	KeyWorker: {
+		Key:  KeyWorker,
+		Name: "Worker",
+		Contents: map[string]string{
+			LocaleZh: "你是一个Worker，负责执行具体的任务。",
+			LocaleEn: "You are a Worker. Carry out the concrete task you are given.",
+		},
+	},
+	KeyCEOFinal: {
+		Key:  KeyCEOFinal,
+		Name: "CEO 最终决策",
+		Contents: map[string]string{
+			LocaleZh: "你是一个CEO，负责整合所有信息给出最终答案。",
+			LocaleEn: "You are the CEO. Combine all of the information and give the final answer.",
+		},
+	},
+	KeyVoteJudge: {
+		Key:  KeyVoteJudge,
+		Name: "投票评审",
+		Contents: map[string]string{
+			LocaleZh: "你是一个公正的评审",
+			LocaleEn: "You are an impartial reviewer.",
+		},
+	},
+	KeyLeaderReview: {
+		Key:  KeyLeaderReview,
+		Name: "领导评估",
+		Contents: map[string]string{
+			LocaleZh: "你是一个严格的领导",
+			LocaleEn: "You are a demanding team lead.",
+		},
+	},
+	KeyCEOReview: {
+		Key:  KeyCEOReview,
+		Name: "CEO 业绩评估",
+		Contents: map[string]string{
+			LocaleZh: "你是一个追求业绩的CEO",
+			LocaleEn: "You are a results-driven CEO.",
+		},
+	},
+	KeyLeaderPerf: {
+		Key:  KeyLeaderPerf,
+		Name: "领导业绩评估",
+		Contents: map[string]string{
+			LocaleZh: "你是一个追求业绩的领导者",
+			LocaleEn: "You are a results-driven leader.",
+		},
+	},
+	KeyRateLeader: {
+		Key:  KeyRateLeader,
+		Name: "下属评价上级",
+		Variables: []Variable{
+			{Name: "leader", Type: VarString, Required: true, Description: "上级名称"},
+		},
+		Contents: map[string]string{
+			LocaleZh: "你是 {{.leader}} 的下属。请根据上级安排的任务是否清晰、合理、可执行, 为上级的任务安排打分。",
+			LocaleEn: "You report to {{.leader}}. Rate your lead's assignment by how clear, reasonable and actionable the task was.",
+		},
+	},
+	KeyVoteScore: {
+		Key:  KeyVoteScore,
+		Name: "投票评审打分",
+		Contents: map[string]string{
+			LocaleZh: "你是一个专业的AI评估专家。请严格评估并只输出JSON。",
+			LocaleEn: "You are a professional AI evaluator. Evaluate strictly and output JSON only.",
+		},
+	},
+	KeyVoteCompare: {
+		Key:  KeyVoteCompare,
+		Name: "投票比较排序",
+		Contents: map[string]string{
+			LocaleZh: "你是一个公正的评审。",
+			LocaleEn: "You are an impartial reviewer.",
+		},
+	},
+	KeyContextSummary: {
+		Key:  KeyContextSummary,
+		Name: "对话摘要",
+		Contents: map[string]string{
+			LocaleZh: "你是对话摘要助手。请用简洁的要点总结以下对话, 保留事实、结论、用户偏好和未完成的事项, 不要添加新内容。",
+			LocaleEn: "You summarize conversations. Summarize the conversation below as concise bullet points, keeping facts, conclusions, user preferences and open items. Do not add anything new.",
+		},
+	},
+	KeyEvalJudge: {
+		Key:  KeyEvalJudge,
+		Name: "评测评审",
+		Contents: map[string]string{
+			LocaleZh: "你是一个严格、公正的评审, 只根据评分标准打分。",
+			LocaleEn: "You are a strict and impartial reviewer. Score only against the rubric.",
+		},
+	},
+	KeyMemoryConsolidate: {
+		Key:  KeyMemoryConsolidate,
+		Name: "记忆整理",
+		Contents: map[string]string{
+			LocaleZh: "你是智能体的记忆整理助手。请把以下历史行为和结果记录提炼为少量可复用的经验: 合并重复内容, 保留失败原因、有效做法、对象偏好和关键事实, 丢弃没有长期价值的流水记录。每条经验独立成句, 不超过10条。",
+			LocaleEn: "You organize an agent's memory. Distill the history of actions and results below into a few reusable lessons: merge duplicates, keep failure causes, effective practices, stakeholder preferences and key facts, and drop routine records with no lasting value. Write each lesson as a standalone sentence, at most 10 lessons.",
+		},
+	},
+}
+
+// BuiltinKeys 内置模板Key (排序)
+func BuiltinKeys() []string {
+	keys := make([]string, 0, len(builtins))
+	for k := range builtins {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// builtin 返回内置模板的副本 (版本1)
+func builtin(key string) (*Template, bool) {
+	tpl, ok := builtins[key]
+	if !ok {
+		return nil, false
+	}
+	tpl.Version = 1
+	tpl.Builtin = true
+	return &tpl, true
+}
@@ -0,0 +1,537 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"agent-flow/internal/store"
+	"gorm.io/gorm"
+)
+
+// VarType 变量类型
+type VarType string
+
+const (
+	VarString VarType = "string"
+	VarInt    VarType = "int"
+	VarNumber VarType = "number"
+	VarBool   VarType = "bool"
+	VarList   VarType = "list" // 字符串列表, 渲染时可用 {{join .x "、"}}
+	VarJSON   VarType = "json" // 任意结构, 原样传入模板
+)
+
+// 支持的语言
+const (
+	LocaleZh = "zh"
+	LocaleEn = "en"
+)
+
+// Variable 模板变量定义
+type Variable struct {
+	Name        string      `json:"name"`
+	Type        VarType     `json:"type"`
+	Required    bool        `json:"required"`
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+// Ref 提示词引用 (智能体/流程节点/协作角色通过它引用模板)
+type Ref struct {
+	Key     string `json:"key"`
+	Version int    `json:"version,omitempty"` // 0表示最新版本
+	Locale  string `json:"locale,omitempty"`  // 为空时使用默认语言
+}
+
+// Template 某个版本的提示词模板
+type Template struct {
+	Key         string            `json:"key"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Version     int               `json:"version"`
+	Variables   []Variable        `json:"variables"`
+	Contents    map[string]string `json:"contents"` // 语言 -> 模板内容
+	Changelog   string            `json:"changelog,omitempty"`
+	Builtin     bool              `json:"builtin,omitempty"` // 内置默认模板 (未写入数据库)
+}
+
+// ErrNotFound 模板或版本不存在
+var ErrNotFound = errors.New("prompt not found")
+
+// Service 提示词模板服务 (数据库为空时只使用内置模板)
+type Service struct {
+	db     *store.Postgres
+	locale string
+
+	mu    sync.RWMutex
+	cache map[string]*Template // key@version -> 模板 (版本不可变, 可永久缓存)
+}
+
+// NewService 创建提示词服务, db为nil时只提供内置模板
+func NewService(db *store.Postgres) *Service {
+	locale := os.Getenv("PROMPT_LOCALE")
+	if locale == "" {
+		locale = LocaleZh
+	}
+	return &Service{
+		db:     db,
+		locale: locale,
+		cache:  make(map[string]*Template),
+	}
+}
+
+// EnsureDefaults 把数据库中缺失的内置模板写入为版本1
+func (s *Service) EnsureDefaults() error {
+	if s.db == nil {
+		return nil
+	}
+	for _, key := range BuiltinKeys() {
+		if _, err := s.db.GetPromptTemplate(key); err == nil {
+			continue
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		tpl := builtins[key]
+		if _, err := s.Create(tpl, "内置默认模板"); err != nil {
+			return fmt.Errorf("seed prompt %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// List 列出所有模板 (最新版本)
+func (s *Service) List() ([]*Template, error) {
+	seen := make(map[string]bool)
+	var result []*Template
+
+	if s.db != nil {
+		rows, err := s.db.ListPromptTemplates()
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			tpl, err := s.load(row, 0)
+			if err != nil {
+				return nil, err
+			}
+			seen[row.Key] = true
+			result = append(result, tpl)
+		}
+	}
+
+	for _, key := range BuiltinKeys() {
+		if !seen[key] {
+			tpl, _ := builtin(key)
+			result = append(result, tpl)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+// Get 获取模板, version为0时返回最新版本; 数据库中没有时回退到内置模板
+func (s *Service) Get(key string, version int) (*Template, error) {
+	if version > 0 {
+		if tpl, ok := s.cached(key, version); ok {
+			return tpl, nil
+		}
+	}
+
+	if s.db != nil {
+		row, err := s.db.GetPromptTemplate(key)
+		switch {
+		case err == nil:
+			return s.load(*row, version)
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, err
+		}
+	}
+
+	if tpl, ok := builtin(key); ok && version <= 1 {
+		return tpl, nil
+	}
+	return nil, fmt.Errorf("%w: %s@%d", ErrNotFound, key, version)
+}
+
+// Versions 列出模板的所有版本 (新版本在前)
+func (s *Service) Versions(key string) ([]*Template, error) {
+	if s.db == nil {
+		if tpl, ok := builtin(key); ok {
+			return []*Template{tpl}, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	row, err := s.db.GetPromptTemplate(key)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, err
+	}
+	versions, err := s.db.ListPromptVersions(row.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Template, 0, len(versions))
+	for _, v := range versions {
+		tpl, err := toTemplate(*row, v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tpl)
+	}
+	return result, nil
+}
+
+// Create 创建模板或追加新版本 (版本号自动递增)
+func (s *Service) Create(tpl Template, changelog string) (*Template, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("prompt registry requires a database")
+	}
+	if err := Validate(tpl); err != nil {
+		return nil, err
+	}
+
+	variables, err := json.Marshal(tpl.Variables)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := json.Marshal(tpl.Contents)
+	if err != nil {
+		return nil, err
+	}
+
+	row := &store.PromptTemplate{Key: tpl.Key, Name: tpl.Name, Description: tpl.Description}
+	version := &store.PromptVersion{Variables: string(variables), Contents: string(contents), Changelog: changelog}
+	if err := s.db.CreatePromptVersion(row, version); err != nil {
+		return nil, err
+	}
+	return toTemplate(*row, *version)
+}
+
+// Delete 删除模板及其所有版本
+func (s *Service) Delete(key string) error {
+	if s.db == nil {
+		return fmt.Errorf("prompt registry requires a database")
+	}
+	if err := s.db.DeletePromptTemplate(key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for k := range s.cache {
+		if strings.HasPrefix(k, key+"@") {
+			delete(s.cache, k)
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Render 渲染引用的模板
+func (s *Service) Render(ref Ref, vars map[string]interface{}) (string, error) {
+	tpl, err := s.Get(ref.Key, ref.Version)
+	if err != nil {
+		return "", err
+	}
+	locale := ref.Locale
+	if locale == "" {
+		locale = s.locale
+	}
+	return tpl.Render(locale, vars)
+}
+
+// RenderOrBuiltin 渲染模板, 服务未配置 (s为nil) 或渲染失败时使用内置模板, 都失败时返回空串;
+// 供模型投票、上下文压缩等内部调用使用
+func (s *Service) RenderOrBuiltin(ref Ref, vars map[string]interface{}) string {
+	if s != nil {
+		text, err := s.Render(ref, vars)
+		if err == nil {
+			return text
+		}
+		log.Printf("[Prompt] render %s@%d failed, using builtin: %v", ref.Key, ref.Version, err)
+	}
+	text, err := NewService(nil).Render(Ref{Key: ref.Key, Locale: ref.Locale}, vars)
+	if err != nil {
+		return ""
+	}
+	return text
+}
+
+// Render 按语言渲染模板: 校验并转换变量类型, 缺少必填变量时报错
+func (t *Template) Render(locale string, vars map[string]interface{}) (string, error) {
+	content, ok := t.content(locale)
+	if !ok {
+		return "", fmt.Errorf("prompt %s@%d has no content", t.Key, t.Version)
+	}
+
+	data, err := t.bind(vars)
+	if err != nil {
+		return "", err
+	}
+
+	parsed, err := parse(t.Key, content)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render prompt %s@%d: %w", t.Key, t.Version, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// content 选择语言版本: 指定语言 > 中文 > 英文 > 任意
+func (t *Template) content(locale string) (string, bool) {
+	for _, l := range []string{locale, LocaleZh, LocaleEn} {
+		if c, ok := t.Contents[l]; ok && c != "" {
+			return c, true
+		}
+	}
+	for _, l := range sortedLocales(t.Contents) {
+		return t.Contents[l], true
+	}
+	return "", false
+}
+
+// bind 按变量定义校验输入, 未声明的变量原样保留
+func (t *Template) bind(vars map[string]interface{}) (map[string]interface{}, error) {
+	data := make(map[string]interface{}, len(vars)+len(t.Variables))
+	for k, v := range vars {
+		data[k] = v
+	}
+
+	var problems []string
+	for _, v := range t.Variables {
+		value, ok := data[v.Name]
+		if !ok || value == nil {
+			switch {
+			case v.Default != nil:
+				value = v.Default
+			case v.Required:
+				problems = append(problems, fmt.Sprintf("%s is required", v.Name))
+				continue
+			default:
+				data[v.Name] = zeroValue(v.Type)
+				continue
+			}
+		}
+		converted, err := convert(v.Type, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", v.Name, err))
+			continue
+		}
+		data[v.Name] = converted
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("prompt %s@%d variables: %s", t.Key, t.Version, strings.Join(problems, "; "))
+	}
+	return data, nil
+}
+
+// Validate 校验模板定义: Key、变量类型和每个语言版本的模板语法
+func Validate(tpl Template) error {
+	if tpl.Key == "" {
+		return fmt.Errorf("prompt key is required")
+	}
+	if len(tpl.Contents) == 0 {
+		return fmt.Errorf("prompt %s has no content", tpl.Key)
+	}
+
+	names := make(map[string]bool)
+	for _, v := range tpl.Variables {
+		if v.Name == "" {
+			return fmt.Errorf("variable name is required")
+		}
+		if names[v.Name] {
+			return fmt.Errorf("duplicate variable: %s", v.Name)
+		}
+		names[v.Name] = true
+		if zeroValue(v.Type) == nil && v.Type != VarJSON {
+			return fmt.Errorf("variable %s: unknown type %q", v.Name, v.Type)
+		}
+		if v.Default != nil {
+			if _, err := convert(v.Type, v.Default); err != nil {
+				return fmt.Errorf("variable %s default: %v", v.Name, err)
+			}
+		}
+	}
+
+	for locale, content := range tpl.Contents {
+		if _, err := parse(tpl.Key, content); err != nil {
+			return fmt.Errorf("locale %s: %w", locale, err)
+		}
+	}
+	return nil
+}
+
+// ========== 内部工具 ==========
+
+func (s *Service) cached(key string, version int) (*Template, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tpl, ok := s.cache[fmt.Sprintf("%s@%d", key, version)]
+	return tpl, ok
+}
+
+// load 从数据库加载指定版本并缓存
+func (s *Service) load(row store.PromptTemplate, version int) (*Template, error) {
+	if version == 0 {
+		version = row.LatestVersion
+	}
+	if tpl, ok := s.cached(row.Key, version); ok {
+		return tpl, nil
+	}
+
+	v, err := s.db.GetPromptVersion(row.ID, version)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s@%d", ErrNotFound, row.Key, version)
+		}
+		return nil, err
+	}
+	tpl, err := toTemplate(row, *v)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[fmt.Sprintf("%s@%d", row.Key, tpl.Version)] = tpl
+	s.mu.Unlock()
+	return tpl, nil
+}
+
+func toTemplate(row store.PromptTemplate, v store.PromptVersion) (*Template, error) {
+	tpl := &Template{
+		Key:         row.Key,
+		Name:        row.Name,
+		Description: row.Description,
+		Version:     v.Version,
+		Changelog:   v.Changelog,
+	}
+	if v.Variables != "" {
+		if err := json.Unmarshal([]byte(v.Variables), &tpl.Variables); err != nil {
+			return nil, fmt.Errorf("prompt %s@%d variables: %w", row.Key, v.Version, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(v.Contents), &tpl.Contents); err != nil {
+		return nil, fmt.Errorf("prompt %s@%d contents: %w", row.Key, v.Version, err)
+	}
+	return tpl, nil
+}
+
+var funcs = template.FuncMap{
+	"join": func(items []string, sep string) string { return strings.Join(items, sep) },
+	"json": func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	},
+}
+
+func parse(name, content string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(content)
+}
+
+func zeroValue(t VarType) interface{} {
+	switch t {
+	case VarString, "":
+		return ""
+	case VarInt:
+		return 0
+	case VarNumber:
+		return 0.0
+	case VarBool:
+		return false
+	case VarList:
+		return []string{}
+	}
+	return nil
+}
+
+// convert 按变量类型转换 (JSON数字为float64, 字符串形式的数字/布尔也接受)
+func convert(t VarType, v interface{}) (interface{}, error) {
+	switch t {
+	case VarString, "":
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case float64, int, bool:
+			return fmt.Sprint(x), nil
+		}
+	case VarInt:
+		switch x := v.(type) {
+		case int:
+			return x, nil
+		case float64:
+			if x == float64(int(x)) {
+				return int(x), nil
+			}
+		case string:
+			if n, err := strconv.Atoi(strings.TrimSpace(x)); err == nil {
+				return n, nil
+			}
+		}
+	case VarNumber:
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case int:
+			return float64(x), nil
+		case string:
+			if n, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil {
+				return n, nil
+			}
+		}
+	case VarBool:
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(x)); err == nil {
+				return b, nil
+			}
+		}
+	case VarList:
+		switch x := v.(type) {
+		case []string:
+			return x, nil
+		case []interface{}:
+			items := make([]string, 0, len(x))
+			for _, item := range x {
+				items = append(items, fmt.Sprint(item))
+			}
+			return items, nil
+		case string:
+			var items []string
+			for _, item := range strings.Split(x, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			return items, nil
+		}
+	case VarJSON:
+		return v, nil
+	}
+	return nil, fmt.Errorf("expected %s, got %T", t, v)
+}
+
+func sortedLocales(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
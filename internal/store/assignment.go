@@ -0,0 +1,82 @@
+package store
+
+import (
+	"time"
+)
+
+// ModelAssignment 智能体/角色当前使用的模型
+type ModelAssignment struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Subject    string    `gorm:"size:100;uniqueIndex;not null" json:"subject"` // agent:<id> 或 role:<ceo|manager|worker>
+	Model      string    `gorm:"size:100;not null" json:"model"`
+	Locked     bool      `json:"locked"` // 管理员锁定, 投票和评估不再切换
+	LockedBy   string    `gorm:"size:100" json:"locked_by,omitempty"`
+	AssignedAt time.Time `json:"assigned_at"` // 切换到当前模型的时间 (计算任期)
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (ModelAssignment) TableName() string {
+	return "model_assignments"
+}
+
+// ModelSwitchLog 模型切换记录 (包括被规则拦截的切换)
+type ModelSwitchLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Subject   string    `gorm:"size:100;index:idx_switch_subject_time;not null" json:"subject"`
+	FromModel string    `gorm:"size:100" json:"from_model"`
+	ToModel   string    `gorm:"size:100" json:"to_model"`
+	Source    string    `gorm:"size:20" json:"source"`   // vote/perf/evaluation/admin
+	Votes     string    `gorm:"type:jsonb" json:"votes"` // 投票明细(JSON)
+	Score     *int      `json:"score"`
+	Reason    string    `gorm:"type:text" json:"reason"`
+	Applied   bool      `json:"applied"`
+	Blocked   string    `gorm:"type:text" json:"blocked,omitempty"` // 未生效的原因
+	CreatedAt time.Time `gorm:"index:idx_switch_subject_time" json:"created_at"`
+}
+
+func (ModelSwitchLog) TableName() string {
+	return "model_switch_logs"
+}
+
+func (p *Postgres) GetModelAssignment(subject string) (*ModelAssignment, error) {
+	var a ModelAssignment
+	err := p.db.Where("subject = ?", subject).First(&a).Error
+	return &a, err
+}
+
+func (p *Postgres) ListModelAssignments() ([]ModelAssignment, error) {
+	var assignments []ModelAssignment
+	err := p.db.Order("subject").Find(&assignments).Error
+	return assignments, err
+}
+
+func (p *Postgres) SaveModelAssignment(a *ModelAssignment) error {
+	return p.db.Save(a).Error
+}
+
+func (p *Postgres) CreateModelSwitchLog(entry *ModelSwitchLog) error {
+	return p.db.Create(entry).Error
+}
+
+// ListModelSwitchLogs 切换记录 (最新在前), subject为空时返回全部
+func (p *Postgres) ListModelSwitchLogs(subject string, limit int) ([]ModelSwitchLog, error) {
+	var logs []ModelSwitchLog
+	q := p.db.Order("created_at DESC")
+	if subject != "" {
+		q = q.Where("subject = ?", subject)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&logs).Error
+	return logs, err
+}
+
+// CountModelSwitches since 之后实际生效的切换次数
+func (p *Postgres) CountModelSwitches(subject string, since time.Time) (int64, error) {
+	var n int64
+	err := p.db.Model(&ModelSwitchLog{}).
+		Where("subject = ? AND applied AND source <> ? AND created_at >= ?", subject, "admin", since).
+		Count(&n).Error
+	return n, err
+}
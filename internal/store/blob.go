@@ -0,0 +1,119 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Blob 本地存储的媒体文件元数据
+type Blob struct {
+	ID        string    `json:"id"` // 内容的sha256, 相同内容只存一份
+	MimeType  string    `json:"mime_type"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Source    string    `json:"source"` // 来源, 如 wechat/feishu
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlobStore 本地文件系统的内容寻址存储
+type BlobStore struct {
+	dir string
+}
+
+// NewBlobStore 创建媒体存储 (dir为空时使用 BLOB_DIR 或 ./data/blobs)
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if dir == "" {
+		dir = os.Getenv("BLOB_DIR")
+	}
+	if dir == "" {
+		dir = "./data/blobs"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+// Put 保存文件, 返回元数据
+func (b *BlobStore) Put(data []byte, mimeType, name, source string) (*Blob, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+
+	if existing, err := b.Stat(id); err == nil {
+		return existing, nil
+	}
+
+	path := b.path(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+
+	blob := &Blob{
+		ID:        id,
+		MimeType:  mimeType,
+		Name:      name,
+		Size:      int64(len(data)),
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+	meta, _ := json.Marshal(blob)
+	if err := os.WriteFile(path+".json", meta, 0644); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// Get 读取文件内容和元数据
+func (b *BlobStore) Get(id string) ([]byte, *Blob, error) {
+	blob, err := b.Stat(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(b.path(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, blob, nil
+}
+
+// Stat 读取元数据
+func (b *BlobStore) Stat(id string) (*Blob, error) {
+	if !validBlobID(id) {
+		return nil, fmt.Errorf("invalid blob id: %s", id)
+	}
+	meta, err := os.ReadFile(b.path(id) + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var blob Blob
+	if err := json.Unmarshal(meta, &blob); err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// path 按前两位分目录存放
+func (b *BlobStore) path(id string) string {
+	return filepath.Join(b.dir, id[:2], id)
+}
+
+// validBlobID 只接受sha256十六进制, 防止路径穿越
+func validBlobID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
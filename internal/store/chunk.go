@@ -0,0 +1,74 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KnowledgeChunk 知识分块及其向量, 检索以分块为单位.
+// 向量以 JSON 存在 jsonb 中, 只用于各副本重建内存索引, 数据库不做向量检索;
+// 全部分块都要加载进每个副本的内存, 规模上限见 memory 包的 maxIndexedChunks
+type KnowledgeChunk struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	KnowledgeID uint      `json:"knowledge_id" gorm:"index;not null"`
+	AgentID     uint      `json:"agent_id" gorm:"index"`
+	Seq         int       `json:"seq"` // 在知识中的序号, 从0开始
+	Content     string    `json:"content" gorm:"type:text"`
+	Model       string    `json:"model" gorm:"size:100"`               // 生成向量的模型, 为空表示未向量化
+	Embedding   []float32 `json:"-" gorm:"serializer:json;type:jsonb"` // 归一化后的向量
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (KnowledgeChunk) TableName() string {
+	return "knowledge_chunks"
+}
+
+// ReplaceKnowledgeChunks 用新的分块替换知识原有的分块
+func (p *Postgres) ReplaceKnowledgeChunks(knowledgeID uint, chunks []KnowledgeChunk) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("knowledge_id = ?", knowledgeID).Delete(&KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		return tx.Create(&chunks).Error
+	})
+}
+
+// ListKnowledgeChunks 全部分块 (按ID排序), 用于重建内存索引
+func (p *Postgres) ListKnowledgeChunks() ([]KnowledgeChunk, error) {
+	var chunks []KnowledgeChunk
+	err := p.db.Order("id").Find(&chunks).Error
+	return chunks, err
+}
+
+// ListChunksOfKnowledge 一条知识的分块 (按序号排序), 用于同步其他副本的写入
+func (p *Postgres) ListChunksOfKnowledge(knowledgeID uint) ([]KnowledgeChunk, error) {
+	var chunks []KnowledgeChunk
+	err := p.db.Where("knowledge_id = ?", knowledgeID).Order("seq").Find(&chunks).Error
+	return chunks, err
+}
+
+// ListUnindexedKnowledge 有内容但还没有分块, 或分块不是由 embedModel 向量化的知识 (embedModel 为空时只看是否有分块)
+func (p *Postgres) ListUnindexedKnowledge(embedModel string, limit int) ([]Knowledge, error) {
+	var knowledge []Knowledge
+	indexed := p.db.Table("knowledge_chunks").Select("1").Where("knowledge_chunks.knowledge_id = knowledge.id")
+	if embedModel != "" {
+		indexed = indexed.Where("knowledge_chunks.model = ?", embedModel)
+	}
+	q := p.db.Where("content <> ''").Where("NOT EXISTS (?)", indexed).Order("id")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&knowledge).Error
+	return knowledge, err
+}
+
+// ListAllKnowledge 全部知识, 用于重建索引
+func (p *Postgres) ListAllKnowledge() ([]Knowledge, error) {
+	var knowledge []Knowledge
+	err := p.db.Order("id").Find(&knowledge).Error
+	return knowledge, err
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EvalDataset 评测数据集 (挂在某个智能体下)
+type EvalDataset struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	AgentID      uint       `gorm:"index;not null" json:"agent_id"`
+	Name         string     `gorm:"size:255;not null" json:"name"`
+	Description  string     `gorm:"type:text" json:"description"`
+	Scorer       string     `gorm:"size:50;not null" json:"scorer"`  // 默认评分器
+	ScorerConfig string     `gorm:"type:jsonb" json:"scorer_config"` // 评分器参数(JSON)
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Cases        []EvalCase `gorm:"foreignKey:DatasetID" json:"cases,omitempty"`
+}
+
+func (EvalDataset) TableName() string {
+	return "eval_datasets"
+}
+
+// EvalCase 评测用例
+type EvalCase struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	DatasetID    uint      `gorm:"index;not null" json:"dataset_id"`
+	Input        string    `gorm:"type:text;not null" json:"input"`
+	Expected     string    `gorm:"type:text" json:"expected"`
+	Scorer       string    `gorm:"size:50" json:"scorer,omitempty"`           // 为空时使用数据集的评分器
+	ScorerConfig string    `gorm:"type:jsonb" json:"scorer_config,omitempty"` // 为空时使用数据集的参数
+	Weight       float64   `gorm:"default:1" json:"weight"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (EvalCase) TableName() string {
+	return "eval_cases"
+}
+
+// EvalRun 一次批量评测 (数据集 × 候选模型)
+type EvalRun struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	DatasetID   uint         `gorm:"index;not null" json:"dataset_id"`
+	AgentID     uint         `gorm:"index" json:"agent_id"`
+	Models      string       `gorm:"type:jsonb" json:"models"`      // 参与评测的模型(JSON)
+	Leaderboard string       `gorm:"type:jsonb" json:"leaderboard"` // 排行榜(JSON)
+	Status      string       `gorm:"size:20" json:"status"`         // running/completed/failed
+	Error       string       `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	FinishedAt  *time.Time   `json:"finished_at"`
+	Results     []EvalResult `gorm:"foreignKey:RunID" json:"results,omitempty"`
+}
+
+func (EvalRun) TableName() string {
+	return "eval_runs"
+}
+
+// EvalResult 单个用例在单个模型上的结果
+type EvalResult struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	RunID     uint      `gorm:"index;not null" json:"run_id"`
+	CaseID    uint      `json:"case_id"`
+	Model     string    `gorm:"size:100" json:"model"`
+	Output    string    `gorm:"type:text" json:"output"`
+	Score     float64   `json:"score"` // 0-1
+	Passed    bool      `json:"passed"`
+	Detail    string    `gorm:"type:text" json:"detail"`
+	Error     string    `gorm:"type:text" json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	Tokens    int       `json:"tokens"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (EvalResult) TableName() string {
+	return "eval_results"
+}
+
+func (p *Postgres) CreateEvalDataset(ds *EvalDataset) error {
+	return p.db.Create(ds).Error
+}
+
+// GetEvalDataset 获取数据集及其用例
+func (p *Postgres) GetEvalDataset(id uint) (*EvalDataset, error) {
+	var ds EvalDataset
+	err := p.db.Preload("Cases", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&ds, id).Error
+	return &ds, err
+}
+
+// ListEvalDatasets 数据集列表, agentID为0时返回全部
+func (p *Postgres) ListEvalDatasets(agentID uint) ([]EvalDataset, error) {
+	var datasets []EvalDataset
+	q := p.db.Order("id")
+	if agentID != 0 {
+		q = q.Where("agent_id = ?", agentID)
+	}
+	err := q.Find(&datasets).Error
+	return datasets, err
+}
+
+func (p *Postgres) UpdateEvalDataset(ds *EvalDataset) error {
+	return p.db.Omit("Cases").Save(ds).Error
+}
+
+// DeleteEvalDataset 删除数据集及其用例和评测记录
+func (p *Postgres) DeleteEvalDataset(id uint) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		runs := tx.Model(&EvalRun{}).Select("id").Where("dataset_id = ?", id)
+		if err := tx.Where("run_id IN (?)", runs).Delete(&EvalResult{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("dataset_id = ?", id).Delete(&EvalRun{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("dataset_id = ?", id).Delete(&EvalCase{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&EvalDataset{}, id).Error
+	})
+}
+
+func (p *Postgres) CreateEvalCases(cases []EvalCase) error {
+	if len(cases) == 0 {
+		return nil
+	}
+	return p.db.Create(&cases).Error
+}
+
+func (p *Postgres) DeleteEvalCase(datasetID, caseID uint) error {
+	return p.db.Where("dataset_id = ?", datasetID).Delete(&EvalCase{}, caseID).Error
+}
+
+func (p *Postgres) CreateEvalRun(run *EvalRun) error {
+	return p.db.Create(run).Error
+}
+
+func (p *Postgres) UpdateEvalRun(run *EvalRun) error {
+	return p.db.Omit("Results").Save(run).Error
+}
+
+// GetEvalRun 获取评测记录及每个用例的结果
+func (p *Postgres) GetEvalRun(id uint) (*EvalRun, error) {
+	var run EvalRun
+	err := p.db.Preload("Results", func(db *gorm.DB) *gorm.DB { return db.Order("model, case_id") }).First(&run, id).Error
+	return &run, err
+}
+
+func (p *Postgres) ListEvalRuns(datasetID uint, limit int) ([]EvalRun, error) {
+	var runs []EvalRun
+	q := p.db.Where("dataset_id = ?", datasetID).Order("created_at DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&runs).Error
+	return runs, err
+}
+
+func (p *Postgres) CreateEvalResults(results []EvalResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	return p.db.Create(&results).Error
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"time"
+)
+
+// AgentRun 智能体的一次运行结果 (绩效KPI的数据来源)
+type AgentRun struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	AgentID    uint      `gorm:"index:idx_agent_run_time;not null" json:"agent_id"`
+	Source     string    `gorm:"size:50" json:"source"` // run/collaboration/channel/flow
+	Model      string    `gorm:"size:100" json:"model"`
+	Success    bool      `json:"success"`
+	StopReason string    `gorm:"size:50" json:"stop_reason"`
+	Steps      int       `json:"steps"`
+	Tokens     int       `json:"tokens"`
+	LatencyMs  int64     `json:"latency_ms"`
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt  time.Time `gorm:"index:idx_agent_run_time" json:"created_at"`
+}
+
+func (AgentRun) TableName() string {
+	return "agent_runs"
+}
+
+// AgentRating 下属对上级的打分 (协作中对收到的任务安排评价)
+type AgentRating struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	RaterID   uint      `gorm:"index;not null" json:"rater_id"` // 打分的下属
+	RateeID   uint      `gorm:"index;not null" json:"ratee_id"` // 被打分的上级
+	Score     int       `json:"score"`                          // 0-100
+	Comment   string    `gorm:"type:text" json:"comment"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (AgentRating) TableName() string {
+	return "agent_ratings"
+}
+
+// EvaluationCycle 一轮绩效评估
+type EvaluationCycle struct {
+	ID          uint              `gorm:"primaryKey" json:"id"`
+	Goal        string            `gorm:"type:text" json:"goal"`
+	OrgRootID   uint              `json:"org_root_id"` // 0表示评估周期内有运行记录的所有智能体
+	PeriodStart time.Time         `json:"period_start"`
+	PeriodEnd   time.Time         `json:"period_end"`
+	KPIs        string            `gorm:"type:jsonb" json:"kpis"` // 整体KPI(JSON)
+	Summary     string            `gorm:"type:text" json:"summary"`
+	Status      string            `gorm:"size:20" json:"status"` // running/completed/failed
+	Error       string            `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	FinishedAt  *time.Time        `json:"finished_at"`
+	Evaluations []AgentEvaluation `gorm:"foreignKey:CycleID" json:"evaluations,omitempty"`
+}
+
+func (EvaluationCycle) TableName() string {
+	return "evaluation_cycles"
+}
+
+// AgentEvaluation 某个智能体在一轮评估中的结果
+type AgentEvaluation struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	CycleID          uint      `gorm:"index;not null" json:"cycle_id"`
+	AgentID          uint      `gorm:"index;not null" json:"agent_id"`
+	Role             string    `gorm:"size:20" json:"role"`
+	ReviewerID       uint      `json:"reviewer_id"` // 评估者 (上级), 0表示CEO自评
+	KPIs             string    `gorm:"type:jsonb" json:"kpis"`
+	SubordinateScore *int      `json:"subordinate_score"` // 下属打分平均值, 没有打分时为空
+	Score            int       `json:"score"`             // 评估得分 0-100
+	Review           string    `gorm:"type:text" json:"review"`
+	Model            string    `gorm:"size:100" json:"model"`     // 评估时使用的模型
+	NewModel         string    `gorm:"size:100" json:"new_model"` // 切换后的模型, 未切换时与Model相同
+	Switched         bool      `json:"switched"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func (AgentEvaluation) TableName() string {
+	return "agent_evaluations"
+}
+
+// RunStats 一段时间内的运行统计
+type RunStats struct {
+	Runs         int     `json:"runs"`
+	Succeeded    int     `json:"succeeded"`
+	SuccessRate  float64 `json:"success_rate"`
+	AvgTokens    float64 `json:"avg_tokens"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	AvgSteps     float64 `json:"avg_steps"`
+}
+
+func (p *Postgres) CreateAgentRun(run *AgentRun) error {
+	return p.db.Create(run).Error
+}
+
+// AgentRunStats 统计智能体在 [from, to) 内的运行结果
+func (p *Postgres) AgentRunStats(agentID uint, from, to time.Time) (*RunStats, error) {
+	var stats RunStats
+	err := p.db.Model(&AgentRun{}).
+		Select("COUNT(*) AS runs, "+
+			"COALESCE(SUM(CASE WHEN success THEN 1 ELSE 0 END), 0) AS succeeded, "+
+			"COALESCE(AVG(tokens), 0) AS avg_tokens, "+
+			"COALESCE(AVG(latency_ms), 0) AS avg_latency_ms, "+
+			"COALESCE(AVG(steps), 0) AS avg_steps").
+		Where("agent_id = ? AND created_at >= ? AND created_at < ?", agentID, from, to).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	if stats.Runs > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) / float64(stats.Runs)
+	}
+	return &stats, nil
+}
+
+// ActiveAgentIDs 在 [from, to) 内有运行记录的智能体
+func (p *Postgres) ActiveAgentIDs(from, to time.Time) ([]uint, error) {
+	var ids []uint
+	err := p.db.Model(&AgentRun{}).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Distinct().Order("agent_id").Pluck("agent_id", &ids).Error
+	return ids, err
+}
+
+func (p *Postgres) CreateAgentRating(rating *AgentRating) error {
+	return p.db.Create(rating).Error
+}
+
+// AverageRating 上级在 [from, to) 内收到的下属平均打分, 没有打分时返回nil
+func (p *Postgres) AverageRating(rateeID uint, from, to time.Time) (*int, error) {
+	var row struct {
+		Count int
+		Avg   float64
+	}
+	err := p.db.Model(&AgentRating{}).
+		Select("COUNT(*) AS count, COALESCE(AVG(score), 0) AS avg").
+		Where("ratee_id = ? AND created_at >= ? AND created_at < ?", rateeID, from, to).
+		Scan(&row).Error
+	if err != nil || row.Count == 0 {
+		return nil, err
+	}
+	avg := int(row.Avg + 0.5)
+	return &avg, nil
+}
+
+func (p *Postgres) CreateEvaluationCycle(cycle *EvaluationCycle) error {
+	return p.db.Create(cycle).Error
+}
+
+func (p *Postgres) UpdateEvaluationCycle(cycle *EvaluationCycle) error {
+	return p.db.Omit("Evaluations").Save(cycle).Error
+}
+
+// LastEvaluationCycle 最近一轮完成的评估 (用于确定下一轮的统计区间)
+func (p *Postgres) LastEvaluationCycle() (*EvaluationCycle, error) {
+	var cycle EvaluationCycle
+	err := p.db.Where("status = ?", "completed").Order("period_end DESC").First(&cycle).Error
+	return &cycle, err
+}
+
+func (p *Postgres) ListEvaluationCycles(limit int) ([]EvaluationCycle, error) {
+	var cycles []EvaluationCycle
+	q := p.db.Order("created_at DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&cycles).Error
+	return cycles, err
+}
+
+// GetEvaluationCycle 获取评估轮次及其中每个智能体的结果
+func (p *Postgres) GetEvaluationCycle(id uint) (*EvaluationCycle, error) {
+	var cycle EvaluationCycle
+	err := p.db.Preload("Evaluations").First(&cycle, id).Error
+	return &cycle, err
+}
+
+func (p *Postgres) CreateAgentEvaluation(eval *AgentEvaluation) error {
+	return p.db.Create(eval).Error
+}
+
+// ListAgentEvaluations 智能体的历次评估 (最新在前)
+func (p *Postgres) ListAgentEvaluations(agentID uint, limit int) ([]AgentEvaluation, error) {
+	var evals []AgentEvaluation
+	q := p.db.Where("agent_id = ?", agentID).Order("created_at DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&evals).Error
+	return evals, err
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"time"
+)
+
+// GuardrailEvent 护栏拦截/改写记录
+type GuardrailEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	AgentID   uint      `gorm:"index:idx_guardrail_agent_time" json:"agent_id"`
+	Source    string    `gorm:"size:50" json:"source"` // run/task/collaboration/eval
+	Stage     string    `gorm:"size:10" json:"stage"`  // input/output
+	Rule      string    `gorm:"size:50" json:"rule"`   // allow_topics/deny_topics/pii/max_length/moderation
+	Action    string    `gorm:"size:20" json:"action"` // blocked/rewritten
+	Reason    string    `gorm:"type:text" json:"reason"`
+	CreatedAt time.Time `gorm:"index:idx_guardrail_agent_time" json:"created_at"`
+}
+
+func (GuardrailEvent) TableName() string {
+	return "guardrail_events"
+}
+
+func (p *Postgres) CreateGuardrailEvent(e *GuardrailEvent) error {
+	return p.db.Create(e).Error
+}
+
+// ListGuardrailEvents 护栏记录 (最新在前), agentID为0时返回全部
+func (p *Postgres) ListGuardrailEvents(agentID uint, limit int) ([]GuardrailEvent, error) {
+	var events []GuardrailEvent
+	q := p.db.Order("created_at DESC")
+	if agentID != 0 {
+		q = q.Where("agent_id = ?", agentID)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"time"
+)
+
+// KnowledgeSource 导入的源文档, 同一智能体下按名称识别, 重新上传同名文档时增量更新
+type KnowledgeSource struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	AgentID     uint      `json:"agent_id" gorm:"uniqueIndex:idx_source_agent_name;not null"`
+	Name        string    `json:"name" gorm:"size:255;uniqueIndex:idx_source_agent_name;not null"`
+	Format      string    `json:"format" gorm:"size:20"`       // markdown/html/text/pdf/docx
+	ContentHash string    `json:"content_hash" gorm:"size:64"` // 原始文件的sha256
+	BlobID      string    `json:"blob_id" gorm:"size:64"`      // 原始文件
+	Size        int64     `json:"size"`
+	ChunkSize   int       `json:"chunk_size"`
+	Overlap     int       `json:"overlap"`
+	Tags        string    `json:"tags" gorm:"size:500"`
+	AccessLevel int       `json:"access_level"`
+	Sections    int       `json:"sections"` // 当前对应的知识条数
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (KnowledgeSource) TableName() string {
+	return "knowledge_sources"
+}
+
+// IngestJob 文档导入任务, 由后台worker领取执行
+type IngestJob struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	AgentID     uint       `json:"agent_id" gorm:"index;not null"`
+	SourceID    *uint      `json:"source_id" gorm:"index"`
+	Name        string     `json:"name" gorm:"size:255"` // 源文档名称
+	Format      string     `json:"format" gorm:"size:20"`
+	BlobID      string     `json:"blob_id" gorm:"size:64"`
+	ChunkSize   int        `json:"chunk_size"`
+	Overlap     int        `json:"overlap"`
+	Tags        string     `json:"tags" gorm:"size:500"`
+	AccessLevel int        `json:"access_level"`
+	Status      string     `json:"status" gorm:"size:20;index"` // pending/running/completed/failed
+	Stage       string     `json:"stage" gorm:"size:20"`        // extracting/indexing/cleanup
+	Total       int        `json:"total"`                       // 文档中的段落数
+	Done        int        `json:"done"`
+	Progress    int        `json:"progress"` // 0-100
+	Added       int        `json:"added"`
+	Updated     int        `json:"updated"`
+	Unchanged   int        `json:"unchanged"`
+	Duplicates  int        `json:"duplicates"` // 与已有知识内容相同而跳过的段落
+	Removed     int        `json:"removed"`    // 新版本中已不存在而删除的段落
+	Error       string     `json:"error,omitempty" gorm:"type:text"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+}
+
+func (IngestJob) TableName() string {
+	return "ingest_jobs"
+}
+
+// ========== 源文档 ==========
+
+func (p *Postgres) SaveKnowledgeSource(s *KnowledgeSource) error {
+	return p.db.Save(s).Error
+}
+
+func (p *Postgres) GetKnowledgeSource(id uint) (*KnowledgeSource, error) {
+	var s KnowledgeSource
+	err := p.db.First(&s, id).Error
+	return &s, err
+}
+
+// FindKnowledgeSource 按名称查找智能体的源文档
+func (p *Postgres) FindKnowledgeSource(agentID uint, name string) (*KnowledgeSource, error) {
+	var s KnowledgeSource
+	err := p.db.Where("agent_id = ? AND name = ?", agentID, name).First(&s).Error
+	return &s, err
+}
+
+func (p *Postgres) ListKnowledgeSources(agentID uint) ([]KnowledgeSource, error) {
+	var sources []KnowledgeSource
+	err := p.db.Where("agent_id = ?", agentID).Order("updated_at DESC").Find(&sources).Error
+	return sources, err
+}
+
+func (p *Postgres) DeleteKnowledgeSource(id uint) error {
+	return p.db.Delete(&KnowledgeSource{}, id).Error
+}
+
+// ListSourceKnowledge 源文档导入的知识
+func (p *Postgres) ListSourceKnowledge(sourceID uint) ([]Knowledge, error) {
+	var knowledge []Knowledge
+	err := p.db.Where("source_id = ?", sourceID).Order("id").Find(&knowledge).Error
+	return knowledge, err
+}
+
+// KnowledgeHashExists 智能体是否已有相同内容的知识
+func (p *Postgres) KnowledgeHashExists(agentID uint, hash string) (bool, error) {
+	var n int64
+	err := p.db.Model(&Knowledge{}).Where("agent_id = ? AND content_hash = ?", agentID, hash).Count(&n).Error
+	return n > 0, err
+}
+
+// ========== 导入任务 ==========
+
+func (p *Postgres) CreateIngestJob(j *IngestJob) error {
+	return p.db.Create(j).Error
+}
+
+func (p *Postgres) GetIngestJob(id uint) (*IngestJob, error) {
+	var j IngestJob
+	err := p.db.First(&j, id).Error
+	return &j, err
+}
+
+// ListIngestJobs 智能体的导入任务 (最新在前)
+func (p *Postgres) ListIngestJobs(agentID uint, limit int) ([]IngestJob, error) {
+	var jobs []IngestJob
+	q := p.db.Where("agent_id = ?", agentID).Order("id DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&jobs).Error
+	return jobs, err
+}
+
+// ClaimIngestJob 领取任务: 仅当状态和更新时间与读取时一致才改为运行中, 保证多副本下只有一个领取成功
+func (p *Postgres) ClaimIngestJob(j *IngestJob) (bool, error) {
+	now := time.Now()
+	res := p.db.Model(&IngestJob{}).
+		Where("id = ? AND status = ? AND updated_at = ?", j.ID, j.Status, j.UpdatedAt).
+		Updates(map[string]interface{}{"status": "running", "started_at": now, "updated_at": now, "error": ""})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (p *Postgres) UpdateIngestJob(id uint, updates map[string]interface{}) error {
+	return p.db.Model(&IngestJob{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// PendingIngestJobs 待执行的导入任务, 以及超过 staleBefore 未更新进度的运行中任务 (执行副本已退出)
+func (p *Postgres) PendingIngestJobs(staleBefore time.Time, limit int) ([]IngestJob, error) {
+	var jobs []IngestJob
+	err := p.db.Where("status = ? OR (status = ? AND updated_at < ?)", "pending", "running", staleBefore).
+		Order("id").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
@@ -0,0 +1,307 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Memory 智能体记忆
+type Memory struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	AgentID        uint       `json:"agent_id" gorm:"index:idx_memory_agent_time"` // 智能体ID
+	ParentID       *uint      `json:"parent_id" gorm:"index"`                      // 上级智能体ID (nil表示顶级)
+	Type           string     `json:"type" gorm:"size:20;index"`                   // 记忆类型 action/decision/result/learn/event
+	Content        string     `json:"content" gorm:"type:text"`                    // 记忆内容
+	Metadata       string     `json:"metadata" gorm:"type:text"`                   // 附加数据 (JSON)
+	Importance     int        `json:"importance"`                                  // 重要程度 1-10
+	AccessCount    int        `json:"access_count"`                                // 被检索进上下文的次数
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"index:idx_memory_agent_time"`
+}
+
+func (Memory) TableName() string {
+	return "memories"
+}
+
+// Knowledge 知识 (可被上级查看)
+type Knowledge struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	AgentID     uint      `json:"agent_id" gorm:"index"`  // 智能体ID
+	ParentID    *uint     `json:"parent_id" gorm:"index"` // 上级智能体ID
+	Title       string    `json:"title" gorm:"size:255"`  // 知识标题
+	Content     string    `json:"content" gorm:"type:text"`
+	Tags        string    `json:"tags" gorm:"size:500"`              // 标签 (逗号分隔)
+	AccessLevel int       `json:"access_level"`                      // 访问级别 1-10
+	SourceID    *uint     `json:"source_id" gorm:"index"`            // 导入的源文档, 手动添加为nil
+	ContentHash string    `json:"content_hash" gorm:"size:64;index"` // 标题+内容的sha256, 用于去重
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (Knowledge) TableName() string {
+	return "knowledge"
+}
+
+// AgentRelationship 智能体关系, 每对智能体只有一条记录
+type AgentRelationship struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ParentID     uint      `json:"parent_id" gorm:"uniqueIndex:idx_relationship_pair;not null"` // 上级ID
+	ChildID      uint      `json:"child_id" gorm:"uniqueIndex:idx_relationship_pair;index;not null"`
+	RelationType string    `json:"relation_type" gorm:"size:20;not null"` // manage/delegate/collaborate
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (AgentRelationship) TableName() string {
+	return "agent_relationships"
+}
+
+// ========== 记忆 ==========
+
+func (p *Postgres) CreateMemory(m *Memory) error {
+	return p.db.Create(m).Error
+}
+
+// ListMemories 智能体的记忆 (最新在前), since 为零值时不限时间
+func (p *Postgres) ListMemories(agentID uint, since time.Time, limit int) ([]Memory, error) {
+	var memories []Memory
+	q := p.db.Where("agent_id = ?", agentID).Order("created_at DESC")
+	if !since.IsZero() {
+		q = q.Where("created_at >= ?", since)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&memories).Error
+	return memories, err
+}
+
+func (p *Postgres) DeleteMemory(agentID, id uint) error {
+	return p.db.Where("agent_id = ?", agentID).Delete(&Memory{}, id).Error
+}
+
+// ListAgentsMemories 多个智能体的记忆 (最新在前)
+func (p *Postgres) ListAgentsMemories(agentIDs []uint, limit int) ([]Memory, error) {
+	var memories []Memory
+	if len(agentIDs) == 0 {
+		return memories, nil
+	}
+	q := p.db.Where("agent_id IN ?", agentIDs).Order("created_at DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&memories).Error
+	return memories, err
+}
+
+// ListKeyMemories 多个智能体的经验记忆和重要性不低于 minImportance 的记忆 (重要的和新的在前)
+func (p *Postgres) ListKeyMemories(agentIDs []uint, learnType string, minImportance, limit int) ([]Memory, error) {
+	var memories []Memory
+	if len(agentIDs) == 0 {
+		return memories, nil
+	}
+	q := p.db.Where("agent_id IN ?", agentIDs).
+		Where("type = ? OR importance >= ?", learnType, minImportance).
+		Order("importance DESC, created_at DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&memories).Error
+	return memories, err
+}
+
+// TouchMemories 记录记忆被访问
+func (p *Postgres) TouchMemories(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return p.db.Model(&Memory{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"access_count":     gorm.Expr("access_count + 1"),
+		"last_accessed_at": time.Now(),
+	}).Error
+}
+
+// ConsolidationCandidates 早于 before 的指定类型记忆, 按智能体和时间排序
+func (p *Postgres) ConsolidationCandidates(types []string, before time.Time, limit int) ([]Memory, error) {
+	var memories []Memory
+	err := p.db.Where("type IN ? AND created_at < ?", types, before).
+		Order("agent_id, created_at").Limit(limit).Find(&memories).Error
+	return memories, err
+}
+
+// ConsolidateMemories 写入汇总后的记忆并删除被汇总的原始记忆
+func (p *Postgres) ConsolidateMemories(learned []Memory, sourceIDs []uint) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if len(learned) > 0 {
+			if err := tx.Create(&learned).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id IN ?", sourceIDs).Delete(&Memory{}).Error
+	})
+}
+
+// DeleteExpiredMemories 删除 before 之前创建且之后未被访问的记忆, 重要性不低于 keepImportance 的保留 (0表示不保留)
+func (p *Postgres) DeleteExpiredMemories(memType string, before time.Time, keepImportance int) (int64, error) {
+	q := p.db.Where("type = ? AND created_at < ? AND (last_accessed_at IS NULL OR last_accessed_at < ?)", memType, before, before)
+	if keepImportance > 0 {
+		q = q.Where("importance < ?", keepImportance)
+	}
+	res := q.Delete(&Memory{})
+	return res.RowsAffected, res.Error
+}
+
+// TrimMemories 每个智能体的某类记忆只保留 maxCount 条 (重要的和新的优先保留),
+// 重要性不低于 keepImportance 的始终保留 (0表示不保留)
+func (p *Postgres) TrimMemories(memType string, maxCount, keepImportance int) (int64, error) {
+	res := p.db.Exec(`DELETE FROM memories WHERE id IN (
+		SELECT id FROM (
+			SELECT id, importance, ROW_NUMBER() OVER (PARTITION BY agent_id ORDER BY importance DESC, created_at DESC) AS rn
+			FROM memories WHERE type = ?
+		) ranked WHERE rn > ? AND (? <= 0 OR importance < ?))`, memType, maxCount, keepImportance, keepImportance)
+	return res.RowsAffected, res.Error
+}
+
+// ========== 知识 ==========
+
+func (p *Postgres) CreateKnowledge(k *Knowledge) error {
+	return p.db.Create(k).Error
+}
+
+func (p *Postgres) GetKnowledge(id uint) (*Knowledge, error) {
+	var k Knowledge
+	err := p.db.First(&k, id).Error
+	return &k, err
+}
+
+// ListKnowledge 多个智能体的知识 (最新更新在前)
+func (p *Postgres) ListKnowledge(agentIDs []uint) ([]Knowledge, error) {
+	var knowledge []Knowledge
+	if len(agentIDs) == 0 {
+		return knowledge, nil
+	}
+	err := p.db.Where("agent_id IN ?", agentIDs).Order("updated_at DESC").Find(&knowledge).Error
+	return knowledge, err
+}
+
+// SearchKnowledge 在多个智能体的知识中按关键词搜索标题、内容和标签
+func (p *Postgres) SearchKnowledge(agentIDs []uint, keyword string, limit int) ([]Knowledge, error) {
+	var knowledge []Knowledge
+	if len(agentIDs) == 0 {
+		return knowledge, nil
+	}
+	like := "%" + keyword + "%"
+	q := p.db.Where("agent_id IN ?", agentIDs).
+		Where("title ILIKE ? OR content ILIKE ? OR tags ILIKE ?", like, like, like).
+		Order("updated_at DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&knowledge).Error
+	return knowledge, err
+}
+
+func (p *Postgres) UpdateKnowledge(k *Knowledge) error {
+	return p.db.Save(k).Error
+}
+
+// DeleteKnowledge 删除知识及其分块, 返回是否存在
+func (p *Postgres) DeleteKnowledge(agentID, id uint) (bool, error) {
+	var deleted bool
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("agent_id = ?", agentID).Delete(&Knowledge{}, id)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		deleted = true
+		return tx.Where("knowledge_id = ?", id).Delete(&KnowledgeChunk{}).Error
+	})
+	return deleted, err
+}
+
+// ========== 关系 ==========
+
+// relationshipLock 关系写入使用的事务级咨询锁, 串行化层级变更, 避免并发写入各自通过环检测后共同成环
+const relationshipLock = 7302
+
+// SaveRelationship 保存关系; replaceParent 时先删除 child 已有的上级关系 (manage/delegate), 保证只有一个上级.
+// 写入在持有关系锁的事务内进行, check 不为空时先在同一事务内调用, 通过 parentOf 查询上级做校验, 返回错误则不写入.
+// 返回 child 原来的上级
+func (p *Postgres) SaveRelationship(rel *AgentRelationship, replaceParent bool, check func(parentOf func(childID uint) (*uint, error)) error) (*uint, error) {
+	var oldParent *uint
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", relationshipLock).Error; err != nil {
+			return err
+		}
+		txStore := &Postgres{db: tx}
+		parentOf := func(childID uint) (*uint, error) {
+			parent, err := txStore.GetParentRelationship(childID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &parent.ParentID, nil
+		}
+		if check != nil {
+			if err := check(parentOf); err != nil {
+				return err
+			}
+		}
+		if replaceParent {
+			var err error
+			if oldParent, err = parentOf(rel.ChildID); err != nil {
+				return err
+			}
+			if err := tx.Where("child_id = ? AND parent_id <> ? AND relation_type IN ?", rel.ChildID, rel.ParentID, []string{"manage", "delegate"}).
+				Delete(&AgentRelationship{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "parent_id"}, {Name: "child_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"relation_type"}),
+		}).Create(rel).Error
+	})
+	return oldParent, err
+}
+
+func (p *Postgres) DeleteRelationship(parentID, childID uint) (int64, error) {
+	res := p.db.Where("parent_id = ? AND child_id = ?", parentID, childID).Delete(&AgentRelationship{})
+	return res.RowsAffected, res.Error
+}
+
+// ListChildRelationships 直接下级的关系记录
+func (p *Postgres) ListChildRelationships(parentID uint) ([]AgentRelationship, error) {
+	var rels []AgentRelationship
+	err := p.db.Where("parent_id = ?", parentID).Order("id").Find(&rels).Error
+	return rels, err
+}
+
+// GetParentRelationship 上级关系 (manage/delegate)
+func (p *Postgres) GetParentRelationship(childID uint) (*AgentRelationship, error) {
+	var rel AgentRelationship
+	err := p.db.Where("child_id = ? AND relation_type IN ?", childID, []string{"manage", "delegate"}).First(&rel).Error
+	return &rel, err
+}
+
+func (p *Postgres) ListRelationships() ([]AgentRelationship, error) {
+	var rels []AgentRelationship
+	err := p.db.Order("parent_id, id").Find(&rels).Error
+	return rels, err
+}
+
+// DeleteAgentRelationships 删除智能体作为上级或下级的所有关系, 返回被删除的记录
+func (p *Postgres) DeleteAgentRelationships(agentID uint) ([]AgentRelationship, error) {
+	var rels []AgentRelationship
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("parent_id = ? OR child_id = ?", agentID, agentID).Find(&rels).Error; err != nil {
+			return err
+		}
+		return tx.Where("parent_id = ? OR child_id = ?", agentID, agentID).Delete(&AgentRelationship{}).Error
+	})
+	return rels, err
+}
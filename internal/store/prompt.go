@@ -0,0 +1,118 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PromptTemplate 提示词模板 (按Key引用, 内容在版本中)
+type PromptTemplate struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Key           string    `gorm:"size:100;uniqueIndex;not null" json:"key"` // 引用ID, 如 agent.ceo
+	Name          string    `gorm:"size:255" json:"name"`
+	Description   string    `gorm:"type:text" json:"description"`
+	LatestVersion int       `json:"latest_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (PromptTemplate) TableName() string {
+	return "prompt_templates"
+}
+
+// PromptVersion 提示词版本 (创建后不再修改)
+type PromptVersion struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	TemplateID uint      `gorm:"uniqueIndex:idx_prompt_version;not null" json:"template_id"`
+	Version    int       `gorm:"uniqueIndex:idx_prompt_version;not null" json:"version"`
+	Variables  string    `gorm:"type:jsonb" json:"variables"` // 变量定义(JSON)
+	Contents   string    `gorm:"type:jsonb" json:"contents"`  // 语言 -> 模板内容(JSON), 如 {"zh": "...", "en": "..."}
+	Changelog  string    `gorm:"type:text" json:"changelog"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (PromptVersion) TableName() string {
+	return "prompt_versions"
+}
+
+func (p *Postgres) ListPromptTemplates() ([]PromptTemplate, error) {
+	var templates []PromptTemplate
+	err := p.db.Order("key").Find(&templates).Error
+	return templates, err
+}
+
+func (p *Postgres) GetPromptTemplate(key string) (*PromptTemplate, error) {
+	var tpl PromptTemplate
+	err := p.db.Where("key = ?", key).First(&tpl).Error
+	return &tpl, err
+}
+
+func (p *Postgres) UpdatePromptTemplate(tpl *PromptTemplate) error {
+	return p.db.Save(tpl).Error
+}
+
+// DeletePromptTemplate 删除模板及其所有版本
+func (p *Postgres) DeletePromptTemplate(key string) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		var tpl PromptTemplate
+		if err := tx.Where("key = ?", key).First(&tpl).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("template_id = ?", tpl.ID).Delete(&PromptVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tpl).Error
+	})
+}
+
+// CreatePromptVersion 追加新版本 (模板不存在时创建), 版本号自增
+func (p *Postgres) CreatePromptVersion(tpl *PromptTemplate, version *PromptVersion) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		var existing PromptTemplate
+		err := tx.Where("key = ?", tpl.Key).First(&existing).Error
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			if err := tx.Create(tpl).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			if tpl.Name != "" {
+				existing.Name = tpl.Name
+			}
+			if tpl.Description != "" {
+				existing.Description = tpl.Description
+			}
+			*tpl = existing
+		}
+
+		version.TemplateID = tpl.ID
+		version.Version = tpl.LatestVersion + 1
+		if err := tx.Create(version).Error; err != nil {
+			return err
+		}
+		tpl.LatestVersion = version.Version
+		return tx.Save(tpl).Error
+	})
+}
+
+// GetPromptVersion 获取指定版本, version为0时返回最新版本
+func (p *Postgres) GetPromptVersion(templateID uint, version int) (*PromptVersion, error) {
+	var v PromptVersion
+	q := p.db.Where("template_id = ?", templateID)
+	if version > 0 {
+		q = q.Where("version = ?", version)
+	} else {
+		q = q.Order("version DESC")
+	}
+	err := q.First(&v).Error
+	return &v, err
+}
+
+func (p *Postgres) ListPromptVersions(templateID uint) ([]PromptVersion, error) {
+	var versions []PromptVersion
+	err := p.db.Where("template_id = ?", templateID).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
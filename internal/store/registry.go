@@ -0,0 +1,168 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ModelProvider 模型供应商 (凭证加密存储)
+type ModelProvider struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Name         string    `gorm:"size:100;uniqueIndex;not null" json:"name"` // 唯一名称, 作为模型的provider引用
+	Type         string    `gorm:"size:50;not null" json:"type"`              // openai/anthropic/glm/qwen/deepseek/custom...
+	BaseURL      string    `gorm:"size:500" json:"base_url"`
+	APIKeySecret string    `gorm:"type:text" json:"-"` // AES-GCM加密后的API Key
+	Enabled      bool      `json:"enabled"`            // 不设数据库默认值: GORM 创建时会跳过零值字段, false 会被写成默认值
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (ModelProvider) TableName() string {
+	return "model_providers"
+}
+
+// ModelDefinition 模型定义
+type ModelDefinition struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"size:100;uniqueIndex;not null" json:"name"` // 对外使用的模型名, 如 qwen-max
+	ProviderID    uint      `gorm:"index;not null" json:"provider_id"`
+	ModelName     string    `gorm:"size:100;not null" json:"model_name"` // 供应商侧的模型名
+	MaxTokens     int       `json:"max_tokens"`
+	Temperature   float64   `json:"temperature"`
+	TopP          float64   `json:"top_p"`
+	CostPer1K     float64   `json:"cost_per_1k"`
+	ContextWindow int       `json:"context_window"`
+	Vision        bool      `json:"vision"`  // 支持图片输入
+	Enabled       bool      `json:"enabled"` // 同 ModelProvider.Enabled, 由调用方显式设置
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (ModelDefinition) TableName() string {
+	return "model_definitions"
+}
+
+func (p *Postgres) CreateModelProvider(provider *ModelProvider) error {
+	return p.db.Create(provider).Error
+}
+
+func (p *Postgres) GetModelProvider(id uint) (*ModelProvider, error) {
+	var provider ModelProvider
+	err := p.db.First(&provider, id).Error
+	return &provider, err
+}
+
+func (p *Postgres) ListModelProviders() ([]ModelProvider, error) {
+	var providers []ModelProvider
+	err := p.db.Order("id").Find(&providers).Error
+	return providers, err
+}
+
+func (p *Postgres) UpdateModelProvider(provider *ModelProvider) error {
+	return p.db.Save(provider).Error
+}
+
+func (p *Postgres) DeleteModelProvider(id uint) error {
+	return p.db.Delete(&ModelProvider{}, id).Error
+}
+
+func (p *Postgres) CreateModelDefinition(def *ModelDefinition) error {
+	return p.db.Create(def).Error
+}
+
+func (p *Postgres) GetModelDefinition(id uint) (*ModelDefinition, error) {
+	var def ModelDefinition
+	err := p.db.First(&def, id).Error
+	return &def, err
+}
+
+func (p *Postgres) ListModelDefinitions() ([]ModelDefinition, error) {
+	var defs []ModelDefinition
+	err := p.db.Order("id").Find(&defs).Error
+	return defs, err
+}
+
+func (p *Postgres) UpdateModelDefinition(def *ModelDefinition) error {
+	return p.db.Save(def).Error
+}
+
+func (p *Postgres) DeleteModelDefinition(id uint) error {
+	return p.db.Delete(&ModelDefinition{}, id).Error
+}
+
+// ========== 凭证加密 ==========
+
+// secretKey 从 MODEL_SECRET_KEY 派生AES-256密钥
+func secretKey() ([]byte, error) {
+	raw := os.Getenv("MODEL_SECRET_KEY")
+	if raw == "" {
+		return nil, fmt.Errorf("MODEL_SECRET_KEY is not set")
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:], nil
+}
+
+// EncryptSecret 加密凭证 (AES-GCM, base64编码)
+func EncryptSecret(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret 解密凭证
+func DecryptSecret(encoded string) (string, error) {
+	if encoded == "" {
+		return "", nil
+	}
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid secret")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret: %w", err)
+	}
+	return string(plain), nil
+}
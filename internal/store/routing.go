@@ -0,0 +1,78 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// RoutingPolicy 模型路由策略 (覆盖内置策略)
+type RoutingPolicy struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TaskType  string    `gorm:"size:50;uniqueIndex" json:"task_type"` // 空字符串为默认策略
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	Config    string    `gorm:"type:jsonb;not null" json:"config"` // 完整策略(JSON)
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (RoutingPolicy) TableName() string {
+	return "routing_policies"
+}
+
+// RoutingDecision 路由决策日志
+type RoutingDecision struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	DecisionID string    `gorm:"size:50;index" json:"id"`
+	TaskType   string    `gorm:"size:50" json:"task_type"`
+	Requested  string    `gorm:"size:100" json:"requested"`
+	Policy     string    `gorm:"size:100" json:"policy"`
+	Candidates string    `gorm:"type:jsonb" json:"candidates"` // 候选模型(JSON数组)
+	Attempts   string    `gorm:"type:jsonb" json:"attempts"`   // 每次尝试(JSON数组)
+	Selected   string    `gorm:"size:100" json:"selected"`
+	Reason     string    `gorm:"type:text" json:"reason"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+func (RoutingDecision) TableName() string {
+	return "routing_decisions"
+}
+
+// SaveRoutingPolicy 按任务类型写入或覆盖策略
+func (p *Postgres) SaveRoutingPolicy(policy *RoutingPolicy) error {
+	return p.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "config", "updated_at"}),
+	}).Create(policy).Error
+}
+
+func (p *Postgres) ListRoutingPolicies() ([]RoutingPolicy, error) {
+	var policies []RoutingPolicy
+	err := p.db.Order("task_type").Find(&policies).Error
+	return policies, err
+}
+
+// CreateRoutingDecisions 批量写入决策日志
+func (p *Postgres) CreateRoutingDecisions(decisions []RoutingDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	return p.db.Create(&decisions).Error
+}
+
+// ListRoutingDecisions 最近的决策 (最新的在前)
+func (p *Postgres) ListRoutingDecisions(limit int) ([]RoutingDecision, error) {
+	var decisions []RoutingDecision
+	query := p.db.Order("created_at DESC, id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&decisions).Error
+	return decisions, err
+}
+
+// DeleteRoutingDecisionsBefore 清理过期的决策日志
+func (p *Postgres) DeleteRoutingDecisionsBefore(before time.Time) (int64, error) {
+	result := p.db.Where("created_at < ?", before).Delete(&RoutingDecision{})
+	return result.RowsAffected, result.Error
+}
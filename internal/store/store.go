@@ -27,6 +27,30 @@ func NewPostgres(dsn string) (*Postgres, error) {
 		&Flow{},
 		&Channel{},
 		&Conversation{},
+		&ModelProvider{},
+		&ModelDefinition{},
+		&PromptTemplate{},
+		&PromptVersion{},
+		&AgentRun{},
+		&AgentRating{},
+		&EvaluationCycle{},
+		&AgentEvaluation{},
+		&ModelAssignment{},
+		&ModelSwitchLog{},
+		&EvalDataset{},
+		&EvalCase{},
+		&EvalRun{},
+		&EvalResult{},
+		&Task{},
+		&GuardrailEvent{},
+		&Memory{},
+		&Knowledge{},
+		&AgentRelationship{},
+		&KnowledgeChunk{},
+		&KnowledgeSource{},
+		&IngestJob{},
+		&RoutingPolicy{},
+		&RoutingDecision{},
 	)
 
 	return &Postgres{db: db}, nil
@@ -49,6 +73,8 @@ type Agent struct {
 	ModelName   string    `gorm:"size:100" json:"model_name"`
 	ModelConfig string    `gorm:"type:jsonb" json:"model_config"` // JSON存储
 	Tools       string    `gorm:"type:jsonb" json:"tools"`         // JSON存储
+	PromptKey   string    `gorm:"size:100" json:"prompt_key"`     // 系统提示词模板, 为空时使用默认
+	PromptVersion int     `json:"prompt_version"`                  // 0表示始终使用最新版本
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -151,6 +177,43 @@ func (r *Redis) Del(ctx context.Context, keys ...string) error {
 	return r.client.Del(ctx, keys...).Err()
 }
 
+// Eval 执行Lua脚本 (原子操作, 如分布式限流)
+func (r *Redis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return r.client.Eval(ctx, script, keys, args...).Result()
+}
+
+// Publish 发布消息 (通知其他副本刷新缓存)
+func (r *Redis) Publish(ctx context.Context, channel, message string) error {
+	return r.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe 订阅频道, ctx 结束时关闭订阅和返回的通道
+func (r *Redis) Subscribe(ctx context.Context, channel string) <-chan string {
+	pubsub := r.client.Subscribe(ctx, channel)
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case out <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
 // 便捷方法
 func (p *Postgres) CreateAgent(agent *Agent) error {
 	return p.db.Create(agent).Error
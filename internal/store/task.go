@@ -0,0 +1,109 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Task 委派给智能体的任务, 子任务通过 ParentID 挂在上级任务下
+type Task struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	ParentID     *uint      `gorm:"index" json:"parent_id"`
+	RootID       uint       `gorm:"index" json:"root_id"`              // 顶层任务ID (顶层任务为自身)
+	CreatorID    uint       `gorm:"index" json:"creator_id"`           // 委派方智能体, 0表示用户/系统
+	AssigneeID   uint       `gorm:"index;not null" json:"assignee_id"` // 执行的智能体
+	Title        string     `gorm:"size:255" json:"title"`
+	Instructions string     `gorm:"type:text;not null" json:"instructions"`
+	OutputSchema string     `gorm:"type:jsonb" json:"output_schema"` // 期望输出的JSON Schema, {} 表示自由文本
+	Deadline     *time.Time `json:"deadline"`
+	Status       string     `gorm:"size:20;index" json:"status"` // pending/running/completed/failed/escalated/cancelled
+	Result       string     `gorm:"type:text" json:"result"`
+	Error        string     `gorm:"type:text" json:"error,omitempty"`
+	Escalation   string     `gorm:"type:text" json:"escalation,omitempty"` // 上报给上级的原因
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	StartedAt    *time.Time `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	Subtasks     []Task     `gorm:"foreignKey:ParentID" json:"subtasks,omitempty"`
+}
+
+func (Task) TableName() string {
+	return "tasks"
+}
+
+// TaskFilter 任务列表过滤条件, 零值表示不过滤
+type TaskFilter struct {
+	Status     string
+	AssigneeID uint
+	RootID     uint
+	TopLevel   bool // 只返回顶层任务
+	Limit      int
+}
+
+// CreateTask 创建任务, 顶层任务的 RootID 为自身ID
+func (p *Postgres) CreateTask(t *Task) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Subtasks").Create(t).Error; err != nil {
+			return err
+		}
+		if t.RootID != 0 {
+			return nil
+		}
+		t.RootID = t.ID
+		return tx.Model(t).Update("root_id", t.ID).Error
+	})
+}
+
+// GetTask 获取任务及其直接子任务
+func (p *Postgres) GetTask(id uint) (*Task, error) {
+	var t Task
+	err := p.db.Preload("Subtasks", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&t, id).Error
+	return &t, err
+}
+
+// GetTasks 批量获取任务 (按ID排序)
+func (p *Postgres) GetTasks(ids []uint) ([]Task, error) {
+	var tasks []Task
+	if len(ids) == 0 {
+		return tasks, nil
+	}
+	err := p.db.Where("id IN ?", ids).Order("id").Find(&tasks).Error
+	return tasks, err
+}
+
+// ListTasks 任务列表 (最新在前)
+func (p *Postgres) ListTasks(f TaskFilter) ([]Task, error) {
+	var tasks []Task
+	q := p.db.Order("id DESC")
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.AssigneeID != 0 {
+		q = q.Where("assignee_id = ?", f.AssigneeID)
+	}
+	if f.RootID != 0 {
+		q = q.Where("root_id = ?", f.RootID)
+	}
+	if f.TopLevel {
+		q = q.Where("parent_id IS NULL")
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	err := q.Find(&tasks).Error
+	return tasks, err
+}
+
+// TransitionTask 仅当任务处于 from 中的状态时写入 updates, 返回是否更新 (多副本下保证状态只流转一次)
+func (p *Postgres) TransitionTask(id uint, from []string, updates map[string]interface{}) (bool, error) {
+	res := p.db.Model(&Task{}).Where("id = ? AND status IN ?", id, from).Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+// PendingTasks 待领取的任务 (最早创建的在前)
+func (p *Postgres) PendingTasks(limit int) ([]Task, error) {
+	var tasks []Task
+	err := p.db.Where("status = ?", "pending").Order("id").Limit(limit).Find(&tasks).Error
+	return tasks, err
+}
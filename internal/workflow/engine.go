@@ -5,9 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"agent-flow/internal/agent"
+	"agent-flow/internal/model"
+	"agent-flow/internal/prompt"
 	"agent-flow/internal/store"
 )
 
@@ -20,6 +26,7 @@ const (
 	NodeTypeCondition NodeType = "condition"
 	NodeTypeTool      NodeType = "tool"
 	NodeTypeLLM       NodeType = "llm"
+	NodeTypeVote      NodeType = "vote" // 多模型投票
 )
 
 // Node 流程节点
@@ -60,15 +67,22 @@ type Engine struct {
 	db        *store.Postgres
 	redis     *store.Redis
 	agentSvc  *agent.Service
+	modelSvc  *model.Service
+	prompts   *prompt.Service
 	nodeMutex sync.Map // 节点级别锁
 }
 
 // NewEngine 创建流程引擎
-func NewEngine(db *store.Postgres, redis *store.Redis, agentSvc *agent.Service) *Engine {
+func NewEngine(db *store.Postgres, redis *store.Redis, agentSvc *agent.Service, modelSvc *model.Service, prompts *prompt.Service) *Engine {
+	if prompts == nil {
+		prompts = prompt.NewService(db)
+	}
 	return &Engine{
 		db:       db,
 		redis:    redis,
 		agentSvc: agentSvc,
+		modelSvc: modelSvc,
+		prompts:  prompts,
 	}
 }
 
@@ -97,6 +111,8 @@ type NodeExecution struct {
 	Output   string                 `json:"output"`
 	Error    string                 `json:"error,omitempty"`
 	Duration int64                  `json:"duration_ms"`
+	Data     interface{}            `json:"data,omitempty"` // 结构化输出 (如投票详情)
+	Logs     []string               `json:"logs,omitempty"` // 节点执行日志
 }
 
 // Execute 执行流程
@@ -129,12 +145,16 @@ func (e *Engine) Execute(ctx context.Context, req ExecuteRequest) (*ExecuteRespo
 		Context:   req.Context,
 		Variables: make(map[string]interface{}),
 		Results:   make(map[string]string),
+		flow:      flow,
 	}
 
 	// 从触发器开始执行
 	var results []NodeExecution
 	for _, startNode := range startNodes {
 		nodeResults, err := e.executeNode(ctx, graph, startNode, execCtx)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("flow %s cancelled: %w", req.FlowID, ctx.Err())
+		}
 		if err != nil {
 			log.Printf("Node %s execution error: %v", startNode.ID, err)
 			continue
@@ -160,6 +180,7 @@ type ExecutionContext struct {
 	Context   map[string]interface{}
 	Variables map[string]interface{}
 	Results   map[string]string // 节点ID -> 输出
+	flow      *Flow
 	mu        sync.RWMutex
 }
 
@@ -214,37 +235,46 @@ func (e *Engine) findStartNodes(flow *Flow) []Node {
 func (e *Engine) executeNode(ctx context.Context, graph NodeGraph, node Node, execCtx *ExecutionContext) ([]NodeExecution, error) {
 	// 获取节点锁 (防止并发执行同一节点)
 	lockKey := fmt.Sprintf("flow:%s:node:%s", execCtx.FlowID, node.ID)
-	execCtx.mu.Lock()
+	lock, _ := e.nodeMutex.LoadOrStore(lockKey, &sync.Mutex{})
 	// TODO: 使用Redis分布式锁
-	execCtx.mu.Unlock()
+	lock.(*sync.Mutex).Lock()
 
 	var result string
 	var err error
-	var duration int64
+	start := time.Now()
 
 	switch node.Type {
 	case NodeTypeTrigger:
 		result, err = e.executeTrigger(node, execCtx)
 	case NodeTypeAgent:
-		result, err = e.executeAgent(node, execCtx)
+		result, err = e.executeAgent(ctx, node, execCtx)
 	case NodeTypeCondition:
 		result, err = e.executeCondition(node, graph, execCtx)
 	case NodeTypeTool:
 		result, err = e.executeTool(node, execCtx)
 	case NodeTypeLLM:
-		result, err = e.executeLLM(node, execCtx)
+		result, err = e.executeLLM(ctx, node, execCtx)
+	case NodeTypeVote:
+		result, err = e.executeVote(ctx, node, execCtx)
 	default:
 		err = fmt.Errorf("unknown node type: %s", node.Type)
 	}
+	duration := time.Since(start).Milliseconds()
+	lock.(*sync.Mutex).Unlock()
 
 	execCtx.SetResult(node.ID, result)
 
+	input, _ := execCtx.GetVar("node_input_" + node.ID).(string)
 	execution := NodeExecution{
 		NodeID:   node.ID,
 		NodeType: node.Type,
-		Input:    execCtx.GetVar("node_input_" + node.ID).(string),
+		Input:    input,
 		Output:   result,
 		Duration: duration,
+		Data:     execCtx.GetVar("node_data_" + node.ID),
+	}
+	if logs, ok := execCtx.GetVar("node_logs_" + node.ID).([]string); ok {
+		execution.Logs = logs
 	}
 	if err != nil {
 		execution.Error = err.Error()
@@ -256,13 +286,17 @@ func (e *Engine) executeNode(ctx context.Context, graph NodeGraph, node Node, ex
 	// 执行子节点
 	children := graph[node.ID]
 	for _, childID := range children {
-		childNode := e.findNode(flow, childID)
+		if ctx.Err() != nil {
+			// 服务停止或请求取消, 不再继续执行后续节点
+			return results, ctx.Err()
+		}
+		childNode := e.findNode(execCtx.flow, childID)
 		if childNode == nil {
 			continue
 		}
 
 		// 条件分支检查
-		edge := e.findEdge(flow, node.ID, childID)
+		edge := e.findEdge(execCtx.flow, node.ID, childID)
 		if edge != nil && edge.Condition != "" {
 			if !e.evaluateCondition(edge.Condition, execCtx) {
 				continue
@@ -318,8 +352,11 @@ func (e *Engine) executeTrigger(node Node, execCtx *ExecutionContext) (string, e
 }
 
 // executeAgent 执行智能体节点
-func (e *Engine) executeAgent(node Node, execCtx *ExecutionContext) (string, error) {
-	agentID, _ := node.Data["agentId"].(string)
+func (e *Engine) executeAgent(ctx context.Context, node Node, execCtx *ExecutionContext) (string, error) {
+	agentID := ""
+	if id := nodeInt(node.Data["agentId"]); id > 0 {
+		agentID = fmt.Sprint(id)
+	}
 	prevResult := e.getPreviousNode(execCtx, node.ID)
 	input := execCtx.GetResult(prevResult)
 	
@@ -327,10 +364,10 @@ func (e *Engine) executeAgent(node Node, execCtx *ExecutionContext) (string, err
 
 	if agentID == "" {
 		// 使用默认Agent
-		return e.agentSvc.Process(context.Background(), input, execCtx.UserID)
+		return e.agentSvc.Process(ctx, input, execCtx.UserID)
 	}
 
-	return e.agentSvc.ProcessWithAgent(context.Background(), agentID, input, execCtx.UserID)
+	return e.agentSvc.ProcessWithAgent(ctx, agentID, input, execCtx.UserID)
 }
 
 // executeCondition 执行条件分支
@@ -378,23 +415,132 @@ func (e *Engine) executeTool(node Node, execCtx *ExecutionContext) (string, erro
 }
 
 // executeLLM 执行大模型节点
-func (e *Engine) executeLLM(node Node, execCtx *ExecutionContext) (string, error) {
-	prompt, _ := node.Data["prompt"].(string)
-	model, _ := node.Data["model"].(string)
+func (e *Engine) executeLLM(ctx context.Context, node Node, execCtx *ExecutionContext) (string, error) {
+	systemPrompt, _ := node.Data["prompt"].(string)
+	modelName, _ := node.Data["model"].(string)
 	input := execCtx.GetResult(e.getPreviousNode(execCtx, node.ID))
 	
 	execCtx.SetVar("node_input_"+node.ID, input)
 
+	// 引用提示词模板时优先于内联文本, 模板变量可以使用 {{.input}}
+	if key, _ := node.Data["promptKey"].(string); key != "" {
+		vars := map[string]interface{}{"input": input}
+		if extra, ok := node.Data["promptVars"].(map[string]interface{}); ok {
+			for k, v := range extra {
+				vars[k] = v
+			}
+		}
+		locale, _ := node.Data["promptLocale"].(string)
+		rendered, err := e.prompts.Render(prompt.Ref{Key: key, Version: nodeInt(node.Data["promptVersion"]), Locale: locale}, vars)
+		if err != nil {
+			return "", err
+		}
+		systemPrompt = rendered
+	}
+
 	// 构建完整prompt
-	fullPrompt := prompt + "\n\n输入: " + input
+	fullPrompt := systemPrompt + "\n\n输入: " + input
 
-	return e.agentSvc.CallLLM(ctx, model, fullPrompt)
+	return e.agentSvc.CallLLM(ctx, modelName, fullPrompt)
+}
+
+// executeVote 执行投票节点: 多个模型回答同一问题, 按投票方法选出最佳答案
+func (e *Engine) executeVote(ctx context.Context, node Node, execCtx *ExecutionContext) (string, error) {
+	prompt, _ := node.Data["prompt"].(string)
+	votingMethod, _ := node.Data["votingMethod"].(string)
+	taskType, _ := node.Data["taskType"].(string)
+	judgeModel, _ := node.Data["judgeModel"].(string)
+	input := execCtx.GetResult(e.getPreviousNode(execCtx, node.ID))
+
+	execCtx.SetVar("node_input_"+node.ID, input)
+
+	if e.modelSvc == nil {
+		return "", fmt.Errorf("model service not configured")
+	}
+
+	messages := []model.Message{}
+	if prompt != "" {
+		messages = append(messages, model.Message{Role: "system", Content: prompt})
+	}
+	messages = append(messages, model.Message{Role: "user", Content: input})
+
+	resp, err := e.modelSvc.Vote(ctx, model.VoteRequest{
+		Models:       nodeModels(node.Data["models"]),
+		Messages:     messages,
+		TaskType:     taskType,
+		VotingMethod: votingMethod,
+		JudgeModel:   judgeModel,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// 投票详情供后续节点和执行记录使用
+	execCtx.SetVar("node_data_"+node.ID, resp)
+	execCtx.SetVar("vote_"+node.ID, resp)
+
+	var logs []string
+	for _, m := range sortedModels(resp.Responses) {
+		line := fmt.Sprintf("[%s] score=%.1f: %s", m, resp.Scores[m], resp.Responses[m])
+		logs = append(logs, line)
+		log.Printf("[Vote] node=%s %s", node.ID, line)
+	}
+	logs = append(logs, fmt.Sprintf("winner=%s", resp.Winner))
+	execCtx.SetVar("node_logs_"+node.ID, logs)
+
+	return resp.WinnerContent, nil
+}
+
+// nodeModels 解析节点配置中的模型列表 (数组或逗号分隔字符串)
+func nodeModels(v interface{}) []string {
+	var models []string
+	switch list := v.(type) {
+	case []interface{}:
+		for _, item := range list {
+			if name, ok := item.(string); ok && name != "" {
+				models = append(models, name)
+			}
+		}
+	case []string:
+		models = list
+	case string:
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				models = append(models, name)
+			}
+		}
+	}
+	return models
+}
+
+// nodeInt 解析节点配置中的整数 (JSON数字或字符串)
+func nodeInt(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case string:
+		var i int
+		fmt.Sscanf(n, "%d", &i)
+		return i
+	}
+	return 0
+}
+
+func sortedModels(responses map[string]string) []string {
+	models := make([]string, 0, len(responses))
+	for m := range responses {
+		models = append(models, m)
+	}
+	sort.Strings(models)
+	return models
 }
 
 // getPreviousNode 获取上一节点
 func (e *Engine) getPreviousNode(execCtx *ExecutionContext, nodeID string) string {
 	// 简化实现：查找最近的结果
-	for k, v := range execCtx.Results {
+	for k := range execCtx.Results {
 		if k != nodeID {
 			return k
 		}
@@ -405,8 +551,11 @@ func (e *Engine) getPreviousNode(execCtx *ExecutionContext, nodeID string) strin
 // getFlow 获取流程配置
 func (e *Engine) getFlow(flowID string) (*Flow, error) {
 	// 从DB或缓存获取
-	id := 1 // TODO: 解析flowID
-	flowData, err := e.db.GetFlow(id)
+	id, err := strconv.ParseUint(flowID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flow id %q", flowID)
+	}
+	flowData, err := e.db.GetFlow(uint(id))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,83 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-flow/internal/model"
+)
+
+// newOllama 模拟 Ollama: 按模型名返回固定回复
+func newOllama(t *testing.T, replies map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			var models []map[string]string
+			for name := range replies {
+				models = append(models, map[string]string{"name": name})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": models})
+		case "/api/show":
+			json.NewEncoder(w).Encode(map[string]interface{}{})
+		case "/api/chat":
+			var req struct {
+				Model string `json:"model"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"model":       req.Model,
+				"message":     map[string]string{"role": "assistant", "content": replies[req.Model]},
+				"done_reason": "stop",
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExecuteVote(t *testing.T) {
+	srv := newOllama(t, map[string]string{
+		"short": "ok",
+		"long":  "这是一个更完整的回答, 包含了具体步骤和原因说明。",
+	})
+	t.Setenv("OLLAMA_BASE_URL", srv.URL)
+	svc := model.NewService()
+	if _, err := svc.DiscoverModels(context.Background(), model.ProviderOllama); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	e := &Engine{modelSvc: svc}
+	execCtx := &ExecutionContext{
+		Variables: make(map[string]interface{}),
+		Results:   map[string]string{"trigger": "如何重置密码?"},
+	}
+	node := Node{ID: "vote", Type: NodeTypeVote, Data: map[string]interface{}{
+		"models":       []interface{}{"ollama/short", "ollama/long"},
+		"votingMethod": "length",
+	}}
+
+	out, err := e.executeVote(context.Background(), node, execCtx)
+	if err != nil {
+		t.Fatalf("executeVote: %v", err)
+	}
+	if !strings.Contains(out, "完整的回答") {
+		t.Fatalf("winner content = %q, want the longer reply", out)
+	}
+	resp, ok := execCtx.GetVar("node_data_vote").(*model.VoteResponse)
+	if !ok || resp.Winner != "ollama/long" || len(resp.Responses) != 2 {
+		t.Fatalf("vote data = %+v", execCtx.GetVar("node_data_vote"))
+	}
+	if input, _ := execCtx.GetVar("node_input_vote").(string); input != "如何重置密码?" {
+		t.Fatalf("node input = %q", input)
+	}
+	if logs, _ := execCtx.GetVar("node_logs_vote").([]string); len(logs) != 3 {
+		t.Fatalf("logs = %v", logs)
+	}
+}